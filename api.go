@@ -0,0 +1,566 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// JSON API under /api/v1. It shares the validation and persistence helpers
+// with the HTMX handlers and only differs in how requests are decoded and
+// responses are rendered.
+
+const apiMaxBodyBytes = 1 << 20
+
+type apiErrorBody struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Printf("Error encoding JSON response: %v\n", err)
+	}
+}
+
+func apiError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]apiErrorBody{"error": {Code: code, Message: message}})
+}
+
+// apiFail maps the shared domain errors to status codes
+func apiFail(w http.ResponseWriter, err error) {
+	var verr *ValidationError
+	switch {
+	case errors.As(err, &verr):
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]apiErrorBody{"error": {
+			Code:    "validation_failed",
+			Message: verr.Message,
+			Fields:  map[string]string{verr.Field: verr.Message},
+		}})
+	case errors.Is(err, errEmailTaken):
+		apiError(w, http.StatusConflict, "email_taken", err.Error())
+	default:
+		fmt.Printf("API error: %v\n", err)
+		apiError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+	}
+}
+
+// decodeJSON reads a single JSON object into v, rejecting unknown fields so
+// typos do not silently drop data
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		apiError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON body: "+err.Error())
+		return false
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		apiError(w, http.StatusBadRequest, "invalid_json", "Request body must contain a single JSON object")
+		return false
+	}
+	return true
+}
+
+// apiAuthMiddleware is the JSON counterpart of authMiddleware: it answers
+// with status codes instead of redirecting to the login page. Like
+// authMiddleware it accepts either a bearer token or the session cookie.
+func apiAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := bearerToken(r); ok {
+			token, user, err := authenticateBearer(r)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="afcb", error="invalid_token"`)
+				apiError(w, http.StatusUnauthorized, "invalid_token", "Invalid or expired API token")
+				return
+			}
+			next.ServeHTTP(w, withUser(withAPIToken(r, token), user))
+			return
+		}
+
+		session, err := sessionFromRequest(r)
+		if err != nil {
+			apiError(w, http.StatusUnauthorized, "unauthenticated", "Authentication required")
+			return
+		}
+		user, err := db.GetUser(session.Username)
+		if err != nil {
+			db.DeleteSession(session.ID)
+			apiError(w, http.StatusUnauthorized, "unauthenticated", "Authentication required")
+			return
+		}
+		if user.NeedPasswordChange {
+			apiError(w, http.StatusForbidden, "password_change_required", "Password must be changed before using the API")
+			return
+		}
+		next.ServeHTTP(w, withUser(withSession(r, session), user))
+	})
+}
+
+func apiAllow(p Permission, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hasPermission(r, p) {
+			apiError(w, http.StatusForbidden, "forbidden", "You do not have permission to perform this action")
+			return
+		}
+		next(w, r)
+	})
+}
+
+func apiAllowContactEdit(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !canEditContact(r, mux.Vars(r)["id"]) {
+			apiError(w, http.StatusForbidden, "forbidden", "You do not have permission to perform this action")
+			return
+		}
+		next(w, r)
+	})
+}
+
+func registerAPIRoutes(router *mux.Router) {
+	api := router.PathPrefix("/api/v1").Subrouter()
+	api.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiError(w, http.StatusNotFound, "not_found", "No such endpoint")
+	})
+	api.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+	})
+	api.Use(apiAuthMiddleware)
+	// cookie sessions, e.g. a logged-in browser, need the page's CSRF token
+	api.Use(requireCSRF(func(w http.ResponseWriter, r *http.Request) {
+		apiError(w, http.StatusForbidden, "csrf_failed", "Missing or invalid X-CSRF-Token header")
+	}))
+
+	api.Handle("/contacts", apiAllow(PermViewContacts, apiListContacts)).Methods("GET")
+	api.Handle("/contacts", apiAllow(PermEditContacts, apiCreateContact)).Methods("POST")
+	api.Handle("/contacts/{id}", apiAllow(PermViewContacts, apiGetContact)).Methods("GET")
+	api.Handle("/contacts/{id}", apiAllowContactEdit(apiUpdateContact)).Methods("PUT", "PATCH")
+	api.Handle("/contacts/{id}", apiAllow(PermEditContacts, apiDeleteContact)).Methods("DELETE")
+
+	api.Handle("/companies", apiAllow(PermViewCompanies, apiListCompanies)).Methods("GET")
+	api.Handle("/companies", apiAllow(PermManageCompanies, apiCreateCompany)).Methods("POST")
+	api.Handle("/companies/{id}", apiAllow(PermViewCompanies, apiGetCompany)).Methods("GET")
+	api.Handle("/companies/{id}", apiAllow(PermManageCompanies, apiUpdateCompany)).Methods("PUT", "PATCH")
+	api.Handle("/companies/{id}", apiAllow(PermManageCompanies, apiDeleteCompany)).Methods("DELETE")
+
+	api.HandleFunc("/users/me", apiCurrentUser).Methods("GET")
+	api.Handle("/users", apiAllow(PermManageUsers, apiListUsers)).Methods("GET")
+
+	api.Handle("/license", apiAllow(PermManageLicense, apiLicense)).Methods("GET")
+}
+
+// CONTACTS
+
+// contactRequest uses pointers so PATCH can tell a missing field from an
+// empty one. PUT treats missing fields as empty.
+type contactRequest struct {
+	ContactType *string `json:"contact_type"`
+	FirstName   *string `json:"first_name"`
+	LastName    *string `json:"last_name"`
+	Email       *string `json:"email"`
+	Phone       *string `json:"phone"`
+	Password    *string `json:"password"`
+	CompanyID   *string `json:"company_id"`
+
+	Emails    *[]ContactEmail   `json:"emails"`
+	Phones    *[]ContactPhone   `json:"phones"`
+	Addresses *[]ContactAddress `json:"addresses"`
+
+	Custom *map[string]string `json:"custom"`
+	Tags   *[]string          `json:"tags"`
+}
+
+func (req *contactRequest) apply(c *Contact, replace bool) {
+	set := func(dst *string, src *string) {
+		if src != nil {
+			*dst = *src
+		} else if replace {
+			*dst = ""
+		}
+	}
+	set(&c.ContactType, req.ContactType)
+	set(&c.FirstName, req.FirstName)
+	set(&c.LastName, req.LastName)
+	set(&c.Email, req.Email)
+	set(&c.Phone, req.Phone)
+
+	if req.CompanyID != nil && *req.CompanyID != "" {
+		companyID := *req.CompanyID
+		c.CompanyID = &companyID
+	} else if req.CompanyID != nil || replace {
+		c.CompanyID = nil
+	}
+
+	// detail lists are replaced as a whole
+	if req.Emails != nil {
+		c.Emails = *req.Emails
+	} else if replace {
+		c.Emails = nil
+	}
+	if req.Phones != nil {
+		c.Phones = *req.Phones
+	} else if replace {
+		c.Phones = nil
+	}
+	if req.Addresses != nil {
+		c.Addresses = *req.Addresses
+	} else if replace {
+		c.Addresses = nil
+	}
+	if req.Tags != nil {
+		c.Tags = *req.Tags
+	} else if replace {
+		c.Tags = nil
+	}
+	c.Custom = applyCustomValues(c.Custom, req.Custom, replace)
+}
+
+// applyCustomValues returns the custom field values after a request. PUT
+// replaces them; PATCH only changes the fields it names, where an empty
+// value clears the field.
+func applyCustomValues(current map[string]string, values *map[string]string, replace bool) map[string]string {
+	if values == nil {
+		if replace {
+			return nil
+		}
+		return current
+	}
+	result := map[string]string{}
+	if !replace {
+		for name, value := range current {
+			result[name] = value
+		}
+	}
+	for name, value := range *values {
+		result[name] = value
+	}
+	return result
+}
+
+func apiListContacts(w http.ResponseWriter, r *http.Request) {
+	opts := listOptionsFromRequest(r, contactSorts)
+	contacts, total, err := db.ListContacts(r.URL.Query().Get("q"), opts)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	if contacts == nil {
+		contacts = []Contact{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"contacts": contacts,
+		"total":    total,
+		"page":     opts.Page,
+		"per_page": opts.PerPage,
+	})
+}
+
+func apiGetContact(w http.ResponseWriter, r *http.Request) {
+	contact, err := db.GetContact(mux.Vars(r)["id"])
+	if err != nil {
+		apiError(w, http.StatusNotFound, "not_found", "Contact not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, contact)
+}
+
+func apiCreateContact(w http.ResponseWriter, r *http.Request) {
+	var req contactRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	contact := &Contact{}
+	req.apply(contact, true)
+	if err := validateContact(contact); err != nil {
+		apiFail(w, err)
+		return
+	}
+	if err := checkEmailAvailable(contact.Email, ""); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	password := ""
+	if req.Password != nil {
+		password = *req.Password
+	}
+	if err := createContactWithUser(r, contact, password); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	fmt.Printf("New contact created via API: %s %s (ID: %s)\n", contact.FirstName, contact.LastName, contact.ID)
+	recordAudit(r, AuditCreate, "contact", contact.ID, nil, contact)
+	w.Header().Set("Location", "/api/v1/contacts/"+contact.ID)
+	writeJSON(w, http.StatusCreated, contact)
+}
+
+func apiUpdateContact(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	contact, err := db.GetContact(id)
+	if err != nil {
+		apiError(w, http.StatusNotFound, "not_found", "Contact not found")
+		return
+	}
+	before := *contact
+
+	var req contactRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	req.apply(contact, r.Method == http.MethodPut)
+	if err := validateContact(contact); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	password := ""
+	if req.Password != nil {
+		password = *req.Password
+	}
+	if err := checkOwnContactEdit(r, &before, contact, password); err != nil {
+		apiError(w, http.StatusForbidden, "forbidden", err.Error())
+		return
+	}
+	if err := checkEmailAvailable(contact.Email, id); err != nil {
+		apiFail(w, err)
+		return
+	}
+	if err := updateContactWithUser(contact, password); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	fmt.Printf("Contact updated via API: %s\n", contact.ID)
+	saveContactVersion(r, &before, contact, 0)
+	auditContactUpdate(r, &before, contact, password)
+	writeJSON(w, http.StatusOK, contact)
+}
+
+func apiDeleteContact(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	contact, err := db.GetContact(id)
+	if err != nil {
+		apiError(w, http.StatusNotFound, "not_found", "Contact not found")
+		return
+	}
+
+	if err := db.DeleteContact(id); err != nil {
+		apiFail(w, err)
+		return
+	}
+	recordAudit(r, AuditDelete, "contact", id, contact, nil)
+	if err := db.RevokeContactCredentials(id); err != nil {
+		fmt.Printf("Warning: Failed to revoke user access: %v\n", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// COMPANIES
+
+// companyRequest follows the same PUT/PATCH rules as contactRequest.
+// Documents are uploaded through the HTML forms only.
+type companyRequest struct {
+	Name               *string `json:"name"`
+	BankName           *string `json:"bank_name"`
+	AccountNumber      *string `json:"account_number"`
+	RegistrationNumber *string `json:"registration_number"`
+
+	Custom *map[string]string `json:"custom"`
+}
+
+func (req *companyRequest) apply(c *Company, replace bool) {
+	set := func(dst *string, src *string) {
+		if src != nil {
+			*dst = *src
+		} else if replace {
+			*dst = ""
+		}
+	}
+	set(&c.Name, req.Name)
+	set(&c.BankName, req.BankName)
+	set(&c.AccountNumber, req.AccountNumber)
+	set(&c.RegistrationNumber, req.RegistrationNumber)
+	c.Custom = applyCustomValues(c.Custom, req.Custom, replace)
+}
+
+func apiListCompanies(w http.ResponseWriter, r *http.Request) {
+	opts := listOptionsFromRequest(r, companySorts)
+	companies, total, err := db.ListCompanies(r.URL.Query().Get("q"), opts)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	if companies == nil {
+		companies = []Company{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"companies": companies,
+		"total":     total,
+		"page":      opts.Page,
+		"per_page":  opts.PerPage,
+	})
+}
+
+func apiGetCompany(w http.ResponseWriter, r *http.Request) {
+	company, err := db.GetCompany(mux.Vars(r)["id"])
+	if err != nil {
+		apiError(w, http.StatusNotFound, "not_found", "Company not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, company)
+}
+
+func apiCreateCompany(w http.ResponseWriter, r *http.Request) {
+	var req companyRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	company := &Company{}
+	req.apply(company, true)
+	if err := validateCompany(company); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	id, err := genID()
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	company.ID = id
+	if user, err := currentUserRecord(r); err == nil {
+		company.CreatedBy = &user.Username
+	}
+
+	if err := db.CreateCompany(company); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	// reload to pick up created_at
+	if created, err := db.GetCompany(id); err == nil {
+		company = created
+	}
+	fmt.Printf("New company created via API: %s (ID: %s)\n", company.Name, company.ID)
+	recordAudit(r, AuditCreate, "company", company.ID, nil, company)
+	w.Header().Set("Location", "/api/v1/companies/"+company.ID)
+	writeJSON(w, http.StatusCreated, company)
+}
+
+func apiUpdateCompany(w http.ResponseWriter, r *http.Request) {
+	company, err := db.GetCompany(mux.Vars(r)["id"])
+	if err != nil {
+		apiError(w, http.StatusNotFound, "not_found", "Company not found")
+		return
+	}
+	before := *company
+
+	var req companyRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	req.apply(company, r.Method == http.MethodPut)
+	if err := validateCompany(company); err != nil {
+		apiFail(w, err)
+		return
+	}
+	if err := db.UpdateCompany(company); err != nil {
+		apiFail(w, err)
+		return
+	}
+	recordAudit(r, AuditUpdate, "company", company.ID, &before, company)
+	writeJSON(w, http.StatusOK, company)
+}
+
+func apiDeleteCompany(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	company, err := db.GetCompany(id)
+	if err != nil {
+		apiError(w, http.StatusNotFound, "not_found", "Company not found")
+		return
+	}
+
+	if err := db.DeleteCompany(id); err != nil {
+		apiFail(w, err)
+		return
+	}
+	recordAudit(r, AuditDelete, "company", id, company, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// USERS
+
+func apiCurrentUser(w http.ResponseWriter, r *http.Request) {
+	user, err := currentUserRecord(r)
+	if err != nil {
+		apiError(w, http.StatusUnauthorized, "unauthenticated", "Authentication required")
+		return
+	}
+
+	permissions := rolePermissions[user.Role]
+	if permissions == nil {
+		permissions = []Permission{}
+	}
+	writeJSON(w, http.StatusOK, struct {
+		*User
+		Permissions []Permission `json:"permissions"`
+	}{user, permissions})
+}
+
+func apiListUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := db.GetAllUsers()
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	if users == nil {
+		users = []User{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"users": users})
+}
+
+// LICENSE
+
+type licenseStatus struct {
+	Configured bool     `json:"configured"`
+	Valid      bool     `json:"valid"`
+	Expired    bool     `json:"expired"`
+	Error      string   `json:"error,omitempty"`
+	License    *License `json:"license,omitempty"`
+}
+
+func apiLicense(w http.ResponseWriter, r *http.Request) {
+	licenseManager, err := NewLicenseManager()
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	status := licenseStatus{}
+	licenseKey := os.Getenv("AFCB_LICENSE_KEY")
+	if licenseKey != "" {
+		status.Configured = true
+		license, err := licenseManager.ValidateLicense(licenseKey)
+		if err != nil {
+			status.Error = err.Error()
+		} else {
+			status.License = license
+			status.Expired = time.Now().After(license.ExpiryDate)
+			status.Valid = !status.Expired
+		}
+	}
+	writeJSON(w, http.StatusOK, status)
+}
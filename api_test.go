@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestContactEditLeavesOtherLoginsAlone(t *testing.T) {
+	testDB := useTestDB(t)
+
+	editorCard := Contact{ID: "c1", ContactType: "Work", FirstName: "Eve", LastName: "Editor", Email: "eve@acme.test", Phone: "+44 20 7946 0958"}
+	ownCard := Contact{ID: "c2", ContactType: "Work", FirstName: "Sam", LastName: "Self", Email: "sam@acme.test", Phone: "+44 20 7946 0959"}
+	for _, c := range []*Contact{&editorCard, &ownCard} {
+		if err := insertContact(testDB, c); err != nil {
+			t.Fatalf("insertContact failed: %v", err)
+		}
+	}
+	users := []*User{
+		{Username: "boss@acme.test", Password: "boss-password", Role: RoleAdmin},
+		{Username: editorCard.Email, Password: "eve-password", ContactID: &editorCard.ID, Role: RoleEditor},
+		{Username: ownCard.Email, Password: "sam-password", ContactID: &ownCard.ID, Role: RoleContact},
+	}
+	for _, u := range users {
+		if err := testDB.CreateUser(u); err != nil {
+			t.Fatalf("CreateUser failed: %v", err)
+		}
+	}
+
+	update := func(as *User, id, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest("PATCH", "/api/v1/contacts/"+id, strings.NewReader(body))
+		r = withUser(mux.SetURLVars(r, map[string]string{"id": id}), as)
+		w := httptest.NewRecorder()
+		apiAllowContactEdit(apiUpdateContact).ServeHTTP(w, r)
+		return w
+	}
+	passwordOf := func(username string) string {
+		t.Helper()
+		u, err := testDB.GetUser(username)
+		if err != nil {
+			t.Fatalf("GetUser failed: %v", err)
+		}
+		return u.Password
+	}
+	works := func(username, password string) bool {
+		ok, _ := verifyPassword(passwordOf(username), password)
+		return ok
+	}
+
+	// an editor points a card at the admin's username and sets a password
+	w := update(users[1], ownCard.ID, `{"email": "BOSS@acme.test", "password": "taken-over"}`)
+	if w.Code != http.StatusConflict {
+		t.Errorf("email of another login: status %d, want %d: %s", w.Code, http.StatusConflict, w.Body)
+	}
+	if !works("boss@acme.test", "boss-password") {
+		t.Errorf("editing a card changed the password of a login not linked to it")
+	}
+
+	// a password set on a card goes to the card's own login only, which has
+	// to replace it and is signed out
+	for _, u := range users {
+		if _, _, err := testDB.CreateSession(u.Username, httptest.NewRequest("POST", "/login", nil)); err != nil {
+			t.Fatalf("CreateSession failed: %v", err)
+		}
+	}
+	if w := update(users[1], ownCard.ID, `{"password": "new-sam-password"}`); w.Code != http.StatusOK {
+		t.Fatalf("password change: status %d: %s", w.Code, w.Body)
+	}
+	if !works(ownCard.Email, "new-sam-password") || !works("boss@acme.test", "boss-password") || !works(editorCard.Email, "eve-password") {
+		t.Errorf("the password did not go to the linked login only")
+	}
+	for _, u := range users {
+		stored, _ := testDB.GetUser(u.Username)
+		var sessions int
+		testDB.QueryRow("SELECT COUNT(*) FROM sessions WHERE username = ?", u.Username).Scan(&sessions)
+		if changed := u == users[2]; stored.NeedPasswordChange != changed || (sessions == 0) != changed {
+			t.Errorf("%s after the password change: needs change %t, %d sessions", u.Username, stored.NeedPasswordChange, sessions)
+		}
+	}
+
+	// self-service users keep their email and change passwords elsewhere
+	for _, body := range []string{`{"email": "sam@elsewhere.test"}`, `{"password": "sneaky"}`} {
+		if w := update(users[2], ownCard.ID, body); w.Code != http.StatusForbidden {
+			t.Errorf("own card edit %s: status %d, want %d", body, w.Code, http.StatusForbidden)
+		}
+	}
+	if w := update(users[2], ownCard.ID, `{"first_name": "Samuel"}`); w.Code != http.StatusOK {
+		t.Errorf("own card edit: status %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Audit log. Mutating handlers call recordAudit after a change has been
+// committed. A failure to write the event is logged and never fails the
+// request that caused it.
+
+const (
+	AuditCreate          = "create"
+	AuditUpdate          = "update"
+	AuditDelete          = "delete"
+	AuditPasswordChange  = "password_change"
+	AuditLicenseActivate = "license_activate"
+	AuditRestore         = "restore"
+	AuditPurge           = "purge"
+	AuditMerge           = "merge"
+	AuditLockout         = "lockout"
+	AuditUnlock          = "unlock"
+)
+
+// auditActions and auditEntityTypes populate the filter drop-downs
+var auditActions = []string{AuditCreate, AuditUpdate, AuditDelete, AuditRestore, AuditPurge, AuditMerge, AuditPasswordChange, AuditLicenseActivate, AuditLockout, AuditUnlock}
+
+var auditEntityTypes = []string{"contact", "company", "user", "session", "invite", "api_token", "license", "custom_field", "tag", "contact_type"}
+
+// auditChange is one field's value before and after the change. Creates
+// only have To, deletes only have From.
+type auditChange struct {
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+type AuditEvent struct {
+	ID         int64                  `json:"id"`
+	CreatedAt  time.Time              `json:"created_at"`
+	Actor      string                 `json:"actor"`
+	Via        string                 `json:"via"`
+	Action     string                 `json:"action"`
+	EntityType string                 `json:"entity_type"`
+	EntityID   string                 `json:"entity_id"`
+	IP         string                 `json:"ip"`
+	Changes    map[string]auditChange `json:"changes,omitempty"`
+}
+
+// auditFields flattens v to its JSON fields, so json:"-" keeps passwords and
+// hashes out of the log
+func auditFields(v interface{}) map[string]interface{} {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+	delete(fields, "id")
+	return fields
+}
+
+// auditDiff returns the fields that differ between before and after. Either
+// side may be nil for creates and deletes, which then skip empty fields.
+func auditDiff(before, after interface{}) map[string]auditChange {
+	from, to := auditFields(before), auditFields(after)
+	changes := map[string]auditChange{}
+	for field, old := range from {
+		value, ok := to[field]
+		if !ok && (old == nil || old == "") {
+			continue
+		}
+		if !reflect.DeepEqual(old, value) {
+			changes[field] = auditChange{From: old, To: value}
+		}
+	}
+	for field, value := range to {
+		if _, ok := from[field]; !ok && value != nil && value != "" {
+			changes[field] = auditChange{To: value}
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+// auditActor names the signed-in user behind r
+func auditActor(r *http.Request) string {
+	user, err := currentUserRecord(r)
+	if err != nil {
+		return "anonymous"
+	}
+	return user.Username
+}
+
+// auditVia tells web forms, the JSON API and API tokens apart
+func auditVia(r *http.Request) string {
+	if token := apiTokenFromContext(r); token != nil {
+		return "token " + token.Prefix
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		return "api"
+	}
+	return "web"
+}
+
+// recordAudit stores one event for the change made by r
+func recordAudit(r *http.Request, action, entityType, entityID string, before, after interface{}) {
+	event := &AuditEvent{
+		CreatedAt:  time.Now().UTC(),
+		Actor:      auditActor(r),
+		Via:        auditVia(r),
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		IP:         clientIP(r),
+		Changes:    auditDiff(before, after),
+	}
+	if err := db.CreateAuditEvent(event); err != nil {
+		fmt.Printf("Warning: Failed to record audit event %s %s %s: %v\n", action, entityType, entityID, err)
+	}
+}
+
+// recordSystemAudit stores an event for a change made by a background job
+func recordSystemAudit(job, action, entityType, entityID string, before, after interface{}) {
+	event := &AuditEvent{
+		CreatedAt:  time.Now().UTC(),
+		Actor:      "system",
+		Via:        job,
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Changes:    auditDiff(before, after),
+	}
+	if err := db.CreateAuditEvent(event); err != nil {
+		fmt.Printf("Warning: Failed to record audit event %s %s %s: %v\n", action, entityType, entityID, err)
+	}
+}
+
+// auditContactUpdate records an edit of a contact card. Passwords never show
+// up in the diff, so a new one is logged as a separate event.
+func auditContactUpdate(r *http.Request, before, after *Contact, password string) {
+	recordAudit(r, AuditUpdate, "contact", after.ID, before, after)
+	if password != "" {
+		recordAudit(r, AuditPasswordChange, "user", after.Email, nil, nil)
+	}
+}
+
+func (db *DB) CreateAuditEvent(event *AuditEvent) error {
+	var changes sql.NullString
+	if len(event.Changes) > 0 {
+		data, err := json.Marshal(event.Changes)
+		if err != nil {
+			return err
+		}
+		changes = sql.NullString{String: string(data), Valid: true}
+	}
+
+	result, err := db.Exec(`INSERT INTO audit_events (created_at, actor, via, action, entity_type, entity_id, ip, changes)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		event.CreatedAt, event.Actor, event.Via, event.Action, event.EntityType, event.EntityID, event.IP, changes)
+	if err != nil {
+		return err
+	}
+	event.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// AuditFilter narrows the log. Empty fields match everything; From and To are
+// inclusive dates in YYYY-MM-DD form.
+type AuditFilter struct {
+	Actor      string
+	Action     string
+	EntityType string
+	EntityID   string
+	From       string
+	To         string
+}
+
+func auditFilterFromRequest(r *http.Request) AuditFilter {
+	q := r.URL.Query()
+	return AuditFilter{
+		Actor:      strings.TrimSpace(q.Get("actor")),
+		Action:     q.Get("action"),
+		EntityType: q.Get("entity_type"),
+		EntityID:   strings.TrimSpace(q.Get("entity_id")),
+		From:       q.Get("from"),
+		To:         q.Get("to"),
+	}
+}
+
+func (f AuditFilter) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		conds = append(conds, cond)
+		args = append(args, arg)
+	}
+
+	if f.Actor != "" {
+		add("actor = ?", f.Actor)
+	}
+	if f.Action != "" {
+		add("action = ?", f.Action)
+	}
+	if f.EntityType != "" {
+		add("entity_type = ?", f.EntityType)
+	}
+	if f.EntityID != "" {
+		add("entity_id = ?", f.EntityID)
+	}
+	if from, err := time.Parse("2006-01-02", f.From); err == nil {
+		add("created_at >= ?", from)
+	}
+	if to, err := time.Parse("2006-01-02", f.To); err == nil {
+		add("created_at < ?", to.AddDate(0, 0, 1))
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// values encodes the filter for page and export links
+func (f AuditFilter) values() url.Values {
+	v := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	set("actor", f.Actor)
+	set("action", f.Action)
+	set("entity_type", f.EntityType)
+	set("entity_id", f.EntityID)
+	set("from", f.From)
+	set("to", f.To)
+	return v
+}
+
+// ListAuditEvents returns one page of matching events, newest first, and the
+// total number of matches
+func (db *DB) ListAuditEvents(filter AuditFilter, opts ListOptions) ([]AuditEvent, int, error) {
+	where, args := filter.where()
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM audit_events"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`SELECT id, created_at, actor, via, action, entity_type, entity_id, ip, changes
+		FROM audit_events`+where+` ORDER BY created_at DESC, id DESC`+opts.limitClause(), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var events []AuditEvent
+	for rows.Next() {
+		var e AuditEvent
+		var changes sql.NullString
+		if err := rows.Scan(&e.ID, &e.CreatedAt, &e.Actor, &e.Via, &e.Action, &e.EntityType, &e.EntityID, &e.IP, &changes); err != nil {
+			return nil, 0, err
+		}
+		if changes.Valid {
+			if err := json.Unmarshal([]byte(changes.String), &e.Changes); err != nil {
+				fmt.Printf("Warning: Unreadable changes on audit event %d: %v\n", e.ID, err)
+			}
+		}
+		events = append(events, e)
+	}
+	return events, total, rows.Err()
+}
+
+type auditFieldChange struct {
+	Field string
+	From  string
+	To    string
+}
+
+// ChangeList returns the changes sorted by field name for display
+func (e AuditEvent) ChangeList() []auditFieldChange {
+	return changeList(e.Changes)
+}
+
+func changeList(changes map[string]auditChange) []auditFieldChange {
+	list := make([]auditFieldChange, 0, len(changes))
+	for field, change := range changes {
+		list = append(list, auditFieldChange{field, formatAuditValue(change.From), formatAuditValue(change.To)})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Field < list[j].Field })
+	return list
+}
+
+func formatAuditValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+// AUDIT PAGE
+
+type auditView struct {
+	Pager
+	Filter      AuditFilter
+	Events      []AuditEvent
+	Actions     []string
+	EntityTypes []string
+	CSRFToken   string
+}
+
+func (v auditView) query(page int) string {
+	values := v.Filter.values()
+	if page > 0 {
+		values.Set("page", fmt.Sprint(page))
+	}
+	return values.Encode()
+}
+
+func (v auditView) PrevQuery() string   { return v.query(v.Page - 1) }
+func (v auditView) NextQuery() string   { return v.query(v.Page + 1) }
+func (v auditView) ExportQuery() string { return v.query(0) }
+
+var auditTemplates = template.Must(template.New("audit").Parse(`
+{{define "audit-page"}}
+<!doctype html>
+<html lang="en">
+    <head>
+        <meta charset="UTF-8" />
+        <meta name="viewport" content="width=device-width, initial-scale=1.0" />
+        <title>Audit Log - AFCB</title>
+        <link rel="icon" type="image/x-icon" href="/static/favicon/favicon.ico" />
+        <script src="https://cdn.tailwindcss.com"></script>
+        <script src="https://unpkg.com/htmx.org@1.9.10"></script>
+        <script src="/static/csrf.js"></script>
+    </head>
+    <body class="bg-gray-100" hx-headers='{"X-CSRF-Token": "{{.CSRFToken}}"}'>
+        <nav class="bg-white shadow-md">
+            <div class="container mx-auto px-4">
+                <div class="flex justify-between items-center py-4">
+                    <a href="/" class="text-2xl font-bold text-blue-600">AFcb</a>
+                    <div class="flex items-center space-x-4">
+                        <a href="/" class="text-gray-600 hover:text-blue-600">Contacts</a>
+                        <a href="/companies-page" class="text-gray-600 hover:text-blue-600">Companies</a>
+                        <a href="/admin/users" class="text-gray-600 hover:text-blue-600">Users</a>
+                        <a href="/admin/fields" class="text-gray-600 hover:text-blue-600">Fields</a>
+                        <a href="/admin/tags" class="text-gray-600 hover:text-blue-600">Tags</a>
+                        <a href="/admin/contact-types" class="text-gray-600 hover:text-blue-600">Types</a>
+                        <a href="/admin/duplicates" class="text-gray-600 hover:text-blue-600">Duplicates</a>
+                        <a href="/admin/audit" class="text-blue-600 font-semibold">Audit Log</a>
+                        <a href="/admin/license" class="text-gray-600 hover:text-blue-600">Admin</a>
+                        <a href="/logout" class="px-4 py-2 bg-red-600 text-white rounded-md hover:bg-red-700">Logout</a>
+                    </div>
+                </div>
+            </div>
+        </nav>
+        <main class="container mx-auto px-4 py-8">
+            <h1 class="text-3xl font-bold text-gray-800 mb-6">Audit Log</h1>
+            <form class="bg-white rounded-lg shadow-md p-4 mb-6 flex flex-wrap items-end gap-4"
+                  hx-get="/admin/audit/events"
+                  hx-trigger="input delay:500ms, submit"
+                  hx-target="#audit-events"
+                  hx-swap="innerHTML">
+                <label class="text-sm text-gray-700">Actor
+                    <input type="text" name="actor" value="{{.Filter.Actor}}" class="block border rounded py-1 px-2">
+                </label>
+                <label class="text-sm text-gray-700">Action
+                    <select name="action" class="block border rounded py-1 px-2">
+                        <option value="">Any</option>
+                        {{range .Actions}}<option value="{{.}}" {{if eq . $.Filter.Action}}selected{{end}}>{{.}}</option>{{end}}
+                    </select>
+                </label>
+                <label class="text-sm text-gray-700">Entity
+                    <select name="entity_type" class="block border rounded py-1 px-2">
+                        <option value="">Any</option>
+                        {{range .EntityTypes}}<option value="{{.}}" {{if eq . $.Filter.EntityType}}selected{{end}}>{{.}}</option>{{end}}
+                    </select>
+                </label>
+                <label class="text-sm text-gray-700">Entity ID
+                    <input type="text" name="entity_id" value="{{.Filter.EntityID}}" class="block border rounded py-1 px-2">
+                </label>
+                <label class="text-sm text-gray-700">From
+                    <input type="date" name="from" value="{{.Filter.From}}" class="block border rounded py-1 px-2">
+                </label>
+                <label class="text-sm text-gray-700">To
+                    <input type="date" name="to" value="{{.Filter.To}}" class="block border rounded py-1 px-2">
+                </label>
+            </form>
+            <div id="audit-events">
+                {{template "audit-events" .}}
+            </div>
+        </main>
+    </body>
+</html>
+{{end}}
+
+{{define "audit-events"}}
+<div class="flex justify-between items-center mb-2 text-sm text-gray-600">
+    <span>{{if .Total}}Showing {{.First}}-{{.Last}} of {{.Total}} events{{else}}No events match{{end}}</span>
+    <span class="space-x-3">
+        <a href="/admin/audit/export?format=csv&{{.ExportQuery}}" class="text-blue-600 hover:underline">Export CSV</a>
+        <a href="/admin/audit/export?format=json&{{.ExportQuery}}" class="text-blue-600 hover:underline">Export JSON</a>
+    </span>
+</div>
+<div class="bg-white rounded-lg shadow-md overflow-hidden">
+    <table class="min-w-full divide-y divide-gray-200">
+        <thead class="bg-gray-50">
+            <tr>
+                <th class="px-4 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Time (UTC)</th>
+                <th class="px-4 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Actor</th>
+                <th class="px-4 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Action</th>
+                <th class="px-4 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Entity</th>
+                <th class="px-4 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Changes</th>
+            </tr>
+        </thead>
+        <tbody class="bg-white divide-y divide-gray-200">
+            {{range .Events}}
+            <tr class="align-top">
+                <td class="px-4 py-3 whitespace-nowrap text-sm text-gray-500">{{.CreatedAt.Format "2006-01-02 15:04:05"}}</td>
+                <td class="px-4 py-3 whitespace-nowrap text-sm">
+                    <div class="text-gray-900">{{.Actor}}</div>
+                    <div class="text-gray-500 text-xs">{{.Via}} &middot; {{.IP}}</div>
+                </td>
+                <td class="px-4 py-3 whitespace-nowrap text-sm text-gray-900">{{.Action}}</td>
+                <td class="px-4 py-3 whitespace-nowrap text-sm">
+                    <div class="text-gray-900">{{.EntityType}}</div>
+                    <div class="text-gray-500 text-xs">{{.EntityID}}</div>
+                </td>
+                <td class="px-4 py-3 text-sm">
+                    {{range .ChangeList}}
+                    <div>
+                        <span class="font-medium text-gray-700">{{.Field}}:</span>
+                        {{if .From}}<span class="text-red-600 line-through">{{.From}}</span>{{end}}
+                        {{if and .From .To}}&rarr;{{end}}
+                        {{if .To}}<span class="text-green-700">{{.To}}</span>{{end}}
+                    </div>
+                    {{else}}
+                    <span class="text-gray-400">-</span>
+                    {{end}}
+                </td>
+            </tr>
+            {{end}}
+        </tbody>
+    </table>
+</div>
+<div class="flex justify-end space-x-2 mt-4 text-sm">
+    {{if .HasPrev}}
+    <button class="px-3 py-1 border rounded bg-white hover:bg-gray-100"
+            hx-get="/admin/audit/events?{{.PrevQuery}}" hx-target="#audit-events" hx-swap="innerHTML">Previous</button>
+    {{end}}
+    {{if .HasNext}}
+    <button class="px-3 py-1 border rounded bg-white hover:bg-gray-100"
+            hx-get="/admin/audit/events?{{.NextQuery}}" hx-target="#audit-events" hx-swap="innerHTML">Next</button>
+    {{end}}
+</div>
+{{end}}
+`))
+
+func renderAudit(w http.ResponseWriter, r *http.Request, name string) {
+	filter := auditFilterFromRequest(r)
+	opts := listOptionsFromRequest(r, nil)
+
+	events, total, err := db.ListAuditEvents(filter, opts)
+	if err != nil {
+		http.Error(w, "Failed to load audit log: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	view := auditView{
+		Pager:       Pager{ListOptions: opts, Total: total},
+		Filter:      filter,
+		Events:      events,
+		Actions:     auditActions,
+		EntityTypes: auditEntityTypes,
+		CSRFToken:   csrfToken(r),
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := auditTemplates.ExecuteTemplate(w, name, view); err != nil {
+		fmt.Printf("Error rendering %s: %v\n", name, err)
+	}
+}
+
+func auditPageHandler(w http.ResponseWriter, r *http.Request) {
+	renderAudit(w, r, "audit-page")
+}
+
+func auditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	renderAudit(w, r, "audit-events")
+}
+
+// auditExportHandler serves GET /admin/audit/export?format=csv|json with the
+// same filters as the page, without paging
+func auditExportHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		http.Error(w, "Unsupported export format: "+format, http.StatusBadRequest)
+		return
+	}
+
+	events, _, err := db.ListAuditEvents(auditFilterFromRequest(r), ListOptions{})
+	if err != nil {
+		http.Error(w, "Export failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("audit_%s.%s", time.Now().Format("20060102"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	if format == "json" {
+		if events == nil {
+			events = []AuditEvent{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(events)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"Time", "Actor", "Via", "Action", "Entity Type", "Entity ID", "IP", "Changes"})
+	for _, e := range events {
+		changes := ""
+		if len(e.Changes) > 0 {
+			data, _ := json.Marshal(e.Changes)
+			changes = string(data)
+		}
+		cw.Write([]string{
+			e.CreatedAt.Format(time.RFC3339), csvSafe(e.Actor), e.Via, e.Action,
+			e.EntityType, csvSafe(e.EntityID), e.IP, csvSafe(changes),
+		})
+	}
+	cw.Flush()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAuditDiff(t *testing.T) {
+	acme := "acme"
+	before := &Contact{ID: "c1", ContactType: "Work", FirstName: "John", LastName: "Smith", Email: "john@acme.test", Phone: "111", Password: "old"}
+	after := *before
+	after.Phone = "222"
+	after.CompanyID = &acme
+	after.Password = "new"
+
+	got := auditDiff(before, &after)
+	want := map[string]auditChange{
+		"phone":      {From: "111", To: "222"},
+		"company_id": {From: nil, To: "acme"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("update diff = %v, want %v", got, want)
+	}
+
+	if got := auditDiff(before, before); got != nil {
+		t.Errorf("unchanged diff = %v, want nil", got)
+	}
+
+	created := auditDiff(nil, before)
+	if _, ok := created["password"]; ok {
+		t.Error("create diff must not contain the password")
+	}
+	if _, ok := created["id"]; ok {
+		t.Error("create diff must not repeat the id")
+	}
+	if created["email"].To != "john@acme.test" || created["email"].From != nil {
+		t.Errorf("create diff email = %v", created["email"])
+	}
+
+	deleted := auditDiff(before, nil)
+	if deleted["first_name"].From != "John" || deleted["first_name"].To != nil {
+		t.Errorf("delete diff first_name = %v", deleted["first_name"])
+	}
+}
+
+func TestListAuditEvents(t *testing.T) {
+	conn := openTestDB(t)
+	if _, err := migrateUp(conn, 0); err != nil {
+		t.Fatalf("migrateUp failed: %v", err)
+	}
+	testDB := &DB{DB: conn}
+
+	day := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	for i, e := range []AuditEvent{
+		{CreatedAt: day, Actor: "af", Action: AuditCreate, EntityType: "contact", EntityID: "c1"},
+		{CreatedAt: day.Add(time.Hour), Actor: "af", Action: AuditUpdate, EntityType: "contact", EntityID: "c1",
+			Changes: map[string]auditChange{"phone": {From: "111", To: "222"}}},
+		{CreatedAt: day.AddDate(0, 0, 1), Actor: "ed", Action: AuditDelete, EntityType: "company", EntityID: "k1"},
+	} {
+		e.Via = "web"
+		if err := testDB.CreateAuditEvent(&e); err != nil {
+			t.Fatalf("CreateAuditEvent %d failed: %v", i, err)
+		}
+	}
+
+	list := func(f AuditFilter, opts ListOptions) ([]string, int) {
+		t.Helper()
+		events, total, err := testDB.ListAuditEvents(f, opts)
+		if err != nil {
+			t.Fatalf("ListAuditEvents(%+v) failed: %v", f, err)
+		}
+		var actions []string
+		for _, e := range events {
+			actions = append(actions, e.Action)
+		}
+		return actions, total
+	}
+
+	if got, total := list(AuditFilter{}, ListOptions{}); !reflect.DeepEqual(got, []string{"delete", "update", "create"}) || total != 3 {
+		t.Errorf("all events = %v (%d), want newest first", got, total)
+	}
+	if got, _ := list(AuditFilter{EntityType: "contact", EntityID: "c1"}, ListOptions{}); len(got) != 2 {
+		t.Errorf("contact c1 events = %v, want 2", got)
+	}
+	if got, _ := list(AuditFilter{Actor: "ed"}, ListOptions{}); !reflect.DeepEqual(got, []string{"delete"}) {
+		t.Errorf("events by ed = %v, want [delete]", got)
+	}
+	// the To date is inclusive
+	if got, _ := list(AuditFilter{From: "2026-03-10", To: "2026-03-10"}, ListOptions{}); len(got) != 2 {
+		t.Errorf("events on 2026-03-10 = %v, want 2", got)
+	}
+	if got, total := list(AuditFilter{}, ListOptions{Page: 2, PerPage: 2}); !reflect.DeepEqual(got, []string{"create"}) || total != 3 {
+		t.Errorf("page 2 = %v (%d), want [create] of 3", got, total)
+	}
+
+	events, _, _ := testDB.ListAuditEvents(AuditFilter{Action: AuditUpdate}, ListOptions{})
+	if len(events) != 1 || events[0].Changes["phone"].To != "222" {
+		t.Errorf("update event changes = %+v", events)
+	}
+}
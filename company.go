@@ -1,13 +1,26 @@
 package main
 
+import "strings"
+
 type Company struct {
-	ID                       string
-	Name                     string
-	BankName                 string
-	AccountNumber            string
-	AccountDocumentPath      string
-	RegistrationNumber       string
-	RegistrationDocumentPath string
-	CreatedAt                string
-	CreatedBy                *string
+	ID                       string  `json:"id"`
+	Name                     string  `json:"name"`
+	BankName                 string  `json:"bank_name"`
+	AccountNumber            string  `json:"account_number"`
+	AccountDocumentPath      string  `json:"account_document"`
+	RegistrationNumber       string  `json:"registration_number"`
+	RegistrationDocumentPath string  `json:"registration_document"`
+	CreatedAt                string  `json:"created_at"`
+	CreatedBy                *string `json:"created_by"`
+
+	// values of admin-defined fields by name, see custom_fields.go
+	Custom map[string]string `json:"custom,omitempty"`
+}
+
+// validateCompany checks the field rules shared by the HTMX forms and the API
+func validateCompany(c *Company) error {
+	if strings.TrimSpace(c.Name) == "" {
+		return &ValidationError{Field: "name", Message: "Company name is required"}
+	}
+	return validateCustomValues("company", c.Custom)
 }
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Server settings. They come from built-in defaults, then a YAML file
+// (afcb.yaml if present, or the one given by -config or AFCB_CONFIG), then
+// AFCB_* environment variables and finally command line flags, each level
+// overriding the one before. The result is validated once at startup.
+
+type Config struct {
+	Listen      string `yaml:"listen"`
+	DBPath      string `yaml:"db_path"`
+	StaticDir   string `yaml:"static_dir"`
+	TemplateDir string `yaml:"template_dir"`
+
+	Uploads struct {
+		Dir       string `yaml:"dir"`
+		MaxSizeMB int64  `yaml:"max_size_mb"`
+	} `yaml:"uploads"`
+
+	// TLS is served when a certificate is given or self_signed is on
+	TLS struct {
+		CertFile     string        `yaml:"cert_file"`
+		KeyFile      string        `yaml:"key_file"`
+		SelfSigned   bool          `yaml:"self_signed"`   // generate the certificate files if missing
+		RedirectFrom string        `yaml:"redirect_from"` // plain HTTP address redirecting to HTTPS, e.g. :80
+		HSTSMaxAge   time.Duration `yaml:"hsts_max_age"`  // 0 sends no HSTS header
+	} `yaml:"tls"`
+
+	// Timeouts of the HTTP server. Write covers the slowest response, such
+	// as a large export; Shutdown is how long in-flight requests may finish
+	// after SIGINT or SIGTERM.
+	Timeouts struct {
+		Read     time.Duration `yaml:"read"`
+		Write    time.Duration `yaml:"write"`
+		Idle     time.Duration `yaml:"idle"`
+		Shutdown time.Duration `yaml:"shutdown"`
+	} `yaml:"timeouts"`
+
+	// Login throttling, see login_limit.go
+	Login struct {
+		MaxFailures int           `yaml:"max_failures"` // failed logins before an account is locked
+		Lockout     time.Duration `yaml:"lockout"`
+	} `yaml:"login"`
+
+	Session struct {
+		Lifetime    time.Duration `yaml:"lifetime"`
+		IdleTimeout time.Duration `yaml:"idle_timeout"`
+	} `yaml:"session"`
+
+	// PublicURL is how users reach the server, used for links in emails.
+	// Empty uses the listen address.
+	PublicURL string `yaml:"public_url"`
+
+	// Mail delivery, see mailer.go
+	Mail struct {
+		Driver string `yaml:"driver"` // "log", "file" or "smtp"
+		From   string `yaml:"from"`
+		Dir    string `yaml:"dir"` // where the file driver writes messages
+		SMTP   struct {
+			Host     string `yaml:"host"`
+			Port     int    `yaml:"port"`
+			Username string `yaml:"username"` // empty sends without authentication
+			Password string `yaml:"password"`
+		} `yaml:"smtp"`
+	} `yaml:"mail"`
+
+	// Invitations of new contacts, see invite.go
+	Invites struct {
+		Lifetime time.Duration `yaml:"lifetime"`
+	} `yaml:"invites"`
+
+	// Recycle bin, see trash.go
+	Trash struct {
+		RetentionDays int `yaml:"retention_days"` // 0 keeps deleted rows until purged by hand
+	} `yaml:"trash"`
+
+	// Phone numbers, see phone.go
+	Phone struct {
+		DefaultCountry string `yaml:"default_country"` // ISO 3166 code of numbers written without one
+	} `yaml:"phone"`
+
+	Log struct {
+		Level string `yaml:"level"` // "info" or "debug"
+		File  string `yaml:"file"`  // empty logs to the console
+	} `yaml:"log"`
+
+	// Admin is the account created on first start
+	Admin struct {
+		Username string `yaml:"username"`
+		Password string `yaml:"password"`
+	} `yaml:"admin"`
+}
+
+const defaultConfigFile = "afcb.yaml"
+
+// config holds the settings in effect. Tests run with the defaults.
+var config = defaultConfig()
+
+func defaultConfig() *Config {
+	c := &Config{
+		Listen:      ":1330",
+		DBPath:      "./afcb.db",
+		StaticDir:   "./static",
+		TemplateDir: "./templates",
+	}
+	c.Uploads.Dir = "./uploads"
+	c.Uploads.MaxSizeMB = 32
+	c.TLS.HSTSMaxAge = 365 * 24 * time.Hour
+	c.Timeouts.Read = time.Minute
+	c.Timeouts.Write = 2 * time.Minute
+	c.Timeouts.Idle = 2 * time.Minute
+	c.Timeouts.Shutdown = 30 * time.Second
+	c.Login.MaxFailures = 5
+	c.Login.Lockout = 15 * time.Minute
+	c.Session.Lifetime = 7 * 24 * time.Hour
+	c.Session.IdleTimeout = 2 * time.Hour
+	c.Mail.Driver = "log"
+	c.Mail.From = "AFcb <noreply@localhost>"
+	c.Mail.Dir = "./mail"
+	c.Mail.SMTP.Port = 587
+	c.Invites.Lifetime = 7 * 24 * time.Hour
+	c.Trash.RetentionDays = 30
+	c.Phone.DefaultCountry = "US"
+	c.Log.Level = "info"
+	c.Admin.Username = "af"
+	c.Admin.Password = "afcb"
+	return c
+}
+
+// loadConfig builds the configuration from the file, the environment and
+// the flags in args. It returns the arguments left after the flags, e.g. a
+// "migrate" command.
+func loadConfig(args []string) (*Config, []string, error) {
+	c := defaultConfig()
+
+	fs := flag.NewFlagSet("afcb", flag.ContinueOnError)
+	configFile := fs.String("config", "", "configuration file (default "+defaultConfigFile+" if it exists, or $AFCB_CONFIG)")
+	var flags Config
+	var sessionLifetime, sessionIdle, hstsMaxAge string
+	fs.StringVar(&flags.Listen, "listen", "", "address to listen on, e.g. :1330")
+	fs.StringVar(&flags.DBPath, "db", "", "SQLite database file")
+	fs.StringVar(&flags.StaticDir, "static-dir", "", "directory of the static files")
+	fs.StringVar(&flags.TemplateDir, "template-dir", "", "directory of the page templates")
+	fs.StringVar(&flags.Uploads.Dir, "upload-dir", "", "directory for uploaded documents")
+	fs.Int64Var(&flags.Uploads.MaxSizeMB, "upload-max-mb", 0, "largest accepted upload request in MB")
+	fs.StringVar(&flags.TLS.CertFile, "tls-cert", "", "TLS certificate file")
+	fs.StringVar(&flags.TLS.KeyFile, "tls-key", "", "TLS private key file")
+	fs.BoolVar(&flags.TLS.SelfSigned, "tls-self-signed", false, "generate a self-signed certificate if the files are missing")
+	fs.StringVar(&flags.TLS.RedirectFrom, "tls-redirect-from", "", "plain HTTP address redirecting to HTTPS, e.g. :80")
+	fs.StringVar(&hstsMaxAge, "hsts-max-age", "", "Strict-Transport-Security max-age, 0 to disable")
+	fs.StringVar(&sessionLifetime, "session-lifetime", "", "how long a login lasts, e.g. 168h")
+	fs.StringVar(&sessionIdle, "session-idle-timeout", "", "inactivity after which a login ends, e.g. 2h")
+	fs.IntVar(&flags.Trash.RetentionDays, "trash-retention-days", 0, "days deleted rows stay in the recycle bin, 0 to keep them")
+	fs.StringVar(&flags.Phone.DefaultCountry, "phone-country", "", "country of phone numbers without a country code, e.g. GB")
+	fs.StringVar(&flags.Log.Level, "log-level", "", "info or debug")
+	fs.StringVar(&flags.Log.File, "log-file", "", "append the log to this file instead of the console")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	// file
+	path, required := *configFile, true
+	if path == "" {
+		path = os.Getenv("AFCB_CONFIG")
+	}
+	if path == "" {
+		path, required = defaultConfigFile, false
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && err != io.EOF {
+			return nil, nil, fmt.Errorf("config file %s: %v", path, err)
+		}
+	case required || !errors.Is(err, os.ErrNotExist):
+		return nil, nil, fmt.Errorf("config file: %v", err)
+	}
+
+	// environment
+	var errs []error
+	envString := func(name string, dst *string) {
+		if v, ok := os.LookupEnv(name); ok {
+			*dst = v
+		}
+	}
+	envBool := func(name string, dst *bool) {
+		if v, ok := os.LookupEnv(name); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid boolean %q", name, v))
+			}
+			*dst = b
+		}
+	}
+	envDuration := func(name string, dst *time.Duration) {
+		if v, ok := os.LookupEnv(name); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid duration %q", name, v))
+			}
+			*dst = d
+		}
+	}
+	envString("AFCB_LISTEN", &c.Listen)
+	envString("AFCB_DB_PATH", &c.DBPath)
+	envString("AFCB_STATIC_DIR", &c.StaticDir)
+	envString("AFCB_TEMPLATE_DIR", &c.TemplateDir)
+	envString("AFCB_UPLOAD_DIR", &c.Uploads.Dir)
+	if v, ok := os.LookupEnv("AFCB_UPLOAD_MAX_MB"); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("AFCB_UPLOAD_MAX_MB: invalid number %q", v))
+		}
+		c.Uploads.MaxSizeMB = n
+	}
+	envString("AFCB_TLS_CERT", &c.TLS.CertFile)
+	envString("AFCB_TLS_KEY", &c.TLS.KeyFile)
+	envBool("AFCB_TLS_SELF_SIGNED", &c.TLS.SelfSigned)
+	envString("AFCB_TLS_REDIRECT_FROM", &c.TLS.RedirectFrom)
+	envDuration("AFCB_TLS_HSTS_MAX_AGE", &c.TLS.HSTSMaxAge)
+	envDuration("AFCB_READ_TIMEOUT", &c.Timeouts.Read)
+	envDuration("AFCB_WRITE_TIMEOUT", &c.Timeouts.Write)
+	envDuration("AFCB_IDLE_TIMEOUT", &c.Timeouts.Idle)
+	envDuration("AFCB_SHUTDOWN_TIMEOUT", &c.Timeouts.Shutdown)
+	if v, ok := os.LookupEnv("AFCB_LOGIN_MAX_FAILURES"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("AFCB_LOGIN_MAX_FAILURES: invalid number %q", v))
+		}
+		c.Login.MaxFailures = n
+	}
+	envDuration("AFCB_LOGIN_LOCKOUT", &c.Login.Lockout)
+	envDuration("AFCB_SESSION_LIFETIME", &c.Session.Lifetime)
+	envDuration("AFCB_SESSION_IDLE_TIMEOUT", &c.Session.IdleTimeout)
+	envString("AFCB_PUBLIC_URL", &c.PublicURL)
+	envString("AFCB_MAIL_DRIVER", &c.Mail.Driver)
+	envString("AFCB_MAIL_FROM", &c.Mail.From)
+	envString("AFCB_MAIL_DIR", &c.Mail.Dir)
+	envString("AFCB_SMTP_HOST", &c.Mail.SMTP.Host)
+	if v, ok := os.LookupEnv("AFCB_SMTP_PORT"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("AFCB_SMTP_PORT: invalid number %q", v))
+		}
+		c.Mail.SMTP.Port = n
+	}
+	envString("AFCB_SMTP_USERNAME", &c.Mail.SMTP.Username)
+	envString("AFCB_SMTP_PASSWORD", &c.Mail.SMTP.Password)
+	envDuration("AFCB_INVITE_LIFETIME", &c.Invites.Lifetime)
+	if v, ok := os.LookupEnv("AFCB_TRASH_RETENTION_DAYS"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("AFCB_TRASH_RETENTION_DAYS: invalid number %q", v))
+		}
+		c.Trash.RetentionDays = n
+	}
+	envString("AFCB_PHONE_COUNTRY", &c.Phone.DefaultCountry)
+	envString("AFCB_LOG_LEVEL", &c.Log.Level)
+	envString("AFCB_LOG_FILE", &c.Log.File)
+	envString("AFCB_ADMIN_USERNAME", &c.Admin.Username)
+	envString("AFCB_ADMIN_PASSWORD", &c.Admin.Password)
+
+	// flags, only those given
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			c.Listen = flags.Listen
+		case "db":
+			c.DBPath = flags.DBPath
+		case "static-dir":
+			c.StaticDir = flags.StaticDir
+		case "template-dir":
+			c.TemplateDir = flags.TemplateDir
+		case "upload-dir":
+			c.Uploads.Dir = flags.Uploads.Dir
+		case "upload-max-mb":
+			c.Uploads.MaxSizeMB = flags.Uploads.MaxSizeMB
+		case "tls-cert":
+			c.TLS.CertFile = flags.TLS.CertFile
+		case "tls-key":
+			c.TLS.KeyFile = flags.TLS.KeyFile
+		case "tls-self-signed":
+			c.TLS.SelfSigned = flags.TLS.SelfSigned
+		case "tls-redirect-from":
+			c.TLS.RedirectFrom = flags.TLS.RedirectFrom
+		case "session-lifetime", "session-idle-timeout", "hsts-max-age":
+			dst := &c.Session.Lifetime
+			switch f.Name {
+			case "session-idle-timeout":
+				dst = &c.Session.IdleTimeout
+			case "hsts-max-age":
+				dst = &c.TLS.HSTSMaxAge
+			}
+			d, err := time.ParseDuration(f.Value.String())
+			if err != nil {
+				errs = append(errs, fmt.Errorf("-%s: invalid duration %q", f.Name, f.Value))
+			}
+			*dst = d
+		case "trash-retention-days":
+			c.Trash.RetentionDays = flags.Trash.RetentionDays
+		case "phone-country":
+			c.Phone.DefaultCountry = flags.Phone.DefaultCountry
+		case "log-level":
+			c.Log.Level = flags.Log.Level
+		case "log-file":
+			c.Log.File = flags.Log.File
+		}
+	})
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+
+	if c.TLS.SelfSigned && c.TLS.CertFile == "" && c.TLS.KeyFile == "" {
+		c.TLS.CertFile, c.TLS.KeyFile = defaultSelfSignedCert, defaultSelfSignedKey
+	}
+	c.Phone.DefaultCountry = strings.ToUpper(strings.TrimSpace(c.Phone.DefaultCountry))
+
+	if err := c.validate(); err != nil {
+		return nil, nil, err
+	}
+	return c, fs.Args(), nil
+}
+
+// validate checks every setting and reports all problems at once
+func (c *Config) validate() error {
+	var errs []error
+	fail := func(setting, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", setting, fmt.Sprintf(format, args...)))
+	}
+
+	checkAddress := func(setting, addr string) {
+		if _, port, err := net.SplitHostPort(addr); err != nil {
+			fail(setting, "%q is not a host:port address", addr)
+		} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			fail(setting, "invalid port %q", port)
+		}
+	}
+	checkAddress("listen", c.Listen)
+
+	if c.DBPath == "" {
+		fail("db_path", "is required")
+	} else if dir := filepath.Dir(c.DBPath); !isDir(dir) {
+		fail("db_path", "directory %s does not exist", dir)
+	}
+	for _, d := range []struct{ setting, dir, file string }{
+		{"static_dir", c.StaticDir, "index.html"},
+		{"template_dir", c.TemplateDir, "companies.html"},
+	} {
+		if _, err := os.Stat(filepath.Join(d.dir, d.file)); err != nil {
+			fail(d.setting, "%s has no %s", d.dir, d.file)
+		}
+	}
+
+	if c.Uploads.Dir == "" {
+		fail("uploads.dir", "is required")
+	}
+	if c.Uploads.MaxSizeMB < 1 {
+		fail("uploads.max_size_mb", "must be at least 1")
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		fail("tls", "cert_file and key_file must be set together")
+	}
+	for _, f := range []struct{ setting, path string }{{"tls.cert_file", c.TLS.CertFile}, {"tls.key_file", c.TLS.KeyFile}} {
+		if f.path == "" {
+			continue
+		}
+		// self-signed files are created on startup
+		if _, err := os.Stat(f.path); err != nil && !(c.TLS.SelfSigned && errors.Is(err, os.ErrNotExist)) {
+			fail(f.setting, "%v", err)
+		}
+	}
+	if c.TLS.RedirectFrom != "" {
+		if !c.TLSEnabled() {
+			fail("tls.redirect_from", "needs TLS to be configured")
+		}
+		checkAddress("tls.redirect_from", c.TLS.RedirectFrom)
+	}
+	if c.TLS.HSTSMaxAge < 0 {
+		fail("tls.hsts_max_age", "cannot be negative")
+	}
+
+	for _, t := range []struct {
+		setting string
+		d       time.Duration
+	}{{"timeouts.read", c.Timeouts.Read}, {"timeouts.write", c.Timeouts.Write}, {"timeouts.idle", c.Timeouts.Idle}, {"timeouts.shutdown", c.Timeouts.Shutdown}} {
+		if t.d < time.Second {
+			fail(t.setting, "must be at least 1s")
+		}
+	}
+
+	if c.Login.MaxFailures < 1 {
+		fail("login.max_failures", "must be at least 1")
+	}
+	if c.Login.Lockout < time.Minute {
+		fail("login.lockout", "must be at least 1m")
+	}
+
+	if c.Session.Lifetime < time.Minute {
+		fail("session.lifetime", "must be at least 1m")
+	}
+	if c.Session.IdleTimeout < time.Minute {
+		fail("session.idle_timeout", "must be at least 1m")
+	}
+
+	if c.PublicURL != "" {
+		if u, err := url.Parse(c.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("public_url", "%q is not an http or https URL", c.PublicURL)
+		}
+	}
+
+	switch c.Mail.Driver {
+	case "log":
+	case "file":
+		if c.Mail.Dir == "" {
+			fail("mail.dir", "is required by the file driver")
+		}
+	case "smtp":
+		if c.Mail.SMTP.Host == "" {
+			fail("mail.smtp.host", "is required by the smtp driver")
+		}
+		if c.Mail.SMTP.Port < 1 || c.Mail.SMTP.Port > 65535 {
+			fail("mail.smtp.port", "invalid port %d", c.Mail.SMTP.Port)
+		}
+	default:
+		fail("mail.driver", "must be log, file or smtp, not %q", c.Mail.Driver)
+	}
+	if _, err := mail.ParseAddress(c.Mail.From); err != nil {
+		fail("mail.from", "%q is not an email address", c.Mail.From)
+	}
+	if c.Invites.Lifetime < time.Hour {
+		fail("invites.lifetime", "must be at least 1h")
+	}
+
+	if c.Trash.RetentionDays < 0 {
+		fail("trash.retention_days", "cannot be negative")
+	}
+	if !validPhoneCountry(c.Phone.DefaultCountry) {
+		fail("phone.default_country", "unknown country %q", c.Phone.DefaultCountry)
+	}
+
+	if c.Log.Level != "info" && c.Log.Level != "debug" {
+		fail("log.level", "must be info or debug, not %q", c.Log.Level)
+	}
+
+	if strings.TrimSpace(c.Admin.Username) == "" {
+		fail("admin.username", "is required")
+	}
+	if c.Admin.Password == "" {
+		fail("admin.password", "is required")
+	}
+
+	if len(errs) > 0 {
+		msg := "invalid configuration:"
+		for _, err := range errs {
+			msg += "\n  " + err.Error()
+		}
+		return errors.New(msg)
+	}
+	return nil
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// TLSEnabled reports whether the server speaks HTTPS
+func (c *Config) TLSEnabled() bool {
+	return c.TLS.CertFile != ""
+}
+
+// MaxUploadBytes is the size limit of upload requests
+func (c *Config) MaxUploadBytes() int64 {
+	return c.Uploads.MaxSizeMB << 20
+}
+
+// URL is the address the server can be reached at locally
+func (c *Config) URL() string {
+	host, port, _ := net.SplitHostPort(c.Listen)
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	scheme := "http"
+	if c.TLSEnabled() {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
+
+// BaseURL is the start of links sent to users
+func (c *Config) BaseURL() string {
+	if c.PublicURL != "" {
+		return strings.TrimRight(c.PublicURL, "/")
+	}
+	return c.URL()
+}
+
+func staticPath(name string) string {
+	return filepath.Join(config.StaticDir, name)
+}
+
+func templatePath(name string) string {
+	return filepath.Join(config.TemplateDir, name)
+}
+
+// setupLogging sends the console output to the log file, if one is set
+func setupLogging(c *Config) error {
+	if c.Log.File == "" {
+		return nil
+	}
+	f, err := os.OpenFile(c.Log.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("log.file: %v", err)
+	}
+	// most messages are fmt.Printf calls, which write to os.Stdout
+	os.Stdout = f
+	os.Stderr = f
+	log.SetOutput(f)
+	return nil
+}
+
+// debugf prints a message only at log level debug
+func debugf(format string, args ...interface{}) {
+	if config.Log.Level == "debug" {
+		fmt.Printf("DEBUG: "+format, args...)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "afcb.yaml")
+	yaml := `
+listen: ":8080"
+db_path: ` + filepath.Join(dir, "file.db") + `
+uploads:
+  max_size_mb: 10
+session:
+  lifetime: 24h
+log:
+  level: debug
+trash:
+  retention_days: 7
+phone:
+  default_country: de
+`
+	if err := os.WriteFile(file, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AFCB_CONFIG", file)
+	t.Setenv("AFCB_LISTEN", ":9090")
+	t.Setenv("AFCB_UPLOAD_MAX_MB", "20")
+	t.Setenv("AFCB_TRASH_RETENTION_DAYS", "14")
+
+	c, args, err := loadConfig([]string{"-listen", "127.0.0.1:7070", "-session-idle-timeout", "30m", "-phone-country", "gb", "migrate", "status"})
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if c.Listen != "127.0.0.1:7070" {
+		t.Errorf("listen = %q, want the flag", c.Listen)
+	}
+	if c.Uploads.MaxSizeMB != 20 {
+		t.Errorf("uploads.max_size_mb = %d, want the environment", c.Uploads.MaxSizeMB)
+	}
+	if c.Trash.RetentionDays != 14 || c.Phone.DefaultCountry != "GB" {
+		t.Errorf("trash.retention_days = %d, phone.default_country = %q; want the environment and the flag", c.Trash.RetentionDays, c.Phone.DefaultCountry)
+	}
+	if c.DBPath != filepath.Join(dir, "file.db") || c.Session.Lifetime != 24*time.Hour || c.Log.Level != "debug" {
+		t.Errorf("file settings not applied: %+v", c)
+	}
+	if c.Session.IdleTimeout != 30*time.Minute || c.Uploads.Dir != "./uploads" || c.Admin.Username != "af" {
+		t.Errorf("defaults or flags not applied: %+v", c)
+	}
+	if len(args) != 2 || args[0] != "migrate" {
+		t.Errorf("remaining args = %v", args)
+	}
+	if c.URL() != "http://127.0.0.1:7070" {
+		t.Errorf("URL = %q", c.URL())
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	t.Setenv("AFCB_CONFIG", "")
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want []string
+	}{
+		{"missing config file", []string{"-config", "/nonexistent/afcb.yaml"}, nil, []string{"config file"}},
+		{"bad env duration", nil, map[string]string{"AFCB_SESSION_LIFETIME": "a week"}, []string{"AFCB_SESSION_LIFETIME"}},
+		{"bad flag duration", []string{"-session-lifetime", "7"}, nil, []string{"-session-lifetime"}},
+		{"all invalid settings reported", []string{"-listen", "1330", "-upload-max-mb", "0", "-tls-cert", "cert.pem", "-log-level", "trace"}, nil,
+			[]string{"listen:", "uploads.max_size_mb", "tls:", "tls.cert_file", "log.level"}},
+		{"db directory", []string{"-db", "/nonexistent/afcb.db"}, nil, []string{"db_path"}},
+		{"redirect without TLS", []string{"-tls-redirect-from", ":80"}, nil, []string{"tls.redirect_from"}},
+		{"bad env number", nil, map[string]string{"AFCB_TRASH_RETENTION_DAYS": "a month"}, []string{"AFCB_TRASH_RETENTION_DAYS"}},
+		{"trash and phone", []string{"-trash-retention-days", "-1", "-phone-country", "XX"}, nil,
+			[]string{"trash.retention_days", "phone.default_country"}},
+		{"bad env boolean", nil, map[string]string{"AFCB_TLS_SELF_SIGNED": "maybe"}, []string{"AFCB_TLS_SELF_SIGNED"}},
+		{"smtp without host", nil, map[string]string{"AFCB_MAIL_DRIVER": "smtp", "AFCB_MAIL_FROM": "nobody", "AFCB_PUBLIC_URL": "crm.example.com"},
+			[]string{"mail.smtp.host", "mail.from", "public_url"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, _, err := loadConfig(tt.args)
+			if err == nil {
+				t.Fatalf("loadConfig(%v) succeeded", tt.args)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigSelfSigned(t *testing.T) {
+	t.Setenv("AFCB_CONFIG", "")
+	c, _, err := loadConfig([]string{"-tls-self-signed", "-tls-redirect-from", ":8080"})
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if c.TLS.CertFile != defaultSelfSignedCert || c.TLS.KeyFile != defaultSelfSignedKey || c.URL() != "https://localhost:1330" {
+		t.Errorf("self-signed TLS settings = %+v, URL %s", c.TLS, c.URL())
+	}
+}
+
+func TestLoadConfigUnknownKey(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "afcb.yaml")
+	if err := os.WriteFile(file, []byte("listn: \":8080\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadConfig([]string{"-config", file}); err == nil || !strings.Contains(err.Error(), "listn") {
+		t.Errorf("loadConfig with a misspelled key = %v, want an error naming it", err)
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 
 	gonanoid "github.com/matoous/go-nanoid"
@@ -9,16 +11,54 @@ import (
 
 // struct for contact details
 type Contact struct {
-	ID          string
-	ContactType string
-	FirstName   string
-	LastName    string
-	Email       string
-	Phone       string
-	Password    string
-	CompanyID   *string
+	ID          string  `json:"id"`
+	ContactType string  `json:"contact_type"`
+	FirstName   string  `json:"first_name"`
+	LastName    string  `json:"last_name"`
+	Email       string  `json:"email"`
+	Phone       string  `json:"phone"`
+	PhoneE164   string  `json:"phone_e164,omitempty"` // see phone.go
+	Password    string  `json:"-"`
+	CompanyID   *string `json:"company_id"`
+
+	// further labeled entries, see contact_details.go
+	Emails    []ContactEmail   `json:"emails,omitempty"`
+	Phones    []ContactPhone   `json:"phones,omitempty"`
+	Addresses []ContactAddress `json:"addresses,omitempty"`
+
+	// values of admin-defined fields by name, see custom_fields.go
+	Custom map[string]string `json:"custom,omitempty"`
+
+	// names of the contact's tags, see tags.go
+	Tags []string `json:"tags,omitempty"`
+}
+
+// ValidationError reports a single invalid field. It is shared by the HTMX
+// handlers, which show Message, and the JSON API, which also returns Field.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
 }
 
+var errEmailTaken = errors.New("a contact with this email already exists")
+
+// errEmailInTrash wraps errEmailTaken: the email stays reserved while its
+// contact sits in the recycle bin
+var errEmailInTrash = fmt.Errorf("%w in the recycle bin", errEmailTaken)
+
+// errEmailIsLogin counts as errEmailTaken: the email is the username of a
+// login that belongs to someone else
+var errEmailIsLogin error = emailTakenError("this email is the username of another login")
+
+type emailTakenError string
+
+func (e emailTakenError) Error() string { return string(e) }
+func (e emailTakenError) Unwrap() error { return errEmailTaken }
+
 // generate unique 6-character ID using custom alphabet & numbers
 func genID() (string, error) {
 	id, err := gonanoid.Generate("drofylla12301993", 6)
@@ -31,3 +71,149 @@ func genID() (string, error) {
 func isValidEmail(email string) bool {
 	return strings.Contains(email, "@") && strings.Contains(email, ".")
 }
+
+// validateContact checks the field rules for creating or updating a contact
+func validateContact(c *Contact) error {
+	required := []struct{ field, label, value string }{
+		{"contact_type", "Contact type", c.ContactType},
+		{"first_name", "First name", c.FirstName},
+		{"last_name", "Last name", c.LastName},
+		{"email", "Email", c.Email},
+		{"phone", "Phone", c.Phone},
+	}
+	for _, r := range required {
+		if strings.TrimSpace(r.value) == "" {
+			return &ValidationError{Field: r.field, Message: r.label + " is required"}
+		}
+	}
+
+	if !emailRegex.MatchString(c.Email) {
+		return &ValidationError{Field: "email", Message: "Invalid email address format"}
+	}
+	e164, err := validatePhone("phone", c.Phone)
+	if err != nil {
+		return err
+	}
+	c.Phone, c.PhoneE164 = strings.TrimSpace(c.Phone), e164
+
+	if err := validateContactType(c); err != nil {
+		return err
+	}
+	if err := validateContactDetails(c); err != nil {
+		return err
+	}
+	if err := validateCustomValues("contact", c.Custom); err != nil {
+		return err
+	}
+	if err := validateContactTags(c); err != nil {
+		return err
+	}
+
+	if c.CompanyID != nil {
+		if _, err := db.GetCompany(*c.CompanyID); err != nil {
+			return &ValidationError{Field: "company_id", Message: "Company does not exist"}
+		}
+	}
+	return nil
+}
+
+// checkEmailAvailable returns errEmailTaken when another contact than
+// exceptID already uses email, or a login not linked to exceptID has it as
+// its username
+func checkEmailAvailable(email, exceptID string) error {
+	existing, err := db.GetContactByEmail(email)
+	if err == nil && existing != nil && existing.ID != exceptID {
+		return errEmailTaken
+	}
+	if db.EmailInTrash(email) {
+		return errEmailInTrash
+	}
+	if db.EmailIsOtherLogin(email, exceptID) {
+		return errEmailIsLogin
+	}
+	return nil
+}
+
+// checkOwnContactEdit stops users who may only edit their own card from
+// changing its email or their password through it. Both are the login's
+// business: the email is what an admin invites, the password is changed on
+// the change password page.
+func checkOwnContactEdit(r *http.Request, before, after *Contact, password string) error {
+	if hasPermission(r, PermEditContacts) {
+		return nil
+	}
+	if !strings.EqualFold(before.Email, after.Email) {
+		return &ValidationError{Field: "email", Message: "Ask an administrator to change your email address"}
+	}
+	if password != "" {
+		return &ValidationError{Field: "password", Message: "Change your password on the change password page"}
+	}
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// createContactWithUser stores a new contact and the login account that
+// goes with it. Without a password nobody can sign in to the account until
+// the contact chooses one through the invitation they are emailed.
+func createContactWithUser(r *http.Request, contact *Contact, password string) error {
+	invite := password == ""
+	contact.Password = password
+
+	if err := db.CreateContact(contact); err != nil {
+		if isUniqueViolation(err) {
+			return errEmailTaken
+		}
+		return err
+	}
+
+	// Create user account for this contact
+	user := &User{
+		Username:           contact.Email,
+		Password:           password,
+		ContactID:          &contact.ID,
+		NeedPasswordChange: true,
+		Role:               RoleContact,
+	}
+
+	// Check if user already exists
+	if _, err := db.GetUser(contact.Email); err != nil {
+		// User doesn't exist, create new one
+		if err := db.CreateUser(user); err != nil {
+			fmt.Printf("Warning: Failed to create user account for contact: %v\n", err)
+		} else {
+			fmt.Printf("User account created for contact: %s\n", contact.Email)
+			if invite {
+				inviteNewUsers(r, []string{contact.Email})
+			}
+		}
+	} else {
+		fmt.Printf("Warning: User account already exists for email: %s\n", contact.Email)
+	}
+	return nil
+}
+
+// updateContactWithUser saves an edited contact. A non-empty password is
+// also set on the login linked to the contact; no other login is touched,
+// whatever the card's email says.
+func updateContactWithUser(contact *Contact, password string) error {
+	if password != "" {
+		contact.Password = password
+	}
+
+	if err := db.UpdateContact(contact); err != nil {
+		if isUniqueViolation(err) {
+			return errEmailTaken
+		}
+		return err
+	}
+
+	if password != "" {
+		if err := db.UpdateContactUserPassword(contact.ID, password); err != nil {
+			fmt.Printf("Warning: Failed to update user password: %v\n", err)
+		}
+	}
+	return nil
+}
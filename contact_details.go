@@ -0,0 +1,358 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// Contact details. The primary email and phone stay on the contacts row,
+// where the email doubles as the login name; any further emails, phones and
+// postal addresses are labeled entries in contact_emails, contact_phones and
+// contact_addresses, kept in the order they were entered.
+
+type ContactEmail struct {
+	Label string `json:"label"`
+	Email string `json:"email"`
+}
+
+type ContactPhone struct {
+	Label string `json:"label"`
+	Phone string `json:"phone"`
+	E164  string `json:"e164,omitempty"`
+}
+
+type ContactAddress struct {
+	Label      string `json:"label"`
+	Street     string `json:"street"`
+	City       string `json:"city"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+}
+
+// String formats the address on one line, skipping empty parts
+func (a ContactAddress) String() string {
+	var parts []string
+	for _, p := range []string{a.Street, a.City, a.Region, a.PostalCode, a.Country} {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// labels offered by the contact forms; entries without one are "other"
+var (
+	emailLabels   = []string{"work", "home", "other"}
+	phoneLabels   = []string{"mobile", "work", "home", "fax", "other"}
+	addressLabels = []string{"work", "home", "other"}
+)
+
+// normalizeLabel lowercases label and checks it against allowed
+func normalizeLabel(label string, allowed []string) (string, bool) {
+	label = strings.ToLower(strings.TrimSpace(label))
+	if label == "" {
+		return "other", true
+	}
+	for _, l := range allowed {
+		if l == label {
+			return label, true
+		}
+	}
+	return "", false
+}
+
+// validateContactDetails checks the extra entries of c, normalizing their
+// labels and trimming their values on the way
+func validateContactDetails(c *Contact) error {
+	badLabel := func(field, label string, allowed []string) error {
+		return &ValidationError{Field: field, Message: fmt.Sprintf("Unknown label %q, use one of %s", label, strings.Join(allowed, ", "))}
+	}
+
+	for i := range c.Emails {
+		e := &c.Emails[i]
+		label, ok := normalizeLabel(e.Label, emailLabels)
+		if !ok {
+			return badLabel("emails", e.Label, emailLabels)
+		}
+		e.Label, e.Email = label, strings.TrimSpace(e.Email)
+		if !emailRegex.MatchString(e.Email) {
+			return &ValidationError{Field: "emails", Message: fmt.Sprintf("Invalid email address %q", e.Email)}
+		}
+	}
+	for i := range c.Phones {
+		p := &c.Phones[i]
+		label, ok := normalizeLabel(p.Label, phoneLabels)
+		if !ok {
+			return badLabel("phones", p.Label, phoneLabels)
+		}
+		p.Label, p.Phone = label, strings.TrimSpace(p.Phone)
+		if p.Phone == "" {
+			return &ValidationError{Field: "phones", Message: "Phone number is required"}
+		}
+		e164, err := validatePhone("phones", p.Phone)
+		if err != nil {
+			return err
+		}
+		p.E164 = e164
+	}
+	for i := range c.Addresses {
+		a := &c.Addresses[i]
+		label, ok := normalizeLabel(a.Label, addressLabels)
+		if !ok {
+			return badLabel("addresses", a.Label, addressLabels)
+		}
+		a.Label = label
+		for _, s := range []*string{&a.Street, &a.City, &a.Region, &a.PostalCode, &a.Country} {
+			*s = strings.TrimSpace(*s)
+		}
+		if a.String() == "" {
+			return &ValidationError{Field: "addresses", Message: "Address is empty"}
+		}
+	}
+	return nil
+}
+
+// contactDetailsFromForm reads the repeated detail rows of the contact
+// modals. Rows left blank are dropped.
+func contactDetailsFromForm(r *http.Request, c *Contact) {
+	at := func(values []string, i int) string {
+		if i < len(values) {
+			return values[i]
+		}
+		return ""
+	}
+
+	c.Emails = nil
+	labels, values := r.Form["EmailLabel"], r.Form["OtherEmail"]
+	for i, value := range values {
+		if strings.TrimSpace(value) != "" {
+			c.Emails = append(c.Emails, ContactEmail{Label: at(labels, i), Email: value})
+		}
+	}
+
+	c.Phones = nil
+	labels, values = r.Form["PhoneLabel"], r.Form["OtherPhone"]
+	for i, value := range values {
+		if strings.TrimSpace(value) != "" {
+			c.Phones = append(c.Phones, ContactPhone{Label: at(labels, i), Phone: value})
+		}
+	}
+
+	c.Addresses = nil
+	labels = r.Form["AddressLabel"]
+	for i := range labels {
+		a := ContactAddress{
+			Label:      labels[i],
+			Street:     at(r.Form["AddressStreet"], i),
+			City:       at(r.Form["AddressCity"], i),
+			Region:     at(r.Form["AddressRegion"], i),
+			PostalCode: at(r.Form["AddressPostalCode"], i),
+			Country:    at(r.Form["AddressCountry"], i),
+		}
+		if a.String() != "" {
+			c.Addresses = append(c.Addresses, a)
+		}
+	}
+}
+
+// SQL expressions joining the extra emails, phones and addresses of the
+// contact whose id is %s into one searchable value. They contain no commas,
+// so likeConditions can use them as columns.
+const (
+	contactEmailsText    = "(SELECT group_concat(email) FROM contact_emails WHERE contact_id = %s)"
+	contactPhonesText    = "(SELECT group_concat(phone) FROM contact_phones WHERE contact_id = %s)"
+	contactAddressesText = "(SELECT group_concat(street || ' ' || city || ' ' || region || ' ' || postal_code || ' ' || country) FROM contact_addresses WHERE contact_id = %s)"
+)
+
+func insertContactDetails(ex execer, c *Contact) error {
+	for i, e := range c.Emails {
+		if _, err := ex.Exec("INSERT INTO contact_emails (contact_id, position, label, email) VALUES (?, ?, ?, ?)",
+			c.ID, i, e.Label, e.Email); err != nil {
+			return err
+		}
+	}
+	for i, p := range c.Phones {
+		if _, err := ex.Exec("INSERT INTO contact_phones (contact_id, position, label, phone, phone_e164) VALUES (?, ?, ?, ?, ?)",
+			c.ID, i, p.Label, p.Phone, storedE164(p.E164)); err != nil {
+			return err
+		}
+	}
+	for i, a := range c.Addresses {
+		if _, err := ex.Exec(`INSERT INTO contact_addresses
+			(contact_id, position, label, street, city, region, postal_code, country)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			c.ID, i, a.Label, a.Street, a.City, a.Region, a.PostalCode, a.Country); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteContactDetails(ex execer, contactID string) error {
+	for _, table := range []string{"contact_emails", "contact_phones", "contact_addresses"} {
+		if _, err := ex.Exec("DELETE FROM "+table+" WHERE contact_id = ?", contactID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attachContactDetails loads the extra entries of every contact in contacts
+func (db *DB) attachContactDetails(contacts []Contact) error {
+	index := make(map[string]int, len(contacts))
+	ids := make([]interface{}, len(contacts))
+	for i := range contacts {
+		index[contacts[i].ID] = i
+		ids[i] = contacts[i].ID
+		contacts[i].Emails, contacts[i].Phones, contacts[i].Addresses = nil, nil, nil
+	}
+
+	// stay well below SQLite's limit on bound parameters
+	const chunkSize = 500
+	for start := 0; start < len(ids); start += chunkSize {
+		chunk := ids[start:min(start+chunkSize, len(ids))]
+		each := func(table, columns string, scan func(rows *sql.Rows) error) error {
+			rows, err := db.Query("SELECT contact_id, "+columns+" FROM "+table+
+				" WHERE contact_id IN (?"+strings.Repeat(", ?", len(chunk)-1)+") ORDER BY contact_id, position", chunk...)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			for rows.Next() {
+				if err := scan(rows); err != nil {
+					return err
+				}
+			}
+			return rows.Err()
+		}
+
+		err := each("contact_emails", "label, email", func(rows *sql.Rows) error {
+			var id string
+			var e ContactEmail
+			if err := rows.Scan(&id, &e.Label, &e.Email); err != nil {
+				return err
+			}
+			c := &contacts[index[id]]
+			c.Emails = append(c.Emails, e)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = each("contact_phones", "label, phone, COALESCE(phone_e164, '')", func(rows *sql.Rows) error {
+			var id string
+			var p ContactPhone
+			if err := rows.Scan(&id, &p.Label, &p.Phone, &p.E164); err != nil {
+				return err
+			}
+			c := &contacts[index[id]]
+			c.Phones = append(c.Phones, p)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = each("contact_addresses", "label, street, city, region, postal_code, country", func(rows *sql.Rows) error {
+			var id string
+			var a ContactAddress
+			if err := rows.Scan(&id, &a.Label, &a.Street, &a.City, &a.Region, &a.PostalCode, &a.Country); err != nil {
+				return err
+			}
+			c := &contacts[index[id]]
+			c.Addresses = append(c.Addresses, a)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// contactDetailsHTML holds the detail rows shared by the add and edit
+// modals. New rows are cloned from the <template> elements by addDetailRow
+// in script.js.
+var contactDetailsHTML = `
+{{define "label-options"}}{{$selected := .Selected}}{{range .Labels}}<option value="{{.}}" {{if eq . $selected}}selected{{end}}>{{.}}</option>{{end}}{{end}}
+
+{{define "contact-details"}}
+<div class="mb-4">
+    <label class="block text-gray-700 text-sm font-bold mb-2">Other Emails</label>
+    <div id="other-emails">
+        {{range .Emails}}{{template "email-row" detailRow .Label .Email}}{{end}}
+    </div>
+    <template id="email-row-template">{{template "email-row" detailRow "" ""}}</template>
+    <button type="button" onclick="addDetailRow('email-row-template', 'other-emails')" class="text-sm text-blue-600 hover:text-blue-900">+ Add email</button>
+</div>
+<div class="mb-4">
+    <label class="block text-gray-700 text-sm font-bold mb-2">Other Phones</label>
+    <div id="other-phones">
+        {{range .Phones}}{{template "phone-row" detailRow .Label .Phone}}{{end}}
+    </div>
+    <template id="phone-row-template">{{template "phone-row" detailRow "" ""}}</template>
+    <button type="button" onclick="addDetailRow('phone-row-template', 'other-phones')" class="text-sm text-blue-600 hover:text-blue-900">+ Add phone</button>
+</div>
+<div class="mb-4">
+    <label class="block text-gray-700 text-sm font-bold mb-2">Addresses</label>
+    <div id="addresses">
+        {{range .Addresses}}{{template "address-row" .}}{{end}}
+    </div>
+    <template id="address-row-template">{{template "address-row" emptyAddress}}</template>
+    <button type="button" onclick="addDetailRow('address-row-template', 'addresses')" class="text-sm text-blue-600 hover:text-blue-900">+ Add address</button>
+</div>
+{{end}}
+
+{{define "email-row"}}
+<div class="detail-row flex items-center mb-2">
+    <select name="EmailLabel" class="shadow border rounded py-2 px-2 text-gray-700 text-sm mr-1">{{template "label-options" labelOptions "email" .Label}}</select>
+    <input name="OtherEmail" type="email" value="{{.Value}}" placeholder="Email" class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
+    <button type="button" onclick="this.closest('.detail-row').remove()" class="ml-1 text-gray-400 hover:text-red-600" title="Remove">&times;</button>
+</div>
+{{end}}
+
+{{define "phone-row"}}
+<div class="detail-row flex items-center mb-2">
+    <select name="PhoneLabel" class="shadow border rounded py-2 px-2 text-gray-700 text-sm mr-1">{{template "label-options" labelOptions "phone" .Label}}</select>
+    <input name="OtherPhone" type="tel" value="{{.Value}}" placeholder="Phone" class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
+    <button type="button" onclick="this.closest('.detail-row').remove()" class="ml-1 text-gray-400 hover:text-red-600" title="Remove">&times;</button>
+</div>
+{{end}}
+
+{{define "address-row"}}
+<div class="detail-row border rounded p-2 mb-2">
+    <div class="flex items-center justify-between mb-1">
+        <select name="AddressLabel" class="shadow border rounded py-1 px-2 text-gray-700 text-sm">{{template "label-options" labelOptions "address" .Label}}</select>
+        <button type="button" onclick="this.closest('.detail-row').remove()" class="text-gray-400 hover:text-red-600" title="Remove">&times;</button>
+    </div>
+    <input name="AddressStreet" type="text" value="{{.Street}}" placeholder="Street" class="shadow appearance-none border rounded w-full py-1 px-2 mb-1 text-gray-700 text-sm">
+    <div class="flex">
+        <input name="AddressCity" type="text" value="{{.City}}" placeholder="City" class="shadow appearance-none border rounded w-1/2 py-1 px-2 mb-1 mr-1 text-gray-700 text-sm">
+        <input name="AddressRegion" type="text" value="{{.Region}}" placeholder="Region" class="shadow appearance-none border rounded w-1/2 py-1 px-2 mb-1 text-gray-700 text-sm">
+    </div>
+    <div class="flex">
+        <input name="AddressPostalCode" type="text" value="{{.PostalCode}}" placeholder="Postal code" class="shadow appearance-none border rounded w-1/2 py-1 px-2 mr-1 text-gray-700 text-sm">
+        <input name="AddressCountry" type="text" value="{{.Country}}" placeholder="Country" class="shadow appearance-none border rounded w-1/2 py-1 px-2 text-gray-700 text-sm">
+    </div>
+</div>
+{{end}}
+`
+
+var contactDetailsFuncs = template.FuncMap{
+	"detailRow": func(label, value string) map[string]string {
+		return map[string]string{"Label": label, "Value": value}
+	},
+	"labelOptions": func(kind, selected string) map[string]interface{} {
+		labels := map[string][]string{"email": emailLabels, "phone": phoneLabels, "address": addressLabels}[kind]
+		if selected == "" {
+			selected = labels[0]
+		}
+		return map[string]interface{}{"Labels": labels, "Selected": selected}
+	},
+	"emptyAddress": func() ContactAddress { return ContactAddress{} },
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Contact types. Every contact has exactly one; admins manage the list, its
+// order in the forms and the badge colors on /admin/contact-types. Contacts
+// store the type by name, so renaming a type renames it on its contacts.
+
+type ContactType struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Color    string `json:"color"`
+	Position int    `json:"position"`
+}
+
+// Classes returns the Tailwind classes of the type's badge
+func (t ContactType) Classes() string {
+	return badgeClasses(t.Color)
+}
+
+func validateContactTypeDef(t *ContactType) error {
+	t.Name = strings.Join(strings.Fields(t.Name), " ")
+	if t.Name == "" {
+		return &ValidationError{Field: "name", Message: "Type name is required"}
+	}
+	if len(t.Name) > 40 {
+		return &ValidationError{Field: "name", Message: "Type names are at most 40 characters"}
+	}
+	if _, ok := badgeColorClasses[t.Color]; !ok {
+		return &ValidationError{Field: "color", Message: "Unknown color"}
+	}
+	return nil
+}
+
+// validateContactType checks that the type of c exists and replaces it by
+// its stored spelling
+func validateContactType(c *Contact) error {
+	types, err := db.ListContactTypes()
+	if err != nil {
+		return err
+	}
+	name := strings.TrimSpace(c.ContactType)
+	for _, t := range types {
+		if strings.EqualFold(t.Name, name) {
+			c.ContactType = t.Name
+			return nil
+		}
+	}
+	return &ValidationError{Field: "contact_type", Message: fmt.Sprintf("Unknown contact type %q", name)}
+}
+
+// DATABASE
+
+func (db *DB) ListContactTypes() ([]ContactType, error) {
+	rows, err := db.Query("SELECT id, name, color, position FROM contact_types ORDER BY position, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var types []ContactType
+	for rows.Next() {
+		var t ContactType
+		if err := rows.Scan(&t.ID, &t.Name, &t.Color, &t.Position); err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+	}
+	return types, rows.Err()
+}
+
+func (db *DB) GetContactType(id int64) (*ContactType, error) {
+	var t ContactType
+	err := db.QueryRow("SELECT id, name, color, position FROM contact_types WHERE id = ?", id).
+		Scan(&t.ID, &t.Name, &t.Color, &t.Position)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// CreateContactType appends a type after the existing ones
+func (db *DB) CreateContactType(t *ContactType) error {
+	result, err := db.Exec(`INSERT INTO contact_types (name, color, position)
+		VALUES (?, ?, (SELECT COALESCE(MAX(position), 0) + 1 FROM contact_types))`, t.Name, t.Color)
+	if err != nil {
+		return err
+	}
+	t.ID, err = result.LastInsertId()
+	return err
+}
+
+// UpdateContactType saves the name and color of t and renames the type on
+// the contacts that had oldName
+func (db *DB) UpdateContactType(t *ContactType, oldName string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE contact_types SET name = ?, color = ? WHERE id = ?", t.Name, t.Color, t.ID); err != nil {
+		return err
+	}
+	if t.Name != oldName {
+		if _, err := tx.Exec("UPDATE contacts SET contact_type = ? WHERE contact_type = ?", t.Name, oldName); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// MoveContactType swaps the position of t with the type before it (up) or
+// after it
+func (db *DB) MoveContactType(t *ContactType, up bool) error {
+	query := "SELECT id, position FROM contact_types WHERE position > ? ORDER BY position LIMIT 1"
+	if up {
+		query = "SELECT id, position FROM contact_types WHERE position < ? ORDER BY position DESC LIMIT 1"
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var otherID int64
+	var otherPosition int
+	err = tx.QueryRow(query, t.Position).Scan(&otherID, &otherPosition)
+	if err == sql.ErrNoRows {
+		return nil // already first or last
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE contact_types SET position = ? WHERE id = ?", otherPosition, t.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE contact_types SET position = ? WHERE id = ?", t.Position, otherID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) DeleteContactType(id int64) error {
+	_, err := db.Exec("DELETE FROM contact_types WHERE id = ?", id)
+	return err
+}
+
+// contactTypeCounts returns the number of contacts per type name, including
+// the recycle bin since restored contacts need their type back
+func (db *DB) contactTypeCounts() (map[string]int, error) {
+	rows, err := db.Query("SELECT contact_type, COUNT(*) FROM contacts GROUP BY contact_type")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var name string
+		var n int
+		if err := rows.Scan(&name, &n); err != nil {
+			return nil, err
+		}
+		counts[name] = n
+	}
+	return counts, rows.Err()
+}
+
+// DISPLAY
+
+// loadContactTypes is ListContactTypes for rendering, where a failure only
+// empties the drop-downs
+func loadContactTypes() []ContactType {
+	types, err := db.ListContactTypes()
+	if err != nil {
+		fmt.Printf("Warning: Failed to load contact types: %v\n", err)
+	}
+	return types
+}
+
+func contactTypeNames() []string {
+	var names []string
+	for _, t := range loadContactTypes() {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+// contactTypeClasses returns the badge classes of the type called name
+func contactTypeClasses(types []ContactType, name string) string {
+	for _, t := range types {
+		if t.Name == name {
+			return t.Classes()
+		}
+	}
+	return badgeClasses("gray")
+}
+
+// ADMIN PAGE
+
+var contactTypesPage = template.Must(template.New("contact-types").Funcs(template.FuncMap{
+	"colors": func() []string { return badgeColors },
+}).Parse(`
+{{define "types-page"}}
+<!doctype html>
+<html lang="en">
+    <head>
+        <meta charset="UTF-8" />
+        <meta name="viewport" content="width=device-width, initial-scale=1.0" />
+        <title>Contact Types - AFCB</title>
+        <link rel="icon" type="image/x-icon" href="/static/favicon/favicon.ico" />
+        <script src="https://cdn.tailwindcss.com"></script>
+        <script src="https://unpkg.com/htmx.org@1.9.10"></script>
+        <script src="/static/csrf.js"></script>
+    </head>
+    <body class="bg-gray-100" hx-headers='{"X-CSRF-Token": "{{.CSRFToken}}"}'>
+        <nav class="bg-white shadow-md">
+            <div class="container mx-auto px-4">
+                <div class="flex justify-between items-center py-4">
+                    <a href="/" class="text-2xl font-bold text-blue-600">AFcb</a>
+                    <div class="flex items-center space-x-4">
+                        <a href="/" class="text-gray-600 hover:text-blue-600">Contacts</a>
+                        <a href="/companies-page" class="text-gray-600 hover:text-blue-600">Companies</a>
+                        <a href="/admin/users" class="text-gray-600 hover:text-blue-600">Users</a>
+                        <a href="/admin/fields" class="text-gray-600 hover:text-blue-600">Fields</a>
+                        <a href="/admin/tags" class="text-gray-600 hover:text-blue-600">Tags</a>
+                        <a href="/admin/contact-types" class="text-blue-600 font-semibold">Types</a>
+                        <a href="/admin/duplicates" class="text-gray-600 hover:text-blue-600">Duplicates</a>
+                        <a href="/admin/audit" class="text-gray-600 hover:text-blue-600">Audit Log</a>
+                        <a href="/admin/license" class="text-gray-600 hover:text-blue-600">Admin</a>
+                        <a href="/logout" class="px-4 py-2 bg-red-600 text-white rounded-md hover:bg-red-700">Logout</a>
+                    </div>
+                </div>
+            </div>
+        </nav>
+        <main class="container mx-auto px-4 py-8">
+            <h1 class="text-3xl font-bold text-gray-800 mb-6">Contact Types</h1>
+            <form class="bg-white rounded-lg shadow-md p-4 mb-6 flex flex-wrap items-end gap-4"
+                  hx-post="/admin/contact-types"
+                  hx-target="#contact-types"
+                  hx-swap="innerHTML"
+                  hx-on::after-request="if(event.detail.successful) this.reset()">
+                <label class="text-sm text-gray-700">Name
+                    <input type="text" name="name" required maxlength="40" placeholder="Supplier" class="block border rounded py-1 px-2">
+                </label>
+                <label class="text-sm text-gray-700">Color
+                    <select name="color" class="block border rounded py-1 px-2">
+                        {{range colors}}<option value="{{.}}">{{.}}</option>{{end}}
+                    </select>
+                </label>
+                <button type="submit" class="px-4 py-2 bg-blue-600 text-white rounded-md hover:bg-blue-700">Add Type</button>
+            </form>
+            <div id="contact-types">
+                {{template "types-list" .}}
+            </div>
+        </main>
+    </body>
+</html>
+{{end}}
+
+{{define "types-list"}}
+{{if .Error}}<div class="bg-red-50 border border-red-200 text-red-700 rounded p-3 mb-4">{{.Error}}</div>{{end}}
+<div class="bg-white rounded-lg shadow-md overflow-hidden">
+    <table class="min-w-full divide-y divide-gray-200">
+        <thead class="bg-gray-50">
+            <tr>
+                <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Order</th>
+                <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Type</th>
+                <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Contacts</th>
+                <th class="px-6 py-3"></th>
+            </tr>
+        </thead>
+        <tbody class="bg-white divide-y divide-gray-200">
+            {{range $i, $t := .Types}}
+            <tr>
+                <td class="px-6 py-3 text-sm text-gray-500 whitespace-nowrap">
+                    <button class="px-1 hover:text-blue-600 {{if eq $i 0}}invisible{{end}}" title="Move up"
+                            hx-post="/admin/contact-types/{{.ID}}/move" hx-vals='{"dir": "up"}'
+                            hx-target="#contact-types" hx-swap="innerHTML">&uarr;</button>
+                    <button class="px-1 hover:text-blue-600" title="Move down"
+                            hx-post="/admin/contact-types/{{.ID}}/move" hx-vals='{"dir": "down"}'
+                            hx-target="#contact-types" hx-swap="innerHTML">&darr;</button>
+                </td>
+                <td class="px-6 py-3">
+                    <form class="flex items-center gap-2"
+                          hx-put="/admin/contact-types/{{.ID}}"
+                          hx-target="#contact-types"
+                          hx-swap="innerHTML">
+                        <span class="px-3 py-1 rounded-full text-sm font-medium {{.Classes}}">{{.Name}}</span>
+                        <input type="text" name="name" value="{{.Name}}" required maxlength="40" class="border rounded py-1 px-2 text-sm">
+                        {{$color := .Color}}
+                        <select name="color" class="border rounded py-1 px-2 text-sm">
+                            {{range colors}}<option value="{{.}}" {{if eq . $color}}selected{{end}}>{{.}}</option>{{end}}
+                        </select>
+                        <button type="submit" class="text-sm text-blue-600 hover:text-blue-900">Save</button>
+                    </form>
+                </td>
+                <td class="px-6 py-3 text-sm text-gray-500">{{index $.Counts .Name}}</td>
+                <td class="px-6 py-3 text-sm text-right">
+                    {{if index $.Counts .Name}}
+                    <span class="text-gray-400" title="Move its contacts to another type first">In use</span>
+                    {{else}}
+                    <button class="text-red-600 hover:text-red-900"
+                            hx-delete="/admin/contact-types/{{.ID}}"
+                            hx-target="#contact-types"
+                            hx-swap="innerHTML"
+                            hx-confirm="Delete the contact type {{.Name}}?">Delete</button>
+                    {{end}}
+                </td>
+            </tr>
+            {{end}}
+        </tbody>
+    </table>
+</div>
+{{end}}
+`))
+
+func renderContactTypes(w http.ResponseWriter, r *http.Request, name, errMessage string) {
+	types, err := db.ListContactTypes()
+	var counts map[string]int
+	if err == nil {
+		counts, err = db.contactTypeCounts()
+	}
+	if err != nil {
+		http.Error(w, "Failed to load contact types: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Types     []ContactType
+		Counts    map[string]int
+		Error     string
+		CSRFToken string
+	}{types, counts, errMessage, csrfToken(r)}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := contactTypesPage.ExecuteTemplate(w, name, data); err != nil {
+		fmt.Printf("Error rendering contact types: %v\n", err)
+	}
+}
+
+func contactTypesPageHandler(w http.ResponseWriter, r *http.Request) {
+	renderContactTypes(w, r, "types-page", "")
+}
+
+func createContactTypeHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	t := &ContactType{Name: r.FormValue("name"), Color: r.FormValue("color")}
+	err := validateContactTypeDef(t)
+	if err == nil {
+		err = db.CreateContactType(t)
+		if isUniqueViolation(err) {
+			err = fmt.Errorf("a contact type named %s already exists", t.Name)
+		}
+	}
+	if err != nil {
+		renderContactTypes(w, r, "types-list", err.Error())
+		return
+	}
+
+	recordAudit(r, AuditCreate, "contact_type", strconv.FormatInt(t.ID, 10), nil, t)
+	fmt.Printf("Contact type %s created\n", t.Name)
+	renderContactTypes(w, r, "types-list", "")
+}
+
+// contactTypeFromRequest loads the type named by the {id} route variable
+func contactTypeFromRequest(w http.ResponseWriter, r *http.Request) (*ContactType, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Contact type not found", http.StatusNotFound)
+		return nil, false
+	}
+	t, err := db.GetContactType(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Contact type not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return t, true
+}
+
+func updateContactTypeHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := contactTypeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	before := *t
+	t.Name = r.FormValue("name")
+	t.Color = r.FormValue("color")
+	err := validateContactTypeDef(t)
+	if err == nil {
+		err = db.UpdateContactType(t, before.Name)
+		if isUniqueViolation(err) {
+			err = fmt.Errorf("a contact type named %s already exists", t.Name)
+		}
+	}
+	if err != nil {
+		renderContactTypes(w, r, "types-list", err.Error())
+		return
+	}
+
+	recordAudit(r, AuditUpdate, "contact_type", strconv.FormatInt(t.ID, 10), &before, t)
+	if t.Name != before.Name {
+		fmt.Printf("Contact type %s renamed to %s\n", before.Name, t.Name)
+	}
+	renderContactTypes(w, r, "types-list", "")
+}
+
+func moveContactTypeHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := contactTypeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := db.MoveContactType(t, r.FormValue("dir") == "up"); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderContactTypes(w, r, "types-list", "")
+}
+
+func deleteContactTypeHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := contactTypeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	counts, err := db.contactTypeCounts()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n := counts[t.Name]; n > 0 {
+		renderContactTypes(w, r, "types-list", fmt.Sprintf("%s is still used by %d contacts", t.Name, n))
+		return
+	}
+	if err := db.DeleteContactType(t.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, AuditDelete, "contact_type", strconv.FormatInt(t.ID, 10), t, nil)
+	fmt.Printf("Contact type %s deleted\n", t.Name)
+	renderContactTypes(w, r, "types-list", "")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestContactTypesMigration(t *testing.T) {
+	conn := openTestDB(t)
+	if _, err := migrateUp(conn, 16); err != nil {
+		t.Fatalf("migrateUp failed: %v", err)
+	}
+	for i, contactType := range []string{"work ", "WORK", "Vendor", "vendor", "", "Family"} {
+		_, err := conn.Exec(`INSERT INTO contacts (id, contact_type, first_name, last_name, email, phone)
+			VALUES (?, ?, 'First', 'Last', ?, '111')`, string(rune('a'+i)), contactType, string(rune('a'+i))+"@example.test")
+		if err != nil {
+			t.Fatalf("Failed to insert contact: %v", err)
+		}
+	}
+	if _, err := migrateUp(conn, 0); err != nil {
+		t.Fatalf("migrateUp failed: %v", err)
+	}
+
+	got := map[string]string{}
+	rows, err := conn.Query("SELECT id, contact_type FROM contacts")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, contactType string
+		if err := rows.Scan(&id, &contactType); err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		got[id] = contactType
+	}
+	want := map[string]string{"a": "Work", "b": "Work", "c": "Vendor", "d": "Vendor", "e": "Personal", "f": "Family"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("contact types after the migration = %v, want %v", got, want)
+	}
+
+	types, err := (&DB{DB: conn}).ListContactTypes()
+	if err != nil {
+		t.Fatalf("ListContactTypes failed: %v", err)
+	}
+	var names []string
+	for _, ct := range types {
+		names = append(names, ct.Name)
+	}
+	if want := []string{"Personal", "Work", "Family", "Vendor"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("contact types = %v, want %v", names, want)
+	}
+}
+
+func TestContactTypes(t *testing.T) {
+	testDB := useTestDB(t)
+
+	// types are matched case-insensitively and stored with their own spelling
+	c := Contact{ID: "c1", ContactType: " work", FirstName: "John", LastName: "Smith", Email: "john@acme.test", Phone: "111"}
+	if err := validateContactType(&c); err != nil || c.ContactType != "Work" {
+		t.Errorf("validateContactType = %q, %v; want Work", c.ContactType, err)
+	}
+	if err := validateContactType(&Contact{ContactType: "Supplier"}); err == nil {
+		t.Errorf("an unknown contact type was accepted")
+	}
+	if err := insertContact(testDB, &c); err != nil {
+		t.Fatalf("insertContact failed: %v", err)
+	}
+
+	// renaming a type renames it on its contacts
+	types, err := testDB.ListContactTypes()
+	if err != nil || len(types) != 3 {
+		t.Fatalf("ListContactTypes = %v, %v", types, err)
+	}
+	work := types[1]
+	work.Name = "Business"
+	if err := testDB.UpdateContactType(&work, "Work"); err != nil {
+		t.Fatalf("UpdateContactType failed: %v", err)
+	}
+	if reloaded, _ := testDB.GetContact("c1"); reloaded.ContactType != "Business" {
+		t.Errorf("contact type after the rename = %q, want Business", reloaded.ContactType)
+	}
+
+	// moving swaps with the neighbour and stops at the ends
+	if err := testDB.MoveContactType(&work, true); err != nil {
+		t.Fatalf("MoveContactType failed: %v", err)
+	}
+	first := types[0]
+	if err := testDB.MoveContactType(&types[2], false); err != nil {
+		t.Fatalf("MoveContactType failed: %v", err)
+	}
+	types, _ = testDB.ListContactTypes()
+	var names []string
+	for _, ct := range types {
+		names = append(names, ct.Name)
+	}
+	if want := []string{"Business", first.Name, "Family"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("contact types after moving = %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+)
+
+// Cross-site request forgery protection. Every session has a random token
+// that pages put in an hx-headers attribute on <body>, so HTMX sends it with
+// every request. Requests that change data must carry it in the
+// X-CSRF-Token header, which a form on another site cannot set. Clients
+// using a bearer token send no cookie and are not checked.
+
+const csrfHeader = "X-CSRF-Token"
+
+// csrfToken returns the token pages rendered for r must send back
+func csrfToken(r *http.Request) string {
+	if session, ok := r.Context().Value(sessionContextKey).(*Session); ok {
+		return session.CSRFToken
+	}
+	return ""
+}
+
+func csrfSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// validCSRF reports whether r may go ahead
+func validCSRF(r *http.Request) bool {
+	if csrfSafeMethod(r.Method) || apiTokenFromContext(r) != nil {
+		return true
+	}
+	want := csrfToken(r)
+	got := r.Header.Get(csrfHeader)
+	return want != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// requireCSRF rejects changes without the session's token using reject. It
+// runs after the authentication middleware, which puts the session on the
+// request.
+func requireCSRF(reject http.HandlerFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !validCSRF(r) {
+				fmt.Printf("Warning: Rejected %s %s from %s: missing or invalid CSRF token\n", r.Method, r.URL.Path, clientIP(r))
+				reject(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// csrfErrorHandler answers a rejected page request. HTMX requests get a
+// notice added to the page; static/csrf.js lets HTMX swap in the 403.
+func csrfErrorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("HX-Request") == "true" {
+		w.Header().Set("HX-Retarget", "body")
+		w.Header().Set("HX-Reswap", "beforeend")
+	}
+	w.Header().Set("X-CSRF-Error", "1")
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusForbidden)
+	fmt.Fprint(w, csrfErrorHTML)
+}
+
+const csrfErrorHTML = `
+<div id="csrf-error" class="fixed bottom-4 right-4 z-50 max-w-sm bg-red-50 border border-red-200 text-red-800 rounded-lg shadow-lg p-4">
+    <p class="font-semibold">Your change was not saved</p>
+    <p class="text-sm mt-1">This page is out of date, for example because you signed in again in another tab. Reload the page and try again.</p>
+    <div class="mt-3 flex gap-2">
+        <button onclick="location.reload()" class="bg-red-600 hover:bg-red-700 text-white text-sm px-3 py-1 rounded">Reload</button>
+        <button onclick="this.closest('#csrf-error').remove()" class="text-sm px-3 py-1 rounded border border-red-300">Dismiss</button>
+    </div>
+</div>`
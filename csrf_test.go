@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequireCSRF(t *testing.T) {
+	session := &Session{ID: "s1", Username: "af", CSRFToken: "token-1"}
+	handler := requireCSRF(csrfErrorHandler)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name   string
+		method string
+		header string
+		bearer bool
+		noAuth bool
+		want   int
+	}{
+		{"GET needs no token", "GET", "", false, false, http.StatusNoContent},
+		{"POST with the session token", "POST", "token-1", false, false, http.StatusNoContent},
+		{"DELETE with the session token", "DELETE", "token-1", false, false, http.StatusNoContent},
+		{"POST without a token", "POST", "", false, false, http.StatusForbidden},
+		{"PUT with another token", "PUT", "token-2", false, false, http.StatusForbidden},
+		{"POST without a session", "POST", "", false, true, http.StatusForbidden},
+		{"bearer token client", "POST", "", true, false, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/contacts", nil)
+			if tt.header != "" {
+				r.Header.Set(csrfHeader, tt.header)
+			}
+			switch {
+			case tt.bearer:
+				r = withAPIToken(r, &APIToken{Username: "af"})
+			case !tt.noAuth:
+				r = withSession(r, session)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSRFErrorFragment(t *testing.T) {
+	r := httptest.NewRequest("POST", "/contacts", nil)
+	r.Header.Set("HX-Request", "true")
+	w := httptest.NewRecorder()
+	csrfErrorHandler(w, r)
+
+	if w.Code != http.StatusForbidden || w.Header().Get("HX-Retarget") != "body" || w.Header().Get("X-CSRF-Error") == "" {
+		t.Errorf("response = %d %v", w.Code, w.Header())
+	}
+	if !strings.Contains(w.Body.String(), `id="csrf-error"`) {
+		t.Errorf("body is not the error fragment: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,654 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/mux"
+)
+
+// Custom fields. Admins define extra fields for contacts or companies on
+// /admin/fields; their values live in custom_field_values keyed by field and
+// entity, and travel on Contact.Custom and Company.Custom as name => value.
+// Empty values are not stored.
+
+type CustomFieldType string
+
+const (
+	FieldText   CustomFieldType = "text"
+	FieldNumber CustomFieldType = "number"
+	FieldDate   CustomFieldType = "date"
+	FieldSelect CustomFieldType = "select"
+	FieldURL    CustomFieldType = "url"
+)
+
+// customFieldTypes lists the types in the order the admin form offers them
+var customFieldTypes = []CustomFieldType{FieldText, FieldNumber, FieldDate, FieldSelect, FieldURL}
+
+// customFieldEntities are the entity types that can have custom fields
+var customFieldEntities = []string{"contact", "company"}
+
+type CustomField struct {
+	ID         int64           `json:"id"`
+	EntityType string          `json:"entity_type"`
+	Name       string          `json:"name"` // key in Custom maps, derived from Label
+	Label      string          `json:"label"`
+	Type       CustomFieldType `json:"type"`
+	Options    []string        `json:"options,omitempty"` // choices of a select
+}
+
+// customFieldName turns a label such as "VAT number" into "vat_number"
+func customFieldName(label string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(label)) {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			b.WriteRune(r)
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "_"):
+			b.WriteByte('_')
+		}
+	}
+	return strings.TrimSuffix(b.String(), "_")
+}
+
+// validateCustomField checks a new field definition and fills in its name
+func validateCustomField(f *CustomField) error {
+	f.Label = strings.TrimSpace(f.Label)
+	if f.Label == "" {
+		return &ValidationError{Field: "label", Message: "Label is required"}
+	}
+	f.Name = customFieldName(f.Label)
+	if f.Name == "" {
+		return &ValidationError{Field: "label", Message: "Label needs at least one letter or digit"}
+	}
+
+	validEntity := false
+	for _, e := range customFieldEntities {
+		validEntity = validEntity || e == f.EntityType
+	}
+	if !validEntity {
+		return &ValidationError{Field: "entity_type", Message: "Fields can be added to contacts or companies"}
+	}
+
+	validType := false
+	for _, t := range customFieldTypes {
+		validType = validType || t == f.Type
+	}
+	if !validType {
+		return &ValidationError{Field: "type", Message: "Unknown field type"}
+	}
+
+	var options []string
+	for _, o := range f.Options {
+		if o = strings.TrimSpace(o); o != "" {
+			options = append(options, o)
+		}
+	}
+	f.Options = nil
+	if f.Type == FieldSelect {
+		if len(options) == 0 {
+			return &ValidationError{Field: "options", Message: "A select field needs at least one option"}
+		}
+		f.Options = options
+	}
+	return nil
+}
+
+// normalize checks value against the field type and returns it in the form
+// it is stored in: numbers without formatting, dates as YYYY-MM-DD and URLs
+// with a scheme
+func (f *CustomField) normalize(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+
+	switch f.Type {
+	case FieldNumber:
+		n, err := strconv.ParseFloat(strings.ReplaceAll(value, ",", ""), 64)
+		if err != nil {
+			return "", fmt.Errorf("%s must be a number", f.Label)
+		}
+		return strconv.FormatFloat(n, 'f', -1, 64), nil
+	case FieldDate:
+		d, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return "", fmt.Errorf("%s must be a date (YYYY-MM-DD)", f.Label)
+		}
+		return d.Format("2006-01-02"), nil
+	case FieldSelect:
+		for _, o := range f.Options {
+			if o == value {
+				return value, nil
+			}
+		}
+		return "", fmt.Errorf("%s must be one of %s", f.Label, strings.Join(f.Options, ", "))
+	case FieldURL:
+		if !strings.Contains(value, "://") {
+			value = "https://" + value
+		}
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "", fmt.Errorf("%s must be a web address", f.Label)
+		}
+		return u.String(), nil
+	}
+	return value, nil
+}
+
+// validateCustomValues checks values against the fields defined for
+// entityType, normalizing them in place and dropping empty ones
+func validateCustomValues(entityType string, values map[string]string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	fields, err := db.ListCustomFields(entityType)
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]*CustomField, len(fields))
+	for i := range fields {
+		byName[fields[i].Name] = &fields[i]
+	}
+
+	for name, value := range values {
+		field, ok := byName[name]
+		if !ok {
+			return &ValidationError{Field: "custom." + name, Message: fmt.Sprintf("Unknown custom field %q", name)}
+		}
+		normalized, err := field.normalize(value)
+		if err != nil {
+			return &ValidationError{Field: "custom." + name, Message: err.Error()}
+		}
+		if normalized == "" {
+			delete(values, name)
+		} else {
+			values[name] = normalized
+		}
+	}
+	return nil
+}
+
+// customValuesFromForm overlays the custom inputs present in the form on
+// current. Fields missing from the form, e.g. added after it was opened,
+// keep their values.
+func customValuesFromForm(r *http.Request, entityType string, current map[string]string) map[string]string {
+	values := map[string]string{}
+	for name, value := range current {
+		values[name] = value
+	}
+
+	fields, err := db.ListCustomFields(entityType)
+	if err != nil {
+		fmt.Printf("Warning: Failed to load custom fields: %v\n", err)
+		return values
+	}
+	for _, f := range fields {
+		if posted, ok := r.Form["custom."+f.Name]; ok {
+			values[f.Name] = strings.Join(posted, "")
+		}
+	}
+	return values
+}
+
+// DATABASE
+
+// customValuesText selects the values of one entity as a single string for
+// search; %s are the entity type and the id expression
+const customValuesText = "(SELECT group_concat(value) FROM custom_field_values WHERE entity_type = '%s' AND entity_id = %s)"
+
+func scanCustomField(scan func(dest ...interface{}) error) (*CustomField, error) {
+	var f CustomField
+	var options string
+	if err := scan(&f.ID, &f.EntityType, &f.Name, &f.Label, &f.Type, &options); err != nil {
+		return nil, err
+	}
+	if options != "" {
+		f.Options = strings.Split(options, "\n")
+	}
+	return &f, nil
+}
+
+// ListCustomFields returns the fields of entityType in display order
+func (db *DB) ListCustomFields(entityType string) ([]CustomField, error) {
+	rows, err := db.Query(`SELECT id, entity_type, name, label, field_type, options FROM custom_fields
+		WHERE entity_type = ? ORDER BY position, id`, entityType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fields []CustomField
+	for rows.Next() {
+		f, err := scanCustomField(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, *f)
+	}
+	return fields, rows.Err()
+}
+
+func (db *DB) GetCustomField(id int64) (*CustomField, error) {
+	return scanCustomField(db.QueryRow(`SELECT id, entity_type, name, label, field_type, options
+		FROM custom_fields WHERE id = ?`, id).Scan)
+}
+
+// CreateCustomField appends a field after the existing ones of its entity type
+func (db *DB) CreateCustomField(f *CustomField) error {
+	result, err := db.Exec(`INSERT INTO custom_fields (entity_type, name, label, field_type, options, position, created_at)
+		VALUES (?, ?, ?, ?, ?, (SELECT COALESCE(MAX(position), 0) + 1 FROM custom_fields WHERE entity_type = ?), ?)`,
+		f.EntityType, f.Name, f.Label, f.Type, strings.Join(f.Options, "\n"), f.EntityType, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	f.ID, err = result.LastInsertId()
+	return err
+}
+
+// DeleteCustomField removes a field together with all of its values
+func (db *DB) DeleteCustomField(id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM custom_field_values WHERE field_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM custom_fields WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertCustomValues stores values by field name; names that are not
+// defined for entityType are skipped
+func insertCustomValues(ex execer, entityType, entityID string, values map[string]string) error {
+	for name, value := range values {
+		if value == "" {
+			continue
+		}
+		if _, err := ex.Exec(`INSERT INTO custom_field_values (field_id, entity_type, entity_id, value)
+			SELECT id, entity_type, ?, ? FROM custom_fields WHERE entity_type = ? AND name = ?`,
+			entityID, value, entityType, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteCustomValues(ex execer, entityType, entityID string) error {
+	_, err := ex.Exec("DELETE FROM custom_field_values WHERE entity_type = ? AND entity_id = ?", entityType, entityID)
+	return err
+}
+
+// replaceCustomValues swaps the stored values of an entity for values
+func replaceCustomValues(ex execer, entityType, entityID string, values map[string]string) error {
+	if err := deleteCustomValues(ex, entityType, entityID); err != nil {
+		return err
+	}
+	return insertCustomValues(ex, entityType, entityID, values)
+}
+
+// customValuesFor loads the values of the given entities as
+// id => name => value
+func (db *DB) customValuesFor(entityType string, ids []string) (map[string]map[string]string, error) {
+	values := map[string]map[string]string{}
+
+	// stay well below SQLite's limit on bound parameters
+	const chunkSize = 500
+	for start := 0; start < len(ids); start += chunkSize {
+		chunk := ids[start:min(start+chunkSize, len(ids))]
+		args := []interface{}{entityType}
+		for _, id := range chunk {
+			args = append(args, id)
+		}
+
+		rows, err := db.Query(`SELECT v.entity_id, f.name, v.value FROM custom_field_values v
+			JOIN custom_fields f ON f.id = v.field_id
+			WHERE v.entity_type = ? AND v.entity_id IN (?`+strings.Repeat(", ?", len(chunk)-1)+`)`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id, name, value string
+			if err := rows.Scan(&id, &name, &value); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if values[id] == nil {
+				values[id] = map[string]string{}
+			}
+			values[id][name] = value
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (db *DB) attachContactCustomValues(contacts []Contact) error {
+	ids := make([]string, len(contacts))
+	for i := range contacts {
+		ids[i] = contacts[i].ID
+	}
+	values, err := db.customValuesFor("contact", ids)
+	if err != nil {
+		return err
+	}
+	for i := range contacts {
+		contacts[i].Custom = values[contacts[i].ID]
+	}
+	return nil
+}
+
+func (db *DB) attachCompanyCustomValues(companies []Company) error {
+	ids := make([]string, len(companies))
+	for i := range companies {
+		ids[i] = companies[i].ID
+	}
+	values, err := db.customValuesFor("company", ids)
+	if err != nil {
+		return err
+	}
+	for i := range companies {
+		companies[i].Custom = values[companies[i].ID]
+	}
+	return nil
+}
+
+// DISPLAY
+
+// customFieldValue pairs a field with the value of one entity
+type customFieldValue struct {
+	CustomField
+	Value string
+}
+
+// IsURL reports whether Value is a link that can be rendered as such
+func (v customFieldValue) IsURL() bool {
+	return v.Type == FieldURL && (strings.HasPrefix(v.Value, "https://") || strings.HasPrefix(v.Value, "http://"))
+}
+
+// customFieldValues lists every field with its value in values, for forms
+func customFieldValues(fields []CustomField, values map[string]string) []customFieldValue {
+	list := make([]customFieldValue, len(fields))
+	for i, f := range fields {
+		list[i] = customFieldValue{CustomField: f, Value: values[f.Name]}
+	}
+	return list
+}
+
+// filledCustomValues lists only the fields that have a value, for display
+func filledCustomValues(fields []CustomField, values map[string]string) []customFieldValue {
+	var list []customFieldValue
+	for _, v := range customFieldValues(fields, values) {
+		if v.Value != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// loadCustomFields is ListCustomFields for rendering, where a failure only
+// hides the fields
+func loadCustomFields(entityType string) []CustomField {
+	fields, err := db.ListCustomFields(entityType)
+	if err != nil {
+		fmt.Printf("Warning: Failed to load custom fields: %v\n", err)
+	}
+	return fields
+}
+
+// customFieldsHTML renders the inputs for custom fields in the add and edit
+// modals, one per field, named "custom.<name>"
+var customFieldsHTML = `
+{{define "custom-fields"}}
+{{range .}}
+<div class="mb-4">
+    <label class="block text-gray-700 text-sm font-bold mb-2" for="custom-{{.Name}}">{{.Label}}</label>
+    {{if eq .Type "select"}}
+    <select id="custom-{{.Name}}" name="custom.{{.Name}}" class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
+        <option value="">-</option>
+        {{$value := .Value}}
+        {{range .Options}}<option value="{{.}}" {{if eq . $value}}selected{{end}}>{{.}}</option>{{end}}
+    </select>
+    {{else}}
+    <input id="custom-{{.Name}}" name="custom.{{.Name}}" value="{{.Value}}"
+           type="{{if eq .Type "number"}}number{{else if eq .Type "date"}}date{{else if eq .Type "url"}}url{{else}}text{{end}}" {{if eq .Type "number"}}step="any"{{end}}
+           class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
+    {{end}}
+</div>
+{{end}}
+{{end}}
+
+{{define "custom-values"}}
+{{range .}}
+<div class="text-sm mt-1">
+    <span class="text-xs uppercase text-gray-400 mr-1">{{.Label}}</span>
+    {{if .IsURL}}<a href="{{.Value}}" target="_blank" rel="noopener" class="text-blue-600 hover:underline">{{.Value}}</a>{{else}}{{.Value}}{{end}}
+</div>
+{{end}}
+{{end}}
+`
+
+var customFieldTemplates = template.Must(template.New("custom").Parse(customFieldsHTML))
+
+// renderCustomTemplate executes one of customFieldTemplates into a string,
+// for the HTML that main.go assembles with fmt
+func renderCustomTemplate(name string, values []customFieldValue) string {
+	var b strings.Builder
+	if err := customFieldTemplates.ExecuteTemplate(&b, name, values); err != nil {
+		fmt.Printf("Error rendering %s: %v\n", name, err)
+	}
+	return b.String()
+}
+
+// ADMIN PAGE
+
+var customFieldsPage = template.Must(template.New("fields").Parse(`
+{{define "fields-page"}}
+<!doctype html>
+<html lang="en">
+    <head>
+        <meta charset="UTF-8" />
+        <meta name="viewport" content="width=device-width, initial-scale=1.0" />
+        <title>Custom Fields - AFCB</title>
+        <link rel="icon" type="image/x-icon" href="/static/favicon/favicon.ico" />
+        <script src="https://cdn.tailwindcss.com"></script>
+        <script src="https://unpkg.com/htmx.org@1.9.10"></script>
+        <script src="/static/csrf.js"></script>
+    </head>
+    <body class="bg-gray-100" hx-headers='{"X-CSRF-Token": "{{.CSRFToken}}"}'>
+        <nav class="bg-white shadow-md">
+            <div class="container mx-auto px-4">
+                <div class="flex justify-between items-center py-4">
+                    <a href="/" class="text-2xl font-bold text-blue-600">AFcb</a>
+                    <div class="flex items-center space-x-4">
+                        <a href="/" class="text-gray-600 hover:text-blue-600">Contacts</a>
+                        <a href="/companies-page" class="text-gray-600 hover:text-blue-600">Companies</a>
+                        <a href="/admin/users" class="text-gray-600 hover:text-blue-600">Users</a>
+                        <a href="/admin/fields" class="text-blue-600 font-semibold">Fields</a>
+                        <a href="/admin/tags" class="text-gray-600 hover:text-blue-600">Tags</a>
+                        <a href="/admin/contact-types" class="text-gray-600 hover:text-blue-600">Types</a>
+                        <a href="/admin/duplicates" class="text-gray-600 hover:text-blue-600">Duplicates</a>
+                        <a href="/admin/audit" class="text-gray-600 hover:text-blue-600">Audit Log</a>
+                        <a href="/admin/license" class="text-gray-600 hover:text-blue-600">Admin</a>
+                        <a href="/logout" class="px-4 py-2 bg-red-600 text-white rounded-md hover:bg-red-700">Logout</a>
+                    </div>
+                </div>
+            </div>
+        </nav>
+        <main class="container mx-auto px-4 py-8">
+            <h1 class="text-3xl font-bold text-gray-800 mb-6">Custom Fields</h1>
+            <form class="bg-white rounded-lg shadow-md p-4 mb-6 flex flex-wrap items-end gap-4"
+                  hx-post="/admin/fields"
+                  hx-target="#custom-fields"
+                  hx-swap="innerHTML"
+                  hx-on::after-request="if(event.detail.successful) this.reset()">
+                <label class="text-sm text-gray-700">For
+                    <select name="entity_type" class="block border rounded py-1 px-2">
+                        {{range .Entities}}<option value="{{.}}">{{.}}</option>{{end}}
+                    </select>
+                </label>
+                <label class="text-sm text-gray-700">Label
+                    <input type="text" name="label" required placeholder="VAT number" class="block border rounded py-1 px-2">
+                </label>
+                <label class="text-sm text-gray-700">Type
+                    <select name="type" class="block border rounded py-1 px-2">
+                        {{range .Types}}<option value="{{.}}">{{.}}</option>{{end}}
+                    </select>
+                </label>
+                <label class="text-sm text-gray-700">Options
+                    <textarea name="options" rows="2" placeholder="select only, one per line" class="block border rounded py-1 px-2"></textarea>
+                </label>
+                <button type="submit" class="px-4 py-2 bg-blue-600 text-white rounded-md hover:bg-blue-700">Add Field</button>
+            </form>
+            <div id="custom-fields">
+                {{template "fields-list" .}}
+            </div>
+        </main>
+    </body>
+</html>
+{{end}}
+
+{{define "fields-list"}}
+{{if .Error}}<div class="bg-red-50 border border-red-200 text-red-700 rounded p-3 mb-4">{{.Error}}</div>{{end}}
+{{range .Groups}}
+<h2 class="text-xl font-semibold text-gray-700 mb-2 capitalize">{{.EntityType}} fields</h2>
+<div class="bg-white rounded-lg shadow-md overflow-hidden mb-6">
+    <table class="min-w-full divide-y divide-gray-200">
+        <thead class="bg-gray-50">
+            <tr>
+                <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Label</th>
+                <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Name</th>
+                <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Type</th>
+                <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Options</th>
+                <th class="px-6 py-3"></th>
+            </tr>
+        </thead>
+        <tbody class="bg-white divide-y divide-gray-200">
+            {{range .Fields}}
+            <tr>
+                <td class="px-6 py-3 text-sm font-medium text-gray-900">{{.Label}}</td>
+                <td class="px-6 py-3 text-sm text-gray-500 font-mono">{{.Name}}</td>
+                <td class="px-6 py-3 text-sm text-gray-500">{{.Type}}</td>
+                <td class="px-6 py-3 text-sm text-gray-500">{{range $i, $o := .Options}}{{if $i}}, {{end}}{{$o}}{{end}}</td>
+                <td class="px-6 py-3 text-sm text-right">
+                    <button class="text-red-600 hover:text-red-900"
+                            hx-delete="/admin/fields/{{.ID}}"
+                            hx-target="#custom-fields"
+                            hx-swap="innerHTML"
+                            hx-confirm="Delete {{.Label}} and every value stored in it?">Delete</button>
+                </td>
+            </tr>
+            {{else}}
+            <tr><td colspan="5" class="px-6 py-4 text-center text-gray-500">No custom fields yet</td></tr>
+            {{end}}
+        </tbody>
+    </table>
+</div>
+{{end}}
+{{end}}
+`))
+
+type customFieldGroup struct {
+	EntityType string
+	Fields     []CustomField
+}
+
+func renderCustomFields(w http.ResponseWriter, r *http.Request, name, errMessage string) {
+	data := struct {
+		Entities  []string
+		Types     []CustomFieldType
+		Groups    []customFieldGroup
+		Error     string
+		CSRFToken string
+	}{
+		Entities:  customFieldEntities,
+		Types:     customFieldTypes,
+		Error:     errMessage,
+		CSRFToken: csrfToken(r),
+	}
+	for _, entity := range customFieldEntities {
+		fields, err := db.ListCustomFields(entity)
+		if err != nil {
+			http.Error(w, "Failed to load custom fields: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data.Groups = append(data.Groups, customFieldGroup{EntityType: entity, Fields: fields})
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := customFieldsPage.ExecuteTemplate(w, name, data); err != nil {
+		fmt.Printf("Error rendering custom fields: %v\n", err)
+	}
+}
+
+func customFieldsPageHandler(w http.ResponseWriter, r *http.Request) {
+	renderCustomFields(w, r, "fields-page", "")
+}
+
+func createCustomFieldHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	field := &CustomField{
+		EntityType: r.FormValue("entity_type"),
+		Label:      r.FormValue("label"),
+		Type:       CustomFieldType(r.FormValue("type")),
+		Options:    strings.Split(r.FormValue("options"), "\n"),
+	}
+	err := validateCustomField(field)
+	if err == nil {
+		err = db.CreateCustomField(field)
+		if isUniqueViolation(err) {
+			err = fmt.Errorf("a %s field named %s already exists", field.EntityType, field.Name)
+		}
+	}
+	if err != nil {
+		renderCustomFields(w, r, "fields-list", err.Error())
+		return
+	}
+
+	recordAudit(r, AuditCreate, "custom_field", strconv.FormatInt(field.ID, 10), nil, field)
+	fmt.Printf("Custom field %s added to %s\n", field.Name, field.EntityType)
+	renderCustomFields(w, r, "fields-list", "")
+}
+
+func deleteCustomFieldHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Field not found", http.StatusNotFound)
+		return
+	}
+	field, err := db.GetCustomField(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Field not found", http.StatusNotFound)
+		return
+	}
+	if err == nil {
+		err = db.DeleteCustomField(id)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, AuditDelete, "custom_field", strconv.FormatInt(id, 10), field, nil)
+	fmt.Printf("Custom field %s removed from %s\n", field.Name, field.EntityType)
+	renderCustomFields(w, r, "fields-list", "")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCustomFieldName(t *testing.T) {
+	for label, want := range map[string]string{
+		"VAT number":      "vat_number",
+		"  Website (URL)": "website_url",
+		"Größe":           "gr_e",
+		"---":             "",
+	} {
+		if got := customFieldName(label); got != want {
+			t.Errorf("customFieldName(%q) = %q, want %q", label, got, want)
+		}
+	}
+}
+
+func TestCustomFieldNormalize(t *testing.T) {
+	tests := []struct {
+		field   CustomField
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{CustomField{Type: FieldNumber}, "1,234.50", "1234.5", false},
+		{CustomField{Type: FieldNumber}, "lots", "", true},
+		{CustomField{Type: FieldDate}, "2024-02-29", "2024-02-29", false},
+		{CustomField{Type: FieldDate}, "29/02/2024", "", true},
+		{CustomField{Type: FieldSelect, Options: []string{"Gold", "Silver"}}, "Gold", "Gold", false},
+		{CustomField{Type: FieldSelect, Options: []string{"Gold", "Silver"}}, "Bronze", "", true},
+		{CustomField{Type: FieldURL}, "example.com/about", "https://example.com/about", false},
+		{CustomField{Type: FieldURL}, "javascript:alert(1)", "", true},
+		{CustomField{Type: FieldText}, "  hello ", "hello", false},
+		{CustomField{Type: FieldNumber}, "  ", "", false},
+	}
+	for _, tt := range tests {
+		got, err := tt.field.normalize(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("normalize(%s, %q) = %q, %v; want %q, error %t", tt.field.Type, tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCustomValues(t *testing.T) {
+	testDB := useTestDB(t)
+
+	for _, f := range []CustomField{
+		{EntityType: "contact", Label: "Birthday", Type: FieldDate},
+		{EntityType: "contact", Label: "Tier", Type: FieldSelect, Options: []string{"Gold", "Silver", ""}},
+		{EntityType: "company", Label: "VAT number", Type: FieldText},
+	} {
+		if err := validateCustomField(&f); err != nil {
+			t.Fatalf("validateCustomField(%s) failed: %v", f.Label, err)
+		}
+		if err := testDB.CreateCustomField(&f); err != nil {
+			t.Fatalf("CreateCustomField(%s) failed: %v", f.Label, err)
+		}
+	}
+
+	// values are normalized, empty ones dropped and unknown names rejected
+	values := map[string]string{"birthday": "1990-05-01", "tier": "Gold", "vat_number": ""}
+	if err := validateCustomValues("contact", values); err == nil {
+		t.Errorf("a company field was accepted for a contact")
+	}
+	delete(values, "vat_number")
+	if err := validateCustomValues("contact", values); err != nil {
+		t.Fatalf("validateCustomValues failed: %v", err)
+	}
+
+	contact := Contact{ID: "c1", ContactType: "Work", FirstName: "John", LastName: "Smith", Email: "john@acme.test", Phone: "111", Custom: values}
+	if err := insertContact(testDB, &contact); err != nil {
+		t.Fatalf("insertContact failed: %v", err)
+	}
+	company := Company{ID: "acme", Name: "Acme", Custom: map[string]string{"vat_number": "GB123456789"}}
+	if err := testDB.CreateCompany(&company); err != nil {
+		t.Fatalf("CreateCompany failed: %v", err)
+	}
+
+	loaded, err := testDB.GetContact("c1")
+	if err != nil {
+		t.Fatalf("GetContact failed: %v", err)
+	}
+	if !reflect.DeepEqual(loaded.Custom, values) {
+		t.Errorf("loaded custom values = %v, want %v", loaded.Custom, values)
+	}
+
+	contacts, err := testDB.SearchContacts("custom:gold")
+	if err != nil || len(contacts) != 1 {
+		t.Errorf("custom:gold = %v, %v; want c1", contacts, err)
+	}
+	companies, _, err := testDB.ListCompanies("GB123456789", ListOptions{})
+	if err != nil || len(companies) != 1 || companies[0].Custom["vat_number"] != "GB123456789" {
+		t.Errorf("company search by custom value = %v, %v", companies, err)
+	}
+
+	// an update replaces the values and the index follows
+	loaded.Custom = map[string]string{"tier": "Silver"}
+	if err := testDB.UpdateContact(loaded); err != nil {
+		t.Fatalf("UpdateContact failed: %v", err)
+	}
+	if contacts, _ := testDB.SearchContacts("gold"); len(contacts) != 0 {
+		t.Errorf("gold after the update = %v, want none", contacts)
+	}
+
+	// deleting a field removes its values
+	fields, err := testDB.ListCustomFields("contact")
+	if err != nil || len(fields) != 2 || fields[1].Name != "tier" || len(fields[1].Options) != 2 {
+		t.Fatalf("ListCustomFields = %+v, %v", fields, err)
+	}
+	if err := testDB.DeleteCustomField(fields[1].ID); err != nil {
+		t.Fatalf("DeleteCustomField failed: %v", err)
+	}
+	if reloaded, _ := testDB.GetContact("c1"); len(reloaded.Custom) != 0 {
+		t.Errorf("custom values after deleting the field = %v", reloaded.Custom)
+	}
+	if contacts, _ := testDB.SearchContacts("silver"); len(contacts) != 0 {
+		t.Errorf("silver after deleting the field = %v, want none", contacts)
+	}
+}
@@ -5,16 +5,26 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type DB struct {
 	*sql.DB
+
+	// searchIndex is set when the FTS5 index is available, see search.go
+	searchIndex bool
 }
 
-func InitDB() (*DB, error) {
-	db, err := sql.Open("sqlite3", "./afcb.db")
+// execer is implemented by both *sql.DB and *sql.Tx, so inserts can run
+// standalone or as part of a larger transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func openDB() (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", config.DBPath)
 	if err != nil {
 		return nil, err
 	}
@@ -23,109 +33,82 @@ func InitDB() (*DB, error) {
 	if err := db.Ping(); err != nil {
 		return nil, fmt.Errorf("Failed to connect to database: %v", err)
 	}
+	return db, nil
+}
 
-	//create tables
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS companies (
-			id TEXT PRIMARY KEY,
-			name TEXT NOT NULL,
-			bank_name TEXT,
-			account_number TEXT,
-			account_document_path TEXT,
-			registration_number TEXT,
-			registration_document_path TEXT,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-
-		`CREATE TABLE IF NOT EXISTS users (
-			id INTEGER PRIMARY KEY,
-			username TEXT UNIQUE NOT NULL,
-			password TEXT NOT NULL,
-			contact_id TEXT,
-			needs_password_change BOOLEAN DEFAULT 1,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-
-		`CREATE TABLE IF NOT EXISTS contacts (
-			id TEXT PRIMARY KEY,
-			contact_type TEXT NOT NULL,
-			first_name TEXT NOT NULL,
-			last_name TEXT NOT NULL,
-			email TEXT UNIQUE NOT NULL,
-			phone TEXT NOT NULL,
-			password TEXT,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-	}
-
-	for _, query := range queries {
-		if _, err := db.Exec(query); err != nil {
-			return nil, fmt.Errorf("Failed to create table: %v", err)
-		}
-	}
-
-	// ensure company_id column exists in contacts table
-	_, err = db.Exec(`ALTER TABLE contacts ADD COLUMN company_id TEXT`)
+func InitDB() (*DB, error) {
+	db, err := openDB()
 	if err != nil {
-		// Ignore "duplicate column" errors
-		if !strings.Contains(err.Error(), "duplicate column") {
-			fmt.Printf("Note: Could not alter contacts table: %v\n", err)
-		}
+		return nil, err
 	}
 
-	// ensure the needs_password_change column exists
-	_, err = db.Exec(`ALTER TABLE users ADD COLUMN needs_password_change BOOLEAN DEFAULT 1`)
-	if err != nil {
-		// Ignore "duplicate column" errors
-		if !strings.Contains(err.Error(), "duplicate column") {
-			fmt.Printf("Note: Could not alter users table: %v\n", err)
-		}
+	//bring the schema up to date
+	if _, err := migrateUp(db, 0); err != nil {
+		return nil, fmt.Errorf("Failed to migrate database: %v", err)
 	}
 
-	// Add created_by column to companies table
-	_, err = db.Exec(`ALTER TABLE companies ADD COLUMN created_by TEXT`)
+	// Insert default admin user if not exists - mark as NOT needing password change
+	adminHash, err := hashPassword(config.Admin.Password)
 	if err != nil {
-		// Ignore "duplicate column" errors
-		if !strings.Contains(err.Error(), "duplicate column") {
-			fmt.Printf("Note: Could not alter companies table: %v\n", err)
-		}
+		return nil, err
 	}
-
-	// Insert default admin user if not exists - mark as NOT needing password change
-	result, err := db.Exec(`INSERT OR IGNORE INTO users (username, password, needs_password_change) VALUES (?, ?, ?)`,
-		"af", "afcb", 0) // Admin doesn't need password change
+	result, err := db.Exec(`INSERT OR IGNORE INTO users (username, password, needs_password_change, role) VALUES (?, ?, ?, ?)`,
+		config.Admin.Username, adminHash, 0, RoleAdmin) // Admin doesn't need password change
 	if err != nil {
 		return nil, fmt.Errorf("failed to create default admin user: %v", err)
 	}
 
 	rowsAffected, _ := result.RowsAffected()
 	if rowsAffected > 0 {
-		log.Printf("Default admin user created: af / afcb")
+		log.Printf("Default admin user created: %s", config.Admin.Username)
 	} else {
 		log.Printf("Default admin user already exists")
 	}
 
-	return &DB{db}, nil
+	searchIndex, err := initSearchIndex(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DB{DB: db, searchIndex: searchIndex}, nil
+}
+
+// storedPassword hashes a password before it is written, passing through
+// empty values and values that are already hashed
+func storedPassword(password string) (string, error) {
+	if password == "" || isPasswordHash(password) {
+		return password, nil
+	}
+	return hashPassword(password)
 }
 
 // COMPANY HANDLERS
 func (db *DB) CreateCompany(company *Company) error {
-	_, err := db.Exec(`INSERT INTO companies
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO companies
 		(id, name, bank_name, account_number, account_document_path, registration_number, registration_document_path, created_by)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
 		company.ID, company.Name, company.BankName, company.AccountNumber, company.AccountDocumentPath, company.RegistrationNumber, company.RegistrationDocumentPath, company.CreatedBy)
+	if err == nil {
+		err = insertCustomValues(tx, "company", company.ID, company.Custom)
+	}
 	if err != nil {
-		fmt.Printf("DEBUG: SQL Error in CreateCompany: %v\n", err)
+		debugf("SQL Error in CreateCompany: %v\n", err)
+		return err
 	}
-	return err
+	return tx.Commit()
 }
 
 func (db *DB) GetCompany(id string) (*Company, error) {
 	var company Company
 	var createdBy sql.NullString
 	err := db.QueryRow(`SELECT id, name, bank_name, account_number, account_document_path,
-        registration_number, registration_document_path, created_at, created_by FROM companies WHERE id = ?`, id).Scan(
+        registration_number, registration_document_path, created_at, created_by FROM companies WHERE id = ? AND deleted_at IS NULL`, id).Scan(
 		&company.ID, &company.Name, &company.BankName, &company.AccountNumber,
 		&company.AccountDocumentPath, &company.RegistrationNumber,
 		&company.RegistrationDocumentPath, &company.CreatedAt, &createdBy)
@@ -135,55 +118,42 @@ func (db *DB) GetCompany(id string) (*Company, error) {
 	if createdBy.Valid {
 		company.CreatedBy = &createdBy.String
 	}
-	return &company, nil
+	companies := []Company{company}
+	if err := db.attachCompanyCustomValues(companies); err != nil {
+		return nil, err
+	}
+	return &companies[0], nil
 }
 
+// UpdateCompany saves company and replaces its custom field values
 func (db *DB) UpdateCompany(company *Company) error {
-	_, err := db.Exec(`UPDATE companies SET
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE companies SET
 		name = ?, bank_name = ?, account_number = ?, account_document_path = ?, registration_number = ?, registration_document_path = ? WHERE id = ?`,
 		company.Name, company.BankName, company.AccountNumber, company.AccountDocumentPath, company.RegistrationNumber, company.RegistrationDocumentPath, company.ID)
-	return err
-}
-
-func (db *DB) SearchCompanies(keyword string) ([]Company, error) {
-	query := `SELECT id, name, bank_name, account_number, account_document_path,
-                     registration_number, registration_document_path, created_at, created_by
-              FROM companies
-              WHERE name LIKE ? OR bank_name LIKE ? OR account_number LIKE ? OR registration_number LIKE ?
-              ORDER BY name`
-
-	likeKeyword := "%" + keyword + "%"
-	rows, err := db.Query(query, likeKeyword, likeKeyword, likeKeyword, likeKeyword)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
-
-	var companies []Company
-	for rows.Next() {
-		var company Company
-		var createdBy sql.NullString
-		err := rows.Scan(&company.ID, &company.Name, &company.BankName, &company.AccountNumber,
-			&company.AccountDocumentPath, &company.RegistrationNumber,
-			&company.RegistrationDocumentPath, &company.CreatedAt, &createdBy)
-		if err != nil {
-			return nil, err
-		}
-		if createdBy.Valid {
-			company.CreatedBy = &createdBy.String
-		}
-		companies = append(companies, company)
+	if err := replaceCustomValues(tx, "company", company.ID, company.Custom); err != nil {
+		return err
 	}
-	return companies, nil
+	return tx.Commit()
 }
 
+// DeleteCompany moves a company to the recycle bin. Its documents stay on
+// disk until the company is purged.
 func (db *DB) DeleteCompany(id string) error {
-	_, err := db.Exec("DELETE FROM companies WHERE id = ?", id)
+	_, err := db.Exec("UPDATE companies SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL", time.Now().UTC(), id)
 	return err
 }
 
 func (db *DB) GetAllCompanies() ([]Company, error) {
-	rows, err := db.Query("SELECT id, name, bank_name, account_number, account_document_path, registration_number, registration_document_path, created_at, created_by FROM companies ORDER BY name")
+	rows, err := db.Query("SELECT id, name, bank_name, account_number, account_document_path, registration_number, registration_document_path, created_at, created_by FROM companies WHERE deleted_at IS NULL ORDER BY name")
 	if err != nil {
 		return nil, err
 	}
@@ -208,7 +178,7 @@ func (db *DB) GetAllCompanies() ([]Company, error) {
 }
 
 func (db *DB) GetCompanies() ([]Company, error) {
-	rows, err := db.Query("SELECT id, name FROM companies ORDER BY name")
+	rows, err := db.Query("SELECT id, name FROM companies WHERE deleted_at IS NULL ORDER BY name")
 	if err != nil {
 		return nil, err
 	}
@@ -231,8 +201,8 @@ func (db *DB) GetUser(username string) (*User, error) {
 	var user User
 	var needsChange interface{} //to handle different types
 
-	err := db.QueryRow("SELECT username, password, contact_id, needs_password_change FROM users WHERE username = ?",
-		username).Scan(&user.Username, &user.Password, &user.ContactID, &needsChange)
+	err := db.QueryRow("SELECT username, password, contact_id, needs_password_change, role FROM users WHERE username = ?",
+		username).Scan(&user.Username, &user.Password, &user.ContactID, &needsChange, &user.Role)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("user not found: %s", username)
@@ -257,20 +227,121 @@ func (db *DB) GetUser(username string) (*User, error) {
 }
 
 func (db *DB) CreateUser(user *User) error {
+	return insertUser(db, user)
+}
+
+func insertUser(ex execer, user *User) error {
 	needsChange := 0
 	if user.NeedPasswordChange {
 		needsChange = 1
 	}
-	_, err := db.Exec("INSERT INTO users (username, password, contact_id, needs_password_change) VALUES (?, ?, ?, ?)",
-		user.Username, user.Password, user.ContactID, needsChange)
+	if user.Role == "" {
+		user.Role = RoleContact
+	}
+	password, err := storedPassword(user.Password)
+	if err != nil {
+		return err
+	}
+	_, err = ex.Exec("INSERT INTO users (username, password, contact_id, needs_password_change, role) VALUES (?, ?, ?, ?, ?)",
+		user.Username, password, user.ContactID, needsChange, user.Role)
+	return err
+}
+
+func (db *DB) GetAllUsers() ([]User, error) {
+	rows, err := db.Query("SELECT username, contact_id, role FROM users ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.Username, &user.ContactID, &user.Role); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (db *DB) UpdateUserRole(username string, role Role) error {
+	_, err := db.Exec("UPDATE users SET role = ? WHERE username = ?", role, username)
 	return err
 }
 
+func (db *DB) CountUsersWithRole(role Role) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM users WHERE role = ?", role).Scan(&count)
+	return count, err
+}
+
 func (db *DB) UpdateUserPassword(username, newPassword string) error {
-	_, err := db.Exec("UPDATE users SET password = ?, needs_password_change = 0 WHERE username = ?", newPassword, username)
+	hash, err := hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE users SET password = ?, needs_password_change = 0 WHERE username = ?", hash, username)
 	return err
 }
 
+// UpdateContactUserPassword sets a password chosen by someone else for the
+// login linked to contactID, if it has one. The user has to replace it at
+// the next login, and their sessions end as after a password change.
+func (db *DB) UpdateContactUserPassword(contactID, newPassword string) error {
+	hash, err := hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET password = ?, needs_password_change = 1 WHERE contact_id = ?", hash, contactID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM sessions WHERE username IN (SELECT username FROM users WHERE contact_id = ?)", contactID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// EmailIsOtherLogin reports whether email is the username of a login that is
+// not linked to contactID
+func (db *DB) EmailIsOtherLogin(email, contactID string) bool {
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM users WHERE lower(username) = lower(?) AND (contact_id IS NULL OR contact_id != ?)", email, contactID).Scan(&n)
+	return n > 0
+}
+
+// RehashUserPassword upgrades a legacy or outdated hash after a successful
+// login without touching needs_password_change. A linked contact still
+// holding the same password in plaintext gets the hash too.
+func (db *DB) RehashUserPassword(username, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET password = ? WHERE username = ?", hash, username); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE contacts SET password = ? WHERE id = (SELECT contact_id FROM users WHERE username = ?) AND password = ?",
+		hash, username, password); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func (db *DB) UserNeedsPasswordChange(username string) (bool, error) {
 	var needsChange int
 	err := db.QueryRow("SELECT needs_password_change FROM users WHERE username = ?", username).Scan(&needsChange)
@@ -280,77 +351,145 @@ func (db *DB) UserNeedsPasswordChange(username string) (bool, error) {
 	return needsChange == 1, nil
 }
 
-func (db *DB) DeleteUser(username string) error {
-	_, err := db.Exec("DELETE FROM users WHERE username = ?", username)
-	return err
+// revokeContactCredentials signs the logins linked to contactID out
+// everywhere and drops their API tokens and invitations
+func revokeContactCredentials(ex execer, contactID string) error {
+	for _, table := range []string{"sessions", "api_tokens", "invites"} {
+		if _, err := ex.Exec("DELETE FROM "+table+" WHERE username IN (SELECT username FROM users WHERE contact_id = ?)", contactID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *DB) RevokeContactCredentials(contactID string) error {
+	return revokeContactCredentials(db, contactID)
 }
 
 // CONTACTS HANDLERS
+// CreateContact stores a new contact under a fresh ID
 func (db *DB) CreateContact(contact *Contact) error {
-	_, err := db.Exec(`INSERT INTO contacts
-		(id, contact_type, first_name, last_name, email, phone, password, company_id)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-		contact.ID, contact.ContactType, contact.FirstName, contact.LastName, contact.Email, contact.Phone, contact.Password, contact.CompanyID)
-	return err
+	return insertNewContact(db, contact)
+}
+
+func insertContact(ex execer, contact *Contact) error {
+	password, err := storedPassword(contact.Password)
+	if err != nil {
+		return err
+	}
+	_, err = ex.Exec(`INSERT INTO contacts
+		(id, contact_type, first_name, last_name, email, phone, phone_e164, password, company_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		contact.ID, contact.ContactType, contact.FirstName, contact.LastName, contact.Email, contact.Phone, storedE164(contact.PhoneE164), password, contact.CompanyID)
+	if err != nil {
+		return err
+	}
+	if err := insertContactDetails(ex, contact); err != nil {
+		return err
+	}
+	if err := insertContactTags(ex, contact); err != nil {
+		return err
+	}
+	return insertCustomValues(ex, "contact", contact.ID, contact.Custom)
+}
+
+// maxIDAttempts bounds the draws for a free ID. genID has about three
+// million values, so a draw rarely hits an existing contact and several
+// misses in a row mean something else is wrong.
+const maxIDAttempts = 5
+
+// insertNewContact gives contact a fresh ID and inserts it, drawing again
+// when the ID is already taken
+func insertNewContact(ex execer, contact *Contact) error {
+	for attempt := 1; ; attempt++ {
+		id, err := genID()
+		if err != nil {
+			return err
+		}
+		contact.ID = id
+		err = insertContact(ex, contact)
+		if attempt == maxIDAttempts || !isUniqueViolation(err) || !strings.Contains(err.Error(), "contacts.id") {
+			return err
+		}
+	}
 }
 
+// GetContact returns a live contact together with its extra details
 func (db *DB) GetContact(id string) (*Contact, error) {
 	var contact Contact
 	var companyID sql.NullString
-	err := db.QueryRow(`SELECT id, contact_type, first_name, last_name, email, phone, password, company_id FROM contacts WHERE id = ?`, id).Scan(
-		&contact.ID, &contact.ContactType, &contact.FirstName, &contact.LastName, &contact.Email, &contact.Phone, &contact.Password, &companyID)
+	err := db.QueryRow(`SELECT id, contact_type, first_name, last_name, email, phone, COALESCE(phone_e164, ''), password, company_id FROM contacts WHERE id = ? AND deleted_at IS NULL`, id).Scan(
+		&contact.ID, &contact.ContactType, &contact.FirstName, &contact.LastName, &contact.Email, &contact.Phone, &contact.PhoneE164, &contact.Password, &companyID)
 	if err != nil {
 		return nil, err
 	}
 	if companyID.Valid {
 		contact.CompanyID = &companyID.String
 	}
-	return &contact, nil
+	contacts := []Contact{contact}
+	if err := db.attachContactDetails(contacts); err != nil {
+		return nil, err
+	}
+	if err := db.attachContactCustomValues(contacts); err != nil {
+		return nil, err
+	}
+	if err := db.attachContactTags(contacts); err != nil {
+		return nil, err
+	}
+	return &contacts[0], nil
 }
 
+// UpdateContact saves contact and replaces its extra details, custom field
+// values and tags
 func (db *DB) UpdateContact(contact *Contact) error {
-	_, err := db.Exec(`UPDATE contacts SET contact_type = ?, first_name = ?, last_name = ?, email = ?, phone = ?, password = ?, company_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
-		contact.ContactType, contact.FirstName, contact.LastName, contact.Email, contact.Phone, contact.Password, contact.CompanyID, contact.ID)
-	return err
-}
+	password, err := storedPassword(contact.Password)
+	if err != nil {
+		return err
+	}
 
-func (db *DB) DeleteContact(id string) error {
-	_, err := db.Exec("DELETE FROM contacts WHERE id = ?", id)
-	return err
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := updateContactRows(tx, contact, password); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (db *DB) GetAllContacts() ([]Contact, error) {
-	rows, err := db.Query("SELECT id, contact_type, first_name, last_name, email, phone, company_id FROM contacts")
+// updateContactRows saves contact with its details, custom values and tags.
+// password is the stored form of contact.Password.
+func updateContactRows(ex execer, contact *Contact, password string) error {
+	_, err := ex.Exec(`UPDATE contacts SET contact_type = ?, first_name = ?, last_name = ?, email = ?, phone = ?, phone_e164 = ?, password = ?, company_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		contact.ContactType, contact.FirstName, contact.LastName, contact.Email, contact.Phone, storedE164(contact.PhoneE164), password, contact.CompanyID, contact.ID)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
-
-	var contacts []Contact
-	for rows.Next() {
-		var contact Contact
-		var companyID sql.NullString
-		err := rows.Scan(&contact.ID, &contact.ContactType, &contact.FirstName, &contact.LastName, &contact.Email, &contact.Phone, &companyID)
-		if err != nil {
-			return nil, err
-		}
-		if companyID.Valid {
-			contact.CompanyID = &companyID.String
-		}
-		contacts = append(contacts, contact)
+	if err := deleteContactDetails(ex, contact.ID); err != nil {
+		return err
 	}
-	return contacts, nil
+	if err := insertContactDetails(ex, contact); err != nil {
+		return err
+	}
+	if err := replaceCustomValues(ex, "contact", contact.ID, contact.Custom); err != nil {
+		return err
+	}
+	if err := deleteContactTags(ex, contact.ID); err != nil {
+		return err
+	}
+	return insertContactTags(ex, contact)
 }
 
-func (db *DB) SearchContacts(keyword string) ([]Contact, error) {
-	query := `SELECT c.id, c.contact_type, c.first_name, c.last_name, c.email, c.phone, c.company_id
-              FROM contacts c
-              LEFT JOIN companies comp ON c.company_id = comp.id
-              WHERE c.first_name LIKE ? OR c.last_name LIKE ? OR c.email LIKE ? OR c.phone LIKE ? OR comp.name LIKE ?
-              ORDER BY c.first_name, c.last_name`
+// DeleteContact moves a contact to the recycle bin
+func (db *DB) DeleteContact(id string) error {
+	_, err := db.Exec("UPDATE contacts SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL", time.Now().UTC(), id)
+	return err
+}
 
-	likeKeyword := "%" + keyword + "%"
-	rows, err := db.Query(query, likeKeyword, likeKeyword, likeKeyword, likeKeyword, likeKeyword)
+func (db *DB) GetAllContacts() ([]Contact, error) {
+	rows, err := db.Query("SELECT id, contact_type, first_name, last_name, email, phone, COALESCE(phone_e164, ''), company_id FROM contacts WHERE deleted_at IS NULL")
 	if err != nil {
 		return nil, err
 	}
@@ -360,7 +499,7 @@ func (db *DB) SearchContacts(keyword string) ([]Contact, error) {
 	for rows.Next() {
 		var contact Contact
 		var companyID sql.NullString
-		err := rows.Scan(&contact.ID, &contact.ContactType, &contact.FirstName, &contact.LastName, &contact.Email, &contact.Phone, &companyID)
+		err := rows.Scan(&contact.ID, &contact.ContactType, &contact.FirstName, &contact.LastName, &contact.Email, &contact.Phone, &contact.PhoneE164, &companyID)
 		if err != nil {
 			return nil, err
 		}
@@ -393,7 +532,7 @@ func (db *DB) DebugUserTable() error {
 	}
 
 	// Check actual data
-	dataRows, err := db.Query("SELECT username, password, contact_id, needs_password_change, typeof(needs_password_change) FROM users")
+	dataRows, err := db.Query("SELECT username, contact_id, needs_password_change, typeof(needs_password_change) FROM users")
 	if err != nil {
 		return err
 	}
@@ -401,13 +540,13 @@ func (db *DB) DebugUserTable() error {
 
 	fmt.Println("Users data:")
 	for dataRows.Next() {
-		var username, password string
+		var username string
 		var contactID sql.NullString
 		var needsChange interface{}
 		var needsChangeType string
 
-		dataRows.Scan(&username, &password, &contactID, &needsChange, &needsChangeType)
-		fmt.Printf("  User: %s, Password: %s, NeedsChange: %v (%s)\n", username, password, needsChange, needsChangeType)
+		dataRows.Scan(&username, &contactID, &needsChange, &needsChangeType)
+		fmt.Printf("  User: %s, NeedsChange: %v (%s)\n", username, needsChange, needsChangeType)
 	}
 
 	fmt.Println("=== End debugging ===")
@@ -418,8 +557,8 @@ func (db *DB) DebugUserTable() error {
 func (db *DB) GetContactByEmail(email string) (*Contact, error) {
 	var contact Contact
 	var companyID sql.NullString
-	err := db.QueryRow(`SELECT id, contact_type, first_name, last_name, email, phone, password, company_id FROM contacts WHERE email = ?`, email).Scan(
-		&contact.ID, &contact.ContactType, &contact.FirstName, &contact.LastName, &contact.Email, &contact.Phone, &contact.Password, &companyID)
+	err := db.QueryRow(`SELECT id, contact_type, first_name, last_name, email, phone, COALESCE(phone_e164, ''), password, company_id FROM contacts WHERE email = ? AND deleted_at IS NULL`, email).Scan(
+		&contact.ID, &contact.ContactType, &contact.FirstName, &contact.LastName, &contact.Email, &contact.Phone, &contact.PhoneE164, &contact.Password, &companyID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("contact not found with email: %s", email)
@@ -0,0 +1,741 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/mux"
+)
+
+// Duplicate contacts. The unique email only catches exact repeats, so the
+// same person entered twice with another address or phone formatting slips
+// through. The finder scores pairs of live contacts by normalized name, phone
+// digits and email, and lists likely ones on /admin/duplicates where an admin
+// merges them or dismisses them as different people.
+
+// duplicateThreshold is the score from which a pair is listed. A shared
+// name alone stays below it; name and phone, or an email, reach it.
+const duplicateThreshold = 50
+
+// maxDuplicatePairs caps the review queue, best matches first
+const maxDuplicatePairs = 200
+
+type duplicatePair struct {
+	A, B    Contact
+	Score   int
+	Reasons []string
+}
+
+// normalizedName lowercases s and drops everything but letters and digits
+func normalizedName(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// phoneDigits returns the last nine digits of phone, which ignores country
+// codes and trunk prefixes, or "" when it has too few digits to compare
+func phoneDigits(phone string) string {
+	var digits []rune
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits = append(digits, r)
+		}
+	}
+	if len(digits) < 7 {
+		return ""
+	}
+	if len(digits) > 9 {
+		digits = digits[len(digits)-9:]
+	}
+	return string(digits)
+}
+
+// emailParts splits a lowercased email into its local part, without any
+// +suffix, and its domain
+func emailParts(email string) (local, domain string) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email, ""
+	}
+	local, domain = email[:at], email[at+1:]
+	if plus := strings.Index(local, "+"); plus > 0 {
+		local = local[:plus]
+	}
+	return local, domain
+}
+
+func contactEmailList(c *Contact) []string {
+	emails := []string{strings.ToLower(c.Email)}
+	for _, e := range c.Emails {
+		emails = append(emails, strings.ToLower(e.Email))
+	}
+	return emails
+}
+
+func contactPhoneDigits(c *Contact) []string {
+	var phones []string
+	for _, p := range append([]string{c.Phone}, phoneList(c.Phones)...) {
+		if digits := phoneDigits(p); digits != "" {
+			phones = append(phones, digits)
+		}
+	}
+	return phones
+}
+
+func phoneList(phones []ContactPhone) []string {
+	var list []string
+	for _, p := range phones {
+		list = append(list, p.Phone)
+	}
+	return list
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
+
+// scoreDuplicate rates how likely a and b are the same person, from 0 to
+// 100, and says why
+func scoreDuplicate(a, b *Contact) (int, []string) {
+	score := 0
+	var reasons []string
+
+	firstA, lastA := normalizedName(a.FirstName), normalizedName(a.LastName)
+	firstB, lastB := normalizedName(b.FirstName), normalizedName(b.LastName)
+	switch {
+	case firstA+lastA != "" && firstA == firstB && lastA == lastB:
+		score += 40
+		reasons = append(reasons, "same name")
+	case firstA != "" && lastA != "" && firstA == lastB && lastA == firstB:
+		score += 35
+		reasons = append(reasons, "first and last name swapped")
+	case lastA != "" && lastA == lastB && firstA != "" && firstB != "" &&
+		(levenshtein(firstA, firstB) <= 2 || strings.HasPrefix(firstA, firstB) || strings.HasPrefix(firstB, firstA)):
+		score += 25
+		reasons = append(reasons, "similar name")
+	}
+
+	emailScore, emailReason := 0, ""
+	for _, ea := range contactEmailList(a) {
+		for _, eb := range contactEmailList(b) {
+			localA, domainA := emailParts(ea)
+			localB, domainB := emailParts(eb)
+			switch {
+			case localA == localB && domainA == domainB && localA != "":
+				if emailScore < 50 {
+					emailScore, emailReason = 50, "same email"
+				}
+			case localA == localB && len(localA) >= 3:
+				if emailScore < 30 {
+					emailScore, emailReason = 30, "same email name at another domain"
+				}
+			case domainA == domainB && len(localA) >= 5 && levenshtein(localA, localB) <= 2:
+				if emailScore < 20 {
+					emailScore, emailReason = 20, "similar email"
+				}
+			}
+		}
+	}
+	if emailScore > 0 {
+		score += emailScore
+		reasons = append(reasons, emailReason)
+	}
+
+	phonesB := contactPhoneDigits(b)
+	for _, pa := range contactPhoneDigits(a) {
+		if containsString(phonesB, pa) {
+			score += 40
+			reasons = append(reasons, "same phone")
+			break
+		}
+	}
+	return min(score, 100), reasons
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// duplicateKey identifies the pair of contacts a and b regardless of order
+func duplicateKey(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + "|" + b
+}
+
+// findDuplicates returns the likely duplicate pairs among contacts, best
+// first. Only contacts sharing a name part, email name or phone number are
+// compared, which keeps large address books fast.
+func findDuplicates(contacts []Contact, dismissed map[string]bool) []duplicatePair {
+	blocks := map[string][]int{}
+	for i := range contacts {
+		c := &contacts[i]
+		keys := map[string]bool{}
+		for _, name := range []string{c.FirstName, c.LastName} {
+			if n := normalizedName(name); n != "" {
+				keys["name:"+n] = true
+			}
+		}
+		for _, e := range contactEmailList(c) {
+			if local, _ := emailParts(e); local != "" {
+				keys["email:"+local] = true
+			}
+		}
+		for _, p := range contactPhoneDigits(c) {
+			keys["phone:"+p] = true
+		}
+		for key := range keys {
+			blocks[key] = append(blocks[key], i)
+		}
+	}
+
+	seen := map[string]bool{}
+	var pairs []duplicatePair
+	for _, members := range blocks {
+		for x := 0; x < len(members); x++ {
+			for y := x + 1; y < len(members); y++ {
+				a, b := &contacts[members[x]], &contacts[members[y]]
+				key := duplicateKey(a.ID, b.ID)
+				if seen[key] || dismissed[key] {
+					continue
+				}
+				seen[key] = true
+				if score, reasons := scoreDuplicate(a, b); score >= duplicateThreshold {
+					if a.ID > b.ID {
+						a, b = b, a
+					}
+					pairs = append(pairs, duplicatePair{A: *a, B: *b, Score: score, Reasons: reasons})
+				}
+			}
+		}
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Score != pairs[j].Score {
+			return pairs[i].Score > pairs[j].Score
+		}
+		return duplicateKey(pairs[i].A.ID, pairs[i].B.ID) < duplicateKey(pairs[j].A.ID, pairs[j].B.ID)
+	})
+	return pairs
+}
+
+// mergeFields are the single-valued fields where the admin picks which
+// contact's value survives a merge
+var mergeFields = []struct{ Name, Label string }{
+	{"contact_type", "Contact type"},
+	{"first_name", "First name"},
+	{"last_name", "Last name"},
+	{"email", "Email"},
+	{"phone", "Phone"},
+	{"company", "Company"},
+}
+
+// combineContacts merges other into keep. fromOther names the mergeFields
+// taking other's value; everything else that can hold several values is
+// combined, so the losing email and phone become extra entries.
+func combineContacts(keep, other Contact, fromOther map[string]bool) Contact {
+	merged := keep
+	merged.Emails = append([]ContactEmail(nil), keep.Emails...)
+	merged.Phones = append([]ContactPhone(nil), keep.Phones...)
+	merged.Addresses = append([]ContactAddress(nil), keep.Addresses...)
+	merged.Tags = append([]string(nil), keep.Tags...)
+
+	if fromOther["contact_type"] {
+		merged.ContactType = other.ContactType
+	}
+	if fromOther["first_name"] {
+		merged.FirstName = other.FirstName
+	}
+	if fromOther["last_name"] {
+		merged.LastName = other.LastName
+	}
+	if fromOther["email"] {
+		merged.Email = other.Email
+	}
+	if fromOther["phone"] {
+		merged.Phone = other.Phone
+	}
+	if fromOther["company"] || merged.CompanyID == nil {
+		merged.CompanyID = other.CompanyID
+	}
+	if merged.Password == "" {
+		merged.Password = other.Password
+	}
+
+	emails := append([]ContactEmail{{Label: "other", Email: keep.Email}, {Label: "other", Email: other.Email}}, other.Emails...)
+	for _, e := range emails {
+		known := strings.EqualFold(e.Email, merged.Email)
+		for _, existing := range merged.Emails {
+			known = known || strings.EqualFold(e.Email, existing.Email)
+		}
+		if !known {
+			merged.Emails = append(merged.Emails, e)
+		}
+	}
+
+	samePhone := func(a, b string) bool {
+		if digits := phoneDigits(a); digits != "" {
+			return digits == phoneDigits(b)
+		}
+		return strings.TrimSpace(a) == strings.TrimSpace(b)
+	}
+	phones := append([]ContactPhone{{Label: "other", Phone: keep.Phone}, {Label: "other", Phone: other.Phone}}, other.Phones...)
+	for _, p := range phones {
+		known := samePhone(p.Phone, merged.Phone)
+		for _, existing := range merged.Phones {
+			known = known || samePhone(p.Phone, existing.Phone)
+		}
+		if !known {
+			merged.Phones = append(merged.Phones, p)
+		}
+	}
+
+	for _, a := range other.Addresses {
+		known := false
+		for _, existing := range merged.Addresses {
+			known = known || strings.EqualFold(a.String(), existing.String())
+		}
+		if !known {
+			merged.Addresses = append(merged.Addresses, a)
+		}
+	}
+
+	for _, tag := range other.Tags {
+		known := false
+		for _, existing := range merged.Tags {
+			known = known || strings.EqualFold(tag, existing)
+		}
+		if !known {
+			merged.Tags = append(merged.Tags, tag)
+		}
+	}
+
+	merged.Custom = map[string]string{}
+	for name, value := range other.Custom {
+		merged.Custom[name] = value
+	}
+	for name, value := range keep.Custom {
+		if value != "" {
+			merged.Custom[name] = value
+		}
+	}
+	return merged
+}
+
+// DATABASE
+
+func (db *DB) duplicateDismissals() (map[string]bool, error) {
+	rows, err := db.Query("SELECT contact_a, contact_b FROM duplicate_dismissals")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dismissed := map[string]bool{}
+	for rows.Next() {
+		var a, b string
+		if err := rows.Scan(&a, &b); err != nil {
+			return nil, err
+		}
+		dismissed[duplicateKey(a, b)] = true
+	}
+	return dismissed, rows.Err()
+}
+
+// FindDuplicates compares all live contacts, leaving out dismissed pairs
+func (db *DB) FindDuplicates() ([]duplicatePair, error) {
+	contacts, err := db.GetAllContacts()
+	if err != nil {
+		return nil, err
+	}
+	if err := db.attachContactDetails(contacts); err != nil {
+		return nil, err
+	}
+	dismissed, err := db.duplicateDismissals()
+	if err != nil {
+		return nil, err
+	}
+	return findDuplicates(contacts, dismissed), nil
+}
+
+// DismissDuplicate records that a and b are different people
+func (db *DB) DismissDuplicate(a, b, username string) error {
+	if a > b {
+		a, b = b, a
+	}
+	_, err := db.Exec(`INSERT OR IGNORE INTO duplicate_dismissals (contact_a, contact_b, dismissed_by, dismissed_at)
+		VALUES (?, ?, ?, ?)`, a, b, username, time.Now().UTC())
+	return err
+}
+
+// MergeContacts saves merged, the combination of before and the contact
+// otherID, and moves the latter to the recycle bin with its details and
+// history, so a mistaken merge can be undone by restoring it. Logins of the
+// duplicate move to the merged contact, and the merge becomes a version in
+// its history.
+func (db *DB) MergeContacts(before, merged *Contact, otherID, author string) error {
+	password, err := storedPassword(merged.Password)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var otherEmail string
+	if err := tx.QueryRow("SELECT email FROM contacts WHERE id = ? AND deleted_at IS NULL", otherID).Scan(&otherEmail); err != nil {
+		return err
+	}
+	// emails stay unique in the recycle bin, so when the merged contact takes
+	// the duplicate's email the duplicate gets the one given up in exchange
+	swapEmail := otherEmail == merged.Email && before.Email != merged.Email
+	if swapEmail {
+		otherEmail = "merged:" + otherID
+	}
+	if _, err := tx.Exec("UPDATE contacts SET deleted_at = ?, email = ? WHERE id = ?", time.Now().UTC(), otherEmail, otherID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM duplicate_dismissals WHERE contact_a = ? OR contact_b = ?", otherID, otherID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE users SET contact_id = ? WHERE contact_id = ?", merged.ID, otherID); err != nil {
+		return err
+	}
+
+	if err := updateContactRows(tx, merged, password); err != nil {
+		return err
+	}
+	if err := addContactVersion(tx, before, merged, &ContactVersion{Author: author, MergedFrom: otherID}); err != nil {
+		return err
+	}
+	if swapEmail {
+		if _, err := tx.Exec("UPDATE contacts SET email = ? WHERE id = ?", before.Email, otherID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ADMIN PAGE
+
+var duplicatesPage = template.Must(template.New("duplicates").Parse(`
+{{define "duplicates-page"}}
+<!doctype html>
+<html lang="en">
+    <head>
+        <meta charset="UTF-8" />
+        <meta name="viewport" content="width=device-width, initial-scale=1.0" />
+        <title>Duplicates - AFCB</title>
+        <link rel="icon" type="image/x-icon" href="/static/favicon/favicon.ico" />
+        <script src="https://cdn.tailwindcss.com"></script>
+        <script src="https://unpkg.com/htmx.org@1.9.10"></script>
+        <script src="/static/csrf.js"></script>
+    </head>
+    <body class="bg-gray-100" hx-headers='{"X-CSRF-Token": "{{.CSRFToken}}"}'>
+        <nav class="bg-white shadow-md">
+            <div class="container mx-auto px-4">
+                <div class="flex justify-between items-center py-4">
+                    <a href="/" class="text-2xl font-bold text-blue-600">AFcb</a>
+                    <div class="flex items-center space-x-4">
+                        <a href="/" class="text-gray-600 hover:text-blue-600">Contacts</a>
+                        <a href="/companies-page" class="text-gray-600 hover:text-blue-600">Companies</a>
+                        <a href="/admin/users" class="text-gray-600 hover:text-blue-600">Users</a>
+                        <a href="/admin/fields" class="text-gray-600 hover:text-blue-600">Fields</a>
+                        <a href="/admin/tags" class="text-gray-600 hover:text-blue-600">Tags</a>
+                        <a href="/admin/contact-types" class="text-gray-600 hover:text-blue-600">Types</a>
+                        <a href="/admin/duplicates" class="text-blue-600 font-semibold">Duplicates</a>
+                        <a href="/admin/audit" class="text-gray-600 hover:text-blue-600">Audit Log</a>
+                        <a href="/admin/license" class="text-gray-600 hover:text-blue-600">Admin</a>
+                        <a href="/logout" class="px-4 py-2 bg-red-600 text-white rounded-md hover:bg-red-700">Logout</a>
+                    </div>
+                </div>
+            </div>
+        </nav>
+        <main class="container mx-auto px-4 py-8">
+            <h1 class="text-3xl font-bold text-gray-800 mb-2">Possible Duplicates</h1>
+            <p class="text-sm text-gray-600 mb-6">Contacts that look like the same person, by name, phone number and email.</p>
+            <div id="duplicates">
+                {{template "duplicates-list" .}}
+            </div>
+        </main>
+    </body>
+</html>
+{{end}}
+
+{{define "contact-summary"}}
+<div class="font-medium text-gray-900">{{.FirstName}} {{.LastName}} <span class="text-xs text-gray-400">{{.ID}}</span></div>
+<div class="text-sm text-gray-600">{{.Email}}{{range .Emails}}, {{.Email}}{{end}}</div>
+<div class="text-sm text-gray-600">{{.Phone}}{{range .Phones}}, {{.Phone}}{{end}}</div>
+{{end}}
+
+{{define "duplicates-list"}}
+{{if .Error}}<div class="bg-red-50 border border-red-200 text-red-700 rounded p-3 mb-4">{{.Error}}</div>{{end}}
+{{if .Message}}<div class="bg-green-50 border border-green-200 text-green-700 rounded p-3 mb-4">{{.Message}}</div>{{end}}
+{{if .Truncated}}<p class="text-sm text-gray-500 mb-2">Showing the {{len .Pairs}} best matches of {{.Total}}.</p>{{end}}
+<div class="bg-white rounded-lg shadow-md overflow-hidden">
+    <table class="min-w-full divide-y divide-gray-200">
+        <thead class="bg-gray-50">
+            <tr>
+                <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Score</th>
+                <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Contact</th>
+                <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Possible duplicate</th>
+                <th class="px-6 py-3"></th>
+            </tr>
+        </thead>
+        <tbody class="bg-white divide-y divide-gray-200">
+            {{range .Pairs}}
+            <tr>
+                <td class="px-6 py-3 align-top">
+                    <span class="px-2 py-1 rounded-full text-sm font-medium {{if ge .Score 80}}bg-red-100 text-red-800{{else}}bg-yellow-100 text-yellow-800{{end}}">{{.Score}}</span>
+                    <div class="text-xs text-gray-500 mt-1">{{range $i, $r := .Reasons}}{{if $i}}, {{end}}{{$r}}{{end}}</div>
+                </td>
+                <td class="px-6 py-3 align-top">{{template "contact-summary" .A}}</td>
+                <td class="px-6 py-3 align-top">{{template "contact-summary" .B}}</td>
+                <td class="px-6 py-3 align-top text-sm text-right whitespace-nowrap">
+                    <button class="text-blue-600 hover:text-blue-900"
+                            hx-get="/admin/duplicates/{{.A.ID}}/{{.B.ID}}"
+                            hx-target="#merge-{{.A.ID}}-{{.B.ID}}"
+                            hx-swap="innerHTML">Review</button>
+                    <button class="ml-3 text-gray-600 hover:text-gray-900"
+                            hx-post="/admin/duplicates/{{.A.ID}}/{{.B.ID}}/dismiss"
+                            hx-target="#duplicates"
+                            hx-swap="innerHTML">Not a duplicate</button>
+                </td>
+            </tr>
+            <tr><td colspan="4" id="merge-{{.A.ID}}-{{.B.ID}}"></td></tr>
+            {{else}}
+            <tr><td colspan="4" class="px-6 py-4 text-sm text-gray-500">No possible duplicates found.</td></tr>
+            {{end}}
+        </tbody>
+    </table>
+</div>
+{{end}}
+
+{{define "merge-form"}}
+<form class="bg-gray-50 border rounded p-4 m-4"
+      hx-post="/admin/duplicates/{{.A.ID}}/{{.B.ID}}/merge"
+      hx-target="#duplicates"
+      hx-swap="innerHTML"
+      hx-confirm="Merge these contacts? The one not kept is deleted.">
+    <table class="min-w-full text-sm mb-3">
+        <thead>
+            <tr class="text-left text-xs text-gray-500 uppercase">
+                <th class="py-1 pr-4"></th>
+                <th class="py-1 pr-4">{{.A.ID}}</th>
+                <th class="py-1">{{.B.ID}}</th>
+            </tr>
+        </thead>
+        <tbody>
+            <tr>
+                <td class="py-1 pr-4 font-medium text-gray-700">Keep record</td>
+                <td class="py-1 pr-4"><label><input type="radio" name="keep" value="a" checked> {{.A.ID}}</label></td>
+                <td class="py-1"><label><input type="radio" name="keep" value="b"> {{.B.ID}}</label></td>
+            </tr>
+            {{range .Fields}}
+            <tr>
+                <td class="py-1 pr-4 font-medium text-gray-700">{{.Label}}</td>
+                {{if eq .A .B}}
+                <td class="py-1 text-gray-600" colspan="2">{{if .A}}{{.A}}{{else}}-{{end}}</td>
+                {{else}}
+                <td class="py-1 pr-4"><label><input type="radio" name="{{.Name}}" value="a" {{if or .A (not .B)}}checked{{end}}> {{if .A}}{{.A}}{{else}}-{{end}}</label></td>
+                <td class="py-1"><label><input type="radio" name="{{.Name}}" value="b" {{if and .B (not .A)}}checked{{end}}> {{if .B}}{{.B}}{{else}}-{{end}}</label></td>
+                {{end}}
+            </tr>
+            {{end}}
+        </tbody>
+    </table>
+    <p class="text-xs text-gray-500 mb-3">
+        The other emails, phone numbers, addresses, tags and field values of both contacts are kept.
+        Logins of the deleted contact move to the merged one.
+    </p>
+    <button type="submit" class="px-4 py-2 bg-blue-600 text-white rounded-md hover:bg-blue-700">Merge</button>
+</form>
+{{end}}
+`))
+
+func renderDuplicates(w http.ResponseWriter, r *http.Request, name, message, errMessage string) {
+	pairs, err := db.FindDuplicates()
+	if err != nil {
+		http.Error(w, "Failed to find duplicates: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Pairs     []duplicatePair
+		Total     int
+		Truncated bool
+		Message   string
+		Error     string
+		CSRFToken string
+	}{Pairs: pairs, Total: len(pairs), Message: message, Error: errMessage, CSRFToken: csrfToken(r)}
+	if len(pairs) > maxDuplicatePairs {
+		data.Pairs, data.Truncated = pairs[:maxDuplicatePairs], true
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := duplicatesPage.ExecuteTemplate(w, name, data); err != nil {
+		fmt.Printf("Error rendering duplicates: %v\n", err)
+	}
+}
+
+func duplicatesPageHandler(w http.ResponseWriter, r *http.Request) {
+	renderDuplicates(w, r, "duplicates-page", "", "")
+}
+
+// duplicatePairFromRequest loads the contacts named by the {a} and {b}
+// route variables
+func duplicatePairFromRequest(w http.ResponseWriter, r *http.Request) (*Contact, *Contact, bool) {
+	vars := mux.Vars(r)
+	if vars["a"] == vars["b"] {
+		http.Error(w, "A contact cannot be merged with itself", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	a, err := db.GetContact(vars["a"])
+	if err != nil {
+		http.Error(w, "Contact not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	b, err := db.GetContact(vars["b"])
+	if err != nil {
+		http.Error(w, "Contact not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	return a, b, true
+}
+
+func mergeFormHandler(w http.ResponseWriter, r *http.Request) {
+	a, b, ok := duplicatePairFromRequest(w, r)
+	if !ok {
+		return
+	}
+	companyNames, err := db.companyNamesByID()
+	if err != nil {
+		http.Error(w, "Failed to load companies: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	values := func(c *Contact) map[string]string {
+		company := ""
+		if c.CompanyID != nil {
+			company = companyNames[*c.CompanyID]
+		}
+		return map[string]string{
+			"contact_type": c.ContactType,
+			"first_name":   c.FirstName,
+			"last_name":    c.LastName,
+			"email":        c.Email,
+			"phone":        c.Phone,
+			"company":      company,
+		}
+	}
+	valuesA, valuesB := values(a), values(b)
+
+	type mergeRow struct{ Name, Label, A, B string }
+	data := struct {
+		A, B   *Contact
+		Fields []mergeRow
+	}{A: a, B: b}
+	for _, f := range mergeFields {
+		data.Fields = append(data.Fields, mergeRow{f.Name, f.Label, valuesA[f.Name], valuesB[f.Name]})
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := duplicatesPage.ExecuteTemplate(w, "merge-form", data); err != nil {
+		fmt.Printf("Error rendering merge form: %v\n", err)
+	}
+}
+
+func mergeContactsHandler(w http.ResponseWriter, r *http.Request) {
+	a, b, ok := duplicatePairFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	keepSide := "a"
+	keep, other := a, b
+	if r.FormValue("keep") == "b" {
+		keepSide = "b"
+		keep, other = b, a
+	}
+	fromOther := map[string]bool{}
+	for _, f := range mergeFields {
+		if side := r.FormValue(f.Name); side != "" && side != keepSide {
+			fromOther[f.Name] = true
+		}
+	}
+
+	merged := combineContacts(*keep, *other, fromOther)
+	err := validateContact(&merged)
+	if err == nil {
+		err = db.MergeContacts(keep, &merged, other.ID, auditActor(r))
+		if isUniqueViolation(err) {
+			err = errEmailTaken
+		}
+	}
+	if err != nil {
+		renderDuplicates(w, r, "duplicates-list", "", "Cannot merge: "+err.Error())
+		return
+	}
+
+	recordAudit(r, AuditMerge, "contact", merged.ID, keep, &merged)
+	recordAudit(r, AuditMerge, "contact", other.ID, other, nil)
+	fmt.Printf("Contact %s merged into %s\n", other.ID, merged.ID)
+	renderDuplicates(w, r, "duplicates-list",
+		fmt.Sprintf("Merged %s %s into %s. The duplicate can be restored from the recycle bin.", other.FirstName, other.LastName, merged.ID), "")
+}
+
+func dismissDuplicateHandler(w http.ResponseWriter, r *http.Request) {
+	a, b, ok := duplicatePairFromRequest(w, r)
+	if !ok {
+		return
+	}
+	username, _ := getCurrentUser(r)
+	if err := db.DismissDuplicate(a.ID, b.ID, username); err != nil {
+		http.Error(w, "Failed to dismiss: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("Contacts %s and %s marked as not duplicates by %s\n", a.ID, b.ID, username)
+	renderDuplicates(w, r, "duplicates-list", "", "")
+}
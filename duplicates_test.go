@@ -0,0 +1,175 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScoreDuplicate(t *testing.T) {
+	john := Contact{ID: "a", FirstName: "John", LastName: "Smith", Email: "john@acme.test", Phone: "+44 20 7946 0958"}
+	tests := []struct {
+		other  Contact
+		listed bool
+	}{
+		{Contact{FirstName: "john", LastName: "Smith", Email: "j.smith@mail.test", Phone: "020 7946 0958"}, true},
+		{Contact{FirstName: "Smith", LastName: "John", Email: "john+crm@acme.test", Phone: "555 0100"}, true},
+		{Contact{FirstName: "Jon", LastName: "Smith", Email: "jon@other.test", Phone: "(020) 7946-0958"}, true},
+		{Contact{FirstName: "Mary", LastName: "Jones", Email: "mary@acme.test", Phone: "555 0101",
+			Emails: []ContactEmail{{Label: "work", Email: "JOHN@acme.test"}}}, true},
+		{Contact{FirstName: "John", LastName: "Smith", Email: "other@example.test", Phone: "555 0102"}, false},
+		{Contact{FirstName: "Johanna", LastName: "Smithers", Email: "jo@acme.test", Phone: "555 0103"}, false},
+	}
+	for _, tt := range tests {
+		score, reasons := scoreDuplicate(&john, &tt.other)
+		if listed := score >= duplicateThreshold; listed != tt.listed {
+			t.Errorf("scoreDuplicate(%s %s) = %d %v, listed %t, want %t",
+				tt.other.FirstName, tt.other.LastName, score, reasons, listed, tt.listed)
+		}
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	contacts := []Contact{
+		{ID: "c1", FirstName: "John", LastName: "Smith", Email: "john@acme.test", Phone: "020 7946 0958"},
+		{ID: "c2", FirstName: "John", LastName: "Smith", Email: "js@mail.test", Phone: "+44 20 7946 0958"},
+		{ID: "c3", FirstName: "Mary", LastName: "Smith", Email: "mary@acme.test", Phone: "555 0101"},
+		{ID: "c4", FirstName: "Jon", LastName: "Smith", Email: "jon@acme.test", Phone: "555 0102",
+			Phones: []ContactPhone{{Label: "work", Phone: "0207 946 0958"}}},
+	}
+	pairs := findDuplicates(contacts, nil)
+	var got []string
+	for _, p := range pairs {
+		got = append(got, p.A.ID+"-"+p.B.ID)
+	}
+	if want := []string{"c1-c2", "c1-c4", "c2-c4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("findDuplicates = %v, want %v", got, want)
+	}
+
+	pairs = findDuplicates(contacts, map[string]bool{duplicateKey("c2", "c1"): true})
+	if len(pairs) != 2 || pairs[0].A.ID+"-"+pairs[0].B.ID == "c1-c2" {
+		t.Errorf("findDuplicates kept a dismissed pair: %v", pairs)
+	}
+}
+
+func TestCombineContacts(t *testing.T) {
+	company := "acme"
+	keep := Contact{ID: "c1", ContactType: "Work", FirstName: "Jon", LastName: "Smith", Email: "jon@acme.test", Phone: "020 7946 0958",
+		Phones: []ContactPhone{{Label: "mobile", Phone: "07700 900123"}},
+		Tags:   []string{"VIP"}, Custom: map[string]string{"tier": "Gold"}}
+	other := Contact{ID: "c2", ContactType: "Personal", FirstName: "John", LastName: "Smith", Email: "john@mail.test", Phone: "+44 20 7946 0958",
+		CompanyID: &company,
+		Phones:    []ContactPhone{{Label: "mobile", Phone: "+44 7700 900123"}, {Label: "home", Phone: "01632 960001"}},
+		Addresses: []ContactAddress{{Label: "home", City: "London"}},
+		Tags:      []string{"vip", "Press"}, Custom: map[string]string{"tier": "Silver", "birthday": "1990-05-01"}}
+
+	merged := combineContacts(keep, other, map[string]bool{"first_name": true, "email": true})
+
+	if merged.ID != "c1" || merged.FirstName != "John" || merged.Email != "john@mail.test" || merged.ContactType != "Work" {
+		t.Errorf("merged fields = %+v", merged)
+	}
+	if merged.CompanyID == nil || *merged.CompanyID != "acme" {
+		t.Errorf("merged company = %v, want the one only the duplicate had", merged.CompanyID)
+	}
+	if want := []ContactEmail{{Label: "other", Email: "jon@acme.test"}}; !reflect.DeepEqual(merged.Emails, want) {
+		t.Errorf("merged emails = %v, want %v", merged.Emails, want)
+	}
+	if want := []ContactPhone{{Label: "mobile", Phone: "07700 900123"}, {Label: "home", Phone: "01632 960001"}}; !reflect.DeepEqual(merged.Phones, want) {
+		t.Errorf("merged phones = %v, want %v", merged.Phones, want)
+	}
+	if len(merged.Addresses) != 1 {
+		t.Errorf("merged addresses = %v", merged.Addresses)
+	}
+	if want := []string{"VIP", "Press"}; !reflect.DeepEqual(merged.Tags, want) {
+		t.Errorf("merged tags = %v, want %v", merged.Tags, want)
+	}
+	if want := map[string]string{"tier": "Gold", "birthday": "1990-05-01"}; !reflect.DeepEqual(merged.Custom, want) {
+		t.Errorf("merged custom values = %v, want %v", merged.Custom, want)
+	}
+	if len(keep.Phones) != 1 || len(keep.Tags) != 1 {
+		t.Errorf("combineContacts modified keep: %+v", keep)
+	}
+}
+
+func TestMergeContacts(t *testing.T) {
+	testDB := useTestDB(t)
+	savedCountry := config.Phone.DefaultCountry
+	config.Phone.DefaultCountry = "GB"
+	t.Cleanup(func() { config.Phone.DefaultCountry = savedCountry })
+
+	keep := Contact{ID: "c1", ContactType: "Work", FirstName: "Jon", LastName: "Smith", Email: "jon@acme.test", Phone: "020 7946 0958"}
+	other := Contact{ID: "c2", ContactType: "Work", FirstName: "John", LastName: "Smith", Email: "john@mail.test", Phone: "+44 20 7946 0958",
+		Emails: []ContactEmail{{Label: "home", Email: "johnny@home.test"}}}
+	for _, c := range []*Contact{&keep, &other} {
+		if err := insertContact(testDB, c); err != nil {
+			t.Fatalf("insertContact failed: %v", err)
+		}
+		if err := testDB.CreateUser(&User{Username: c.Email, Password: "secret", ContactID: &c.ID}); err != nil {
+			t.Fatalf("CreateUser failed: %v", err)
+		}
+	}
+
+	edited := other
+	edited.LastName = "Smyth"
+	if err := testDB.SaveContactVersion(&other, &edited, "admin", 0); err != nil {
+		t.Fatalf("SaveContactVersion failed: %v", err)
+	}
+
+	pairs, err := testDB.FindDuplicates()
+	if err != nil || len(pairs) != 1 {
+		t.Fatalf("FindDuplicates = %v, %v; want one pair", pairs, err)
+	}
+
+	// the duplicate's email can become the primary one
+	merged := combineContacts(keep, other, map[string]bool{"first_name": true, "email": true})
+	if err := validateContact(&merged); err != nil {
+		t.Fatalf("validateContact failed: %v", err)
+	}
+	if err := testDB.MergeContacts(&keep, &merged, other.ID, "admin"); err != nil {
+		t.Fatalf("MergeContacts failed: %v", err)
+	}
+
+	if _, err := testDB.GetContact("c2"); err == nil {
+		t.Errorf("the merged duplicate still exists")
+	}
+	// the duplicate waits in the recycle bin with the email the merge freed
+	trashed, err := testDB.GetDeletedContact("c2")
+	if err != nil || trashed.Email != "jon@acme.test" || trashed.FirstName != "John" {
+		t.Errorf("duplicate in the recycle bin = %+v, %v", trashed, err)
+	}
+	if versions, _ := testDB.GetContactVersions("c2"); len(versions) != 2 {
+		t.Errorf("history of the duplicate = %+v, want it kept", versions)
+	}
+	loaded, err := testDB.GetContact("c1")
+	if err != nil {
+		t.Fatalf("GetContact failed: %v", err)
+	}
+	if loaded.Email != "john@mail.test" || len(loaded.Emails) != 2 {
+		t.Errorf("merged contact = %+v", loaded)
+	}
+	if contacts, _ := testDB.SearchContacts("johnny"); len(contacts) != 1 || contacts[0].ID != "c1" {
+		t.Errorf("search for the duplicate's extra email = %v, want c1", contacts)
+	}
+
+	for _, username := range []string{"jon@acme.test", "john@mail.test"} {
+		user, err := testDB.GetUser(username)
+		if err != nil || user.ContactID == nil || *user.ContactID != "c1" {
+			t.Errorf("user %s after the merge = %+v, %v; want contact c1", username, user, err)
+		}
+	}
+
+	versions, err := testDB.GetContactVersions("c1")
+	if err != nil || len(versions) != 2 || versions[1].MergedFrom != "c2" || versions[1].Author != "admin" {
+		t.Errorf("history after the merge = %+v, %v", versions, err)
+	}
+	if pairs, _ := testDB.FindDuplicates(); len(pairs) != 0 {
+		t.Errorf("duplicates after the merge = %v", pairs)
+	}
+
+	// a mistaken merge is undone by restoring the duplicate
+	if restored, err := testDB.RestoreContact("c2"); err != nil || !restored {
+		t.Fatalf("RestoreContact = %t, %v", restored, err)
+	}
+	if c, err := testDB.GetContact("c2"); err != nil || len(c.Emails) != 1 {
+		t.Errorf("restored duplicate = %+v, %v", c, err)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Directory export. The result set is the same one the contact list shows
+// for the given search keyword, so "search, then export" downloads exactly
+// what is on screen. Custom contact fields follow the fixed columns.
+
+var exportColumns = []string{"First Name", "Last Name", "Email", "Phone", "Contact Type", "Company",
+	"Other Emails", "Other Phones", "Addresses", "Tags"}
+
+type exportRow struct {
+	Contact     Contact
+	CompanyName string
+	Custom      []string // values of the exported custom fields, in column order
+}
+
+func (row exportRow) values() []string {
+	c := row.Contact
+
+	// extra details go into one cell per kind as "label: value; ..."
+	var emails, phones, addresses []string
+	for _, e := range c.Emails {
+		emails = append(emails, e.Label+": "+e.Email)
+	}
+	for _, p := range c.Phones {
+		phones = append(phones, p.Label+": "+p.Phone)
+	}
+	for _, a := range c.Addresses {
+		addresses = append(addresses, a.Label+": "+a.String())
+	}
+
+	values := []string{c.FirstName, c.LastName, c.Email, c.Phone, c.ContactType, row.CompanyName,
+		strings.Join(emails, "; "), strings.Join(phones, "; "), strings.Join(addresses, "; "), strings.Join(c.Tags, "; ")}
+	return append(values, row.Custom...)
+}
+
+// exportContacts loads every contact matching keyword, in the list's sort
+// order, with their company names. It also returns the column headers.
+func exportContacts(keyword string, opts ListOptions) ([]string, []exportRow, error) {
+	opts.PerPage = 0
+	contacts, _, err := db.ListContacts(keyword, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	companies, err := db.GetCompanies()
+	if err != nil {
+		return nil, nil, err
+	}
+	fields, err := db.ListCustomFields("contact")
+	if err != nil {
+		return nil, nil, err
+	}
+	columns := append([]string{}, exportColumns...)
+	for _, f := range fields {
+		columns = append(columns, f.Label)
+	}
+	companyNames := map[string]string{}
+	for _, c := range companies {
+		companyNames[c.ID] = c.Name
+	}
+
+	rows := make([]exportRow, 0, len(contacts))
+	for _, c := range contacts {
+		row := exportRow{Contact: c}
+		if c.CompanyID != nil {
+			row.CompanyName = companyNames[*c.CompanyID]
+		}
+		for _, f := range fields {
+			row.Custom = append(row.Custom, c.Custom[f.Name])
+		}
+		rows = append(rows, row)
+	}
+	return columns, rows, nil
+}
+
+// csvSafe neutralises values that spreadsheet applications would otherwise
+// evaluate as formulas
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		// phone numbers legitimately start with +
+		if value[0] == '+' && strings.Trim(value[1:], "0123456789 ()-.") == "" {
+			return value
+		}
+		return "'" + value
+	}
+	return value
+}
+
+func writeContactsCSV(w http.ResponseWriter, columns []string, rows []exportRow) error {
+	// BOM so Excel detects UTF-8
+	w.Write([]byte("\xef\xbb\xbf"))
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		values := row.values()
+		for i := range values {
+			values[i] = csvSafe(values[i])
+		}
+		if err := cw.Write(values); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeContactsVCard(w http.ResponseWriter, columns []string, rows []exportRow) error {
+	now := time.Now()
+	for _, row := range rows {
+		if _, err := fmt.Fprintf(w, "%s\n", formatVCard(&row.Contact, row.CompanyName, now)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeContactsXLSX(w http.ResponseWriter, columns []string, rows []exportRow) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	sheet := "Contacts"
+	f.SetSheetName("Sheet1", sheet)
+
+	header := make([]interface{}, len(columns))
+	for i, col := range columns {
+		header[i] = col
+	}
+	if err := f.SetSheetRow(sheet, "A1", &header); err != nil {
+		return err
+	}
+	bold, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	lastCol, _ := excelize.ColumnNumberToName(len(columns))
+	f.SetCellStyle(sheet, "A1", lastCol+"1", bold)
+
+	for i, row := range rows {
+		values := row.values()
+		cells := make([]interface{}, len(values))
+		for j, v := range values {
+			cells[j] = v // keep phone numbers as text
+		}
+		cell, _ := excelize.CoordinatesToCellName(1, i+2)
+		if err := f.SetSheetRow(sheet, cell, &cells); err != nil {
+			return err
+		}
+	}
+
+	f.SetColWidth(sheet, "A", lastCol, 20)
+	f.SetPanes(sheet, &excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"})
+	if len(rows) > 0 {
+		f.AutoFilter(sheet, fmt.Sprintf("A1:%s%d", lastCol, len(rows)+1), nil)
+	}
+
+	return f.Write(w)
+}
+
+// exportContactsHandler serves GET /contacts/export?format=csv|vcf|xlsx&q=&sort=&dir=
+func exportContactsHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	keyword := r.URL.Query().Get("q")
+
+	var contentType string
+	var write func(http.ResponseWriter, []string, []exportRow) error
+	switch format {
+	case "csv", "":
+		format, contentType, write = "csv", "text/csv; charset=utf-8", writeContactsCSV
+	case "vcf":
+		contentType, write = "text/vcard; charset=utf-8", writeContactsVCard
+	case "xlsx":
+		contentType, write = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", writeContactsXLSX
+	default:
+		http.Error(w, "Unsupported export format: "+format, http.StatusBadRequest)
+		return
+	}
+
+	columns, rows, err := exportContacts(keyword, listOptionsFromRequest(r, contactSorts))
+	if err != nil {
+		http.Error(w, "Export failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("contacts_%s.%s", time.Now().Format("20060102"), format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	if err := write(w, columns, rows); err != nil {
+		fmt.Printf("Error writing %s export: %v\n", format, err)
+		return
+	}
+
+	username, _ := getCurrentUser(r)
+	fmt.Printf("Exported %d contacts as %s for %s (q=%q)\n", len(rows), format, username, keyword)
+}
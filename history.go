@@ -0,0 +1,353 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Contact history. Every saved edit stores a full snapshot of the versioned
+// fields in contact_versions, numbered per contact. Contacts created before
+// versioning existed get their previous state stored as version 1 on their
+// first edit, so that edit still shows up as a diff.
+
+// contactSnapshot holds the fields that are versioned. Passwords are not.
+type contactSnapshot struct {
+	ContactType string  `json:"contact_type"`
+	FirstName   string  `json:"first_name"`
+	LastName    string  `json:"last_name"`
+	Email       string  `json:"email"`
+	Phone       string  `json:"phone"`
+	CompanyID   *string `json:"company_id"`
+}
+
+func snapshotOf(c *Contact) contactSnapshot {
+	return contactSnapshot{
+		ContactType: c.ContactType,
+		FirstName:   c.FirstName,
+		LastName:    c.LastName,
+		Email:       c.Email,
+		Phone:       c.Phone,
+		CompanyID:   c.CompanyID,
+	}
+}
+
+func (s contactSnapshot) applyTo(c *Contact) {
+	c.ContactType = s.ContactType
+	c.FirstName = s.FirstName
+	c.LastName = s.LastName
+	c.Email = s.Email
+	c.Phone = s.Phone
+	c.CompanyID = s.CompanyID
+}
+
+type ContactVersion struct {
+	ContactID    string
+	Version      int
+	CreatedAt    time.Time
+	Author       string
+	RevertedFrom int    // version this one restored, 0 for ordinary edits
+	MergedFrom   string // ID of the duplicate merged into the contact
+	Snapshot     contactSnapshot
+}
+
+const contactVersionColumns = `contact_id, version, created_at, author, reverted_from, merged_from,
+	contact_type, first_name, last_name, email, phone, company_id`
+
+func scanContactVersion(scan func(dest ...interface{}) error) (*ContactVersion, error) {
+	var v ContactVersion
+	var revertedFrom sql.NullInt64
+	var mergedFrom, companyID sql.NullString
+	if err := scan(&v.ContactID, &v.Version, &v.CreatedAt, &v.Author, &revertedFrom, &mergedFrom,
+		&v.Snapshot.ContactType, &v.Snapshot.FirstName, &v.Snapshot.LastName,
+		&v.Snapshot.Email, &v.Snapshot.Phone, &companyID); err != nil {
+		return nil, err
+	}
+	v.RevertedFrom = int(revertedFrom.Int64)
+	v.MergedFrom = mergedFrom.String
+	if companyID.Valid {
+		v.Snapshot.CompanyID = &companyID.String
+	}
+	return &v, nil
+}
+
+// GetContactVersions returns the history of a contact, oldest first
+func (db *DB) GetContactVersions(contactID string) ([]ContactVersion, error) {
+	rows, err := db.Query("SELECT "+contactVersionColumns+" FROM contact_versions WHERE contact_id = ? ORDER BY version", contactID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []ContactVersion
+	for rows.Next() {
+		v, err := scanContactVersion(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, *v)
+	}
+	return versions, rows.Err()
+}
+
+func (db *DB) GetContactVersion(contactID string, version int) (*ContactVersion, error) {
+	return scanContactVersion(db.QueryRow("SELECT "+contactVersionColumns+
+		" FROM contact_versions WHERE contact_id = ? AND version = ?", contactID, version).Scan)
+}
+
+func insertContactVersion(ex execer, v *ContactVersion) error {
+	var revertedFrom, mergedFrom interface{}
+	if v.RevertedFrom > 0 {
+		revertedFrom = v.RevertedFrom
+	}
+	if v.MergedFrom != "" {
+		mergedFrom = v.MergedFrom
+	}
+	_, err := ex.Exec(`INSERT INTO contact_versions (contact_id, version, created_at, author, reverted_from, merged_from,
+		contact_type, first_name, last_name, email, phone, company_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		v.ContactID, v.Version, v.CreatedAt, v.Author, revertedFrom, mergedFrom,
+		v.Snapshot.ContactType, v.Snapshot.FirstName, v.Snapshot.LastName,
+		v.Snapshot.Email, v.Snapshot.Phone, v.Snapshot.CompanyID)
+	return err
+}
+
+// SaveContactVersion records the edit from before to after made by author.
+// Edits that leave every versioned field unchanged are not recorded.
+func (db *DB) SaveContactVersion(before, after *Contact, author string, revertedFrom int) error {
+	if reflect.DeepEqual(snapshotOf(before), snapshotOf(after)) {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := addContactVersion(tx, before, after, &ContactVersion{Author: author, RevertedFrom: revertedFrom}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// addContactVersion stores v, filled in with the number and the snapshot of
+// after, as the next version of the contact
+func addContactVersion(tx *sql.Tx, before, after *Contact, v *ContactVersion) error {
+	var latest int
+	if err := tx.QueryRow("SELECT COALESCE(MAX(version), 0) FROM contact_versions WHERE contact_id = ?", after.ID).Scan(&latest); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if latest == 0 {
+		// first edit since versioning began: keep the state it started from
+		createdAt := now
+		var created sql.NullTime
+		if err := tx.QueryRow("SELECT created_at FROM contacts WHERE id = ?", after.ID).Scan(&created); err == nil && created.Valid {
+			createdAt = created.Time
+		}
+		latest++
+		if err := insertContactVersion(tx, &ContactVersion{ContactID: after.ID, Version: latest, CreatedAt: createdAt, Snapshot: snapshotOf(before)}); err != nil {
+			return err
+		}
+	}
+
+	v.ContactID = after.ID
+	v.Version = latest + 1
+	v.CreatedAt = now
+	v.Snapshot = snapshotOf(after)
+	return insertContactVersion(tx, v)
+}
+
+// saveContactVersion records an edit made through r. Like the audit log it
+// never fails the request.
+func saveContactVersion(r *http.Request, before, after *Contact, revertedFrom int) {
+	if err := db.SaveContactVersion(before, after, auditActor(r), revertedFrom); err != nil {
+		fmt.Printf("Warning: Failed to save version of contact %s: %v\n", after.ID, err)
+	}
+}
+
+// companyNamesByID includes companies in the recycle bin, which old
+// versions may still point at
+func (db *DB) companyNamesByID() (map[string]string, error) {
+	rows, err := db.Query("SELECT id, name FROM companies")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := map[string]string{}
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = name
+	}
+	return names, rows.Err()
+}
+
+// HISTORY HANDLERS
+
+type historyEntry struct {
+	ContactVersion
+	Changes []auditFieldChange
+	Current bool
+}
+
+// contactHistory pairs each version with its diff against the one before,
+// newest first
+func contactHistory(versions []ContactVersion, companyNames map[string]string) []historyEntry {
+	named := func(s contactSnapshot) map[string]interface{} {
+		fields := auditFields(s)
+		delete(fields, "company_id")
+		if s.CompanyID != nil {
+			name, ok := companyNames[*s.CompanyID]
+			if !ok {
+				name = *s.CompanyID
+			}
+			fields["company"] = name
+		}
+		return fields
+	}
+
+	entries := make([]historyEntry, len(versions))
+	for i, v := range versions {
+		var before interface{}
+		if i > 0 {
+			before = named(versions[i-1].Snapshot)
+		}
+		entries[len(versions)-1-i] = historyEntry{
+			ContactVersion: v,
+			Changes:        changeList(auditDiff(before, named(v.Snapshot))),
+			Current:        i == len(versions)-1,
+		}
+	}
+	return entries
+}
+
+var contactHistoryTemplate = template.Must(template.New("history").Parse(`
+{{range .Entries}}
+<div class="border rounded p-2 mb-2 text-sm">
+    <div class="flex justify-between items-center">
+        <span class="font-semibold text-gray-800">
+            Version {{.Version}}{{if .Current}} (current){{end}}
+        </span>
+        {{if not .Current}}
+        <button class="text-blue-600 hover:text-blue-900 text-xs"
+                hx-post="/contacts/{{$.ContactID}}/versions/{{.Version}}/revert"
+                hx-target="#contact-{{$.ContactID}}"
+                hx-swap="outerHTML"
+                hx-confirm="Revert this contact to version {{.Version}}?"
+                hx-on::after-request="if(event.detail.successful) htmx.remove(htmx.find('#contact-modal'))">Revert</button>
+        {{end}}
+    </div>
+    <div class="text-xs text-gray-500 mb-1">
+        {{.CreatedAt.Format "2006-01-02 15:04"}}
+        {{if .Author}}by {{.Author}}{{else}}(original){{end}}
+        {{if .RevertedFrom}}&middot; reverted to version {{.RevertedFrom}}{{end}}
+        {{if .MergedFrom}}&middot; merged with duplicate {{.MergedFrom}}{{end}}
+    </div>
+    {{if eq .Version 1}}
+    <div class="text-gray-600">{{.Snapshot.FirstName}} {{.Snapshot.LastName}} &middot; {{.Snapshot.Email}} &middot; {{.Snapshot.Phone}}</div>
+    {{else}}
+    {{range .Changes}}
+    <div>
+        <span class="font-medium text-gray-700">{{.Field}}:</span>
+        {{if .From}}<span class="text-red-600 line-through">{{.From}}</span>{{end}}
+        {{if and .From .To}}&rarr;{{end}}
+        {{if .To}}<span class="text-green-700">{{.To}}</span>{{end}}
+    </div>
+    {{end}}
+    {{end}}
+</div>
+{{else}}
+<p class="text-sm text-gray-500">No changes recorded yet.</p>
+{{end}}
+`))
+
+func contactHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	versions, err := db.GetContactVersions(id)
+	if err != nil {
+		http.Error(w, "Failed to load history: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	companyNames, err := db.companyNamesByID()
+	if err != nil {
+		http.Error(w, "Failed to load history: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		ContactID string
+		Entries   []historyEntry
+	}{
+		ContactID: id,
+		Entries:   contactHistory(versions, companyNames),
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := contactHistoryTemplate.Execute(w, data); err != nil {
+		fmt.Printf("Error rendering contact history: %v\n", err)
+	}
+}
+
+// revertContactHandler restores the versioned fields of an earlier version.
+// The revert itself becomes a new version, so it can be undone the same way.
+func revertContactHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	number, err := strconv.Atoi(vars["version"])
+	if err != nil {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+
+	version, err := db.GetContactVersion(id, number)
+	if err != nil {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+	contact, err := db.GetContact(id)
+	if err != nil {
+		http.Error(w, "Contact not found", http.StatusNotFound)
+		return
+	}
+
+	before := *contact
+	version.Snapshot.applyTo(contact)
+	if err := validateContact(contact); err != nil {
+		http.Error(w, "Cannot revert: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkOwnContactEdit(r, &before, contact, ""); err != nil {
+		http.Error(w, "Cannot revert: "+err.Error(), http.StatusForbidden)
+		return
+	}
+	// without a password the contact's login, if any, is left as it is
+	err = checkEmailAvailable(contact.Email, id)
+	if err == nil {
+		err = updateContactWithUser(contact, "")
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errEmailTaken) {
+			status = http.StatusConflict
+		}
+		http.Error(w, "Cannot revert: "+err.Error(), status)
+		return
+	}
+
+	saveContactVersion(r, &before, contact, number)
+	recordAudit(r, AuditUpdate, "contact", id, &before, contact)
+	fmt.Printf("Contact %s reverted to version %d\n", id, number)
+	renderCard(w, r, *contact)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestContactVersions(t *testing.T) {
+	conn := openTestDB(t)
+	if _, err := migrateUp(conn, 0); err != nil {
+		t.Fatalf("migrateUp failed: %v", err)
+	}
+	testDB := &DB{DB: conn}
+
+	acme := "acme"
+	if err := testDB.CreateCompany(&Company{ID: acme, Name: "Acme"}); err != nil {
+		t.Fatalf("CreateCompany failed: %v", err)
+	}
+	original := Contact{ID: "c1", ContactType: "Work", FirstName: "John", LastName: "Smith", Email: "john@acme.test", Phone: "111"}
+	if err := insertContact(testDB, &original); err != nil {
+		t.Fatalf("insertContact failed: %v", err)
+	}
+
+	// the first edit also stores the state it started from
+	edited := original
+	edited.Phone = "222"
+	edited.CompanyID = &acme
+	if err := testDB.SaveContactVersion(&original, &edited, "af", 0); err != nil {
+		t.Fatalf("SaveContactVersion failed: %v", err)
+	}
+
+	// password-only changes are not versioned
+	passwordOnly := edited
+	passwordOnly.Password = "secret"
+	if err := testDB.SaveContactVersion(&edited, &passwordOnly, "af", 0); err != nil {
+		t.Fatalf("SaveContactVersion failed: %v", err)
+	}
+
+	reverted := edited
+	reverted.Phone = "111"
+	reverted.CompanyID = nil
+	if err := testDB.SaveContactVersion(&edited, &reverted, "ed", 1); err != nil {
+		t.Fatalf("SaveContactVersion failed: %v", err)
+	}
+
+	versions, err := testDB.GetContactVersions("c1")
+	if err != nil {
+		t.Fatalf("GetContactVersions failed: %v", err)
+	}
+	if len(versions) != 3 {
+		t.Fatalf("got %d versions, want 3", len(versions))
+	}
+	if versions[0].Author != "" || versions[0].Snapshot.Phone != "111" {
+		t.Errorf("baseline version = %+v", versions[0])
+	}
+	if versions[2].Author != "ed" || versions[2].RevertedFrom != 1 {
+		t.Errorf("revert version = %+v", versions[2])
+	}
+
+	history := contactHistory(versions, map[string]string{acme: "Acme"})
+	if !history[0].Current || history[0].Version != 3 {
+		t.Errorf("history is not newest first: %+v", history[0])
+	}
+	want := []auditFieldChange{{"company", "", "Acme"}, {"phone", "111", "222"}}
+	got := history[1].Changes
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("version 2 changes = %v, want %v", got, want)
+	}
+}
+
+func TestRevertLeavesLoginsAlone(t *testing.T) {
+	testDB := useTestDB(t)
+
+	original := Contact{ID: "c1", ContactType: "Work", FirstName: "Sam", LastName: "Self", Email: "sam@old.test", Phone: "+44 20 7946 0959"}
+	if err := insertContact(testDB, &original); err != nil {
+		t.Fatalf("insertContact failed: %v", err)
+	}
+	edited := original
+	edited.FirstName, edited.Email = "Samuel", "sam@acme.test"
+	if err := testDB.UpdateContact(&edited); err != nil {
+		t.Fatalf("UpdateContact failed: %v", err)
+	}
+	if err := testDB.SaveContactVersion(&original, &edited, "af", 0); err != nil {
+		t.Fatalf("SaveContactVersion failed: %v", err)
+	}
+	// since the edit, the old email has become an admin's username
+	users := []*User{
+		{Username: "sam@old.test", Password: "boss-password", Role: RoleAdmin},
+		{Username: edited.Email, Password: "sam-password", ContactID: &edited.ID, Role: RoleContact},
+	}
+	for _, u := range users {
+		if err := testDB.CreateUser(u); err != nil {
+			t.Fatalf("CreateUser failed: %v", err)
+		}
+	}
+
+	revert := func(as *User) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest("POST", "/contacts/c1/versions/1/revert", nil)
+		r = withUser(mux.SetURLVars(r, map[string]string{"id": "c1", "version": "1"}), as)
+		w := httptest.NewRecorder()
+		allowContactEdit(revertContactHandler).ServeHTTP(w, r)
+		return w
+	}
+	if w := revert(users[1]); w.Code != http.StatusForbidden {
+		t.Errorf("reverting the email of one's own card: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := revert(users[0]); w.Code != http.StatusConflict {
+		t.Errorf("reverting to another login's username: status %d, want %d: %s", w.Code, http.StatusConflict, w.Body)
+	}
+
+	for _, u := range users {
+		stored, err := testDB.GetUser(u.Username)
+		if err != nil {
+			t.Fatalf("GetUser failed: %v", err)
+		}
+		if ok, _ := verifyPassword(stored.Password, u.Password); !ok {
+			t.Errorf("the revert changed the password of %s", u.Username)
+		}
+	}
+	if c, _ := testDB.GetContact("c1"); c.Email != edited.Email {
+		t.Errorf("contact email = %q, want it unchanged", c.Email)
+	}
+
+	// once the email is free the revert goes through, still without a login
+	if _, err := testDB.Exec("DELETE FROM users WHERE username = ?", users[0].Username); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+	if w := revert(users[0]); w.Code != http.StatusOK {
+		t.Fatalf("revert: status %d: %s", w.Code, w.Body)
+	}
+	if _, err := testDB.GetUser(original.Email); err == nil {
+		t.Errorf("the revert created a login for %s", original.Email)
+	}
+	if stored, err := testDB.GetUser(edited.Email); err != nil || *stored.ContactID != "c1" {
+		t.Errorf("linked login after the revert = %+v, %v", stored, err)
+	}
+}
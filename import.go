@@ -0,0 +1,714 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Bulk import of contacts from CSV or vCard files. The flow is
+// upload -> (CSV only) column mapping -> dry-run preview -> commit. The file
+// travels between steps in a hidden form field, so nothing is stored
+// server side until the final commit, which re-validates every row and
+// inserts the valid ones in a single transaction.
+
+const (
+	maxImportFileSize = 5 << 20
+	maxImportRows     = 5000
+)
+
+type importField struct {
+	Key   string
+	Label string
+}
+
+// importFields are the targets a CSV column can be mapped to
+var importFields = []importField{
+	{"", "Ignore"},
+	{"first_name", "First name"},
+	{"last_name", "Last name"},
+	{"name", "Full name"},
+	{"email", "Email"},
+	{"phone", "Phone"},
+	{"contact_type", "Contact type"},
+	{"company", "Company (name or ID)"},
+}
+
+// guessImportField maps common CSV header spellings to an import field
+func guessImportField(header string) string {
+	key := strings.Map(func(r rune) rune {
+		if r == ' ' || r == '_' || r == '-' || r == '.' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(header)))
+
+	switch key {
+	case "firstname", "first", "givenname", "forename":
+		return "first_name"
+	case "lastname", "last", "surname", "familyname":
+		return "last_name"
+	case "name", "fullname", "displayname":
+		return "name"
+	case "email", "emailaddress", "mail":
+		return "email"
+	case "phone", "phonenumber", "telephone", "tel", "mobile", "mobilephone", "cell":
+		return "phone"
+	case "type", "contacttype", "category":
+		return "contact_type"
+	case "company", "companyname", "organization", "organisation", "org", "companyid":
+		return "company"
+	}
+	return ""
+}
+
+type importOptions struct {
+	Format         string // "csv" or "vcf"
+	Data           []byte
+	Headers        []string
+	Mapping        []string // import field per CSV column
+	DefaultType    string
+	DefaultCompany string
+}
+
+// EncodedData is what the hidden form field carries between steps
+func (o *importOptions) EncodedData() string {
+	return base64.StdEncoding.EncodeToString(o.Data)
+}
+
+type importRecord struct {
+	Line        int
+	Contact     Contact
+	CompanyName string
+	Errors      []string
+	NewUser     bool // a login account was created, to be invited
+}
+
+func (rec *importRecord) Valid() bool {
+	return len(rec.Errors) == 0
+}
+
+// readCSV parses the upload, tolerating a UTF-8 BOM and ragged rows
+func readCSV(data []byte) ([][]string, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid CSV file: %v", err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("CSV file is empty")
+	}
+	return rows, nil
+}
+
+// parseImportRecords turns the uploaded file into records and validates each
+// of them with the same rules as addContact
+func parseImportRecords(opts *importOptions) ([]importRecord, error) {
+	var records []importRecord
+
+	switch opts.Format {
+	case "csv":
+		rows, err := readCSV(opts.Data)
+		if err != nil {
+			return nil, err
+		}
+		for i, row := range rows[1:] {
+			rec := importRecord{Line: i + 2}
+			var fullName string
+			for col, value := range row {
+				if col >= len(opts.Mapping) {
+					break
+				}
+				value = strings.TrimSpace(value)
+				switch opts.Mapping[col] {
+				case "first_name":
+					rec.Contact.FirstName = value
+				case "last_name":
+					rec.Contact.LastName = value
+				case "name":
+					fullName = value
+				case "email":
+					rec.Contact.Email = value
+				case "phone":
+					rec.Contact.Phone = value
+				case "contact_type":
+					rec.Contact.ContactType = value
+				case "company":
+					rec.CompanyName = value
+				}
+			}
+			if strings.Join(row, "") == "" {
+				continue // blank line
+			}
+			splitFullName(&rec.Contact, fullName)
+			records = append(records, rec)
+		}
+
+	case "vcf":
+		entries, err := parseVCards(bytes.NewReader(opts.Data))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid vCard file: %v", err)
+		}
+		types := contactTypeNames()
+		for i, entry := range entries {
+			rec := importRecord{Line: i + 1, CompanyName: entry.Org}
+			rec.Contact.FirstName = entry.FirstName
+			rec.Contact.LastName = entry.LastName
+			rec.Contact.Email = entry.Email
+			rec.Contact.Phone = entry.Phone
+			splitFullName(&rec.Contact, entry.FullName)
+			for _, category := range entry.Categories {
+				for _, t := range types {
+					if strings.EqualFold(category, t) {
+						rec.Contact.ContactType = t
+					}
+				}
+			}
+			records = append(records, rec)
+		}
+
+	default:
+		return nil, fmt.Errorf("Unsupported file format")
+	}
+
+	if len(records) == 0 {
+		return nil, fmt.Errorf("The file does not contain any contacts")
+	}
+	if len(records) > maxImportRows {
+		return nil, fmt.Errorf("Too many rows: %d (maximum %d per import)", len(records), maxImportRows)
+	}
+
+	if err := validateImportRecords(records, opts); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// splitFullName fills in missing first/last names from a full name
+func splitFullName(c *Contact, fullName string) {
+	fullName = strings.TrimSpace(fullName)
+	if fullName == "" || (c.FirstName != "" && c.LastName != "") {
+		return
+	}
+	first, last := fullName, ""
+	if i := strings.LastIndex(fullName, " "); i > 0 {
+		first, last = strings.TrimSpace(fullName[:i]), strings.TrimSpace(fullName[i+1:])
+	}
+	if c.FirstName == "" {
+		c.FirstName = first
+	}
+	if c.LastName == "" {
+		c.LastName = last
+	}
+}
+
+func validateImportRecords(records []importRecord, opts *importOptions) error {
+	companies, err := db.GetCompanies()
+	if err != nil {
+		return err
+	}
+	companyByName := map[string]Company{}
+	companyByID := map[string]Company{}
+	for _, c := range companies {
+		companyByName[strings.ToLower(c.Name)] = c
+		companyByID[c.ID] = c
+	}
+
+	seen := map[string]int{}
+	for i := range records {
+		rec := &records[i]
+		if rec.Contact.ContactType == "" {
+			rec.Contact.ContactType = opts.DefaultType
+		}
+
+		if rec.CompanyName != "" {
+			company, ok := companyByID[rec.CompanyName]
+			if !ok {
+				company, ok = companyByName[strings.ToLower(rec.CompanyName)]
+			}
+			if !ok {
+				rec.Errors = append(rec.Errors, fmt.Sprintf("Unknown company %q", rec.CompanyName))
+			} else {
+				id := company.ID
+				rec.Contact.CompanyID = &id
+				rec.CompanyName = company.Name
+			}
+		} else if opts.DefaultCompany != "" {
+			if company, ok := companyByID[opts.DefaultCompany]; ok {
+				id := company.ID
+				rec.Contact.CompanyID = &id
+				rec.CompanyName = company.Name
+			}
+		}
+
+		if err := validateContact(&rec.Contact); err != nil {
+			rec.Errors = append(rec.Errors, err.Error())
+			continue
+		}
+
+		email := strings.ToLower(rec.Contact.Email)
+		if line, dup := seen[email]; dup {
+			rec.Errors = append(rec.Errors, fmt.Sprintf("Duplicate of row %d in this file", line))
+			continue
+		}
+		seen[email] = rec.Line
+
+		if err := checkEmailAvailable(rec.Contact.Email, ""); err == errEmailInTrash {
+			rec.Errors = append(rec.Errors, "Email belongs to a contact in the recycle bin")
+		} else if err == errEmailIsLogin {
+			rec.Errors = append(rec.Errors, "Email is the username of another login")
+		} else if err != nil {
+			rec.Errors = append(rec.Errors, "Email already exists")
+		}
+	}
+	return nil
+}
+
+// ImportContacts inserts the valid records and their login accounts in one
+// transaction: either every valid row is imported or none is.
+func (db *DB) ImportContacts(records []importRecord) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	imported := 0
+	for i := range records {
+		rec := &records[i]
+		if !rec.Valid() {
+			continue
+		}
+
+		// no password: the contact chooses one through the invitation, so
+		// nothing is hashed while the import holds the write lock
+		rec.Contact.Password = ""
+
+		if err := insertNewContact(tx, &rec.Contact); err != nil {
+			return 0, fmt.Errorf("row %d: %v", rec.Line, err)
+		}
+		imported++
+
+		var exists int
+		tx.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", rec.Contact.Email).Scan(&exists)
+		if exists > 0 {
+			fmt.Printf("Warning: User account already exists for email: %s\n", rec.Contact.Email)
+			continue
+		}
+		user := &User{
+			Username:           rec.Contact.Email,
+			ContactID:          &rec.Contact.ID,
+			NeedPasswordChange: true,
+			Role:               RoleContact,
+		}
+		if err := insertUser(tx, user); err != nil {
+			return 0, fmt.Errorf("row %d: %v", rec.Line, err)
+		}
+		rec.NewUser = true
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return imported, nil
+}
+
+// readImportOptions restores the state carried by the mapping and preview
+// forms
+func readImportOptions(r *http.Request) (*importOptions, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(r.FormValue("data"))
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("The uploaded file is missing, please start again")
+	}
+
+	opts := &importOptions{
+		Format:         r.FormValue("format"),
+		Data:           data,
+		DefaultType:    r.FormValue("default_type"),
+		DefaultCompany: r.FormValue("default_company"),
+	}
+	if opts.Format == "csv" {
+		rows, err := readCSV(data)
+		if err != nil {
+			return nil, err
+		}
+		opts.Headers = rows[0]
+		for i := range opts.Headers {
+			opts.Mapping = append(opts.Mapping, r.FormValue("map_"+strconv.Itoa(i)))
+		}
+	}
+	return opts, nil
+}
+
+var importTemplatesHTML = `
+{{define "import-page"}}
+<!doctype html>
+<html lang="en">
+    <head>
+        <meta charset="UTF-8" />
+        <meta name="viewport" content="width=device-width, initial-scale=1.0" />
+        <title>Import Contacts - AFCB</title>
+        <link rel="icon" type="image/x-icon" href="/static/favicon/favicon.ico" />
+        <script src="https://cdn.tailwindcss.com"></script>
+        <script src="https://unpkg.com/htmx.org@1.9.10"></script>
+        <script src="/static/csrf.js"></script>
+    </head>
+    <body class="bg-gray-100" hx-headers='{"X-CSRF-Token": "{{.CSRFToken}}"}'>
+        <nav class="bg-white shadow-md">
+            <div class="container mx-auto px-4">
+                <div class="flex justify-between items-center py-4">
+                    <a href="/" class="text-2xl font-bold text-blue-600">AFcb</a>
+                    <div class="flex items-center space-x-4">
+                        <a href="/" class="text-gray-600 hover:text-blue-600">Contacts</a>
+                        <a href="/companies-page" class="text-gray-600 hover:text-blue-600">Companies</a>
+                        <a href="/contacts/import" class="text-blue-600 font-semibold">Import</a>
+                        <a href="/logout" class="px-4 py-2 bg-red-600 text-white rounded-md hover:bg-red-700">Logout</a>
+                    </div>
+                </div>
+            </div>
+        </nav>
+        <main class="container mx-auto px-4 py-8">
+            <h1 class="text-3xl font-bold text-gray-800 mb-6">Import Contacts</h1>
+            <div id="import-step" class="bg-white rounded-lg shadow-md p-6">
+                {{template "import-upload" .}}
+            </div>
+        </main>
+    </body>
+</html>
+{{end}}
+
+{{define "import-upload"}}
+<form hx-post="/contacts/import/upload" hx-encoding="multipart/form-data" hx-target="#import-step" hx-swap="innerHTML">
+    <p class="text-gray-600 mb-4">
+        Upload a CSV file with a header row, or a .vcf file exported from an address book.
+        Nothing is saved until you confirm the preview.
+    </p>
+    <div class="mb-4">
+        <label for="file" class="block text-gray-700 font-bold mb-2">File</label>
+        <input type="file" id="file" name="file" accept=".csv,.vcf,text/csv,text/vcard" required class="text-gray-700">
+    </div>
+    {{template "import-defaults" .}}
+    <button type="submit" class="bg-blue-600 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-blue-700 transition-colors duration-300">
+        Continue
+    </button>
+</form>
+{{end}}
+
+{{define "import-defaults"}}
+<div class="grid grid-cols-1 md:grid-cols-2 gap-4 mb-4">
+    <div>
+        <label for="default_type" class="block text-gray-700 font-bold mb-2">Contact type for rows without one</label>
+        <select id="default_type" name="default_type" class="border rounded w-full py-2 px-3 text-gray-700">
+            {{range .ContactTypes}}<option value="{{.}}" {{if eq . $.DefaultType}}selected{{end}}>{{.}}</option>{{end}}
+        </select>
+    </div>
+    <div>
+        <label for="default_company" class="block text-gray-700 font-bold mb-2">Company for rows without one</label>
+        <select id="default_company" name="default_company" class="border rounded w-full py-2 px-3 text-gray-700">
+            <option value="">No Company</option>
+            {{range .Companies}}<option value="{{.ID}}" {{if eq .ID $.DefaultCompany}}selected{{end}}>{{.Name}}</option>{{end}}
+        </select>
+    </div>
+</div>
+{{end}}
+
+{{define "import-state"}}
+<input type="hidden" name="format" value="{{.Options.Format}}">
+<input type="hidden" name="data" value="{{.Options.EncodedData}}">
+{{end}}
+
+{{define "import-mapping"}}
+<form hx-post="/contacts/import/preview" hx-target="#import-step" hx-swap="innerHTML">
+    {{template "import-state" .}}
+    <h2 class="text-xl font-semibold text-gray-800 mb-2">Map columns</h2>
+    <p class="text-gray-600 mb-4">Choose which contact field each column of {{.FileName}} holds.</p>
+    <table class="min-w-full divide-y divide-gray-200 mb-6">
+        <thead class="bg-gray-50">
+            <tr>
+                <th class="px-4 py-2 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Column</th>
+                <th class="px-4 py-2 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Example</th>
+                <th class="px-4 py-2 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Field</th>
+            </tr>
+        </thead>
+        <tbody class="divide-y divide-gray-200">
+            {{range $i, $h := .Options.Headers}}
+            <tr>
+                <td class="px-4 py-2 text-sm font-medium text-gray-900">{{$h}}</td>
+                <td class="px-4 py-2 text-sm text-gray-500">{{index $.Examples $i}}</td>
+                <td class="px-4 py-2 text-sm">
+                    <select name="map_{{$i}}" class="border rounded py-1 px-2 text-gray-700">
+                        {{$current := index $.Options.Mapping $i}}
+                        {{range $.Fields}}<option value="{{.Key}}" {{if eq .Key $current}}selected{{end}}>{{.Label}}</option>{{end}}
+                    </select>
+                </td>
+            </tr>
+            {{end}}
+        </tbody>
+    </table>
+    {{template "import-defaults" .}}
+    <div class="flex space-x-2">
+        <a href="/contacts/import" class="bg-gray-500 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-gray-600">Start over</a>
+        <button type="submit" class="bg-blue-600 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-blue-700 transition-colors duration-300">
+            Preview
+        </button>
+    </div>
+</form>
+{{end}}
+
+{{define "import-preview"}}
+<form hx-post="/contacts/import/commit" hx-target="#import-step" hx-swap="innerHTML"
+      hx-confirm="Import {{.ValidCount}} contacts?">
+    {{template "import-state" .}}
+    {{range $i, $m := .Options.Mapping}}<input type="hidden" name="map_{{$i}}" value="{{$m}}">{{end}}
+    <input type="hidden" name="default_type" value="{{.DefaultType}}">
+    <input type="hidden" name="default_company" value="{{.DefaultCompany}}">
+    <h2 class="text-xl font-semibold text-gray-800 mb-2">Preview</h2>
+    <p class="text-gray-600 mb-4">
+        <span class="text-green-700 font-semibold">{{.ValidCount}} ready to import</span>,
+        <span class="text-red-600 font-semibold">{{.InvalidCount}} with errors</span> (rows with errors are skipped).
+        New contacts are emailed an invitation to choose their password.
+    </p>
+    <div class="overflow-x-auto mb-6">
+        <table class="min-w-full divide-y divide-gray-200">
+            <thead class="bg-gray-50">
+                <tr>
+                    <th class="px-4 py-2 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Row</th>
+                    <th class="px-4 py-2 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Name</th>
+                    <th class="px-4 py-2 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Email</th>
+                    <th class="px-4 py-2 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Phone</th>
+                    <th class="px-4 py-2 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Type</th>
+                    <th class="px-4 py-2 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Company</th>
+                    <th class="px-4 py-2 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Status</th>
+                </tr>
+            </thead>
+            <tbody class="divide-y divide-gray-200">
+                {{range .Records}}
+                <tr class="{{if not .Valid}}bg-red-50{{end}}">
+                    <td class="px-4 py-2 text-sm text-gray-500">{{.Line}}</td>
+                    <td class="px-4 py-2 text-sm text-gray-900">{{.Contact.FirstName}} {{.Contact.LastName}}</td>
+                    <td class="px-4 py-2 text-sm text-gray-900">{{.Contact.Email}}</td>
+                    <td class="px-4 py-2 text-sm text-gray-900">{{.Contact.Phone}}</td>
+                    <td class="px-4 py-2 text-sm text-gray-900">{{.Contact.ContactType}}</td>
+                    <td class="px-4 py-2 text-sm text-gray-900">{{.CompanyName}}</td>
+                    <td class="px-4 py-2 text-sm">
+                        {{if .Valid}}<span class="text-green-700">OK</span>
+                        {{else}}{{range .Errors}}<div class="text-red-600">{{.}}</div>{{end}}{{end}}
+                    </td>
+                </tr>
+                {{end}}
+            </tbody>
+        </table>
+    </div>
+    <div class="flex space-x-2">
+        <a href="/contacts/import" class="bg-gray-500 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-gray-600">Start over</a>
+        {{if .ValidCount}}
+        <button type="submit" class="bg-green-600 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-green-700 transition-colors duration-300">
+            Import {{.ValidCount}} contacts
+        </button>
+        {{end}}
+    </div>
+</form>
+{{end}}
+
+{{define "import-result"}}
+<div class="bg-green-50 border border-green-200 rounded-lg p-4 mb-4">
+    <p class="text-green-800 font-semibold">Imported {{.Imported}} contacts.{{if .InvalidCount}} {{.InvalidCount}} rows with errors were skipped.{{end}}</p>
+</div>
+<div class="flex space-x-2">
+    <a href="/" class="bg-blue-600 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-blue-700">Back to contacts</a>
+    <a href="/contacts/import" class="bg-gray-500 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-gray-600">Import another file</a>
+</div>
+{{end}}
+
+{{define "import-error"}}
+<div class="bg-red-50 border border-red-200 rounded-lg p-4 mb-4">
+    <p class="text-red-800">{{.Error}}</p>
+</div>
+<a href="/contacts/import" class="bg-gray-500 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-gray-600">Start over</a>
+{{end}}
+`
+
+var importTemplates = template.Must(template.New("import").Parse(importTemplatesHTML))
+
+type importView struct {
+	Options        *importOptions
+	FileName       string
+	Examples       []string
+	Fields         []importField
+	ContactTypes   []string
+	Companies      []Company
+	DefaultType    string
+	DefaultCompany string
+	Records        []importRecord
+	ValidCount     int
+	InvalidCount   int
+	Imported       int
+	Error          string
+	CSRFToken      string
+}
+
+func renderImport(w http.ResponseWriter, name string, view *importView) {
+	if view.Options != nil {
+		view.DefaultType = view.Options.DefaultType
+		view.DefaultCompany = view.Options.DefaultCompany
+	}
+	view.ContactTypes = contactTypeNames()
+	if view.DefaultType == "" && len(view.ContactTypes) > 0 {
+		view.DefaultType = view.ContactTypes[0]
+	}
+	view.Fields = importFields
+	if companies, err := db.GetCompanies(); err == nil {
+		view.Companies = companies
+	}
+	for _, rec := range view.Records {
+		if rec.Valid() {
+			view.ValidCount++
+		} else {
+			view.InvalidCount++
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := importTemplates.ExecuteTemplate(w, name, view); err != nil {
+		fmt.Printf("Error rendering import: %v\n", err)
+	}
+}
+
+func importFailed(w http.ResponseWriter, err error) {
+	fmt.Printf("Import failed: %v\n", err)
+	renderImport(w, "import-error", &importView{Error: err.Error()})
+}
+
+func importPageHandler(w http.ResponseWriter, r *http.Request) {
+	renderImport(w, "import-page", &importView{CSRFToken: csrfToken(r)})
+}
+
+func importUploadHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportFileSize+1<<20)
+	if err := r.ParseMultipartForm(maxImportFileSize); err != nil {
+		importFailed(w, fmt.Errorf("The file is too large (maximum %d MB)", maxImportFileSize>>20))
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		importFailed(w, fmt.Errorf("Please choose a file to import"))
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxImportFileSize+1))
+	if err != nil {
+		importFailed(w, err)
+		return
+	}
+	if len(data) > maxImportFileSize {
+		importFailed(w, fmt.Errorf("The file is too large (maximum %d MB)", maxImportFileSize>>20))
+		return
+	}
+
+	opts := &importOptions{
+		Data:           data,
+		DefaultType:    r.FormValue("default_type"),
+		DefaultCompany: r.FormValue("default_company"),
+	}
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+	if ext == ".vcf" || ext == ".vcard" || bytes.Contains(bytes.ToUpper(data[:min(len(data), 512)]), []byte("BEGIN:VCARD")) {
+		opts.Format = "vcf"
+	} else {
+		opts.Format = "csv"
+	}
+	fmt.Printf("Import upload: %s (%d bytes, %s)\n", header.Filename, len(data), opts.Format)
+
+	// vCards have fixed fields, so they skip the mapping step
+	if opts.Format == "vcf" {
+		records, err := parseImportRecords(opts)
+		if err != nil {
+			importFailed(w, err)
+			return
+		}
+		renderImport(w, "import-preview", &importView{Options: opts, Records: records})
+		return
+	}
+
+	rows, err := readCSV(data)
+	if err != nil {
+		importFailed(w, err)
+		return
+	}
+	opts.Headers = rows[0]
+	examples := make([]string, len(opts.Headers))
+	for i, h := range opts.Headers {
+		opts.Mapping = append(opts.Mapping, guessImportField(h))
+		if len(rows) > 1 && i < len(rows[1]) {
+			examples[i] = rows[1][i]
+		}
+	}
+	renderImport(w, "import-mapping", &importView{Options: opts, FileName: header.Filename, Examples: examples})
+}
+
+func importPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	opts, err := readImportOptions(r)
+	if err != nil {
+		importFailed(w, err)
+		return
+	}
+	records, err := parseImportRecords(opts)
+	if err != nil {
+		importFailed(w, err)
+		return
+	}
+	renderImport(w, "import-preview", &importView{Options: opts, Records: records})
+}
+
+func importCommitHandler(w http.ResponseWriter, r *http.Request) {
+	opts, err := readImportOptions(r)
+	if err != nil {
+		importFailed(w, err)
+		return
+	}
+
+	// validate again: the database may have changed since the preview
+	records, err := parseImportRecords(opts)
+	if err != nil {
+		importFailed(w, err)
+		return
+	}
+
+	imported, err := db.ImportContacts(records)
+	if err != nil {
+		importFailed(w, fmt.Errorf("Import failed, no contacts were added: %v", err))
+		return
+	}
+
+	for i := range records {
+		if records[i].Valid() {
+			recordAudit(r, AuditCreate, "contact", records[i].Contact.ID, nil, &records[i].Contact)
+		}
+	}
+	var invited []string
+	for _, rec := range records {
+		if rec.NewUser {
+			invited = append(invited, rec.Contact.Email)
+		}
+	}
+	inviteNewUsers(r, invited)
+
+	username, _ := getCurrentUser(r)
+	fmt.Printf("Imported %d contacts (%s) by %s\n", imported, opts.Format, username)
+	renderImport(w, "import-result", &importView{Options: opts, Records: records, Imported: imported})
+}
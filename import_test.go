@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestImportContactsLarge(t *testing.T) {
+	testDB := useTestDB(t)
+
+	records := make([]importRecord, maxImportRows)
+	for i := range records {
+		records[i] = importRecord{Line: i + 2, Contact: Contact{ContactType: "Work", FirstName: "Row", LastName: fmt.Sprint(i),
+			Email: fmt.Sprintf("row%d@acme.test", i), Phone: "+44 20 7946 0958"}}
+	}
+
+	// this many draws from genID almost always collide once, which must not
+	// fail the import
+	start := time.Now()
+	imported, err := testDB.ImportContacts(records)
+	if err != nil || imported != maxImportRows {
+		t.Fatalf("ImportContacts = %d, %v; want %d", imported, err, maxImportRows)
+	}
+	// invitation-only accounts hash nothing, so the write lock is short
+	if d := time.Since(start); d > 30*time.Second {
+		t.Errorf("import took %v", d)
+	}
+
+	user, err := testDB.GetUser("row7@acme.test")
+	if err != nil || user.ContactID == nil || *user.ContactID != records[7].Contact.ID || !records[7].NewUser {
+		t.Fatalf("user of row 7: %+v, %v", user, err)
+	}
+	if ok, _ := verifyPassword(user.Password, ""); ok {
+		t.Errorf("an invited account accepts an empty password")
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/mail"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Invitations. Accounts created without a password store none, and an empty
+// stored password never matches a login (see verifyPassword), so nobody can
+// sign in until the user follows the emailed link to /invite/<token> and
+// chooses their own. Only a hash of the token is stored. A token works once
+// and until invites.lifetime has passed; sending a new invitation replaces
+// the open one.
+
+type Invite struct {
+	ID        int64
+	Username  string
+	CreatedBy string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+var (
+	errInviteNotFound = errors.New("invitation not found")
+	errInviteUsed     = errors.New("invitation already used")
+	errInviteExpired  = errors.New("invitation expired")
+)
+
+// CreateInvite replaces the open invitations of username with a new one and
+// returns its token
+func (db *DB) CreateInvite(username, createdBy string, now time.Time) (string, *Invite, error) {
+	token, err := newSessionToken()
+	if err != nil {
+		return "", nil, err
+	}
+	invite := &Invite{
+		Username:  username,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		ExpiresAt: now.Add(config.Invites.Lifetime),
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM invites WHERE username = ? AND used_at IS NULL", username); err != nil {
+		return "", nil, err
+	}
+	result, err := tx.Exec("INSERT INTO invites (token_hash, username, created_by, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		hashSessionToken(token), invite.Username, invite.CreatedBy, invite.CreatedAt, invite.ExpiresAt)
+	if err != nil {
+		return "", nil, err
+	}
+	if invite.ID, err = result.LastInsertId(); err != nil {
+		return "", nil, err
+	}
+	return token, invite, tx.Commit()
+}
+
+func getInvite(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, token string) (*Invite, error) {
+	var invite Invite
+	var usedAt sql.NullTime
+	err := q.QueryRow("SELECT id, username, created_by, created_at, expires_at, used_at FROM invites WHERE token_hash = ?", hashSessionToken(token)).
+		Scan(&invite.ID, &invite.Username, &invite.CreatedBy, &invite.CreatedAt, &invite.ExpiresAt, &usedAt)
+	if err == sql.ErrNoRows {
+		return nil, errInviteNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		invite.UsedAt = &usedAt.Time
+	}
+	return &invite, nil
+}
+
+// usable reports why an invitation can no longer be accepted, if it can't
+func (i *Invite) usable(now time.Time) error {
+	if i.UsedAt != nil {
+		return errInviteUsed
+	}
+	if !now.Before(i.ExpiresAt) {
+		return errInviteExpired
+	}
+	return nil
+}
+
+// GetInvite returns the invitation of token if it can still be accepted
+func (db *DB) GetInvite(token string, now time.Time) (*Invite, error) {
+	invite, err := getInvite(db, token)
+	if err != nil {
+		return nil, err
+	}
+	return invite, invite.usable(now)
+}
+
+// AcceptInvite sets the password of the invited user and of their contact
+// and uses up the invitation
+func (db *DB) AcceptInvite(token, password string, now time.Time) (*Invite, error) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	invite, err := getInvite(tx, token)
+	if err != nil {
+		return nil, err
+	}
+	if err := invite.usable(now); err != nil {
+		return nil, err
+	}
+
+	// the used_at condition keeps two submissions from both succeeding
+	result, err := tx.Exec("UPDATE invites SET used_at = ? WHERE id = ? AND used_at IS NULL", now, invite.ID)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n != 1 {
+		return nil, errInviteUsed
+	}
+	result, err = tx.Exec("UPDATE users SET password = ?, needs_password_change = 0 WHERE username = ?", hash, invite.Username)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n != 1 {
+		return nil, fmt.Errorf("user %s no longer exists", invite.Username)
+	}
+	if _, err := tx.Exec("UPDATE contacts SET password = ? WHERE id = (SELECT contact_id FROM users WHERE username = ?)", hash, invite.Username); err != nil {
+		return nil, err
+	}
+	invite.UsedAt = &now
+	return invite, tx.Commit()
+}
+
+// RevokeInvites cancels the open invitations of username, returning how
+// many there were
+func (db *DB) RevokeInvites(username string) (int64, error) {
+	result, err := db.Exec("DELETE FROM invites WHERE username = ? AND used_at IS NULL", username)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// PendingInvites maps usernames to the expiry of their open invitation
+func (db *DB) PendingInvites(now time.Time) (map[string]time.Time, error) {
+	rows, err := db.Query("SELECT username, expires_at FROM invites WHERE used_at IS NULL AND expires_at > ?", now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pending := map[string]time.Time{}
+	for rows.Next() {
+		var username string
+		var expires time.Time
+		if err := rows.Scan(&username, &expires); err != nil {
+			return nil, err
+		}
+		pending[username] = expires
+	}
+	return pending, rows.Err()
+}
+
+// DeleteExpiredInvites forgets invitations that can no longer be used
+func (db *DB) DeleteExpiredInvites(now time.Time) (int64, error) {
+	result, err := db.Exec("DELETE FROM invites WHERE expires_at < ?", now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// newInvite creates an invitation for username, which must be an email
+// address, and returns the mail carrying its link
+func newInvite(r *http.Request, username string) (Mail, error) {
+	if _, err := mail.ParseAddress(username); err != nil {
+		return Mail{}, fmt.Errorf("%s is not an email address", username)
+	}
+	token, invite, err := db.CreateInvite(username, auditActor(r), time.Now().UTC())
+	if err != nil {
+		return Mail{}, fmt.Errorf("failed to create invitation: %w", err)
+	}
+	recordAudit(r, AuditCreate, "invite", username, nil, map[string]interface{}{"expires_at": invite.ExpiresAt.Format(time.RFC3339)})
+
+	body := fmt.Sprintf(inviteMailBody, username, config.BaseURL()+"/invite/"+token, invite.ExpiresAt.Local().Format("Monday, January 2 at 15:04"))
+	return Mail{To: username, Subject: "Your AFcb account", Body: body}, nil
+}
+
+// inviteUser invites username and sends the mail right away. The invitation
+// is kept when sending fails so an admin can resend it once mail works.
+func inviteUser(r *http.Request, username string) error {
+	m, err := newInvite(r, username)
+	if err != nil {
+		return err
+	}
+	return mailer.Send(m)
+}
+
+// inviteNewUsers invites accounts just created without a password. The mail
+// goes out in the background, so an import doesn't wait for the mail server
+// once per row; failures only warn, and the users page can resend.
+func inviteNewUsers(r *http.Request, usernames []string) {
+	var mails []Mail
+	for _, username := range usernames {
+		m, err := newInvite(r, username)
+		if err != nil {
+			fmt.Printf("Warning: Failed to invite %s: %v\n", username, err)
+			continue
+		}
+		mails = append(mails, m)
+	}
+	sendInBackground(mails)
+}
+
+const inviteMailBody = `Hello,
+
+An account has been created for you on AFcb. Your username is %s.
+
+Choose your password here to sign in:
+
+%s
+
+This link works once and expires on %s. If it has expired, ask an administrator to send a new one.
+`
+
+// INVITE HANDLERS
+
+var invitePage = template.Must(template.New("invite").Parse(`
+<!doctype HTML>
+<html lang="en">
+	<head>
+		<meta charset="UTF-8" />
+		<meta name="viewport" content="width=device-width, initial-scale=1.0" />
+		<title>Set Your Password - AFCB</title>
+		<script src="https://cdn.tailwindcss.com"></script>
+        <script src="https://unpkg.com/htmx.org@1.9.10"></script>
+    </head>
+    <body class="bg-gray-200 flex items-center justify-center min-h-screen">
+        <div class="bg-white p-8 rounded-lg shadow-md w-full max-w-md">
+            {{if .Error}}
+            <h2 class="text-2xl font-bold text-center text-gray-800 mb-6">Invitation not valid</h2>
+            <div class="bg-red-50 border border-red-200 rounded-lg p-4 mb-6">
+                <p class="text-red-800 text-sm">{{.Error}}</p>
+            </div>
+            <a href="/login" class="block text-center bg-blue-600 text-white py-2 px-4 rounded hover:bg-blue-700">Go to Login</a>
+            {{else}}
+            <h2 class="text-2xl font-bold text-center text-gray-800 mb-2">Welcome to AFCB</h2>
+            <p class="text-center text-gray-600 text-sm mb-6">Choose a password for <span class="font-medium">{{.Username}}</span></p>
+            <form hx-post="/invite/{{.Token}}" hx-target="#invite-message" hx-swap="innerHTML">
+                <div class="mb-4">
+                    <label class="block text-gray-700 font-bold mb-2" for="newPassword">Password</label>
+                    <input class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline"
+                           id="newPassword" name="newPassword" type="password" autocomplete="new-password" required minlength="{{.MinLength}}" />
+                </div>
+                <div class="mb-6">
+                    <label class="block text-gray-700 font-bold mb-2" for="confirmPassword">Confirm Password</label>
+                    <input class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline"
+                           id="confirmPassword" name="confirmPassword" type="password" autocomplete="new-password" required minlength="{{.MinLength}}" />
+                </div>
+                <button class="bg-blue-600 hover:bg-blue-700 text-white font-bold py-2 px-4 rounded focus:outline-none focus:shadow-outline w-full" type="submit">
+                    Set Password and Sign In
+                </button>
+            </form>
+            <div id="invite-message" class="mt-4 text-center"></div>
+            {{end}}
+        </div>
+    </body>
+</html>
+`))
+
+func inviteErrorMessage(err error) string {
+	switch err {
+	case errInviteUsed:
+		return "This invitation has already been used. Sign in with the password you chose."
+	case errInviteExpired:
+		return "This invitation has expired. Ask an administrator to send a new one."
+	}
+	return "This invitation link is not valid. It may have been replaced by a newer one."
+}
+
+func invitePageHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	data := struct {
+		Token     string
+		Username  string
+		MinLength int
+		Error     string
+	}{Token: token, MinLength: minPasswordLength}
+
+	invite, err := db.GetInvite(token, time.Now().UTC())
+	if err != nil {
+		data.Error = inviteErrorMessage(err)
+	} else {
+		data.Username = invite.Username
+	}
+
+	// the token is in the URL; don't pass it on to other sites
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Content-Type", "text/html")
+	if err := invitePage.Execute(w, data); err != nil {
+		fmt.Printf("Error rendering invitation page: %v\n", err)
+	}
+}
+
+func acceptInviteHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+
+	password := r.FormValue("newPassword")
+	if password != r.FormValue("confirmPassword") {
+		w.Write([]byte(`<div class="text-red-500">Passwords do not match.</div>`))
+		return
+	}
+	if len(password) < minPasswordLength {
+		fmt.Fprintf(w, `<div class="text-red-500">Password must be at least %d characters long.</div>`, minPasswordLength)
+		return
+	}
+
+	invite, err := db.AcceptInvite(mux.Vars(r)["token"], password, time.Now().UTC())
+	if errors.Is(err, errInviteNotFound) || errors.Is(err, errInviteUsed) || errors.Is(err, errInviteExpired) {
+		fmt.Fprintf(w, `<div class="text-red-500">%s</div>`, template.HTMLEscapeString(inviteErrorMessage(err)))
+		return
+	}
+	if err != nil {
+		fmt.Printf("Warning: Failed to accept invitation: %v\n", err)
+		w.Write([]byte(`<div class="text-red-500">Failed to set your password. Please try again.</div>`))
+		return
+	}
+	username := invite.Username
+
+	// sessions from before belong to whoever knew the old password
+	if _, err := db.DeleteUserSessions(username, ""); err != nil {
+		fmt.Printf("Warning: Failed to revoke sessions of %s: %v\n", username, err)
+	}
+	if err := db.ClearLoginFailures(username); err != nil {
+		fmt.Printf("Warning: Failed to reset failed logins: %v\n", err)
+	}
+	fmt.Printf("Invitation accepted by %s\n", username)
+
+	user, err := db.GetUser(username)
+	if err == nil {
+		r = withUser(r, user)
+	}
+	recordAudit(r, AuditPasswordChange, "user", username, nil, map[string]interface{}{"via": "invitation"})
+
+	token, session, err := db.CreateSession(username, r)
+	if err != nil {
+		fmt.Printf("Warning: Failed to create session for %s: %v\n", username, err)
+		w.Write([]byte(`<div class="text-green-500">Password set. <a href="/login" class="underline">Sign in</a></div>`))
+		return
+	}
+	setSessionCookie(w, token, session.ExpiresAt)
+	w.Header().Set("HX-Redirect", "/")
+	w.Write([]byte(`<div class="text-green-500">Password set! Signing you in...</div>`))
+}
+
+// resendInviteHandler sends username a new invitation, replacing the open one
+func resendInviteHandler(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+	if _, err := db.GetUser(username); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := inviteUser(r, username); err != nil {
+		fmt.Printf("Warning: Failed to invite %s: %v\n", username, err)
+		fmt.Fprintf(w, `<span class="text-red-600 text-sm">Not sent: %s</span>`, template.HTMLEscapeString(err.Error()))
+		return
+	}
+	fmt.Printf("Invitation sent to %s\n", username)
+	fmt.Fprint(w, `<span class="text-green-600 text-sm">Invitation sent</span>`)
+}
+
+func revokeInviteHandler(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+	n, err := db.RevokeInvites(username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if n == 0 {
+		fmt.Fprint(w, `<span class="text-gray-500 text-sm">No open invitation</span>`)
+		return
+	}
+	fmt.Printf("Admin revoked the invitation of %s\n", username)
+	recordAudit(r, AuditDelete, "invite", username, map[string]interface{}{"pending": true}, nil)
+	fmt.Fprint(w, `<span class="text-green-600 text-sm">Invitation revoked</span>`)
+}
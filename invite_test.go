@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recordingMailer struct {
+	sent []Mail
+}
+
+func (m *recordingMailer) Send(mail Mail) error {
+	m.sent = append(m.sent, mail)
+	return nil
+}
+
+func TestInviteLifecycle(t *testing.T) {
+	testDB := useTestDB(t)
+	saved := mailer
+	outbox := &recordingMailer{}
+	mailer = outbox
+	t.Cleanup(func() { mailer = saved })
+
+	contact := Contact{ID: "c1", ContactType: "Work", FirstName: "Mary", LastName: "Jones", Email: "mary+crm@acme.test", Password: "unknown"}
+	if err := insertContact(testDB, &contact); err != nil {
+		t.Fatalf("insertContact failed: %v", err)
+	}
+	if err := testDB.CreateUser(&User{Username: contact.Email, Password: "unknown", ContactID: &contact.ID, NeedPasswordChange: true, Role: RoleContact}); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	// the mail carries the only copy of the token
+	r := httptest.NewRequest("POST", "/contacts", nil)
+	if err := inviteUser(r, contact.Email); err != nil {
+		t.Fatalf("inviteUser failed: %v", err)
+	}
+	if len(outbox.sent) != 1 || outbox.sent[0].To != contact.Email || !strings.Contains(outbox.sent[0].Body, contact.Email) {
+		t.Fatalf("sent %+v", outbox.sent)
+	}
+	first := inviteToken(t, outbox.sent[0].Body)
+
+	// resending replaces the open invitation
+	if err := inviteUser(r, contact.Email); err != nil {
+		t.Fatalf("inviteUser failed: %v", err)
+	}
+	token := inviteToken(t, outbox.sent[1].Body)
+	now := time.Now().UTC()
+	if _, err := testDB.GetInvite(first, now); err != errInviteNotFound {
+		t.Errorf("replaced invitation: %v, want %v", err, errInviteNotFound)
+	}
+	if pending, _ := testDB.PendingInvites(now); len(pending) != 1 {
+		t.Errorf("PendingInvites = %v, want one", pending)
+	}
+	if _, err := testDB.GetInvite(token, now.Add(config.Invites.Lifetime)); err != errInviteExpired {
+		t.Errorf("invitation after its lifetime: %v, want %v", err, errInviteExpired)
+	}
+
+	invite, err := testDB.AcceptInvite(token, "chosen-password", now)
+	if err != nil || invite.Username != contact.Email {
+		t.Fatalf("AcceptInvite = %+v, %v", invite, err)
+	}
+	user, err := testDB.GetUser(contact.Email)
+	if err != nil || user.NeedPasswordChange {
+		t.Fatalf("user after accepting: %+v, %v", user, err)
+	}
+	if ok, _ := verifyPassword(user.Password, "chosen-password"); !ok {
+		t.Errorf("the chosen password does not work")
+	}
+	var contactPassword string
+	testDB.QueryRow("SELECT password FROM contacts WHERE id = ?", contact.ID).Scan(&contactPassword)
+	if ok, _ := verifyPassword(contactPassword, "chosen-password"); !ok {
+		t.Errorf("the contact's password was not updated")
+	}
+
+	if _, err := testDB.AcceptInvite(token, "another-password", now); err != errInviteUsed {
+		t.Errorf("second use: %v, want %v", err, errInviteUsed)
+	}
+
+	// revoking only touches open invitations
+	if n, err := testDB.RevokeInvites(contact.Email); err != nil || n != 0 {
+		t.Errorf("RevokeInvites after use = %d, %v", n, err)
+	}
+	if err := inviteUser(r, contact.Email); err != nil {
+		t.Fatalf("inviteUser failed: %v", err)
+	}
+	if n, err := testDB.RevokeInvites(contact.Email); err != nil || n != 1 {
+		t.Errorf("RevokeInvites = %d, %v", n, err)
+	}
+	if _, err := testDB.GetInvite(inviteToken(t, outbox.sent[2].Body), now); err != errInviteNotFound {
+		t.Errorf("revoked invitation: %v, want %v", err, errInviteNotFound)
+	}
+
+	if err := inviteUser(r, "af"); err == nil {
+		t.Errorf("invited a user without an email address")
+	}
+}
+
+// inviteToken finds the token in the link of an invitation mail
+func inviteToken(t *testing.T, body string) string {
+	t.Helper()
+	_, rest, ok := strings.Cut(body, "/invite/")
+	if !ok {
+		t.Fatalf("no invitation link in %q", body)
+	}
+	return strings.Fields(rest)[0]
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Login throttling. Failed logins are counted per account and per client
+// IP in login_failures. Each failure makes the next attempt wait twice as
+// long, and an account is locked for login.lockout after login.max_failures
+// failures in a row, or until an admin unlocks it. Counts start over once
+// no failure happened for login.lockout.
+
+const maxLoginBackoff = 5 * time.Minute
+
+type loginFailures struct {
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+func loginAccountKey(username string) string {
+	return "user:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+func loginIPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginBackoff is the wait after the given number of failures in a row
+func loginBackoff(failures int) time.Duration {
+	if failures < 1 {
+		return 0
+	}
+	return min(time.Second<<min(failures-1, 20), maxLoginBackoff)
+}
+
+// retryAt is when the next attempt is allowed; zero when it already is
+func (f loginFailures) retryAt(now time.Time) time.Time {
+	if f.LockedUntil != nil && now.Before(*f.LockedUntil) {
+		return *f.LockedUntil
+	}
+	if f.Failures == 0 || now.Sub(f.LastFailureAt) > config.Login.Lockout {
+		return time.Time{}
+	}
+	return f.LastFailureAt.Add(loginBackoff(f.Failures))
+}
+
+func (f loginFailures) locked(now time.Time) bool {
+	return f.LockedUntil != nil && now.Before(*f.LockedUntil)
+}
+
+func getLoginFailures(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, key string) (loginFailures, error) {
+	var f loginFailures
+	var lockedUntil sql.NullTime
+	err := q.QueryRow("SELECT failures, last_failure_at, locked_until FROM login_failures WHERE key = ?", key).
+		Scan(&f.Failures, &f.LastFailureAt, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return loginFailures{}, nil
+	}
+	if lockedUntil.Valid {
+		f.LockedUntil = &lockedUntil.Time
+	}
+	return f, err
+}
+
+// loginAttempts serialises ReserveLoginAttempt, so parallel attempts cannot
+// all pass the check before any of them is counted
+var loginAttempts sync.Mutex
+
+// ReserveLoginAttempt checks whether username may try to log in from ip now
+// and, if so, counts the attempt as a failure before the password is looked
+// at. Otherwise it returns when the next attempt is allowed and whether the
+// account is locked.
+func (db *DB) ReserveLoginAttempt(username, ip string, now time.Time) (time.Time, bool, error) {
+	loginAttempts.Lock()
+	defer loginAttempts.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	defer tx.Rollback()
+
+	keys := []string{loginAccountKey(username), loginIPKey(ip)}
+	counts := make([]loginFailures, len(keys))
+	var retryAt time.Time
+	for i, key := range keys {
+		if counts[i], err = getLoginFailures(tx, key); err != nil {
+			return time.Time{}, false, err
+		}
+		if t := counts[i].retryAt(now); t.After(retryAt) {
+			retryAt = t
+		}
+	}
+	if now.Before(retryAt) {
+		return retryAt, counts[0].locked(now), nil
+	}
+
+	for i, key := range keys {
+		f := counts[i]
+		if now.Sub(f.LastFailureAt) > config.Login.Lockout {
+			f = loginFailures{}
+		}
+		_, err = tx.Exec(`INSERT INTO login_failures (key, failures, last_failure_at, locked_until) VALUES (?, ?, ?, ?)
+			ON CONFLICT(key) DO UPDATE SET failures = excluded.failures, last_failure_at = excluded.last_failure_at,
+				locked_until = excluded.locked_until`,
+			key, f.Failures+1, now, f.LockedUntil)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+	}
+	return time.Time{}, false, tx.Commit()
+}
+
+// RecordLoginFailure locks the account of a reserved attempt that failed once
+// it has failed login.max_failures times in a row. It returns the end of the
+// lockout when this failure started one.
+func (db *DB) RecordLoginFailure(username string, now time.Time) (*time.Time, error) {
+	until := now.Add(config.Login.Lockout)
+	result, err := db.Exec(`UPDATE login_failures SET locked_until = ?
+		WHERE key = ? AND failures >= ? AND (locked_until IS NULL OR locked_until <= ?)`,
+		until, loginAccountKey(username), config.Login.MaxFailures, now)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return nil, err
+	}
+	return &until, nil
+}
+
+// ClearLoginFailures resets the account count after a successful login. The
+// IP count stays, so one valid account cannot be used to keep guessing
+// others.
+func (db *DB) ClearLoginFailures(username string) error {
+	_, err := db.Exec("DELETE FROM login_failures WHERE key = ?", loginAccountKey(username))
+	return err
+}
+
+// ReleaseLoginAttempt takes back the IP's count of a reserved attempt that
+// succeeded
+func (db *DB) ReleaseLoginAttempt(ip string) error {
+	key := loginIPKey(ip)
+	if _, err := db.Exec("UPDATE login_failures SET failures = failures - 1 WHERE key = ?", key); err != nil {
+		return err
+	}
+	_, err := db.Exec("DELETE FROM login_failures WHERE key = ? AND failures <= 0", key)
+	return err
+}
+
+// UnlockAccount lifts a lockout early, reporting whether there was one
+func (db *DB) UnlockAccount(username string, now time.Time) (bool, error) {
+	result, err := db.Exec("DELETE FROM login_failures WHERE key = ? AND locked_until > ?", loginAccountKey(username), now)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// LockedAccounts maps the lowercased names of locked accounts to the end of
+// their lockout
+func (db *DB) LockedAccounts(now time.Time) (map[string]time.Time, error) {
+	rows, err := db.Query("SELECT key, locked_until FROM login_failures WHERE key LIKE 'user:%' AND locked_until > ?", now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locked := map[string]time.Time{}
+	for rows.Next() {
+		var key string
+		var until time.Time
+		if err := rows.Scan(&key, &until); err != nil {
+			return nil, err
+		}
+		locked[strings.TrimPrefix(key, "user:")] = until
+	}
+	return locked, rows.Err()
+}
+
+// DeleteStaleLoginFailures forgets counts that no longer slow anyone down
+func (db *DB) DeleteStaleLoginFailures(now time.Time) (int64, error) {
+	result, err := db.Exec(`DELETE FROM login_failures WHERE last_failure_at < ?
+		AND (locked_until IS NULL OR locked_until < ?)`, now.Add(-config.Login.Lockout), now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// LOGIN LIMIT HANDLERS
+
+// writeLoginThrottled answers a login attempt made before retryAt
+func writeLoginThrottled(w http.ResponseWriter, retryAt, now time.Time, locked bool) {
+	seconds := int(retryAt.Sub(now).Seconds()) + 1
+	wait := fmt.Sprintf("%d seconds", seconds)
+	if seconds > 90 {
+		wait = fmt.Sprintf("%d minutes", (seconds+59)/60)
+	}
+	w.Header().Set("Retry-After", fmt.Sprint(seconds))
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusTooManyRequests)
+	if locked {
+		fmt.Fprintf(w, `<div id="login-message" class="mt-4 text-center text-red-500">Too many failed logins. This account is locked for %s, or until an administrator unlocks it.</div>`, wait)
+		return
+	}
+	fmt.Fprintf(w, `<div id="login-message" class="mt-4 text-center text-red-500">Too many failed logins. Try again in %s.</div>`, wait)
+}
+
+// loginFailed records a failed login and answers it
+func loginFailed(w http.ResponseWriter, r *http.Request, username, reason string) {
+	now := time.Now().UTC()
+	fmt.Printf("Login failed from %s: %s\n", clientIP(r), reason)
+
+	lockedUntil, err := db.RecordLoginFailure(username, now)
+	if err != nil {
+		fmt.Printf("Warning: Failed to record failed login: %v\n", err)
+	}
+	if lockedUntil != nil {
+		fmt.Printf("Account locked until %s after %d failed logins\n", lockedUntil.Format(time.RFC3339), config.Login.MaxFailures)
+		recordAudit(r, AuditLockout, "user", username, nil, map[string]interface{}{
+			"failures": config.Login.MaxFailures, "locked_until": lockedUntil.Format(time.RFC3339)})
+		writeLoginThrottled(w, *lockedUntil, now, true)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write([]byte(`<div id="login-message" class="mt-4 text-center text-red-500">Invalid username or password.</div>`))
+}
+
+func unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+	unlocked, err := db.UnlockAccount(username, time.Now().UTC())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if !unlocked {
+		fmt.Fprint(w, `<span class="text-gray-500 text-sm">Not locked</span>`)
+		return
+	}
+	fmt.Printf("Admin unlocked user: %s\n", username)
+	recordAudit(r, AuditUnlock, "user", username, map[string]interface{}{"locked": true}, map[string]interface{}{"locked": false})
+	fmt.Fprint(w, `<span class="text-green-600 text-sm">Unlocked</span>`)
+}
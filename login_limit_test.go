@@ -0,0 +1,153 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{5, 16 * time.Second},
+		{9, 256 * time.Second},
+		{10, maxLoginBackoff},
+		{100, maxLoginBackoff},
+	}
+	for _, tt := range tests {
+		if got := loginBackoff(tt.failures); got != tt.want {
+			t.Errorf("loginBackoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	conn := openTestDB(t)
+	if _, err := migrateUp(conn, 0); err != nil {
+		t.Fatalf("migrateUp failed: %v", err)
+	}
+	testDB := &DB{DB: conn}
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	// each failure doubles the wait, for the account and the IP
+	for i := 1; i < config.Login.MaxFailures; i++ {
+		if retryAt, _, err := testDB.ReserveLoginAttempt("Mary", "10.0.0.1", now); err != nil || !retryAt.IsZero() {
+			t.Fatalf("attempt %d: retry at %v, %v", i, retryAt, err)
+		}
+		lockedUntil, err := testDB.RecordLoginFailure("Mary", now)
+		if err != nil || lockedUntil != nil {
+			t.Fatalf("failure %d: locked until %v, %v", i, lockedUntil, err)
+		}
+		retryAt, locked, err := testDB.ReserveLoginAttempt("mary", "10.0.0.2", now)
+		if err != nil || locked || !retryAt.Equal(now.Add(loginBackoff(i))) {
+			t.Errorf("after %d failures: retry at %v, locked %t, %v", i, retryAt, locked, err)
+		}
+		if retryAt, _, _ := testDB.ReserveLoginAttempt("bob", "10.0.0.1", now); !retryAt.Equal(now.Add(loginBackoff(i))) {
+			t.Errorf("after %d failures from the IP: retry at %v", i, retryAt)
+		}
+		now = retryAt
+	}
+
+	if retryAt, _, err := testDB.ReserveLoginAttempt("mary", "10.0.0.3", now); err != nil || !retryAt.IsZero() {
+		t.Fatalf("last attempt: retry at %v, %v", retryAt, err)
+	}
+	lockedUntil, err := testDB.RecordLoginFailure("mary", now)
+	if err != nil || lockedUntil == nil || !lockedUntil.Equal(now.Add(config.Login.Lockout)) {
+		t.Fatalf("failure %d: locked until %v, %v; want a lockout", config.Login.MaxFailures, lockedUntil, err)
+	}
+	if _, locked, _ := testDB.ReserveLoginAttempt("mary", "10.0.0.4", now.Add(time.Minute)); !locked {
+		t.Errorf("account not locked from another IP")
+	}
+	locks, err := testDB.LockedAccounts(now)
+	if err != nil || len(locks) != 1 || !locks["mary"].Equal(*lockedUntil) {
+		t.Errorf("LockedAccounts = %v, %v", locks, err)
+	}
+
+	if unlocked, err := testDB.UnlockAccount("Mary", now); err != nil || !unlocked {
+		t.Errorf("UnlockAccount = %t, %v", unlocked, err)
+	}
+	if retryAt, locked, _ := testDB.ReserveLoginAttempt("mary", "10.0.0.4", now); locked || !retryAt.IsZero() {
+		t.Errorf("after unlock: retry at %v, locked %t", retryAt, locked)
+	}
+	if unlocked, _ := testDB.UnlockAccount("mary", now); unlocked {
+		t.Errorf("UnlockAccount of an unlocked account reported a lockout")
+	}
+
+	// counts run out after a quiet period
+	later := now.Add(config.Login.Lockout + time.Minute)
+	if retryAt, _, _ := testDB.ReserveLoginAttempt("bob", "10.0.0.1", later); !retryAt.IsZero() {
+		t.Errorf("IP still throttled after a quiet period: %v", retryAt)
+	}
+	if n, err := testDB.DeleteStaleLoginFailures(later); err != nil || n != 3 {
+		t.Errorf("DeleteStaleLoginFailures = %d, %v; want the 3 counts from before", n, err)
+	}
+}
+
+// TestReserveLoginAttemptInParallel makes sure simultaneous guesses cannot
+// all get in before the first one is counted
+func TestReserveLoginAttemptInParallel(t *testing.T) {
+	conn := openTestDB(t)
+	if _, err := migrateUp(conn, 0); err != nil {
+		t.Fatalf("migrateUp failed: %v", err)
+	}
+	testDB := &DB{DB: conn}
+	now := time.Now().UTC()
+
+	const attempts = 10
+	allowed := make(chan bool, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			retryAt, _, err := testDB.ReserveLoginAttempt("mary", "10.0.0.1", now)
+			allowed <- err == nil && retryAt.IsZero()
+		}()
+	}
+	wg.Wait()
+	close(allowed)
+
+	n := 0
+	for ok := range allowed {
+		if ok {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("%d of %d parallel attempts were let through, want 1", n, attempts)
+	}
+}
+
+func TestClearLoginFailures(t *testing.T) {
+	conn := openTestDB(t)
+	if _, err := migrateUp(conn, 0); err != nil {
+		t.Fatalf("migrateUp failed: %v", err)
+	}
+	testDB := &DB{DB: conn}
+	now := time.Now().UTC()
+
+	testDB.ReserveLoginAttempt("mary", "10.0.0.1", now)
+	testDB.RecordLoginFailure("mary", now)
+	if err := testDB.ClearLoginFailures("MARY"); err != nil {
+		t.Fatalf("ClearLoginFailures failed: %v", err)
+	}
+	if retryAt, _, _ := testDB.ReserveLoginAttempt("mary", "10.0.0.2", now); !retryAt.IsZero() {
+		t.Errorf("account still throttled after a successful login: %v", retryAt)
+	}
+	if retryAt, _, _ := testDB.ReserveLoginAttempt("bob", "10.0.0.1", now); retryAt.IsZero() {
+		t.Errorf("a successful login reset the IP count")
+	}
+
+	// a successful attempt does not count against its IP
+	if err := testDB.ReleaseLoginAttempt("10.0.0.2"); err != nil {
+		t.Fatalf("ReleaseLoginAttempt failed: %v", err)
+	}
+	if retryAt, _, _ := testDB.ReserveLoginAttempt("carol", "10.0.0.2", now); !retryAt.IsZero() {
+		t.Errorf("IP throttled after a successful login: %v", retryAt)
+	}
+}
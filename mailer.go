@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outgoing mail. The mail.driver setting picks how messages leave: "smtp"
+// sends them through a relay, "file" writes each one to mail.dir as an .eml
+// file and "log" prints them without their sign-in links.
+
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(m Mail) error
+}
+
+// mailer delivers the server's mail; main sets it from the configuration
+var mailer Mailer = logMailer{}
+
+func newMailer(c *Config) Mailer {
+	switch c.Mail.Driver {
+	case "smtp":
+		return &smtpMailer{
+			addr:     net.JoinHostPort(c.Mail.SMTP.Host, strconv.Itoa(c.Mail.SMTP.Port)),
+			host:     c.Mail.SMTP.Host,
+			username: c.Mail.SMTP.Username,
+			password: c.Mail.SMTP.Password,
+			from:     c.Mail.From,
+		}
+	case "file":
+		return &fileMailer{dir: c.Mail.Dir, from: c.Mail.From}
+	}
+	return logMailer{}
+}
+
+// mailPending tracks mail handed to sendInBackground that is not sent yet
+var mailPending sync.WaitGroup
+
+// sendInBackground sends mails one after another without holding up the
+// request that produced them. Failures are logged.
+func sendInBackground(mails []Mail) {
+	if len(mails) == 0 {
+		return
+	}
+	mailPending.Add(1)
+	go func() {
+		defer mailPending.Done()
+		sent := 0
+		for _, m := range mails {
+			if err := mailer.Send(m); err != nil {
+				fmt.Printf("Warning: %v\n", err)
+				continue
+			}
+			sent++
+		}
+		fmt.Printf("Sent %d of %d queued mails\n", sent, len(mails))
+	}()
+}
+
+// waitForMail gives background mail up to timeout to go out before the
+// server exits, reporting whether it all did
+func waitForMail(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		mailPending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// formatMail renders m as a plain text RFC 5322 message
+func formatMail(from string, m Mail, now time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(m.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes()
+}
+
+type smtpMailer struct {
+	addr, host         string
+	username, password string
+	from               string
+}
+
+// Send delivers m through the relay. net/smtp upgrades to TLS when the
+// server offers STARTTLS and refuses to send a password in the clear.
+func (s *smtpMailer) Send(m Mail) error {
+	from, err := mail.ParseAddress(s.from)
+	if err != nil {
+		return fmt.Errorf("invalid sender %q: %w", s.from, err)
+	}
+	var auth smtp.Auth
+	if s.username != "" {
+		auth = smtp.PlainAuth("", s.username, s.password, s.host)
+	}
+	if err := smtp.SendMail(s.addr, auth, from.Address, []string{m.To}, formatMail(s.from, m, time.Now())); err != nil {
+		return fmt.Errorf("failed to send mail to %s: %w", m.To, err)
+	}
+	return nil
+}
+
+type fileMailer struct {
+	dir, from string
+}
+
+func (f *fileMailer) Send(m Mail) error {
+	if err := os.MkdirAll(f.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	now := time.Now()
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000"), safeMailName(m.To))
+	// the messages hold sign-in links, so only the server may read them
+	if err := os.WriteFile(filepath.Join(f.dir, name), formatMail(f.from, m, now), 0600); err != nil {
+		return fmt.Errorf("failed to write mail: %w", err)
+	}
+	fmt.Printf("Mail to %s written to %s\n", m.To, filepath.Join(f.dir, name))
+	return nil
+}
+
+// safeMailName keeps an address usable as part of a file name
+func safeMailName(addr string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '@' || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, addr)
+}
+
+// logMailer prints mail instead of sending it, which suits development.
+// Sign-in links are left out, since anyone reading the log could use them.
+type logMailer struct{}
+
+func (logMailer) Send(m Mail) error {
+	fmt.Printf("Mail to %s: %s\n%s\n", m.To, m.Subject, redactInviteLinks(m.Body))
+	return nil
+}
+
+var inviteLinkToken = regexp.MustCompile(`/invite/[A-Za-z0-9_-]+`)
+
+// redactInviteLinks replaces the tokens of invitation links in body
+func redactInviteLinks(body string) string {
+	return inviteLinkToken.ReplaceAllString(body, "/invite/[redacted]")
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m := &fileMailer{dir: dir, from: "AFcb <noreply@acme.test>"}
+	if err := m.Send(Mail{To: "mary+crm@acme.test", Subject: "Grüße", Body: "line one\nline two\n"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("wrote %v, want one .eml file", files)
+	}
+	if info, _ := os.Stat(files[0]); info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+	f, _ := os.Open(files[0])
+	defer f.Close()
+	msg, err := mail.ReadMessage(f)
+	if err != nil {
+		t.Fatalf("not a mail message: %v", err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if msg.Header.Get("To") != "mary+crm@acme.test" || subject != "Grüße" {
+		t.Errorf("headers = %v", msg.Header)
+	}
+	body, err := io.ReadAll(msg.Body)
+	if err != nil || string(body) != "line one\r\nline two\r\n" {
+		t.Errorf("body = %q, %v", body, err)
+	}
+}
+
+func TestLogMailerRedactsInviteLinks(t *testing.T) {
+	body := "Choose your password at https://crm.acme.test/invite/Zm9v_YmFy-42 before Monday."
+	if got, want := redactInviteLinks(body), "Choose your password at https://crm.acme.test/invite/[redacted] before Monday."; got != want {
+		t.Errorf("redactInviteLinks = %q, want %q", got, want)
+	}
+}
+
+type slowMailer struct {
+	recordingMailer
+	release chan struct{}
+}
+
+func (m *slowMailer) Send(mail Mail) error {
+	<-m.release
+	return m.recordingMailer.Send(mail)
+}
+
+func TestSendInBackground(t *testing.T) {
+	slow := &slowMailer{release: make(chan struct{})}
+	saved := mailer
+	mailer = slow
+	t.Cleanup(func() { mailer = saved })
+
+	// returns while the mail server is still busy
+	sendInBackground([]Mail{{To: "a@acme.test"}, {To: "b@acme.test"}})
+	if waitForMail(10 * time.Millisecond) {
+		t.Fatalf("waitForMail returned before the mail was sent")
+	}
+	close(slow.release)
+	if !waitForMail(time.Second) || len(slow.sent) != 2 {
+		t.Errorf("sent %v, want both mails", slow.sent)
+	}
+}
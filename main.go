@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
 	"log"
@@ -17,8 +19,6 @@ var db *DB
 
 var emailRegex = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
 
-const dataFile = "AFcb.db" // Now using SQLite database
-
 var conCard = template.Must(template.New("card").Funcs(template.FuncMap{
 	"getCompanyName": func(companyID *string) string {
 		if companyID == nil {
@@ -30,6 +30,10 @@ var conCard = template.Must(template.New("card").Funcs(template.FuncMap{
 		}
 		return company.Name
 	},
+	// wa.me links take the number without the plus
+	"waNumber": func(e164 string) string {
+		return strings.TrimPrefix(e164, "+")
+	},
 }).Parse(`
     <div class="card bg-white rounded-xl shadow-md p-6 hover:shadow-lg transition-all duration-300" id="contact-{{.Contact.ID}}">
     <div class="details">
@@ -41,13 +45,10 @@ var conCard = template.Must(template.New("card").Funcs(template.FuncMap{
         </div>
         {{end}}
         <span class="type inline-block mt-2 px-3 py-1 rounded-full text-sm font-medium
-            {{if .IsCurrentUser}}bg-yellow-100 text-yellow-800
-            {{else if eq .Contact.ContactType "Personal"}}bg-blue-100 text-blue-800
-            {{else if eq .Contact.ContactType "Work"}}bg-green-100 text-green-800
-            {{else if eq .Contact.ContactType "Family"}}bg-purple-100 text-purple-800
-            {{else}}bg-gray-100 text-gray-800{{end}}">
+            {{if .IsCurrentUser}}bg-yellow-100 text-yellow-800{{else}}{{.TypeClasses}}{{end}}">
             {{if .IsCurrentUser}}Myself{{else}}{{.Contact.ContactType}}{{end}}
         </span>
+        {{.Tags}}
         <div class="details mt-3 text-gray-600">
             <div class="flex items-center mb-1">
                 <svg xmlns="http://www.w3.org/2000/svg" class="h-4 w-4 mr-2" fill="none" viewBox="0 0 24 24" stroke="currentColor">
@@ -65,17 +66,29 @@ var conCard = template.Must(template.New("card").Funcs(template.FuncMap{
                     <path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M3 5a2 2 0 012-2h3.28a1 1 0 01.948.684l1.498 4.493a1 1 0 01-.502 1.21l-2.257 1.13a11.042 11.042 0 005.516 5.516l1.13-2.257a1 1 0 011.21-.502l4.493 1.498a1 1 0 01.684.949V19a2 2 0 01-2 2h-1C9.716 21 3 14.284 3 6V5z" />
                 </svg>
                 <span>{{.Contact.Phone}}</span>
-                <a href="https://wa.me/{{.Contact.Phone}}" target="_blank" class="ml-2 p-1 rounded-full text-green-500 hover:bg-green-100 transition-colors" title="WhatsApp">
+                {{with .Contact.PhoneE164}}
+                <a href="https://wa.me/{{waNumber .}}" target="_blank" class="ml-2 p-1 rounded-full text-green-500 hover:bg-green-100 transition-colors" title="WhatsApp">
                     <svg xmlns="http://www.w3.org/2000/svg" class="h-5 w-5" viewBox="0 0 24 24" fill="currentColor">
                         <path d="M12.04 2.87c-5.42 0-9.82 4.4-9.82 9.82 0 1.94.57 3.8.14 5.39l-1.39 5.09 5.25-1.36c1.5.25 3.09.4 4.56.4 5.42 0 9.82-4.4 9.82-9.82-.01-5.42-4.4-9.81-9.8-9.81zm-.04 17.1c-1.36 0-2.7-.22-3.9-.66l-2.61.68.68-2.55c-.5-1.16-.76-2.43-.76-3.75 0-4.41 3.59-8 8-8s8 3.59 8 8-3.59 8-8 8zm4.53-5.59c-.25-.13-.49-.2-.72-.2-.23 0-.46.07-.69.21-.23.14-.52.28-.84.38-.32.1-.64.16-.96.06-.32-.1-.6-.24-.87-.45-.27-.2-.5-.45-.7-.7-.19-.24-.34-.49-.49-.77s-.27-.58-.33-.89c-.06-.31-.05-.59-.01-.84.04-.26.13-.5.26-.72.13-.22.25-.4.36-.57.11-.17.18-.32.22-.44.04-.12.02-.27-.04-.43-.06-.16-.18-.32-.34-.48-.16-.16-.36-.31-.6-.44-.24-.13-.49-.2-.73-.2-.24 0-.48.05-.72.15-.24.1-.46.25-.66.44-.2.19-.38.41-.54.67-.16.26-.28.53-.4.81s-.2 0-.25-.06c-.05-.06-.2-.25-.37-.47s-.35-.4-.5-.54c-.16-.14-.28-.2-.37-.2s-.22 0-.36-.05c-.14-.05-.3-.08-.5-.09-.19-.01-.39-.01-.58 0-.19 0-.4.04-.61.09-.2.05-.4.14-.57.26-.17.12-.3.27-.4.45-.1.18-.15.39-.15.63s.06.48.19.74c.12.26.3.52.54.78.24.26.54.55.89.87.35.31.75.63 1.18.96 1.05.78 1.95 1.48 2.5 1.77.55.29 1.01.44 1.39.44.38 0 .82-.13 1.34-.38.52-.25.96-.54 1.33-.88.37-.34.6-.78.71-1.32.11-.54.06-1.04-.08-1.52z"/>
                     </svg>
                 </a>
-                <a href="signal://send?text=&phone={{.Contact.Phone}}" target="_blank" class="ml-2 p-1 rounded-full text-gray-800 hover:bg-gray-200 transition-colors" title="Signal">
+                <a href="signal://send?text=&phone={{.}}" target="_blank" class="ml-2 p-1 rounded-full text-gray-800 hover:bg-gray-200 transition-colors" title="Signal">
                     <svg xmlns="http://www.w3.org/2000/svg" class="h-5 w-5" viewBox="0 0 24 24" fill="currentColor">
                         <path d="M12 2C6.48 2 2 6.48 2 12s4.48 10 10 10 10-4.48 10-10S17.52 2 12 2zm.8 14.8c-.37.37-.87.5-1.37.5-.5 0-1-.13-1.37-.5-.75-.75-.75-1.99 0-2.74L12 11.39l-1.44-1.44c-.75-.75-.75-1.99 0-2.74s1.99-.75 2.74 0L12 8.61l1.44-1.44c.75-.75 1.99-.75 2.74 0s.75 1.99 0 2.74L12.8 12.8l1.44 1.44c.75.75.75 1.99 0 2.74zm0 0"/>
                     </svg>
                 </a>
+                {{end}}
             </div>
+            {{range .Contact.Emails}}
+            <div class="text-sm mt-1"><span class="text-xs uppercase text-gray-400 mr-1">{{.Label}}</span><a href="mailto:{{.Email}}" class="hover:underline">{{.Email}}</a></div>
+            {{end}}
+            {{range .Contact.Phones}}
+            <div class="text-sm mt-1"><span class="text-xs uppercase text-gray-400 mr-1">{{.Label}}</span><a href="tel:{{if .E164}}{{.E164}}{{else}}{{.Phone}}{{end}}" class="hover:underline">{{.Phone}}</a></div>
+            {{end}}
+            {{range .Contact.Addresses}}
+            <div class="text-sm mt-1"><span class="text-xs uppercase text-gray-400 mr-1">{{.Label}}</span>{{.String}}</div>
+            {{end}}
+            {{.Custom}}
         </div>
     </div>
     <div class="actions flex justify-end mt-4 space-x-2">
@@ -85,7 +98,8 @@ var conCard = template.Must(template.New("card").Funcs(template.FuncMap{
             	<path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M12 10v6m0 0l-3-3m3 3l3-3m2 8H7a2 2 0 01-2-2V5a2 2 0 012-2h5.586a1 1 0 01.707.293l5.414 5.414a1 1 0 01.293.707V19a2 2 0 01-2 2z"/>
             </svg>
         </a>
-        <button class="edit-btn p-2 rounded-lg border border-gray-300 hover:border-blue-500 hover:bg-blue-50 transition-colors"
+{{if .CanEdit}}
+                <button class="edit-btn p-2 rounded-lg border border-gray-300 hover:border-blue-500 hover:bg-blue-50 transition-colors"
             hx-get="/modal/edit/{{.Contact.ID}}"
             hx-target="#modal-container"
             hx-swap="innerHTML"
@@ -95,11 +109,13 @@ var conCard = template.Must(template.New("card").Funcs(template.FuncMap{
                 <path d="M16.5 3.5a2.121 2.121 0 0 1 3 3L7 19l-4 1 1-4 12.5-12.5z"/>
             </svg>
         </button>
-        <button class="delete-btn p-2 rounded-lg border border-gray-300 hover:border-red-500 hover:bg-red-50 transition-colors"
+{{end}}
+        {{if .CanDelete}}
+                <button class="delete-btn p-2 rounded-lg border border-gray-300 hover:border-red-500 hover:bg-red-50 transition-colors"
                 hx-delete="/contacts/{{.Contact.ID}}"
                 hx-target="#contact-{{.Contact.ID}}"
                 hx-swap="outerHTML"
-                hx-confirm="Are you sure you want to delete this contact?"
+                hx-confirm="Move this contact to the recycle bin?"
                 title="Delete">
             <svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 24 24" width="20" height="20" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round">
                 <polyline points="3 6 5 6 21 6"/>
@@ -108,6 +124,7 @@ var conCard = template.Must(template.New("card").Funcs(template.FuncMap{
                 <line x1="14" y1="11" x2="14" y2="17"/>
             </svg>
         </button>
+        {{end}}
     </div>
 </div>
 `))
@@ -156,6 +173,7 @@ var addCompanyModalHTML = `
                        id="registrationDocument" name="registration_document" type="file" accept=".pdf,.jpg,.jpeg,.png">
                 <p class="text-xs text-gray-500 mt-1">Upload company registration document (PDF, JPG, PNG)</p>
             </div>
+            {{template "custom-fields" .}}
             <div class="flex items-center justify-end">
                 <button type="button" hx-target="#company-modal" hx-swap="outerHTML" hx-get="/modal/close"
                         class="bg-gray-500 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-gray-600 transition-colors duration-300 mr-2">Cancel</button>
@@ -182,9 +200,7 @@ var addModalHTML = `
             <div class="mb-4">
                 <label class="block text-gray-700 text-sm font-bold mb-2" for="contactType">Contact Type</label>
                 <select id="contactType" name="ContactType" class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
-                    <option value="Personal">Personal</option>
-                    <option value="Work">Work</option>
-                    <option value="Family">Family</option>
+                    {{range .ContactTypes}}<option value="{{.Name}}">{{.Name}}</option>{{end}}
                 </select>
             </div>
             <div class="mb-4">
@@ -204,6 +220,7 @@ var addModalHTML = `
                 <label class="block text-gray-700 text-sm font-bold mb-2" for="phone">Phone</label>
                 <input class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline" id="phone" name="Phone" type="tel" placeholder="Phone" required>
             </div>
+            {{template "contact-details" .Contact}}
             <div class="mb-4">
                 <label class="block text-gray-700 text-sm font-bold mb-2" for="company">Company</label>
                 <select id="company" name="CompanyID" class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
@@ -213,11 +230,13 @@ var addModalHTML = `
                     {{end}}
                 </select>
             </div>
+            {{template "custom-fields" .Custom}}
+            {{template "contact-tags" .Tags}}
             <div class="mb-4">
                 <label class="block text-gray-700 text-sm font-bold mb-2" for="password">Password</label>
                 <input class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline"
                        id="password" name="Password" type="password" placeholder="Password for login">
-                <p class="text-xs text-gray-500 mt-1">Leave empty to auto-generate</p>
+                <p class="text-xs text-gray-500 mt-1">Leave empty to email the contact an invitation to choose their own</p>
             </div>
             <div class="flex items-center justify-end">
                 <button type="button" hx-target="#contact-modal" hx-swap="outerHTML" hx-get="/modal/close" class="bg-gray-500 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-gray-600 transition-colors duration-300 mr-2">Cancel</button>
@@ -244,9 +263,7 @@ var editModalHTML = `
             <div class="mb-4">
                 <label class="block text-gray-700 text-sm font-bold mb-2" for="contactType">Contact Type</label>
                 <select id="contactType" name="ContactType" class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
-                    <option value="Personal" {{if eq .Contact.ContactType "Personal"}}selected{{end}}>Personal</option>
-                    <option value="Work" {{if eq .Contact.ContactType "Work"}}selected{{end}}>Work</option>
-                    <option value="Family" {{if eq .Contact.ContactType "Family"}}selected{{end}}>Family</option>
+                    {{range .ContactTypes}}<option value="{{.Name}}" {{if eq .Name $.Contact.ContactType}}selected{{end}}>{{.Name}}</option>{{end}}
                 </select>
             </div>
             <div class="mb-4">
@@ -277,9 +294,8 @@ var editModalHTML = `
             <div class="mb-4">
                 <label class="block text-gray-700 text-sm font-bold mb-2" for="password">Password</label>
                 <input class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline"
-                       id="password" name="Password" type="password" placeholder="Leave empty to keep current"
-                       value="{{if .Contact.Password}}{{.Contact.Password}}{{end}}">
-                <p class="text-xs text-gray-500 mt-1">Leave empty to keep current password</p>
+                       id="password" name="Password" type="password" placeholder="Leave empty to keep current">
+                <p class="text-xs text-gray-500 mt-1">Leave empty to keep current password. A new one signs the user out and must be changed at their next login.</p>
             </div>
             <div class="flex items-center justify-end">
                 <button type="button" hx-target="#contact-modal" hx-swap="outerHTML" hx-get="/modal/close" class="bg-gray-500 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-gray-600 transition-colors duration-300 mr-2">Cancel</button>
@@ -300,7 +316,7 @@ var changePasswordHTML = `
 		<script src="https://cdn.tailwindcss.com"></script>
         <script src="https://unpkg.com/htmx.org@1.9.10"></script>
     </head>
-    <body class="bg-gray-200 flex items-center justify-center min-h-screen">
+    <body class="bg-gray-200 flex items-center justify-center min-h-screen" hx-headers='{"X-CSRF-Token": "{{.CSRFToken}}"}'>
         <div class="bg-white p-8 rounded-lg shadow-md w-full max-w-md">
             <h2 class="text-2xl font-bold text-center text-gray-800 mb-6">
                 Change Your Password
@@ -353,6 +369,8 @@ var changePasswordHTML = `
 </html>
 `
 
+var changePasswordPage = template.Must(template.New("change-password").Parse(changePasswordHTML))
+
 // License activation handler
 func activateLicenseHandler(w http.ResponseWriter, r *http.Request) {
 	// Check if user is admin
@@ -415,6 +433,7 @@ func activateLicenseHandler(w http.ResponseWriter, r *http.Request) {
 
 		// Save license to environment or database
 		// For now, we'll just validate and show success
+		recordAudit(r, AuditLicenseActivate, "license", license.Email, nil, license)
 
 		fmt.Fprintf(w, `
         <div class="bg-green-50 border border-green-200 rounded-xl p-6">
@@ -473,31 +492,44 @@ func activateLicenseHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func indexHandler(w http.ResponseWriter, r *http.Request) {
-	// Check if user is admin
-	isAdmin := false
-	currentUser, err := getCurrentUser(r)
-	if err == nil && currentUser == "af" {
-		isAdmin = true
-	}
+// pageData carries the navigation and toolbar flags shared by full pages
+type pageData struct {
+	IsAdmin            bool
+	CanEditContacts    bool
+	CanViewCompanies   bool
+	CanManageCompanies bool
+	CSRFToken          string
+}
 
-	data := struct {
-		IsAdmin bool
-	}{
-		IsAdmin: isAdmin,
+func newPageData(r *http.Request) pageData {
+	return pageData{
+		IsAdmin:            isAdmin(r),
+		CanEditContacts:    hasPermission(r, PermEditContacts),
+		CanViewCompanies:   hasPermission(r, PermViewCompanies),
+		CanManageCompanies: hasPermission(r, PermManageCompanies),
+		CSRFToken:          csrfToken(r),
 	}
+}
+
+func indexHandler(w http.ResponseWriter, r *http.Request) {
+	data := newPageData(r)
 
-	tmpl := template.Must(template.ParseFiles("static/index.html"))
+	tmpl := template.Must(template.ParseFiles(staticPath("index.html")))
 	tmpl.Execute(w, data)
 }
 
+func licensePageHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := template.Must(template.ParseFiles(staticPath("license.html")))
+	tmpl.Execute(w, newPageData(r))
+}
+
 // helper for admin check
 func isAdmin(r *http.Request) bool {
-	currentUser, err := getCurrentUser(r)
+	user, err := currentUserRecord(r)
 	if err != nil {
 		return false
 	}
-	return currentUser == "af"
+	return user.Role == RoleAdmin
 }
 
 func licenseContentHandler(w http.ResponseWriter, r *http.Request) {
@@ -789,23 +821,11 @@ func licenseAdminHandler(w http.ResponseWriter, r *http.Request) {
 
 // Helper function to get current username from session
 func getCurrentUser(r *http.Request) (string, error) {
-	// First check if user is authenticated
-	sessionCookie, err := r.Cookie("session")
-	if err != nil || sessionCookie.Value != "authenticated" {
-		return "", fmt.Errorf("not authenticated")
-	}
-
-	// Try to get username from current_user cookie first (set during login)
-	if userCookie, err := r.Cookie("current_user"); err == nil {
-		return userCookie.Value, nil
-	}
-
-	// Fallback: try password_change_user cookie
-	if userCookie, err := r.Cookie("password_change_user"); err == nil {
-		return userCookie.Value, nil
+	session, err := sessionFromRequest(r)
+	if err != nil {
+		return "", err
 	}
-
-	return "", fmt.Errorf("user not found in session cookies")
+	return session.Username, nil
 }
 
 // Helper function to check if contact belongs to current user
@@ -813,11 +833,11 @@ func isCurrentUserContact(contactEmail string, r *http.Request) bool {
 	// Get current logged-in user from session
 	currentUser, err := getCurrentUser(r)
 	if err != nil {
-		fmt.Printf("DEBUG: Cannot get current user: %v\n", err)
+		debugf("Cannot get current user: %v\n", err)
 		return false
 	}
 
-	fmt.Printf("DEBUG: Current user: %s, Contact email: %s\n", currentUser, contactEmail)
+	debugf("Current user: %s, Contact email: %s\n", currentUser, contactEmail)
 
 	// Direct comparison: if current user's username IS the contact email
 	// This works because users log in with email as username
@@ -828,7 +848,7 @@ func isCurrentUserContact(contactEmail string, r *http.Request) bool {
 	// Additional check: if the current user has a contact record, check if it matches
 	user, err := db.GetUser(currentUser)
 	if err != nil {
-		fmt.Printf("DEBUG: Cannot get user from DB: %v\n", err)
+		debugf("Cannot get user from DB: %v\n", err)
 		return false
 	}
 
@@ -843,21 +863,31 @@ func isCurrentUserContact(contactEmail string, r *http.Request) bool {
 	return false
 }
 
-func renderCard(w http.ResponseWriter, r *http.Request, c Contact) {
-	w.Header().Set("Content-Type", "text/html")
-
-	// Check if this contact belongs to the current user
-	isCurrentUser := isCurrentUserContact(c.Email, r)
+type cardData struct {
+	Contact       Contact
+	IsCurrentUser bool
+	CanEdit       bool
+	CanDelete     bool
+	Custom        template.HTML // rendered custom field values
+	Tags          template.HTML // rendered tag chips
+	TypeClasses   string        // badge colors of the contact type
+}
 
-	data := struct {
-		Contact       Contact
-		IsCurrentUser bool
-	}{
+func newCardData(r *http.Request, c Contact) cardData {
+	return cardData{
 		Contact:       c,
-		IsCurrentUser: isCurrentUser,
+		IsCurrentUser: isCurrentUserContact(c.Email, r),
+		CanEdit:       canEditContact(r, c.ID),
+		CanDelete:     hasPermission(r, PermEditContacts),
+		Custom:        template.HTML(renderCustomTemplate("custom-values", filledCustomValues(loadCustomFields("contact"), c.Custom))),
+		Tags:          renderTagChips(contactTagList(loadTags(), c.Tags)),
+		TypeClasses:   contactTypeClasses(loadContactTypes(), c.ContactType),
 	}
+}
 
-	conCard.Execute(w, data)
+func renderCard(w http.ResponseWriter, r *http.Request, c Contact) {
+	w.Header().Set("Content-Type", "text/html")
+	conCard.Execute(w, newCardData(r, c))
 }
 
 func getCompanies(w http.ResponseWriter, r *http.Request) {
@@ -896,7 +926,7 @@ func getCompanies(w http.ResponseWriter, r *http.Request) {
                         hx-delete="/companies/%s"
                         hx-target="#company-%s"
                         hx-swap="outerHTML"
-                        hx-confirm="Are you sure you want to delete this company?">Delete</button>
+                        hx-confirm="Move this company to the recycle bin?">Delete</button>
             </div>
         </div>`,
 			company.ID, company.Name, company.BankName, company.AccountNumber,
@@ -906,103 +936,7 @@ func getCompanies(w http.ResponseWriter, r *http.Request) {
 
 // Search companies handler
 func searchCompanies(w http.ResponseWriter, r *http.Request) {
-	keyword := r.URL.Query().Get("q")
-	fmt.Printf("Search companies request received for keyword: '%s'\n", keyword)
-
-	w.Header().Set("Content-Type", "text/html")
-
-	var results []Company
-	var err error
-
-	if keyword == "" {
-		fmt.Println("No keyword provided, returning all companies")
-		results, err = db.GetAllCompanies()
-	} else {
-		results, err = db.SearchCompanies(keyword)
-	}
-
-	if err != nil {
-		http.Error(w, "Search failed: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	fmt.Printf("Found %d companies for keyword '%s'\n", len(results), keyword)
-
-	if len(results) == 0 {
-		fmt.Fprintf(w, `<tr><td colspan="6" class="px-6 py-4 text-center text-gray-500">No companies found for "%s"</td></tr>`, template.HTMLEscapeString(keyword))
-		return
-	}
-
-	for _, company := range results {
-		// Format created date
-		createdDate := company.CreatedAt
-		if len(company.CreatedAt) > 10 {
-			createdDate = company.CreatedAt[:10]
-		}
-
-		fmt.Fprintf(w, `
-        <tr id="company-row-%s">
-        <td class="px-6 py-4 whitespace-nowrap">
-            <div class="text-sm font-medium text-gray-900">%s</div>
-            <div class="text-sm text-gray-500">ID: %s</div>
-        </td>
-        <td class="px-6 py-4">
-            <div class="text-sm text-gray-900"><strong>Bank:</strong> %s</div>
-            <div class="text-sm text-gray-500"><strong>Account:</strong> %s</div>
-            <div class="text-sm text-gray-500">
-                <strong>Document:</strong>
-                %s
-            </div>
-        </td>
-        <td class="px-6 py-4">
-            <div class="text-sm text-gray-900"><strong>Number:</strong> %s</div>
-            <div class="text-sm text-gray-500">
-                <strong>Document:</strong>
-                %s
-            </div>
-        </td>
-        <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-500">
-            %s
-        </td>
-        <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-500">
-            %s
-        </td>
-        <td class="px-6 py-4 whitespace-nowrap text-sm font-medium">
-            <button class="text-blue-600 hover:text-blue-900 mr-3 p-1 rounded hover:bg-blue-50 transition-colors"
-                    hx-get="/modal/edit-company/%s"
-                    hx-target="#modal-container"
-                    hx-swap="innerHTML"
-                    title="Edit">
-                <svg xmlns="http://www.w3.org/2000/svg" class="h-5 w-5" fill="none" viewBox="0 0 24 24" stroke="currentColor">
-                    <path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M11 5H6a2 2 0 00-2 2v11a2 2 0 002 2h11a2 2 0 002-2v-5m-1.414-9.414a2 2 0 112.828 2.828L11.828 15H9v-2.828l8.586-8.586z"/>
-                </svg>
-            </button>
-            <button class="text-red-600 hover:text-red-900 p-1 rounded hover:bg-red-50 transition-colors"
-                    hx-delete="/companies/%s"
-                    hx-target="#company-row-%s"
-                    hx-swap="outerHTML"
-                    hx-confirm="Are you sure you want to delete this company?"
-                    title="Delete">
-                <svg xmlns="http://www.w3.org/2000/svg" class="h-5 w-5" fill="none" viewBox="0 0 24 24" stroke="currentColor">
-                    <path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M19 7l-.867 12.142A2 2 0 0116.138 21H7.862a2 2 0 01-1.995-1.858L5 7m5 4v6m4-6v6m1-10V4a1 1 0 00-1-1h-4a1 1 0 00-1 1v3M4 7h16"/>
-                </svg>
-            </button>
-        </td>
-        </tr>`,
-			company.ID,
-			template.HTMLEscapeString(company.Name),
-			company.ID,
-			template.HTMLEscapeString(company.BankName),
-			template.HTMLEscapeString(company.AccountNumber),
-			getDocumentLinkWithPreview(company.AccountDocumentPath, "Account Document"),
-			template.HTMLEscapeString(company.RegistrationNumber),
-			getDocumentLinkWithPreview(company.RegistrationDocumentPath, "Registration Document"),
-			getCreatedByDisplay(company.CreatedBy), // Created By column
-			createdDate,                            // Created Date column
-			company.ID,
-			company.ID,
-			company.ID)
-	}
+	renderCompanyTable(w, r)
 }
 
 // Enhanced document link function with preview
@@ -1015,8 +949,8 @@ func getDocumentLinkWithPreview(filename, documentType string) string {
 
 func addCompanyModal(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
-	tmpl := template.Must(template.New("company-modal").Parse(addCompanyModalHTML))
-	tmpl.Execute(w, nil)
+	tmpl := template.Must(template.New("company-modal").Parse(addCompanyModalHTML + customFieldsHTML))
+	tmpl.Execute(w, customFieldValues(loadCustomFields("company"), nil))
 }
 
 func addCompany(w http.ResponseWriter, r *http.Request) {
@@ -1025,52 +959,51 @@ func addCompany(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fmt.Println("=== DEBUG: Starting addCompany ===")
+	debugf("=== Starting addCompany ===\n")
 
 	// Parse multipart form for file uploads
-	if err := r.ParseMultipartForm(32 << 20); err != nil {
-		fmt.Printf("DEBUG: ParseMultipartForm error: %v\n", err)
-		http.Error(w, "File too large", http.StatusBadRequest)
+	if err := parseUploadForm(w, r); err != nil {
+		debugf("ParseMultipartForm error: %v\n", err)
 		return
 	}
-	fmt.Println("DEBUG: Multipart form parsed successfully")
+	debugf("Multipart form parsed successfully\n")
 
 	// Get current logged-in user
 	currentUser, err := getCurrentUser(r)
 	if err != nil {
-		fmt.Printf("DEBUG: Could not get current user: %v\n", err)
+		debugf("Could not get current user: %v\n", err)
 		currentUser = "unknown" // fallback
 	}
-	fmt.Printf("DEBUG: Current user: %s\n", currentUser)
+	debugf("Current user: %s\n", currentUser)
 
 	// Print all form values for debugging
-	fmt.Println("DEBUG: Form values:")
+	debugf("Form values:\n")
 	for key, values := range r.Form {
-		fmt.Printf("  %s: %v\n", key, values)
+		debugf("  %s: %v\n", key, values)
 	}
 
 	// Generate company ID
 	id, err := genID()
 	if err != nil {
-		fmt.Printf("DEBUG: genID error: %v\n", err)
+		debugf("genID error: %v\n", err)
 		http.Error(w, "Failed to generate ID", http.StatusInternalServerError)
 		return
 	}
-	fmt.Printf("DEBUG: Generated company ID: %s\n", id)
+	debugf("Generated company ID: %s\n", id)
 
 	// Handle file uploads
-	fmt.Println("DEBUG: Handling file uploads...")
+	debugf("Handling file uploads...\n")
 	accountDoc, err := handleFileUpload(r, "account_document")
 	if err != nil {
-		fmt.Printf("DEBUG: Account document upload error: %v\n", err)
+		debugf("Account document upload error: %v\n", err)
 		http.Error(w, "Failed to upload account document: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	fmt.Printf("DEBUG: Account document: %s\n", accountDoc)
+	debugf("Account document: %s\n", accountDoc)
 
 	registrationDoc, err := handleFileUpload(r, "registration_document")
 	if err != nil {
-		fmt.Printf("DEBUG: Registration document upload error: %v\n", err)
+		debugf("Registration document upload error: %v\n", err)
 		// Clean uploaded file if fails
 		if accountDoc != "" {
 			deleteUploadedFile(accountDoc)
@@ -1078,7 +1011,7 @@ func addCompany(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to upload registration document: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	fmt.Printf("DEBUG: Registration document: %s\n", registrationDoc)
+	debugf("Registration document: %s\n", registrationDoc)
 
 	// Get form values
 	name := r.FormValue("name")
@@ -1086,16 +1019,9 @@ func addCompany(w http.ResponseWriter, r *http.Request) {
 	accountNumber := r.FormValue("account_number")
 	registrationNumber := r.FormValue("registration_number")
 
-	fmt.Printf("DEBUG: Form data - Name: '%s', Bank: '%s', Account: '%s', Reg: '%s'\n",
+	debugf("Form data - Name: '%s', Bank: '%s', Account: '%s', Reg: '%s'\n",
 		name, bankName, accountNumber, registrationNumber)
 
-	// Validate required fields
-	if name == "" {
-		fmt.Println("DEBUG: Company name is required")
-		http.Error(w, "Company name is required", http.StatusBadRequest)
-		return
-	}
-
 	company := &Company{
 		ID:                       id,
 		Name:                     name,
@@ -1105,13 +1031,27 @@ func addCompany(w http.ResponseWriter, r *http.Request) {
 		RegistrationNumber:       registrationNumber,
 		RegistrationDocumentPath: registrationDoc,
 		CreatedBy:                &currentUser, // Set the logged-in user
+		Custom:                   customValuesFromForm(r, "company", nil),
+	}
+
+	// Validate required fields
+	if err := validateCompany(company); err != nil {
+		debugf("%v\n", err)
+		if accountDoc != "" {
+			deleteUploadedFile(accountDoc)
+		}
+		if registrationDoc != "" {
+			deleteUploadedFile(registrationDoc)
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	fmt.Printf("DEBUG: Attempting to create company: %+v\n", company)
+	debugf("Attempting to create company: %+v\n", company)
 
 	// Create company in database
 	if err := db.CreateCompany(company); err != nil {
-		fmt.Printf("DEBUG: CreateCompany error: %v\n", err)
+		debugf("CreateCompany error: %v\n", err)
 		// Clean uploaded files if database operation fails
 		if accountDoc != "" {
 			deleteUploadedFile(accountDoc)
@@ -1123,89 +1063,20 @@ func addCompany(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fmt.Println("DEBUG: Company created successfully in database")
+	debugf("Company created successfully in database\n")
+	recordAudit(r, AuditCreate, "company", company.ID, nil, company)
 
 	w.Header().Set("Content-Type", "text/html")
 
-	// Get the actual created timestamp from the database
-	createdCompany, err := db.GetCompany(company.ID)
-	var createdDate string
-	if err != nil {
-		fmt.Printf("DEBUG: Could not get created company for timestamp: %v\n", err)
-		// Fallback to current time
-		createdDate = "Just now"
+	// reload for the created timestamp set by the database
+	if created, err := db.GetCompany(company.ID); err == nil {
+		company = created
 	} else {
-		// Format the timestamp to show date and time
-		createdDate = formatTimestamp(createdCompany.CreatedAt)
-		if createdDate == "" {
-			createdDate = "Just now"
-		}
+		debugf("Could not get created company for timestamp: %v\n", err)
 	}
+	writeCompanyRow(w, *company, loadCustomFields("company"))
 
-	fmt.Fprintf(w, `
-    <tr id="company-row-%s">
-        <td class="px-6 py-4 whitespace-nowrap">
-            <div class="text-sm font-medium text-gray-900">%s</div>
-            <div class="text-sm text-gray-500">ID: %s</div>
-        </td>
-        <td class="px-6 py-4">
-            <div class="text-sm text-gray-900"><strong>Bank:</strong> %s</div>
-            <div class="text-sm text-gray-500"><strong>Account:</strong> %s</div>
-            <div class="text-sm text-gray-500">
-                <strong>Document:</strong>
-                %s
-            </div>
-        </td>
-        <td class="px-6 py-4">
-            <div class="text-sm text-gray-900"><strong>Number:</strong> %s</div>
-            <div class="text-sm text-gray-500">
-                <strong>Document:</strong>
-                %s
-            </div>
-        </td>
-        <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-500">
-            %s
-        </td>
-        <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-500">
-            %s
-        </td>
-        <td class="px-6 py-4 whitespace-nowrap text-sm font-medium">
-            <button class="text-blue-600 hover:text-blue-900 mr-3 p-1 rounded hover:bg-blue-50 transition-colors"
-                    hx-get="/modal/edit-company/%s"
-                    hx-target="#modal-container"
-                    hx-swap="innerHTML"
-                    title="Edit">
-                <svg xmlns="http://www.w3.org/2000/svg" class="h-5 w-5" fill="none" viewBox="0 0 24 24" stroke="currentColor">
-                    <path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M11 5H6a2 2 0 00-2 2v11a2 2 0 002 2h11a2 2 0 002-2v-5m-1.414-9.414a2 2 0 112.828 2.828L11.828 15H9v-2.828l8.586-8.586z"/>
-                </svg>
-            </button>
-            <button class="text-red-600 hover:text-red-900 p-1 rounded hover:bg-red-50 transition-colors"
-                    hx-delete="/companies/%s"
-                    hx-target="#company-row-%s"
-                    hx-swap="outerHTML"
-                    hx-confirm="Are you sure you want to delete this company?"
-                    title="Delete">
-                <svg xmlns="http://www.w3.org/2000/svg" class="h-5 w-5" fill="none" viewBox="0 0 24 24" stroke="currentColor">
-                    <path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M19 7l-.867 12.142A2 2 0 0116.138 21H7.862a2 2 0 01-1.995-1.858L5 7m5 4v6m4-6v6m1-10V4a1 1 0 00-1-1h-4a1 1 0 00-1 1v3M4 7h16"/>
-                </svg>
-            </button>
-        </td>
-    </tr>`,
-		company.ID,                              // 1. %s - company.ID
-		template.HTMLEscapeString(company.Name), // 2. %s - company.Name
-		company.ID,                              // 3. %s - company.ID (for the ID display)
-		template.HTMLEscapeString(company.BankName),                                           // 4. %s - bank name
-		template.HTMLEscapeString(company.AccountNumber),                                      // 5. %s - account number
-		getDocumentLinkWithPreview(company.AccountDocumentPath, "Account Document"),           // 6. %s - account doc
-		template.HTMLEscapeString(company.RegistrationNumber),                                 // 7. %s - registration number
-		getDocumentLinkWithPreview(company.RegistrationDocumentPath, "Registration Document"), // 8. %s - reg doc
-		getCreatedByDisplay(company.CreatedBy),                                                // 9. %s - created by
-		createdDate,                                                                           // 10. %s - created date with timestamp
-		company.ID,                                                                            // 11. %s - company.ID (for edit button)
-		company.ID,                                                                            // 12. %s - company.ID (for delete button)
-		company.ID)                                                                            // 13. %s - company.ID (for delete target)
-
-	fmt.Println("=== DEBUG: addCompany completed successfully ===")
+	debugf("=== addCompany completed successfully ===\n")
 }
 
 func getCreatedByDisplay(createdBy *string) string {
@@ -1252,6 +1123,8 @@ func checkEmail(w http.ResponseWriter, r *http.Request) {
 	_, err := db.GetContactByEmail(email)
 	if err == nil {
 		fmt.Fprintf(w, `<span class="text-red-500 text-xs">Email already exists</span>`)
+	} else if db.EmailInTrash(email) {
+		fmt.Fprintf(w, `<span class="text-red-500 text-xs">Email belongs to a contact in the recycle bin</span>`)
 	} else {
 		fmt.Fprintf(w, `<span class="text-green-500 text-xs">Email available</span>`)
 	}
@@ -1262,27 +1135,19 @@ func deleteCompany(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
 	fmt.Println("DELETE company request received for id:", id)
 
-	// Get company to find associated documents
 	company, err := db.GetCompany(id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	// Delete uploaded files
-	if company.AccountDocumentPath != "" {
-		deleteUploadedFile(company.AccountDocumentPath)
-	}
-	if company.RegistrationDocumentPath != "" {
-		deleteUploadedFile(company.RegistrationDocumentPath)
-	}
-
-	// Delete company from database
+	// Move company to the recycle bin, its documents are kept until purged
 	if err := db.DeleteCompany(id); err != nil {
 		fmt.Println("Delete company error:", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	recordAudit(r, AuditDelete, "company", id, company, nil)
 
 	// Return empty content - HTMX will remove element
 	w.WriteHeader(http.StatusOK)
@@ -1296,8 +1161,7 @@ func updateCompany(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse multipart form for file uploads
-	if err := r.ParseMultipartForm(32 << 20); err != nil {
-		http.Error(w, "File too large", http.StatusBadRequest)
+	if err := parseUploadForm(w, r); err != nil {
 		return
 	}
 
@@ -1310,12 +1174,19 @@ func updateCompany(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Company not found", http.StatusNotFound)
 		return
 	}
+	before := *company
 
 	// Update basic fields
 	company.Name = r.FormValue("name")
 	company.BankName = r.FormValue("bank_name")
 	company.AccountNumber = r.FormValue("account_number")
 	company.RegistrationNumber = r.FormValue("registration_number")
+	company.Custom = customValuesFromForm(r, "company", company.Custom)
+
+	if err := validateCompany(company); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Handle file uploads - only update if new files are provided
 	if accountDoc, err := handleFileUpload(r, "account_document"); err == nil && accountDoc != "" {
@@ -1339,74 +1210,11 @@ func updateCompany(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to update company: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	recordAudit(r, AuditUpdate, "company", id, &before, company)
 
 	// Return updated table row
 	w.Header().Set("Content-Type", "text/html")
-
-	// Format created date
-	createdDate := formatTimestamp(company.CreatedAt)
-	if createdDate == "" {
-		createdDate = "Unknown"
-	}
-
-	fmt.Fprintf(w, `
-    <tr id="company-row-%s">
-        <td class="px-6 py-4 whitespace-nowrap">
-            <div class="text-sm font-medium text-gray-900">%s</div>
-            <div class="text-sm text-gray-500">ID: %s</div>
-        </td>
-        <td class="px-6 py-4">
-            <div class="text-sm text-gray-900"><strong>Bank:</strong> %s</div>
-            <div class="text-sm text-gray-500"><strong>Account:</strong> %s</div>
-            <div class="text-sm text-gray-500">
-                <strong>Document:</strong>
-                %s
-            </div>
-        </td>
-        <td class="px-6 py-4">
-            <div class="text-sm text-gray-900"><strong>Number:</strong> %s</div>
-            <div class="text-sm text-gray-500">
-                <strong>Document:</strong>
-                %s
-            </div>
-        </td>
-        <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-500">
-            %s
-        </td>
-        <td class="px-6 py-4 whitespace-nowrap text-sm font-medium">
-            <button class="text-blue-600 hover:text-blue-900 mr-3 p-1 rounded hover:bg-blue-50 transition-colors"
-                    hx-get="/modal/edit-company/%s"
-                    hx-target="#modal-container"
-                    hx-swap="innerHTML"
-                    title="Edit">
-                <svg xmlns="http://www.w3.org/2000/svg" class="h-5 w-5" fill="none" viewBox="0 0 24 24" stroke="currentColor">
-                    <path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M11 5H6a2 2 0 00-2 2v11a2 2 0 002 2h11a2 2 0 002-2v-5m-1.414-9.414a2 2 0 112.828 2.828L11.828 15H9v-2.828l8.586-8.586z"/>
-                </svg>
-            </button>
-            <button class="text-red-600 hover:text-red-900 p-1 rounded hover:bg-red-50 transition-colors"
-                    hx-delete="/companies/%s"
-                    hx-target="#company-row-%s"
-                    hx-swap="outerHTML"
-                    hx-confirm="Are you sure you want to delete this company?"
-                    title="Delete">
-                <svg xmlns="http://www.w3.org/2000/svg" class="h-5 w-5" fill="none" viewBox="0 0 24 24" stroke="currentColor">
-                    <path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M19 7l-.867 12.142A2 2 0 0116.138 21H7.862a2 2 0 01-1.995-1.858L5 7m5 4v6m4-6v6m1-10V4a1 1 0 00-1-1h-4a1 1 0 00-1 1v3M4 7h16"/>
-                </svg>
-            </button>
-        </td>
-    </tr>`,
-		company.ID,
-		template.HTMLEscapeString(company.Name),
-		company.ID,
-		template.HTMLEscapeString(company.BankName),
-		template.HTMLEscapeString(company.AccountNumber),
-		getDocumentLinkWithPreview(company.AccountDocumentPath, "Account Document"),
-		template.HTMLEscapeString(company.RegistrationNumber),
-		getDocumentLinkWithPreview(company.RegistrationDocumentPath, "Registration Document"),
-		createdDate,
-		company.ID,
-		company.ID,
-		company.ID)
+	writeCompanyRow(w, *company, loadCustomFields("company"))
 }
 
 // Helper function to display current document
@@ -1418,42 +1226,57 @@ func getCurrentDocumentDisplay(filename string) string {
 }
 
 func getContacts(w http.ResponseWriter, r *http.Request) {
+	renderContactList(w, r)
+}
+
+// renderContactList writes one page of contact cards for /contacts and
+// /search. While more pages remain, a sentinel at the end loads the next one
+// when it scrolls into view.
+func renderContactList(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 
-	fmt.Printf("=== GET /contacts called ===\n")
+	keyword := r.URL.Query().Get("q")
+	for _, tag := range r.URL.Query()["tag"] {
+		keyword = strings.TrimSpace(keyword + " " + tagQuery(tag))
+	}
+	opts := listOptionsFromRequest(r, contactSorts)
 
-	contacts, err := db.GetAllContacts()
+	contacts, total, err := db.ListContacts(keyword, opts)
 	if err != nil {
 		http.Error(w, "Failed to fetch contacts: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	fmt.Printf("Returning %d contacts to client\n", len(contacts))
+	fmt.Printf("Returning contacts %d-%d of %d (q=%q, sort=%q)\n", opts.PerPage*(opts.Page-1)+1, opts.PerPage*(opts.Page-1)+len(contacts), total, keyword, opts.Sort)
 
-	if len(contacts) == 0 {
-		fmt.Printf("No contacts found, returning empty message\n")
-		fmt.Fprintf(w, `<div class="flex items-center justify-center p-8 bg-gray-100 text-gray-500 rounded-lg shadow-md">
+	if total == 0 {
+		if keyword != "" {
+			fmt.Fprintf(w, `<div class="no-results text-center p-8 text-gray-500">No contacts found for "%s"</div>`, template.HTMLEscapeString(keyword))
+		} else {
+			fmt.Fprintf(w, `<div class="flex items-center justify-center p-8 bg-gray-100 text-gray-500 rounded-lg shadow-md">
             No contacts found. Add your first contact!
         </div>`)
+		}
 		return
 	}
 
-	// SINGLE RENDER LOOP - FIXED
 	for _, c := range contacts {
-		isCurrentUser := isCurrentUserContact(c.Email, r)
-		data := struct {
-			Contact       Contact
-			IsCurrentUser bool
-		}{
-			Contact:       c,
-			IsCurrentUser: isCurrentUser,
-		}
-		if err := conCard.Execute(w, data); err != nil {
+		if err := conCard.Execute(w, newCardData(r, c)); err != nil {
 			fmt.Printf("Error rendering contact %s: %v\n", c.ID, err)
 			continue
 		}
 	}
-	fmt.Printf("=== END GET /contacts ===\n")
+
+	pager := Pager{ListOptions: opts, Keyword: keyword, Total: total}
+	if pager.HasNext() {
+		fmt.Fprintf(w, `
+        <div class="col-span-full text-center p-4 text-gray-500"
+             hx-get="/contacts?%s"
+             hx-trigger="revealed"
+             hx-swap="outerHTML">
+            Loading more contacts... (%d of %d shown)
+        </div>`, template.HTMLEscapeString(pager.NextQuery()), pager.Last(), pager.Total)
+	}
 }
 
 func addContact(w http.ResponseWriter, r *http.Request) {
@@ -1467,35 +1290,43 @@ func addContact(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	contactType := r.FormValue("ContactType")
-	firstName := r.FormValue("FirstName")
-	lastName := r.FormValue("LastName")
-	email := r.FormValue("Email")
-	phone := r.FormValue("Phone")
+	newContact := &Contact{
+		ContactType: r.FormValue("ContactType"),
+		FirstName:   r.FormValue("FirstName"),
+		LastName:    r.FormValue("LastName"),
+		Email:       r.FormValue("Email"),
+		Phone:       r.FormValue("Phone"),
+	}
 	password := r.FormValue("Password")
-	companyID := r.FormValue("CompanyID")
-
-	fmt.Printf("Received form data - Type: '%s', Name: '%s %s', Email: '%s', Phone: '%s', Password: '%s', CompanyID: '%s'\n",
-		contactType, firstName, lastName, email, phone, password, companyID)
+	contactDetailsFromForm(r, newContact)
+	newContact.Custom = customValuesFromForm(r, "contact", nil)
+	contactTagsFromForm(r, newContact)
 
-	// Validate required fields
-	if firstName == "" || lastName == "" || email == "" || phone == "" {
-		fmt.Printf("Missing required fields: FirstName='%s', LastName='%s', Email='%s', Phone='%s'\n",
-			firstName, lastName, email, phone)
-		http.Error(w, "All fields are required", http.StatusBadRequest)
-		return
+	// Handle companyID for new contact
+	if companyID := r.FormValue("CompanyID"); companyID != "" {
+		newContact.CompanyID = &companyID
 	}
 
-	// Email validation
-	if !emailRegex.MatchString(email) {
-		http.Error(w, "Invalid email address format", http.StatusBadRequest)
+	fmt.Printf("Received form data - Type: '%s', Name: '%s %s', Email: '%s', Phone: '%s'\n",
+		newContact.ContactType, newContact.FirstName, newContact.LastName, newContact.Email, newContact.Phone)
+
+	if err := validateContact(newContact); err != nil {
+		fmt.Printf("Invalid contact: %v\n", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// Check if email already exists
-	existingContact, err := db.GetContactByEmail(email)
-	if err == nil && existingContact != nil {
-		fmt.Printf("Email already exists: %s\n", email)
+	err := checkEmailAvailable(newContact.Email, "")
+	if err == nil {
+		err = createContactWithUser(r, newContact, password)
+	}
+	if errors.Is(err, errEmailTaken) {
+		fmt.Printf("Email already exists: %s\n", newContact.Email)
+		where := ""
+		if err == errEmailInTrash {
+			where = " in the recycle bin"
+		}
 		w.Header().Set("Content-Type", "text/html")
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(w, `
@@ -1506,7 +1337,8 @@ func addContact(w http.ResponseWriter, r *http.Request) {
 					</div>
 					<h3 class="text-xl font-bold mb-4 text-red-600">Error</h3>
 					<div class="bg-red-50 border border-red-200 rounded-lg p-4 mb-4">
-						<p class="text-red-800">A contact with email <strong>%s</strong> already exists.</p>
+						<p class="text-red-800">A contact with email <strong>%s</strong> already exists%s.</p>
+						<p class="text-red-600 text-sm mt-2">Please use a different email address.</p>
 					</div>
 					<div class="flex justify-end">
 						<button hx-target="#contact-modal" hx-swap="outerHTML" hx-get="/modal/close"
@@ -1515,107 +1347,17 @@ func addContact(w http.ResponseWriter, r *http.Request) {
 						</button>
 					</div>
 				</div>
-			</div>`, template.HTMLEscapeString(email))
+			</div>`, template.HTMLEscapeString(newContact.Email), where)
 		return
 	}
-
-	// Generate ID for new contact
-	newID, err := genID()
 	if err != nil {
-		http.Error(w, "Failed to generate ID", http.StatusInternalServerError)
-		return
-	}
-
-	// Generate default password if not provided
-	if password == "" {
-		// Get last 3 characters of phone
-		phoneLast3 := ""
-		if len(phone) >= 3 {
-			phoneLast3 = phone[len(phone)-3:]
-		} else {
-			phoneLast3 = phone
-		}
-
-		// Get first letter of first name (uppercase)
-		firstNameFirstLetter := ""
-		if len(firstName) > 0 {
-			firstNameFirstLetter = strings.ToUpper(string(firstName[0]))
-		}
-
-		password = phoneLast3 + firstNameFirstLetter
-		fmt.Printf("Auto-generated password: %s (from phone: %s, first name: %s)\n", password, phone, firstName)
-	}
-
-	// Handle companyID for new contact
-	var companyIDPtr *string
-	if companyID != "" {
-		companyIDPtr = &companyID
-	}
-
-	newContact := &Contact{
-		ID:          newID,
-		ContactType: contactType,
-		FirstName:   firstName,
-		LastName:    lastName,
-		Email:       email,
-		Phone:       phone,
-		Password:    password,
-		CompanyID:   companyIDPtr,
-	}
-
-	if err := db.CreateContact(newContact); err != nil {
 		fmt.Printf("Error creating contact: %v\n", err)
-		// Handle unique constraint error gracefully
-		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
-			w.Header().Set("Content-Type", "text/html")
-			w.WriteHeader(http.StatusBadRequest)
-			fmt.Fprintf(w, `
-				<div class="fixed inset-0 bg-gray-600 bg-opacity-50 overflow-y-auto h-full w-full">
-					<div class="relative top-20 mx-auto p-5 border w-96 shadow-lg rounded-md bg-white">
-						<div class="flex justify-end">
-							<button hx-target="#contact-modal" hx-swap="outerHTML" hx-get="/modal/close" class="text-gray-400 hover:text-gray-600">&times;</button>
-						</div>
-						<h3 class="text-xl font-bold mb-4 text-red-600">Error</h3>
-						<div class="bg-red-50 border border-red-200 rounded-lg p-4 mb-4">
-							<p class="text-red-800">A contact with email <strong>%s</strong> already exists.</p>
-							<p class="text-red-600 text-sm mt-2">Please use a different email address.</p>
-						</div>
-						<div class="flex justify-end">
-							<button hx-target="#contact-modal" hx-swap="outerHTML" hx-get="/modal/close"
-									class="bg-gray-500 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-gray-600 transition-colors duration-300">
-								Close
-							</button>
-						</div>
-					</div>
-				</div>`, template.HTMLEscapeString(email))
-			return
-		}
 		http.Error(w, "Failed to create contact: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Create user account for this contact
-	user := &User{
-		Username:           email,
-		Password:           password,
-		ContactID:          &newID,
-		NeedPasswordChange: true,
-	}
-
-	// Check if user already exists
-	_, err = db.GetUser(email)
-	if err != nil {
-		// User doesn't exist, create new one
-		if err := db.CreateUser(user); err != nil {
-			fmt.Printf("Warning: Failed to create user account for contact: %v\n", err)
-		} else {
-			fmt.Printf("User account created for contact: %s\n", email)
-		}
-	} else {
-		fmt.Printf("Warning: User account already exists for email: %s\n", email)
-	}
-
 	fmt.Printf("New contact created: %s %s (ID: %s)\n", newContact.FirstName, newContact.LastName, newContact.ID)
+	recordAudit(r, AuditCreate, "contact", newContact.ID, nil, newContact)
 	renderCard(w, r, *newContact)
 }
 
@@ -1640,6 +1382,7 @@ func updateContact(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Contact not found", http.StatusNotFound)
 		return
 	}
+	before := *contact
 
 	// Update fields
 	contact.ContactType = r.FormValue("ContactType")
@@ -1647,65 +1390,46 @@ func updateContact(w http.ResponseWriter, r *http.Request) {
 	contact.LastName = r.FormValue("LastName")
 	contact.Email = r.FormValue("Email")
 	contact.Phone = r.FormValue("Phone")
+	contactDetailsFromForm(r, contact)
+	contact.Custom = customValuesFromForm(r, "contact", contact.Custom)
+	contactTagsFromForm(r, contact)
 	password := r.FormValue("Password")
 	companyID := r.FormValue("CompanyID")
 
-	// Only update password if provided
-	if password != "" {
-		contact.Password = password
-	}
-
 	if companyID == "" {
 		contact.CompanyID = nil
 	} else {
 		contact.CompanyID = &companyID
 	}
 
-	// Validate required fields
-	if contact.ContactType == "" || contact.FirstName == "" || contact.LastName == "" ||
-		contact.Email == "" || contact.Phone == "" {
-		http.Error(w, "All fields are required", http.StatusBadRequest)
+	if err := validateContact(contact); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	// Email validation
-	if !emailRegex.MatchString(contact.Email) {
-		http.Error(w, "Invalid email address format", http.StatusBadRequest)
+	if err := checkOwnContactEdit(r, &before, contact, password); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
 
 	fmt.Printf("Attempting to update contact %s\n", id)
 
-	// Update contact in database
-	if err := db.UpdateContact(contact); err != nil {
-		fmt.Println("Update error:", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	err = checkEmailAvailable(contact.Email, id)
+	if err == nil {
+		err = updateContactWithUser(contact, password)
 	}
-
-	// Update user account
-	user := &User{
-		Username:  contact.Email,
-		Password:  contact.Password,
-		ContactID: &id,
+	if errors.Is(err, errEmailTaken) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
 	}
-
-	existingUser, err := db.GetUser(contact.Email)
 	if err != nil {
-		// Create new user if doesn't exist
-		if err := db.CreateUser(user); err != nil {
-			fmt.Printf("Warning: Failed to create user account: %v\n", err)
-		}
-	} else {
-		// Update existing user
-		if existingUser.Password != user.Password {
-			if err := db.UpdateUserPassword(contact.Email, user.Password); err != nil {
-				fmt.Printf("Warning: Failed to update user password: %v\n", err)
-			}
-		}
+		fmt.Println("Update error:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	fmt.Printf("Successfully updated contact: %+v\n", contact)
+	fmt.Printf("Successfully updated contact: %s\n", contact.ID)
+	saveContactVersion(r, &before, contact, 0)
+	auditContactUpdate(r, &before, contact, password)
 	renderCard(w, r, *contact)
 }
 
@@ -1720,16 +1444,17 @@ func deleteContact(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Delete contact
+	// Move contact to the recycle bin
 	if err := db.DeleteContact(id); err != nil {
 		fmt.Println("Delete error:", err)
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	recordAudit(r, AuditDelete, "contact", id, contact, nil)
 
-	// Also delete associated user
-	if err := db.DeleteUser(contact.Email); err != nil {
-		fmt.Printf("Warning: Failed to delete user account: %v\n", err)
+	// Sign the associated user out, the account goes when the contact is purged
+	if err := db.RevokeContactCredentials(id); err != nil {
+		fmt.Printf("Warning: Failed to revoke user access: %v\n", err)
 	}
 
 	// Return empty content - HTMX will remove element
@@ -1737,55 +1462,15 @@ func deleteContact(w http.ResponseWriter, r *http.Request) {
 }
 
 func searchContacts(w http.ResponseWriter, r *http.Request) {
-	keyword := r.URL.Query().Get("q")
-	fmt.Printf("Search request received for keyword: '%s'\n", keyword)
-
-	w.Header().Set("Content-Type", "text/html")
-
-	var results []Contact
-	var err error
-
-	if keyword == "" {
-		fmt.Println("No keyword provided, returning all contacts")
-		results, err = db.GetAllContacts()
-	} else {
-		results, err = db.SearchContacts(keyword)
-	}
-
-	if err != nil {
-		http.Error(w, "Search failed: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	fmt.Printf("Found %d results for keyword '%s'\n", len(results), keyword)
-
-	if len(results) == 0 {
-		fmt.Fprintf(w, `<div class="no-results text-center p-8 text-gray-500">No contacts found for "%s"</div>`, template.HTMLEscapeString(keyword))
-		return
-	}
-
-	// SINGLE RENDER LOOP - FIXED
-	for _, c := range results {
-		isCurrentUser := isCurrentUserContact(c.Email, r)
-		data := struct {
-			Contact       Contact
-			IsCurrentUser bool
-		}{
-			Contact:       c,
-			IsCurrentUser: isCurrentUser,
-		}
-		if err := conCard.Execute(w, data); err != nil {
-			fmt.Printf("Error rendering contact %s: %v\n", c.ID, err)
-			continue
-		}
-	}
+	renderContactList(w, r)
 }
 
 // PW CHANGE HANDLER
 func changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	session, sessionErr := sessionFromRequest(r)
+
 	if r.Method == "GET" {
-		_, err := r.Cookie("password_change_user")
-		if err != nil {
+		if sessionErr != nil {
 			w.Header().Set("Content-Type", "text/html")
 			w.Write([]byte(`<div class="bg-white p-8 rounded-lg shadow-md w-full max-w-md">
                     <h2 class="text-2xl font-bold text-center text-gray-800 mb-6">Access Denied</h2>
@@ -1797,7 +1482,7 @@ func changePasswordHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		w.Header().Set("Content-Type", "text/html")
-		w.Write([]byte(changePasswordHTML))
+		changePasswordPage.Execute(w, struct{ CSRFToken string }{session.CSRFToken})
 		return
 	}
 
@@ -1810,13 +1495,19 @@ func changePasswordHandler(w http.ResponseWriter, r *http.Request) {
 		newPassword := r.FormValue("newPassword")
 		confirmPassword := r.FormValue("confirmPassword")
 
-		//Get username from cookie
-		cookie, err := r.Cookie("password_change_user")
-		if err != nil {
+		//Get username from session
+		if sessionErr != nil {
 			w.Write([]byte(`<div class="text-red-500">Session expired. Please login again.</div>`))
 			return
 		}
-		username := cookie.Value
+		username := session.Username
+
+		// this route is outside authRouter, so check the token here
+		if !validCSRF(withSession(r, session)) {
+			fmt.Printf("Warning: Rejected password change of %s: missing or invalid CSRF token\n", username)
+			w.Write([]byte(`<div class="text-red-500">This page is out of date. Reload it and try again.</div>`))
+			return
+		}
 
 		//Valudate password
 		if newPassword != confirmPassword {
@@ -1824,8 +1515,8 @@ func changePasswordHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if len(newPassword) < 6 {
-			w.Write([]byte(`<div class="text-red-500">Password must be at least 6 characters long.</div>`))
+		if len(newPassword) < minPasswordLength {
+			fmt.Fprintf(w, `<div class="text-red-500">Password must be at least %d characters long.</div>`, minPasswordLength)
 			return
 		}
 
@@ -1835,13 +1526,10 @@ func changePasswordHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		//clear cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:   "password_change_user",
-			Value:  "",
-			Path:   "/",
-			MaxAge: -1,
-		})
+		//sign out everywhere else now that the old password is gone
+		if _, err := db.DeleteUserSessions(username, session.ID); err != nil {
+			fmt.Printf("Warning: Failed to revoke other sessions: %v\n", err)
+		}
 
 		//Update contact pw if it's a contact user
 		user, err := db.GetUser(username)
@@ -1854,6 +1542,7 @@ func changePasswordHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		fmt.Printf("Password successfullt changed for user: %s\n", username)
+		recordAudit(r, AuditPasswordChange, "user", username, nil, nil)
 		w.Write([]byte(`<div class="text-green-500">Password updated succesfully! Redirecting...</div>
 			<script>setTimeout(() => window.location.href = "/", 2000)</script>`))
 		return
@@ -1871,12 +1560,19 @@ func addModal(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := struct {
-		Companies []Company
+		Contact      Contact
+		Companies    []Company
+		ContactTypes []ContactType
+		Custom       []customFieldValue
+		Tags         []tagOption
 	}{
-		Companies: companies,
+		Companies:    companies,
+		ContactTypes: loadContactTypes(),
+		Custom:       customFieldValues(loadCustomFields("contact"), nil),
+		Tags:         tagOptions(loadTags(), nil),
 	}
 
-	tmpl := template.Must(template.New("modal").Parse(addModalHTML))
+	tmpl := template.Must(template.New("modal").Funcs(contactDetailsFuncs).Parse(addModalHTML + contactDetailsHTML + customFieldsHTML + tagsHTML))
 	if err := tmpl.Execute(w, data); err != nil {
 		fmt.Printf("Template execution error: %v\n", err)
 		http.Error(w, "Failed to render modal", http.StatusInternalServerError)
@@ -1908,16 +1604,24 @@ func editModal(w http.ResponseWriter, r *http.Request) {
 		Contact      *Contact
 		Companies    []Company
 		CompanyIDStr string
+		Custom       []customFieldValue
+		Tags         []tagOption
+		ContactTypes []ContactType
+		EditLogin    bool // email and password, see checkOwnContactEdit
 	}{
 		Contact:      contact,
 		Companies:    companies,
 		CompanyIDStr: companyIDStr,
+		EditLogin:    hasPermission(r, PermEditContacts),
+		ContactTypes: loadContactTypes(),
+		Custom:       customFieldValues(loadCustomFields("contact"), contact.Custom),
+		Tags:         tagOptions(loadTags(), contact.Tags),
 	}
 
 	w.Header().Set("Content-Type", "text/html")
 
 	// Use a simpler template without pointer comparison issues
-	tmpl := template.Must(template.New("edit-modal").Parse(`
+	tmpl := template.Must(template.New("edit-modal").Funcs(contactDetailsFuncs).Parse(contactDetailsHTML + customFieldsHTML + tagsHTML + `
     <div id="contact-modal" class="fixed inset-0 bg-gray-600 bg-opacity-50 overflow-y-auto h-full w-full">
         <div class="relative top-20 mx-auto p-5 border w-96 shadow-lg rounded-md bg-white">
             <div class="flex justify-end">
@@ -1933,9 +1637,7 @@ func editModal(w http.ResponseWriter, r *http.Request) {
                 <div class="mb-4">
                     <label class="block text-gray-700 text-sm font-bold mb-2" for="contactType">Contact Type</label>
                     <select id="contactType" name="ContactType" class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
-                        <option value="Personal" {{if eq .Contact.ContactType "Personal"}}selected{{end}}>Personal</option>
-                        <option value="Work" {{if eq .Contact.ContactType "Work"}}selected{{end}}>Work</option>
-                        <option value="Family" {{if eq .Contact.ContactType "Family"}}selected{{end}}>Family</option>
+                        {{range .ContactTypes}}<option value="{{.Name}}" {{if eq .Name $.Contact.ContactType}}selected{{end}}>{{.Name}}</option>{{end}}
                     </select>
                 </div>
                 <div class="mb-4">
@@ -1948,12 +1650,13 @@ func editModal(w http.ResponseWriter, r *http.Request) {
                 </div>
                 <div class="mb-4">
                     <label class="block text-gray-700 text-sm font-bold mb-2" for="email">Email</label>
-                    <input class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline" id="email" name="Email" type="email" value="{{.Contact.Email}}" required>
+                    <input class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline{{if not .EditLogin}} bg-gray-100{{end}}" id="email" name="Email" type="email" value="{{.Contact.Email}}" required{{if not .EditLogin}} readonly{{end}}>
                 </div>
                 <div class="mb-4">
                     <label class="block text-gray-700 text-sm font-bold mb-2" for="phone">Phone</label>
                     <input class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline" id="phone" name="Phone" type="tel" value="{{.Contact.Phone}}" required>
                 </div>
+                {{template "contact-details" .Contact}}
                 <div class="mb-4">
                     <label class="block text-gray-700 text-sm font-bold mb-2" for="company">Company</label>
                     <select id="company" name="CompanyID" class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
@@ -1963,18 +1666,28 @@ func editModal(w http.ResponseWriter, r *http.Request) {
                         {{end}}
                     </select>
                 </div>
+                {{template "custom-fields" .Custom}}
+                {{template "contact-tags" .Tags}}
+                {{if .EditLogin}}
                 <div class="mb-4">
                     <label class="block text-gray-700 text-sm font-bold mb-2" for="password">Password</label>
                     <input class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline"
-                           id="password" name="Password" type="password" placeholder="Leave empty to keep current"
-                           value="{{if .Contact.Password}}{{.Contact.Password}}{{end}}">
+                           id="password" name="Password" type="password" placeholder="Leave empty to keep current">
                     <p class="text-xs text-gray-500 mt-1">Leave empty to keep current password</p>
                 </div>
+                {{end}}
                 <div class="flex items-center justify-end">
                     <button type="button" hx-target="#contact-modal" hx-swap="outerHTML" hx-get="/modal/close" class="bg-gray-500 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-gray-600 transition-colors duration-300 mr-2">Cancel</button>
                     <button type="submit" class="bg-blue-600 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-blue-700 transition-colors duration-300">Save Changes</button>
                 </div>
             </form>
+            <div class="mt-6 border-t pt-4">
+                <button type="button" class="text-sm text-blue-600 hover:text-blue-900"
+                        hx-get="/contacts/{{.Contact.ID}}/history"
+                        hx-target="#contact-history"
+                        hx-swap="innerHTML">Show history</button>
+                <div id="contact-history" class="mt-2 max-h-64 overflow-y-auto"></div>
+            </div>
         </div>
     </div>
     `))
@@ -2042,6 +1755,7 @@ func editCompanyModal(w http.ResponseWriter, r *http.Request) {
                            id="registrationDocument" name="registration_document" type="file" accept=".pdf,.jpg,.jpeg,.png">
                     <p class="text-xs text-gray-500 mt-1">Current: ` + getCurrentDocumentDisplay(company.RegistrationDocumentPath) + `</p>
                 </div>
+                ` + renderCustomTemplate("custom-fields", customFieldValues(loadCustomFields("company"), company.Custom)) + `
                 <div class="flex items-center justify-end">
                     <button type="button" hx-target="#company-modal" hx-swap="outerHTML" hx-get="/modal/close"
                             class="bg-gray-500 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-gray-600 transition-colors duration-300 mr-2">Cancel</button>
@@ -2068,46 +1782,61 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 	username := r.FormValue("username")
 	password := r.FormValue("password")
 
-	fmt.Printf("Login attempt: username=%s\n", username)
+	// throttle guessing before looking at the password, see login_limit.go
+	now := time.Now().UTC()
+	retryAt, locked, err := db.ReserveLoginAttempt(username, clientIP(r), now)
+	if err != nil {
+		fmt.Printf("Warning: Failed to check login throttling: %v\n", err)
+		http.Error(w, "Login is unavailable, please try again", http.StatusServiceUnavailable)
+		return
+	}
+	if now.Before(retryAt) {
+		fmt.Printf("Login throttled from %s\n", clientIP(r))
+		writeLoginThrottled(w, retryAt, now, locked)
+		return
+	}
 
 	user, err := db.GetUser(username)
 	if err != nil {
-		fmt.Printf("Login failed - user not found or error: %v\n", err)
-		w.Header().Set("Content-Type", "text/html")
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte(`<div id="login-message" class="mt-4 text-center text-red-500">Invalid username or password.</div>`))
+		// compare anyway, so unknown usernames take as long as wrong passwords
+		verifyPassword("", password)
+		loginFailed(w, r, username, "unknown user")
 		return
 	}
 
-	fmt.Printf("Found user: %s, needs password change: %t\n", user.Username, user.NeedPasswordChange)
+	ok, needsRehash := verifyPassword(user.Password, password)
+	if user.ContactID != nil && db.ContactDeleted(*user.ContactID) {
+		loginFailed(w, r, username, "contact is in the recycle bin")
+		return
+	}
 
-	if user.Password == password {
-		fmt.Printf("Login successful for user: %s\n", username)
+	if ok {
+		fmt.Printf("Login successful from %s\n", clientIP(r))
+		if err := db.ClearLoginFailures(username); err != nil {
+			fmt.Printf("Warning: Failed to reset failed logins: %v\n", err)
+		}
+		if err := db.ReleaseLoginAttempt(clientIP(r)); err != nil {
+			fmt.Printf("Warning: Failed to reset failed logins: %v\n", err)
+		}
 
-		//Set session cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:  "session",
-			Value: "authenticated",
-			Path:  "/",
-		})
+		if needsRehash {
+			if err := db.RehashUserPassword(user.Username, password); err != nil {
+				fmt.Printf("Warning: Failed to rehash password: %v\n", err)
+			}
+		}
 
-		// Set current_user cookie for session tracking
-		http.SetCookie(w, &http.Cookie{
-			Name:  "current_user",
-			Value: username,
-			Path:  "/",
-		})
+		//Create server-side session
+		token, session, err := db.CreateSession(user.Username, r)
+		if err != nil {
+			fmt.Printf("Login failed - could not create session: %v\n", err)
+			http.Error(w, "Failed to create session", http.StatusInternalServerError)
+			return
+		}
+		setSessionCookie(w, token, session.ExpiresAt)
 
 		//Check if need password change
 		if user.NeedPasswordChange {
-			fmt.Printf("User %s needs passowrd change\n", username)
-			//Cookie indicator password change needed
-			http.SetCookie(w, &http.Cookie{
-				Name:   "password_change_user",
-				Value:  username,
-				Path:   "/",
-				MaxAge: 300,
-			})
+			fmt.Println("Login requires a password change")
 			w.Header().Set("HX-Redirect", "/change-password")
 			w.WriteHeader(http.StatusOK)
 			w.Write([]byte("Login successful - password change required"))
@@ -2120,31 +1849,16 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fmt.Printf("Login failed - password mismatch for user: %s\n", username)
-	w.Header().Set("Content-Type", "text/html")
-	w.WriteHeader(http.StatusUnauthorized)
-	w.Write([]byte(`<div id="login-message" class="mt-4 text-center text-red-500">Invalid username or password.</div>`))
+	loginFailed(w, r, username, "wrong password")
 }
 
 func logoutHandler(w http.ResponseWriter, r *http.Request) {
-	http.SetCookie(w, &http.Cookie{
-		Name:   "session",
-		Value:  "",
-		Path:   "/",
-		MaxAge: -1,
-	})
-	http.SetCookie(w, &http.Cookie{
-		Name:   "current_user",
-		Value:  "",
-		Path:   "/",
-		MaxAge: -1,
-	})
-	http.SetCookie(w, &http.Cookie{
-		Name:   "password_change_user",
-		Value:  "",
-		Path:   "/",
-		MaxAge: -1,
-	})
+	if session, err := sessionFromRequest(r); err == nil {
+		if err := db.DeleteSession(session.ID); err != nil {
+			fmt.Printf("Warning: Failed to revoke session: %v\n", err)
+		}
+	}
+	clearSessionCookie(w)
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
@@ -2156,39 +1870,49 @@ func authMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// machine clients authenticate with a personal access token
+		if _, ok := bearerToken(r); ok {
+			token, user, err := authenticateBearer(r)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="afcb"`)
+				http.Error(w, "Unauthorized - "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, withUser(withAPIToken(r, token), user))
+			return
+		}
+
 		//check if user authenticated
-		sessionCookie, err := r.Cookie("session")
-		if err != nil || sessionCookie.Value != "authenticated" {
+		session, err := sessionFromRequest(r)
+		if err != nil {
+			clearSessionCookie(w)
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
 
 		//check if user need password change
-		if _, err := r.Cookie("password_change_user"); err == nil {
+		user, err := db.GetUser(session.Username)
+		if err != nil {
+			// user was deleted while still signed in
+			db.DeleteSession(session.ID)
+			clearSessionCookie(w)
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		if user.NeedPasswordChange {
 			http.Redirect(w, r, "/change-password", http.StatusSeeOther)
 			return
 		}
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, withUser(withSession(r, session), user))
 	})
 }
 
 // server companies page
 func companiesPageHandler(w http.ResponseWriter, r *http.Request) {
-	// Check if user is admin
-	isAdmin := false
-	currentUser, err := getCurrentUser(r)
-	if err == nil && currentUser == "af" {
-		isAdmin = true
-	}
-
-	data := struct {
-		IsAdmin bool
-	}{
-		IsAdmin: isAdmin,
-	}
+	data := newPageData(r)
 
 	// Try parsing from the current directory instead
-	tmpl, err := template.ParseFiles("./templates/companies.html")
+	tmpl, err := template.ParseFiles(templatePath("companies.html"))
 	if err != nil {
 		fmt.Printf("Template error: %v\n", err)
 		http.Error(w, "Template error", http.StatusInternalServerError)
@@ -2205,31 +1929,85 @@ func companiesPageHandler(w http.ResponseWriter, r *http.Request) {
 
 // get companies for table view
 func getCompaniesTable(w http.ResponseWriter, r *http.Request) {
+	renderCompanyTable(w, r)
+}
+
+// renderCompanyTable writes one page of company rows for /companies-table
+// and /search-companies, followed by a row with the page controls
+func renderCompanyTable(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 
-	companies, err := db.GetAllCompanies()
+	keyword := r.URL.Query().Get("q")
+	opts := listOptionsFromRequest(r, companySorts)
+	if r.URL.Query().Get("per_page") == "" {
+		opts.PerPage = 25
+	}
+
+	companies, total, err := db.ListCompanies(keyword, opts)
 	if err != nil {
 		http.Error(w, "Failed to fetch companies: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if len(companies) == 0 {
-		w.Write([]byte(`<tr><td colspan="6" class="px-6 py-4 text-center text-gray-500">No companies found</td></tr>`))
+	if total == 0 {
+		if keyword != "" {
+			fmt.Fprintf(w, `<tr><td colspan="6" class="px-6 py-4 text-center text-gray-500">No companies found for "%s"</td></tr>`, template.HTMLEscapeString(keyword))
+		} else {
+			w.Write([]byte(`<tr><td colspan="6" class="px-6 py-4 text-center text-gray-500">No companies found</td></tr>`))
+		}
 		return
 	}
 
+	fields := loadCustomFields("company")
 	for _, company := range companies {
-		// Format created date
-		createdDate := formatTimestamp(company.CreatedAt)
-		if createdDate == "" {
-			createdDate = "Unknown"
-		}
+		writeCompanyRow(w, company, fields)
+	}
 
-		fmt.Fprintf(w, `
+	pager := Pager{ListOptions: opts, Keyword: keyword, Total: total}
+	if err := pagerRow.Execute(w, pager); err != nil {
+		fmt.Printf("Error rendering pager: %v\n", err)
+	}
+}
+
+var pagerRow = template.Must(template.New("pager").Parse(`
+        <tr id="companies-pager">
+        <td colspan="6" class="px-6 py-3 bg-gray-50">
+            <div class="flex justify-between items-center text-sm text-gray-600">
+                <span>Showing {{.First}}-{{.Last}} of {{.Total}}</span>
+                <div class="space-x-2">
+                    {{if .HasPrev}}
+                    <button class="px-3 py-1 border rounded hover:bg-gray-100"
+                            hx-get="/companies-table?{{.PrevQuery}}"
+                            hx-target="#companies-table-body"
+                            hx-swap="innerHTML">Previous</button>
+                    {{end}}
+                    <span>Page {{.Page}}</span>
+                    {{if .HasNext}}
+                    <button class="px-3 py-1 border rounded hover:bg-gray-100"
+                            hx-get="/companies-table?{{.NextQuery}}"
+                            hx-target="#companies-table-body"
+                            hx-swap="innerHTML">Next</button>
+                    {{end}}
+                </div>
+            </div>
+        </td>
+        </tr>`))
+
+// writeCompanyRow writes one row of the companies table, with the values of
+// the given custom fields below the name
+func writeCompanyRow(w http.ResponseWriter, company Company, fields []CustomField) {
+	// Format created date
+	createdDate := formatTimestamp(company.CreatedAt)
+	if createdDate == "" {
+		createdDate = "Unknown"
+	}
+
+	fmt.Fprintf(w, `
         <tr id="company-row-%s">
         <td class="px-6 py-4 whitespace-nowrap">
             <div class="text-sm font-medium text-gray-900">%s</div>
             <div class="text-sm text-gray-500">ID: %s</div>
+            %s
         </td>
         <td class="px-6 py-4">
             <div class="text-sm text-gray-900"><strong>Bank:</strong> %s</div>
@@ -2266,7 +2044,7 @@ func getCompaniesTable(w http.ResponseWriter, r *http.Request) {
                     hx-delete="/companies/%s"
                     hx-target="#company-row-%s"
                     hx-swap="outerHTML"
-                    hx-confirm="Are you sure you want to delete this company?"
+                    hx-confirm="Move this company to the recycle bin?"
                     title="Delete">
                 <svg xmlns="http://www.w3.org/2000/svg" class="h-5 w-5" fill="none" viewBox="0 0 24 24" stroke="currentColor">
                     <path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M19 7l-.867 12.142A2 2 0 0116.138 21H7.862a2 2 0 01-1.995-1.858L5 7m5 4v6m4-6v6m1-10V4a1 1 0 00-1-1h-4a1 1 0 00-1 1v3M4 7h16"/>
@@ -2274,20 +2052,20 @@ func getCompaniesTable(w http.ResponseWriter, r *http.Request) {
             </button>
         </td>
         </tr>`,
-			company.ID,
-			template.HTMLEscapeString(company.Name),
-			company.ID,
-			template.HTMLEscapeString(company.BankName),
-			template.HTMLEscapeString(company.AccountNumber),
-			getDocumentLinkWithPreview(company.AccountDocumentPath, "Account Document"),
-			template.HTMLEscapeString(company.RegistrationNumber),
-			getDocumentLinkWithPreview(company.RegistrationDocumentPath, "Registration Document"),
-			getCreatedByDisplay(company.CreatedBy), // Created By column
-			createdDate,                            // Created Date column
-			company.ID,
-			company.ID,
-			company.ID)
-	}
+		company.ID,
+		template.HTMLEscapeString(company.Name),
+		company.ID,
+		renderCustomTemplate("custom-values", filledCustomValues(fields, company.Custom)),
+		template.HTMLEscapeString(company.BankName),
+		template.HTMLEscapeString(company.AccountNumber),
+		getDocumentLinkWithPreview(company.AccountDocumentPath, "Account Document"),
+		template.HTMLEscapeString(company.RegistrationNumber),
+		getDocumentLinkWithPreview(company.RegistrationDocumentPath, "Registration Document"),
+		getCreatedByDisplay(company.CreatedBy), // Created By column
+		createdDate,                            // Created Date column
+		company.ID,
+		company.ID,
+		company.ID)
 }
 
 // get document link
@@ -2335,49 +2113,69 @@ func generateContactPDFHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
+	cfg, args, err := loadConfig(os.Args[1:])
+	if err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	config = cfg
+	if err := setupLogging(config); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	mailer = newMailer(config)
+
+	if len(args) > 0 && args[0] == "migrate" {
+		os.Exit(runMigrateCommand(args[1:]))
+	}
+
 	// Initialize database
-	var err error
 	db, err = InitDB()
 	if err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
-	defer db.Close()
 
 	fmt.Println("Database initialized successfully")
 
+	if config.Mail.Driver == "log" {
+		fmt.Println("Warning: mail.driver is log, so invitations are only logged, without their links; set it to file or smtp to deliver them")
+	}
+
 	// Debug: users table
-	if err := db.DebugUserTable(); err != nil {
-		fmt.Printf("Debug error: %v\n", err)
+	if config.Log.Level == "debug" {
+		if err := db.DebugUserTable(); err != nil {
+			fmt.Printf("Debug error: %v\n", err)
+		}
 	}
 
+	// Store the E.164 form of phone numbers saved before it was tracked
+	runPhoneBackfill()
+
+	// Purge expired sessions now and every hour
+	startSessionCleanup(time.Hour)
+	startTrashPurge(time.Hour)
+
 	// Make sure the uploads directory exists
-	if err := os.MkdirAll("./uploads", 0755); err != nil {
+	if err := os.MkdirAll(config.Uploads.Dir, 0755); err != nil {
 		log.Printf("Warning: Could not create uploads directory: %v", err)
 	}
 
 	router := mux.NewRouter()
-
-	// Debug: List all users
-	rows, err := db.Query("SELECT username, password FROM users")
-	if err != nil {
-		fmt.Printf("Error querying users: %v\n", err)
-	} else {
-		defer rows.Close()
-		fmt.Println("Current users in database:")
-		for rows.Next() {
-			var username, password string
-			if err := rows.Scan(&username, &password); err != nil {
-				fmt.Printf("Error scanning user row: %v\n", err)
-				continue
-			}
-			fmt.Printf("  User: %s, Password: %s\n", username, password)
-		}
+	if config.TLSEnabled() && config.TLS.HSTSMaxAge > 0 {
+		router.Use(hstsMiddleware(config.TLS.HSTSMaxAge))
 	}
 
+	// Health checks for the container orchestrator, without login
+	router.HandleFunc("/healthz", healthzHandler).Methods("GET", "HEAD")
+	router.HandleFunc("/readyz", readyzHandler).Methods("GET", "HEAD")
+
 	// Serve login page
 	router.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "GET" {
-			http.ServeFile(w, r, "static/login.html")
+			http.ServeFile(w, r, staticPath("login.html"))
 		} else if r.Method == "POST" {
 			loginHandler(w, r)
 		}
@@ -2385,42 +2183,53 @@ func main() {
 
 	router.HandleFunc("/change-password", changePasswordHandler).Methods("GET", "POST")
 	router.HandleFunc("/logout", logoutHandler).Methods("GET")
+	router.HandleFunc("/invite/{token}", invitePageHandler).Methods("GET")
+	router.HandleFunc("/invite/{token}", acceptInviteHandler).Methods("POST")
+
+	// JSON API, registered before the catch-all HTML router
+	registerAPIRoutes(router)
 
 	// Create sub-router for all authenticated routes
 	authRouter := router.PathPrefix("/").Subrouter()
 	authRouter.Use(authMiddleware)
+	authRouter.Use(requireCSRF(csrfErrorHandler))
 
 	// authRouter.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 	// http.ServeFile(w, r, "static/index.html")
 	// })
 
-	authRouter.HandleFunc("/", indexHandler).Methods("GET")
+	authRouter.Handle("/", allow(PermViewContacts, indexHandler)).Methods("GET")
 
 	// Static file server
-	authRouter.PathPrefix("/static/").Handler(http.StripPrefix("/static", http.FileServer(http.Dir("./static"))))
-	authRouter.HandleFunc("/admin/license", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "static/license.html")
-	}).Methods("GET")
+	authRouter.PathPrefix("/static/").Handler(http.StripPrefix("/static", http.FileServer(http.Dir(config.StaticDir))))
+	authRouter.Handle("/admin/license", allow(PermManageLicense, licensePageHandler)).Methods("GET")
 
 	// Licensing
-	authRouter.HandleFunc("/admin/license", licenseAdminHandler).Methods("GET")
-	authRouter.HandleFunc("/admin/activate-license", activateLicenseHandler).Methods("GET", "POST")
-	authRouter.HandleFunc("/admin/license-content", licenseContentHandler).Methods("GET")
+	authRouter.Handle("/admin/license", allow(PermManageLicense, licenseAdminHandler)).Methods("GET")
+	authRouter.Handle("/admin/activate-license", allow(PermManageLicense, activateLicenseHandler)).Methods("GET", "POST")
+	authRouter.Handle("/admin/license-content", allow(PermManageLicense, licenseContentHandler)).Methods("GET")
 
 	// Contact API endpoints
-	authRouter.HandleFunc("/contacts", getContacts).Methods("GET")
-	authRouter.HandleFunc("/contacts", addContact).Methods("POST")
-	authRouter.HandleFunc("/contacts/{id}", updateContact).Methods("PUT", "PATCH")
-	authRouter.HandleFunc("/contacts/{id}", deleteContact).Methods("DELETE")
+	authRouter.Handle("/contacts", allow(PermViewContacts, getContacts)).Methods("GET")
+	authRouter.Handle("/contacts", allow(PermEditContacts, addContact)).Methods("POST")
+	authRouter.Handle("/contacts/export", allow(PermViewContacts, exportContactsHandler)).Methods("GET")
+	authRouter.Handle("/contacts/import", allow(PermEditContacts, importPageHandler)).Methods("GET")
+	authRouter.Handle("/contacts/import/upload", allow(PermEditContacts, importUploadHandler)).Methods("POST")
+	authRouter.Handle("/contacts/import/preview", allow(PermEditContacts, importPreviewHandler)).Methods("POST")
+	authRouter.Handle("/contacts/import/commit", allow(PermEditContacts, importCommitHandler)).Methods("POST")
+	authRouter.Handle("/contacts/{id}", allowContactEdit(updateContact)).Methods("PUT", "PATCH")
+	authRouter.Handle("/contacts/{id}", allow(PermEditContacts, deleteContact)).Methods("DELETE")
+	authRouter.Handle("/contacts/{id}/history", allowContactEdit(contactHistoryHandler)).Methods("GET")
+	authRouter.Handle("/contacts/{id}/versions/{version}/revert", allowContactEdit(revertContactHandler)).Methods("POST")
 
 	// Modal endpoints
-	authRouter.HandleFunc("/modal/add", addModal).Methods("GET")
-	authRouter.HandleFunc("/modal/edit/{id}", editModal).Methods("GET")
+	authRouter.Handle("/modal/add", allow(PermEditContacts, addModal)).Methods("GET")
+	authRouter.Handle("/modal/edit/{id}", allowContactEdit(editModal)).Methods("GET")
 	authRouter.HandleFunc("/modal/close", closeForm).Methods("GET")
 
 	// Companies page routes
-	authRouter.HandleFunc("/companies-page", companiesPageHandler).Methods("GET")
-	authRouter.HandleFunc("/companies-table", getCompaniesTable).Methods("GET")
+	authRouter.Handle("/companies-page", allow(PermViewCompanies, companiesPageHandler)).Methods("GET")
+	authRouter.Handle("/companies-table", allow(PermViewCompanies, getCompaniesTable)).Methods("GET")
 
 	// Add this in your main() function
 	authRouter.HandleFunc("/test-template", func(w http.ResponseWriter, r *http.Request) {
@@ -2443,31 +2252,113 @@ func main() {
 	})
 
 	// Company search endpoint
-	authRouter.HandleFunc("/search-companies", searchCompanies).Methods("GET")
+	authRouter.Handle("/search-companies", allow(PermViewCompanies, searchCompanies)).Methods("GET")
 
 	// Email validation endpoint
-	authRouter.HandleFunc("/check-email", checkEmail).Methods("GET")
+	authRouter.Handle("/check-email", allow(PermEditContacts, checkEmail)).Methods("GET")
 
 	// Company edit and delete routes
-	authRouter.HandleFunc("/companies/{id}", deleteCompany).Methods("DELETE")
-	authRouter.HandleFunc("/modal/edit-company/{id}", editCompanyModal).Methods("GET")
-	authRouter.HandleFunc("/companies/{id}", updateCompany).Methods("PUT")
+	authRouter.Handle("/companies/{id}", allow(PermManageCompanies, deleteCompany)).Methods("DELETE")
+	authRouter.Handle("/modal/edit-company/{id}", allow(PermManageCompanies, editCompanyModal)).Methods("GET")
+	authRouter.Handle("/companies/{id}", allow(PermManageCompanies, updateCompany)).Methods("PUT")
 
 	// File uploads serving
-	authRouter.PathPrefix("/uploads/").Handler(http.StripPrefix("/uploads", http.FileServer(http.Dir("./uploads"))))
+	authRouter.PathPrefix("/uploads/").Handler(allow(PermViewCompanies, http.StripPrefix("/uploads", http.FileServer(http.Dir(config.Uploads.Dir))).ServeHTTP))
 
-	authRouter.HandleFunc("/modal/add-company", addCompanyModal).Methods("GET")
-	authRouter.HandleFunc("/companies", addCompany).Methods("POST")
-	authRouter.HandleFunc("/companies", getCompanies).Methods("GET")
+	authRouter.Handle("/modal/add-company", allow(PermManageCompanies, addCompanyModal)).Methods("GET")
+	authRouter.Handle("/companies", allow(PermManageCompanies, addCompany)).Methods("POST")
+	authRouter.Handle("/companies", allow(PermViewCompanies, getCompanies)).Methods("GET")
 
 	// Search endpoint
-	authRouter.HandleFunc("/search", searchContacts).Methods("GET")
+	authRouter.Handle("/search", allow(PermViewContacts, searchContacts)).Methods("GET")
+
+	// Session management
+	authRouter.HandleFunc("/account/sessions", sessionsPageHandler).Methods("GET")
+	authRouter.HandleFunc("/account/sessions/revoke-others", revokeOtherSessionsHandler).Methods("POST")
+	authRouter.HandleFunc("/account/sessions/{id}", revokeSessionHandler).Methods("DELETE")
+	authRouter.HandleFunc("/account/tokens", apiTokensPageHandler).Methods("GET")
+	authRouter.HandleFunc("/account/tokens", createAPITokenHandler).Methods("POST")
+	authRouter.HandleFunc("/account/tokens/{id}", revokeAPITokenHandler).Methods("DELETE")
+	// User administration
+	authRouter.Handle("/admin/users", allow(PermManageUsers, usersPageHandler)).Methods("GET")
+	authRouter.Handle("/admin/users/{username}/role", allow(PermManageUsers, updateUserRoleHandler)).Methods("PUT")
+	authRouter.Handle("/admin/users/{username}/sessions", allow(PermManageUsers, revokeUserSessionsHandler)).Methods("DELETE")
+	authRouter.Handle("/admin/users/{username}/unlock", allow(PermManageUsers, unlockUserHandler)).Methods("POST")
+	authRouter.Handle("/admin/users/{username}/invite", allow(PermManageUsers, resendInviteHandler)).Methods("POST")
+	authRouter.Handle("/admin/users/{username}/invite", allow(PermManageUsers, revokeInviteHandler)).Methods("DELETE")
+
+	// Custom fields
+	authRouter.Handle("/admin/fields", allow(PermManageFields, customFieldsPageHandler)).Methods("GET")
+	authRouter.Handle("/admin/fields", allow(PermManageFields, createCustomFieldHandler)).Methods("POST")
+	authRouter.Handle("/admin/fields/{id}", allow(PermManageFields, deleteCustomFieldHandler)).Methods("DELETE")
+
+	// Tags and saved filters
+	authRouter.Handle("/admin/tags", allow(PermManageTags, tagsPageHandler)).Methods("GET")
+	authRouter.Handle("/admin/tags", allow(PermManageTags, createTagHandler)).Methods("POST")
+	authRouter.Handle("/admin/tags/{id}", allow(PermManageTags, updateTagHandler)).Methods("PUT")
+	authRouter.Handle("/admin/tags/{id}", allow(PermManageTags, deleteTagHandler)).Methods("DELETE")
+	authRouter.Handle("/admin/contact-types", allow(PermManageTypes, contactTypesPageHandler)).Methods("GET")
+	authRouter.Handle("/admin/contact-types", allow(PermManageTypes, createContactTypeHandler)).Methods("POST")
+	authRouter.Handle("/admin/contact-types/{id}", allow(PermManageTypes, updateContactTypeHandler)).Methods("PUT")
+	authRouter.Handle("/admin/contact-types/{id}/move", allow(PermManageTypes, moveContactTypeHandler)).Methods("POST")
+	authRouter.Handle("/admin/contact-types/{id}", allow(PermManageTypes, deleteContactTypeHandler)).Methods("DELETE")
+	authRouter.Handle("/admin/duplicates", allow(PermMergeContacts, duplicatesPageHandler)).Methods("GET")
+	authRouter.Handle("/admin/duplicates/{a}/{b}", allow(PermMergeContacts, mergeFormHandler)).Methods("GET")
+	authRouter.Handle("/admin/duplicates/{a}/{b}/merge", allow(PermMergeContacts, mergeContactsHandler)).Methods("POST")
+	authRouter.Handle("/admin/duplicates/{a}/{b}/dismiss", allow(PermMergeContacts, dismissDuplicateHandler)).Methods("POST")
+	authRouter.Handle("/sidebar", allow(PermViewContacts, sidebarHandler)).Methods("GET")
+	authRouter.Handle("/filters", allow(PermViewContacts, saveFilterHandler)).Methods("POST")
+	authRouter.Handle("/filters/{id}/pin", allow(PermViewContacts, pinFilterHandler)).Methods("POST")
+	authRouter.Handle("/filters/{id}", allow(PermViewContacts, deleteFilterHandler)).Methods("DELETE")
+
+	// Recycle bin
+	authRouter.Handle("/trash", allow(PermEditContacts, trashPageHandler)).Methods("GET")
+	authRouter.Handle("/trash/contacts/{id}/restore", allow(PermEditContacts, restoreContactHandler)).Methods("POST")
+	authRouter.Handle("/trash/contacts/{id}", allow(PermEditContacts, purgeContactHandler)).Methods("DELETE")
+	authRouter.Handle("/trash/companies/{id}/restore", allow(PermManageCompanies, restoreCompanyHandler)).Methods("POST")
+	authRouter.Handle("/trash/companies/{id}", allow(PermManageCompanies, purgeCompanyHandler)).Methods("DELETE")
+
+	// Audit log
+	authRouter.Handle("/admin/audit", allow(PermViewAudit, auditPageHandler)).Methods("GET")
+	authRouter.Handle("/admin/audit/events", allow(PermViewAudit, auditEventsHandler)).Methods("GET")
+	authRouter.Handle("/admin/audit/export", allow(PermViewAudit, auditExportHandler)).Methods("GET")
 
 	//PDF CC
-	authRouter.HandleFunc("/contacts/{id}/pdf", generateContactPDFHandler).Methods("GET")
+	authRouter.Handle("/contacts/{id}/pdf", allow(PermViewContacts, generateContactPDFHandler)).Methods("GET")
 
 	// Server start
-	fmt.Println("AFcb started at http://localhost:1330")
-	fmt.Println("Default admin login: af / afcb")
-	log.Fatal(http.ListenAndServe(":1330", router))
+	server := newHTTPServer(config.Listen, router)
+	servers := []*http.Server{server}
+	listen := func(s *http.Server) error { return s.ListenAndServe() }
+	if config.TLSEnabled() {
+		if config.TLS.SelfSigned {
+			if err := ensureSelfSignedCert(config.TLS.CertFile, config.TLS.KeyFile, selfSignedHosts(config.Listen)); err != nil {
+				log.Fatal("Failed to create the self-signed certificate:", err)
+			}
+		}
+		server.TLSConfig = newTLSConfig()
+		if config.TLS.RedirectFrom != "" {
+			servers = append(servers, newHTTPServer(config.TLS.RedirectFrom, httpsRedirectHandler(config.Listen)))
+			fmt.Printf("Redirecting http://%s to HTTPS\n", config.TLS.RedirectFrom)
+		}
+		listen = func(s *http.Server) error {
+			if s == server {
+				return s.ListenAndServeTLS(config.TLS.CertFile, config.TLS.KeyFile)
+			}
+			return s.ListenAndServe()
+		}
+	}
+
+	fmt.Printf("AFcb started at %s\n", config.URL())
+	serveErr := serve(servers, listen)
+	if !waitForMail(config.Timeouts.Shutdown) {
+		fmt.Println("Warning: Stopped before all queued mail was sent; resend the invitations from the users page")
+	}
+	if err := db.Close(); err != nil {
+		fmt.Printf("Warning: Failed to close the database: %v\n", err)
+	}
+	if serveErr != nil {
+		log.Fatal(serveErr)
+	}
+	fmt.Println("AFcb stopped")
 }
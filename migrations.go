@@ -0,0 +1,511 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// Migration is one numbered, forward-only schema change. Each migration runs
+// in its own transaction together with the schema_migrations bookkeeping, so a
+// failure leaves the database at the previous version.
+//
+// Migrations must never be edited or renumbered once released; add a new one
+// instead.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+}
+
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+var migrations = []Migration{
+	{1, "create_base_tables", execAll(
+		`CREATE TABLE IF NOT EXISTS companies (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			bank_name TEXT,
+			account_number TEXT,
+			account_document_path TEXT,
+			registration_number TEXT,
+			registration_document_path TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY,
+			username TEXT UNIQUE NOT NULL,
+			password TEXT NOT NULL,
+			contact_id TEXT,
+			needs_password_change BOOLEAN DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS contacts (
+			id TEXT PRIMARY KEY,
+			contact_type TEXT NOT NULL,
+			first_name TEXT NOT NULL,
+			last_name TEXT NOT NULL,
+			email TEXT UNIQUE NOT NULL,
+			phone TEXT NOT NULL,
+			password TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+	)},
+	{2, "add_contacts_company_id", addColumn("contacts", "company_id", "TEXT", nil)},
+	{3, "add_users_needs_password_change", addColumn("users", "needs_password_change", "BOOLEAN DEFAULT 1", nil)},
+	{4, "add_companies_created_by", addColumn("companies", "created_by", "TEXT", nil)},
+	{5, "create_sessions", execAll(
+		`CREATE TABLE IF NOT EXISTS sessions (
+			id TEXT PRIMARY KEY,
+			username TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			last_seen_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			ip_address TEXT NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_sessions_username ON sessions(username)`,
+	)},
+	// this used to bcrypt every plaintext password inside the migration,
+	// stalling startup on large tables; loginHandler now rehashes them on
+	// the first successful login instead
+	{6, "hash_legacy_passwords", func(*sql.Tx) error { return nil }},
+	// existing logins become self-service contacts except the built-in admin
+	{7, "add_users_role", addColumn("users", "role", "TEXT NOT NULL DEFAULT 'contact'",
+		execAll(`UPDATE users SET role = 'admin' WHERE username = 'af'`))},
+	{8, "index_contacts_company_id", execAll(
+		`CREATE INDEX IF NOT EXISTS idx_contacts_company_id ON contacts(company_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_users_contact_id ON users(contact_id)`,
+	)},
+	{9, "create_api_tokens", execAll(
+		`CREATE TABLE IF NOT EXISTS api_tokens (
+			id INTEGER PRIMARY KEY,
+			username TEXT NOT NULL,
+			name TEXT NOT NULL,
+			token_hash TEXT UNIQUE NOT NULL,
+			prefix TEXT NOT NULL,
+			scopes TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			last_used_at DATETIME,
+			expires_at DATETIME
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_api_tokens_username ON api_tokens(username)`,
+	)},
+	{10, "create_audit_events", execAll(
+		`CREATE TABLE IF NOT EXISTS audit_events (
+			id INTEGER PRIMARY KEY,
+			created_at DATETIME NOT NULL,
+			actor TEXT NOT NULL,
+			via TEXT NOT NULL,
+			action TEXT NOT NULL,
+			entity_type TEXT NOT NULL,
+			entity_id TEXT NOT NULL,
+			ip TEXT,
+			changes TEXT
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_events_entity ON audit_events(entity_type, entity_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events(actor)`,
+	)},
+	{11, "add_contacts_deleted_at", addColumn("contacts", "deleted_at", "DATETIME", nil)},
+	{12, "add_companies_deleted_at", addColumn("companies", "deleted_at", "DATETIME", nil)},
+	{13, "create_contact_versions", execAll(
+		`CREATE TABLE IF NOT EXISTS contact_versions (
+			id INTEGER PRIMARY KEY,
+			contact_id TEXT NOT NULL,
+			version INTEGER NOT NULL,
+			created_at DATETIME NOT NULL,
+			author TEXT NOT NULL,
+			reverted_from INTEGER,
+			contact_type TEXT NOT NULL,
+			first_name TEXT NOT NULL,
+			last_name TEXT NOT NULL,
+			email TEXT NOT NULL,
+			phone TEXT NOT NULL,
+			company_id TEXT,
+			UNIQUE(contact_id, version)
+		)`,
+	)},
+	{14, "create_contact_details", execAll(
+		`CREATE TABLE IF NOT EXISTS contact_emails (
+			id INTEGER PRIMARY KEY,
+			contact_id TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			label TEXT NOT NULL,
+			email TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_contact_emails_contact_id ON contact_emails(contact_id)`,
+		`CREATE TABLE IF NOT EXISTS contact_phones (
+			id INTEGER PRIMARY KEY,
+			contact_id TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			label TEXT NOT NULL,
+			phone TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_contact_phones_contact_id ON contact_phones(contact_id)`,
+		`CREATE TABLE IF NOT EXISTS contact_addresses (
+			id INTEGER PRIMARY KEY,
+			contact_id TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			label TEXT NOT NULL,
+			street TEXT NOT NULL DEFAULT '',
+			city TEXT NOT NULL DEFAULT '',
+			region TEXT NOT NULL DEFAULT '',
+			postal_code TEXT NOT NULL DEFAULT '',
+			country TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_contact_addresses_contact_id ON contact_addresses(contact_id)`,
+	)},
+	{15, "create_custom_fields", execAll(
+		`CREATE TABLE IF NOT EXISTS custom_fields (
+			id INTEGER PRIMARY KEY,
+			entity_type TEXT NOT NULL,
+			name TEXT NOT NULL,
+			label TEXT NOT NULL,
+			field_type TEXT NOT NULL,
+			options TEXT NOT NULL DEFAULT '',
+			position INTEGER NOT NULL,
+			created_at DATETIME NOT NULL,
+			UNIQUE(entity_type, name)
+		)`,
+		`CREATE TABLE IF NOT EXISTS custom_field_values (
+			field_id INTEGER NOT NULL,
+			entity_type TEXT NOT NULL,
+			entity_id TEXT NOT NULL,
+			value TEXT NOT NULL,
+			PRIMARY KEY (field_id, entity_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_custom_field_values_entity ON custom_field_values(entity_type, entity_id)`,
+	)},
+	{16, "create_tags_and_saved_filters", execAll(
+		`CREATE TABLE IF NOT EXISTS tags (
+			id INTEGER PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+			color TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS contact_tags (
+			contact_id TEXT NOT NULL,
+			tag_id INTEGER NOT NULL,
+			PRIMARY KEY (contact_id, tag_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_contact_tags_tag_id ON contact_tags(tag_id)`,
+		`CREATE TABLE IF NOT EXISTS saved_filters (
+			id INTEGER PRIMARY KEY,
+			username TEXT NOT NULL,
+			name TEXT NOT NULL,
+			query TEXT NOT NULL,
+			pinned BOOLEAN NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL,
+			UNIQUE(username, name)
+		)`,
+	)},
+	{17, "create_contact_types", createContactTypes},
+	{18, "add_contact_versions_merged_from", addColumn("contact_versions", "merged_from", "TEXT", nil)},
+	{19, "create_duplicate_dismissals", execAll(
+		`CREATE TABLE IF NOT EXISTS duplicate_dismissals (
+			contact_a TEXT NOT NULL,
+			contact_b TEXT NOT NULL,
+			dismissed_by TEXT NOT NULL,
+			dismissed_at DATETIME NOT NULL,
+			PRIMARY KEY (contact_a, contact_b)
+		)`,
+	)},
+	{20, "add_contacts_phone_e164", addColumn("contacts", "phone_e164", "TEXT", nil)},
+	{21, "add_contact_phones_phone_e164", addColumn("contact_phones", "phone_e164", "TEXT", nil)},
+	{22, "add_sessions_csrf_token", addColumn("sessions", "csrf_token", "TEXT NOT NULL DEFAULT ''", func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE sessions SET csrf_token = lower(hex(randomblob(32)))")
+		return err
+	})},
+	{23, "create_login_failures", execAll(
+		`CREATE TABLE IF NOT EXISTS login_failures (
+			key TEXT PRIMARY KEY,
+			failures INTEGER NOT NULL,
+			last_failure_at DATETIME NOT NULL,
+			locked_until DATETIME
+		)`,
+	)},
+	{24, "create_invites", execAll(
+		`CREATE TABLE IF NOT EXISTS invites (
+			id INTEGER PRIMARY KEY,
+			token_hash TEXT NOT NULL UNIQUE,
+			username TEXT NOT NULL,
+			created_by TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			used_at DATETIME
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_invites_username ON invites(username)`,
+	)},
+}
+
+// execAll returns a migration step running each statement in order
+func execAll(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// addColumn adds a column unless it already exists, which is the case for
+// databases created before migrations were tracked. backfill, if given, only
+// runs when the column was actually added.
+func addColumn(table, column, definition string, backfill func(tx *sql.Tx) error) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		exists, err := columnExists(tx, table, column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+			return err
+		}
+		if backfill != nil {
+			return backfill(tx)
+		}
+		return nil
+	}
+}
+
+func columnExists(tx *sql.Tx, table, column string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notnull, pk int
+		var name, ctype string
+		var dfltValue interface{}
+		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// createContactTypes creates contact_types with the types the forms used to
+// offer and maps the free-text types of existing contacts onto it: values
+// differing only in case or spacing take the stored spelling, anything else
+// becomes a new type. Contacts without a type get the first one.
+func createContactTypes(tx *sql.Tx) error {
+	err := execAll(
+		`CREATE TABLE IF NOT EXISTS contact_types (
+			id INTEGER PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+			color TEXT NOT NULL,
+			position INTEGER NOT NULL
+		)`,
+		`INSERT OR IGNORE INTO contact_types (name, color, position) VALUES
+			('Personal', 'blue', 1), ('Work', 'green', 2), ('Family', 'purple', 3)`,
+		`UPDATE contacts SET contact_type = TRIM(contact_type) WHERE contact_type != TRIM(contact_type)`,
+		`UPDATE contacts SET contact_type = 'Personal' WHERE contact_type = ''`,
+	)(tx)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT DISTINCT contact_type FROM contacts
+		WHERE contact_type COLLATE BINARY NOT IN (SELECT name FROM contact_types)`)
+	if err != nil {
+		return err
+	}
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			rows.Close()
+			return err
+		}
+		values = append(values, value)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, value := range values {
+		var name string
+		err := tx.QueryRow("SELECT name FROM contact_types WHERE name = ?", value).Scan(&name)
+		if err == sql.ErrNoRows {
+			_, err = tx.Exec(`INSERT INTO contact_types (name, color, position)
+				VALUES (?, 'gray', (SELECT MAX(position) + 1 FROM contact_types))`, value)
+			name = value
+			if err == nil {
+				log.Printf("Added contact type %q used by existing contacts", value)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if name != value {
+			if _, err := tx.Exec("UPDATE contacts SET contact_type = ? WHERE contact_type = ?", name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func ensureMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME NOT NULL
+	)`)
+	return err
+}
+
+// migrationStatus lists every known migration and when it was applied
+func migrationStatus(db *sql.DB) ([]MigrationStatus, error) {
+	if err := ensureMigrationsTable(db); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Migration: m}
+		if at, ok := applied[m.Version]; ok {
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// migrateUp applies pending migrations up to and including target; a target
+// of 0 means the latest version. It returns the number applied.
+func migrateUp(db *sql.DB, target int) (int, error) {
+	statuses, err := migrationStatus(db)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read migration status: %v", err)
+	}
+
+	applied := 0
+	for _, status := range statuses {
+		m := status.Migration
+		if target > 0 && m.Version > target {
+			break
+		}
+		if status.AppliedAt != nil {
+			continue
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return applied, err
+		}
+		if err := m.Up(tx); err != nil {
+			tx.Rollback()
+			return applied, fmt.Errorf("migration %d (%s) failed: %v", m.Version, m.Name, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+			m.Version, m.Name, time.Now().UTC()); err != nil {
+			tx.Rollback()
+			return applied, fmt.Errorf("migration %d (%s) failed: %v", m.Version, m.Name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return applied, fmt.Errorf("migration %d (%s) failed to commit: %v", m.Version, m.Name, err)
+		}
+
+		log.Printf("Applied migration %d: %s", m.Version, m.Name)
+		applied++
+	}
+	return applied, nil
+}
+
+// runMigrateCommand implements `afcb migrate [status|up [version]]`
+func runMigrateCommand(args []string) int {
+	conn, err := openDB()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer conn.Close()
+
+	cmd := "status"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	switch cmd {
+	case "status":
+		statuses, err := migrationStatus(conn)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+		pending := 0
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+			} else {
+				pending++
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		tw.Flush()
+		fmt.Printf("\n%d pending migration(s)\n", pending)
+
+	case "up":
+		target := 0
+		if len(args) > 1 {
+			target, err = strconv.Atoi(args[1])
+			if err != nil || target < 1 {
+				fmt.Fprintf(os.Stderr, "invalid target version: %s\n", args[1])
+				return 2
+			}
+		}
+		n, err := migrateUp(conn, target)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("Applied %d migration(s)\n", n)
+
+	default:
+		fmt.Fprintln(os.Stderr, "usage: afcb migrate [status | up [version]]")
+		return 2
+	}
+	return 0
+}
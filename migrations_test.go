@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// useTestDB migrates a fresh database with its search index and makes it the
+// global db until the test ends
+func useTestDB(t *testing.T) *DB {
+	t.Helper()
+	conn := openTestDB(t)
+	if _, err := migrateUp(conn, 0); err != nil {
+		t.Fatalf("migrateUp failed: %v", err)
+	}
+	searchIndex, err := initSearchIndex(conn)
+	if err != nil {
+		t.Fatalf("initSearchIndex failed: %v", err)
+	}
+	testDB := &DB{DB: conn, searchIndex: searchIndex}
+	saved := db
+	db = testDB
+	t.Cleanup(func() { db = saved })
+	return testDB
+}
+
+func TestMigrateUpFreshDatabase(t *testing.T) {
+	conn := openTestDB(t)
+
+	n, err := migrateUp(conn, 0)
+	if err != nil {
+		t.Fatalf("migrateUp failed: %v", err)
+	}
+	if n != len(migrations) {
+		t.Errorf("Applied %d migrations, want %d", n, len(migrations))
+	}
+
+	// Running again must be a no-op
+	n, err = migrateUp(conn, 0)
+	if err != nil {
+		t.Fatalf("Second migrateUp failed: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("Second run applied %d migrations, want 0", n)
+	}
+}
+
+func TestMigrateUpToTarget(t *testing.T) {
+	conn := openTestDB(t)
+
+	if _, err := migrateUp(conn, 3); err != nil {
+		t.Fatalf("migrateUp failed: %v", err)
+	}
+
+	statuses, err := migrationStatus(conn)
+	if err != nil {
+		t.Fatalf("migrationStatus failed: %v", err)
+	}
+	for _, s := range statuses {
+		if applied := s.AppliedAt != nil; applied != (s.Version <= 3) {
+			t.Errorf("Migration %d applied = %t", s.Version, applied)
+		}
+	}
+}
+
+// Databases created before schema_migrations existed already have some of
+// the columns; the migrations must adopt them instead of failing.
+func TestMigrateUpLegacyDatabase(t *testing.T) {
+	conn := openTestDB(t)
+
+	legacy := []string{
+		`CREATE TABLE companies (id TEXT PRIMARY KEY, name TEXT NOT NULL, bank_name TEXT, account_number TEXT,
+			account_document_path TEXT, registration_number TEXT, registration_document_path TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP, created_by TEXT)`,
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, username TEXT UNIQUE NOT NULL, password TEXT NOT NULL,
+			contact_id TEXT, needs_password_change BOOLEAN DEFAULT 1, created_at DATETIME DEFAULT CURRENT_TIMESTAMP)`,
+		`CREATE TABLE contacts (id TEXT PRIMARY KEY, contact_type TEXT NOT NULL, first_name TEXT NOT NULL,
+			last_name TEXT NOT NULL, email TEXT UNIQUE NOT NULL, phone TEXT NOT NULL, password TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP, updated_at DATETIME DEFAULT CURRENT_TIMESTAMP, company_id TEXT)`,
+		`INSERT INTO contacts (id, contact_type, first_name, last_name, email, phone, password)
+			VALUES ('c1', 'Work', 'Jo', 'Smith', 'jo@example.com', '0123', '123J')`,
+		`INSERT INTO users (username, password, contact_id, needs_password_change)
+			VALUES ('af', 'afcb', NULL, 0), ('jo@example.com', '123J', 'c1', 1)`,
+	}
+	for _, stmt := range legacy {
+		if _, err := conn.Exec(stmt); err != nil {
+			t.Fatalf("Failed to set up legacy schema: %v", err)
+		}
+	}
+
+	if _, err := migrateUp(conn, 0); err != nil {
+		t.Fatalf("migrateUp failed: %v", err)
+	}
+
+	rows, err := conn.Query("SELECT username, password, role FROM users")
+	if err != nil {
+		t.Fatalf("Failed to query users: %v", err)
+	}
+	defer rows.Close()
+
+	wantRoles := map[string]Role{"af": RoleAdmin, "jo@example.com": RoleContact}
+	// plaintext passwords are left for the first login to rehash
+	wantPasswords := map[string]string{"af": "afcb", "jo@example.com": "123J"}
+	for rows.Next() {
+		var username, password string
+		var role Role
+		if err := rows.Scan(&username, &password, &role); err != nil {
+			t.Fatalf("Failed to scan user: %v", err)
+		}
+		if ok, rehash := verifyPassword(password, wantPasswords[username]); !ok || !rehash {
+			t.Errorf("Password of %s = %q, want the legacy one", username, password)
+		}
+		if role != wantRoles[username] {
+			t.Errorf("Role of %s = %q, want %q", username, role, wantRoles[username])
+		}
+	}
+	rows.Close()
+
+	testDB := &DB{DB: conn}
+	if err := testDB.RehashUserPassword("jo@example.com", "123J"); err != nil {
+		t.Fatalf("RehashUserPassword failed: %v", err)
+	}
+	var userPassword, contactPassword string
+	conn.QueryRow("SELECT password FROM users WHERE username = 'jo@example.com'").Scan(&userPassword)
+	conn.QueryRow("SELECT password FROM contacts WHERE id = 'c1'").Scan(&contactPassword)
+	if !isPasswordHash(userPassword) || userPassword != contactPassword {
+		t.Errorf("after the first login user password = %q, contact password = %q; want the same hash", userPassword, contactPassword)
+	}
+}
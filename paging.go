@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	defaultPerPage = 30
+	maxPerPage     = 100
+)
+
+// ListOptions selects one page of a listing. PerPage 0 means no limit.
+type ListOptions struct {
+	Sort    string
+	Desc    bool
+	Page    int
+	PerPage int
+}
+
+// sortSpec is an ORDER BY clause with %[1]s where the direction goes, so
+// NULL handling and tie breakers can stay fixed
+type sortSpec string
+
+var contactSorts = map[string]sortSpec{
+	"name":    "c.first_name COLLATE NOCASE %[1]s, c.last_name COLLATE NOCASE %[1]s",
+	"company": "comp.name IS NULL, comp.name COLLATE NOCASE %[1]s, c.first_name COLLATE NOCASE, c.last_name COLLATE NOCASE",
+	"created": "c.created_at %[1]s",
+}
+
+var companySorts = map[string]sortSpec{
+	"name":    "c.name COLLATE NOCASE %[1]s",
+	"created": "c.created_at %[1]s",
+}
+
+// listOptionsFromRequest reads sort, dir, page and per_page from the query
+// string, falling back to safe defaults for anything unknown
+func listOptionsFromRequest(r *http.Request, sorts map[string]sortSpec) ListOptions {
+	q := r.URL.Query()
+	opts := ListOptions{Page: 1, PerPage: defaultPerPage}
+
+	if _, ok := sorts[q.Get("sort")]; ok {
+		opts.Sort = q.Get("sort")
+	}
+	opts.Desc = q.Get("dir") == "desc"
+	if page, err := strconv.Atoi(q.Get("page")); err == nil && page > 0 {
+		opts.Page = page
+	}
+	if perPage, err := strconv.Atoi(q.Get("per_page")); err == nil && perPage > 0 {
+		opts.PerPage = min(perPage, maxPerPage)
+	}
+	return opts
+}
+
+// orderBy renders the ORDER BY clause. Without an explicit sort, ranked
+// search results keep their relevance order and everything else is sorted
+// by name. The id tie breaker keeps pages stable.
+func (o ListOptions) orderBy(sorts map[string]sortSpec, rank string) string {
+	dir := "ASC"
+	if o.Desc {
+		dir = "DESC"
+	}
+
+	var order string
+	switch {
+	case o.Sort != "":
+		order = fmt.Sprintf(string(sorts[o.Sort]), dir)
+	case rank != "":
+		order = rank
+	default:
+		order = fmt.Sprintf(string(sorts["name"]), dir)
+	}
+	return order + ", c.id"
+}
+
+func (o ListOptions) limitClause() string {
+	if o.PerPage <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" LIMIT %d OFFSET %d", o.PerPage, (o.Page-1)*o.PerPage)
+}
+
+// Pager describes where a rendered page sits in the full result set
+type Pager struct {
+	ListOptions
+	Keyword string
+	Total   int
+}
+
+func (p Pager) First() int {
+	if p.Total == 0 {
+		return 0
+	}
+	return (p.Page-1)*p.PerPage + 1
+}
+
+func (p Pager) Last() int {
+	return min(p.Page*p.PerPage, p.Total)
+}
+
+func (p Pager) HasPrev() bool {
+	return p.Page > 1
+}
+
+func (p Pager) HasNext() bool {
+	return p.PerPage > 0 && p.Page*p.PerPage < p.Total
+}
+
+// Query returns the query string for another page of the same listing
+func (p Pager) Query(page int) string {
+	v := url.Values{}
+	if p.Keyword != "" {
+		v.Set("q", p.Keyword)
+	}
+	if p.Sort != "" {
+		v.Set("sort", p.Sort)
+	}
+	if p.Desc {
+		v.Set("dir", "desc")
+	}
+	if p.PerPage != defaultPerPage {
+		v.Set("per_page", strconv.Itoa(p.PerPage))
+	}
+	v.Set("page", strconv.Itoa(page))
+	return v.Encode()
+}
+
+func (p Pager) NextQuery() string {
+	return p.Query(p.Page + 1)
+}
+
+func (p Pager) PrevQuery() string {
+	return p.Query(p.Page - 1)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const passwordHashCost = bcrypt.DefaultCost
+
+// minPasswordLength applies to passwords users choose themselves
+const minPasswordLength = 6
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordHashCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// isPasswordHash reports whether a stored password is a bcrypt hash rather
+// than a legacy plaintext value
+func isPasswordHash(stored string) bool {
+	if !strings.HasPrefix(stored, "$2a$") && !strings.HasPrefix(stored, "$2b$") && !strings.HasPrefix(stored, "$2y$") {
+		return false
+	}
+	_, err := bcrypt.Cost([]byte(stored))
+	return err == nil
+}
+
+// dummyPasswordHash stands in for a missing password, so rejecting a login
+// takes as long whether or not the account has one
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("no password"), passwordHashCost)
+
+// verifyPassword checks a login attempt against the stored value. Legacy
+// plaintext rows are still accepted; needsRehash tells the caller to replace
+// them (or hashes made with an outdated cost) after a successful login. An
+// empty stored value never matches: accounts waiting for an invitation have
+// no password yet, and unknown users are checked against "".
+func verifyPassword(stored, password string) (ok bool, needsRehash bool) {
+	if stored == "" {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return false, false
+	}
+	if !isPasswordHash(stored) {
+		ok = subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+		return ok, ok
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)); err != nil {
+		return false, false
+	}
+	cost, err := bcrypt.Cost([]byte(stored))
+	return true, err != nil || cost != passwordHashCost
+}
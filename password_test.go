@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestPasswordHashing(t *testing.T) {
+	hash, err := hashPassword("s3cret!")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	if !isPasswordHash(hash) {
+		t.Errorf("Expected %q to be recognised as a hash", hash)
+	}
+
+	if ok, rehash := verifyPassword(hash, "s3cret!"); !ok || rehash {
+		t.Errorf("verifyPassword(hash, correct) = %t, %t; want true, false", ok, rehash)
+	}
+	if ok, _ := verifyPassword(hash, "wrong"); ok {
+		t.Error("Expected wrong password to be rejected")
+	}
+}
+
+func TestLegacyPlaintextPasswords(t *testing.T) {
+	if isPasswordHash("afcb") {
+		t.Error("Plaintext password must not be treated as a hash")
+	}
+
+	if ok, rehash := verifyPassword("afcb", "afcb"); !ok || !rehash {
+		t.Errorf("verifyPassword(plain, correct) = %t, %t; want true, true", ok, rehash)
+	}
+	if ok, _ := verifyPassword("afcb", "nope"); ok {
+		t.Error("Expected wrong plaintext password to be rejected")
+	}
+	if ok, _ := verifyPassword("", ""); ok {
+		t.Error("Expected empty stored password to never match")
+	}
+}
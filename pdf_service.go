@@ -158,7 +158,28 @@ func (p *PDFService) GenerateContactCardPDF(contact *Contact, companyName string
 	pdf.Cell(18, 7, "Phone:")
 	pdf.SetFont("Arial", "", 11)
 	pdf.Cell(0, 7, contact.Phone)
-	pdf.Ln(15)
+	pdf.Ln(8)
+
+	// Further emails, phones and addresses, labeled
+	others := make([][2]string, 0, len(contact.Emails)+len(contact.Phones)+len(contact.Addresses))
+	for _, e := range contact.Emails {
+		others = append(others, [2]string{e.Label, e.Email})
+	}
+	for _, p := range contact.Phones {
+		others = append(others, [2]string{p.Label, p.Phone})
+	}
+	for _, a := range contact.Addresses {
+		others = append(others, [2]string{a.Label, a.String()})
+	}
+	for _, o := range others {
+		pdf.SetX(25)
+		pdf.SetFont("Arial", "B", 11)
+		pdf.Cell(18, 7, strings.ToUpper(o[0][:1])+o[0][1:]+":")
+		pdf.SetFont("Arial", "", 11)
+		pdf.Cell(0, 7, o[1])
+		pdf.Ln(8)
+	}
+	pdf.Ln(7)
 
 	var buf bytes.Buffer
 	err = pdf.Output(&buf)
@@ -182,35 +203,5 @@ func (p *PDFService) generateVCardQRCode(contact *Contact, companyName string) (
 }
 
 func (p *PDFService) generateVCardContent(contact *Contact, companyName string) string {
-	var vcard strings.Builder
-
-	vcard.WriteString("BEGIN:VCARD\n")
-	vcard.WriteString("VERSION:3.0\n")
-	vcard.WriteString(fmt.Sprintf("FN:%s %s\n", contact.FirstName, contact.LastName))
-	vcard.WriteString(fmt.Sprintf("N:%s;%s;;;\n", contact.LastName, contact.FirstName))
-
-	if contact.Email != "" {
-		vcard.WriteString(fmt.Sprintf("EMAIL:%s\n", contact.Email))
-	}
-
-	if contact.Phone != "" {
-		cleanPhone := strings.Map(func(r rune) rune {
-			if r >= '0' && r <= '9' || r == '+' {
-				return r
-			}
-			return -1
-		}, contact.Phone)
-		vcard.WriteString(fmt.Sprintf("TEL:%s\n", cleanPhone))
-	}
-
-	if companyName != "" {
-		vcard.WriteString(fmt.Sprintf("ORG:%s\n", companyName))
-	}
-
-	// Timestamp
-	vcard.WriteString(fmt.Sprintf("REV:%s\n", time.Now().Format("20060102T150405Z")))
-
-	vcard.WriteString("END:VCARD")
-
-	return vcard.String()
+	return formatVCard(contact, companyName, time.Now())
 }
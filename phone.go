@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/nyaruka/phonenumbers"
+)
+
+// Phone numbers. Contacts keep the number as typed for display and its
+// E.164 form ("+442079460958") for links, vCards and matching. Numbers
+// written without a country code are read in phone.default_country, an ISO
+// 3166 code.
+
+// normalizePhone returns phone in E.164 form. Numbers starting with + or 00
+// are international; others are national numbers of country, with or
+// without its trunk prefix. Both must be valid numbers of their country,
+// which phonenumbers checks down to the area code.
+func normalizePhone(phone, country string) (string, error) {
+	phone = strings.TrimSpace(phone)
+
+	var digits strings.Builder
+	for i, r := range phone {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+		case strings.ContainsRune(" -./()", r):
+		default:
+			return "", fmt.Errorf("%q is not allowed in a phone number", r)
+		}
+	}
+	if digits.Len() == 0 {
+		return "", fmt.Errorf("phone number has no digits")
+	}
+	// 00 dials abroad in most countries, but not in the default one everywhere
+	if rest, ok := strings.CutPrefix(phone, "00"); ok {
+		phone = "+" + rest
+	}
+
+	if strings.HasPrefix(phone, "+") {
+		number, err := phonenumbers.Parse(phone, "")
+		if err != nil {
+			return "", fmt.Errorf("unknown country code")
+		}
+		if !phonenumbers.IsValidNumber(number) {
+			return "", fmt.Errorf("not a valid number for country code +%d", number.GetCountryCode())
+		}
+		return phonenumbers.Format(number, phonenumbers.E164), nil
+	}
+
+	if !validPhoneCountry(country) {
+		return "", fmt.Errorf("add the country code")
+	}
+	number, err := phonenumbers.Parse(phone, country)
+	if err != nil || !phonenumbers.IsValidNumberForRegion(number, country) {
+		return "", fmt.Errorf("not a valid %s number, or add the country code", country)
+	}
+	return phonenumbers.Format(number, phonenumbers.E164), nil
+}
+
+// validPhoneCountry reports whether code is an ISO 3166 code phone numbers
+// can be read in
+func validPhoneCountry(code string) bool {
+	return phonenumbers.GetCountryCodeForRegion(code) != 0
+}
+
+// validatePhone normalizes a phone of a contact form, reporting problems on
+// field
+func validatePhone(field, phone string) (string, error) {
+	e164, err := normalizePhone(phone, config.Phone.DefaultCountry)
+	if err != nil {
+		return "", &ValidationError{Field: field, Message: fmt.Sprintf("Invalid phone number %q: %v", phone, err)}
+	}
+	return e164, nil
+}
+
+// storedE164 is the phone_e164 column value of e164, NULL until the number
+// could be parsed
+func storedE164(e164 string) interface{} {
+	if e164 == "" {
+		return nil
+	}
+	return e164
+}
+
+// BACKFILL
+
+// backfillPhones stores the E.164 form of the phone numbers saved before
+// normalization existed, or while they could not be parsed. Numbers that
+// still cannot be parsed are reported and left for a later run, e.g. after
+// phone.default_country was set.
+func backfillPhones(conn *sql.DB) (filled, invalid int, err error) {
+	country := config.Phone.DefaultCountry
+	for _, table := range []string{"contacts", "contact_phones"} {
+		rows, err := conn.Query("SELECT id, phone FROM " + table + " WHERE phone_e164 IS NULL")
+		if err != nil {
+			return filled, invalid, err
+		}
+		type pending struct{ id, phone string }
+		var phones []pending
+		for rows.Next() {
+			var p pending
+			if err := rows.Scan(&p.id, &p.phone); err != nil {
+				rows.Close()
+				return filled, invalid, err
+			}
+			phones = append(phones, p)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return filled, invalid, err
+		}
+
+		for _, p := range phones {
+			e164, err := normalizePhone(p.phone, country)
+			if err != nil {
+				fmt.Printf("Warning: Cannot normalize phone %q in %s %s: %v\n", p.phone, table, p.id, err)
+				invalid++
+				continue
+			}
+			if _, err := conn.Exec("UPDATE "+table+" SET phone_e164 = ? WHERE id = ?", e164, p.id); err != nil {
+				return filled, invalid, err
+			}
+			filled++
+		}
+	}
+	return filled, invalid, nil
+}
+
+// runPhoneBackfill is the startup job around backfillPhones
+func runPhoneBackfill() {
+	filled, invalid, err := backfillPhones(db.DB)
+	if err != nil {
+		fmt.Printf("Warning: Phone number backfill failed: %v\n", err)
+		return
+	}
+	if filled > 0 || invalid > 0 {
+		fmt.Printf("Normalized %d phone numbers, %d could not be parsed\n", filled, invalid)
+	}
+}
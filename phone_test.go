@@ -0,0 +1,76 @@
+package main
+
+import "testing"
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		phone, country string
+		want           string
+		wantErr        bool
+	}{
+		{"(415) 555-0132", "US", "+14155550132", false},
+		{"1 415 555 0132", "US", "+14155550132", false},
+		{"+1 415.555.0132", "GB", "+14155550132", false},
+		{"020 7946 0958", "GB", "+442079460958", false},
+		{"+44 (0)20 7946 0958", "US", "+442079460958", false},
+		{"0044 20 7946 0958", "US", "+442079460958", false},
+		{"01 23 45 67 89", "FR", "+33123456789", false},
+		{"06 12 34 56 78", "IT", "+390612345678", false},
+		{"+354 555 1234", "US", "+3545551234", false},
+		{"012-345 6789", "MY", "+60123456789", false},
+		{"0123456789", "US", "", true},
+		{"020 7946 0958", "US", "", true},
+		{"+44 20 7946", "US", "", true},
+		{"+0 123 4567", "US", "", true},
+		{"1230", "US", "", true},
+		{"call me", "US", "", true},
+		{"555 0132", "", "", true},
+	}
+	for _, tt := range tests {
+		got, err := normalizePhone(tt.phone, tt.country)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("normalizePhone(%q, %q) = %q, %v; want %q, error %t", tt.phone, tt.country, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestBackfillPhones(t *testing.T) {
+	conn := openTestDB(t)
+	if _, err := migrateUp(conn, 19); err != nil {
+		t.Fatalf("migrateUp failed: %v", err)
+	}
+	for _, stmt := range []string{
+		`INSERT INTO contacts (id, contact_type, first_name, last_name, email, phone) VALUES
+			('c1', 'Work', 'A', 'A', 'a@example.test', '020 7946 0958'),
+			('c2', 'Work', 'B', 'B', 'b@example.test', '1230')`,
+		`INSERT INTO contact_phones (contact_id, position, label, phone) VALUES ('c1', 0, 'mobile', '+1 415 555 0132')`,
+	} {
+		if _, err := conn.Exec(stmt); err != nil {
+			t.Fatalf("Failed to insert test data: %v", err)
+		}
+	}
+	if _, err := migrateUp(conn, 0); err != nil {
+		t.Fatalf("migrateUp failed: %v", err)
+	}
+
+	savedCountry := config.Phone.DefaultCountry
+	config.Phone.DefaultCountry = "GB"
+	t.Cleanup(func() { config.Phone.DefaultCountry = savedCountry })
+	filled, invalid, err := backfillPhones(conn)
+	if err != nil || filled != 2 || invalid != 1 {
+		t.Fatalf("backfillPhones = %d, %d, %v; want 2 filled and 1 invalid", filled, invalid, err)
+	}
+
+	var e164 string
+	if err := conn.QueryRow("SELECT phone_e164 FROM contacts WHERE id = 'c1'").Scan(&e164); err != nil || e164 != "+442079460958" {
+		t.Errorf("phone_e164 of c1 = %q, %v", e164, err)
+	}
+	if err := conn.QueryRow("SELECT phone_e164 FROM contact_phones WHERE contact_id = 'c1'").Scan(&e164); err != nil || e164 != "+14155550132" {
+		t.Errorf("phone_e164 of the extra phone = %q, %v", e164, err)
+	}
+
+	// only the unparsed number is tried again
+	if filled, invalid, _ := backfillPhones(conn); filled != 0 || invalid != 1 {
+		t.Errorf("second backfillPhones = %d, %d; want 0 filled and 1 invalid", filled, invalid)
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type Role string
+
+const (
+	RoleAdmin   Role = "admin"
+	RoleEditor  Role = "editor"
+	RoleViewer  Role = "viewer"
+	RoleContact Role = "contact" // self-service login created for a contact
+)
+
+type Permission string
+
+const (
+	PermViewContacts    Permission = "contacts:view"
+	PermEditContacts    Permission = "contacts:edit"
+	PermEditOwnContact  Permission = "contacts:edit-own"
+	PermViewCompanies   Permission = "companies:view"
+	PermManageCompanies Permission = "companies:manage"
+	PermManageUsers     Permission = "users:manage"
+	PermManageLicense   Permission = "license:manage"
+	PermViewAudit       Permission = "audit:view"
+	PermManageFields    Permission = "fields:manage"
+	PermManageTags      Permission = "tags:manage"
+	PermManageTypes     Permission = "contact-types:manage"
+	PermMergeContacts   Permission = "contacts:merge"
+)
+
+type roleInfo struct {
+	Role        Role
+	Label       string
+	Description string
+}
+
+// roles lists the assignable roles in the order they are offered in the admin UI
+var roles = []roleInfo{
+	{RoleAdmin, "Admin", "Full access including users and licensing"},
+	{RoleEditor, "Editor", "Manage all contacts and companies"},
+	{RoleViewer, "Viewer", "Read-only access to contacts and companies"},
+	{RoleContact, "Contact", "Browse the directory and edit their own card"},
+}
+
+var rolePermissions = map[Role][]Permission{
+	RoleAdmin: {
+		PermViewContacts, PermEditContacts, PermEditOwnContact,
+		PermViewCompanies, PermManageCompanies,
+		PermManageUsers, PermManageLicense, PermViewAudit, PermManageFields, PermManageTags,
+		PermManageTypes, PermMergeContacts,
+	},
+	RoleEditor: {
+		PermViewContacts, PermEditContacts, PermEditOwnContact,
+		PermViewCompanies, PermManageCompanies,
+	},
+	RoleViewer: {
+		PermViewContacts, PermViewCompanies,
+	},
+	RoleContact: {
+		PermViewContacts, PermEditOwnContact,
+	},
+}
+
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+func (r Role) Can(p Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+const userContextKey contextKey = "user"
+
+func withUser(r *http.Request, user *User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userContextKey, user))
+}
+
+// currentUserRecord returns the signed-in user, preferring the copy that
+// authMiddleware placed on the request context
+func currentUserRecord(r *http.Request) (*User, error) {
+	if user, ok := r.Context().Value(userContextKey).(*User); ok {
+		return user, nil
+	}
+	username, err := getCurrentUser(r)
+	if err != nil {
+		return nil, err
+	}
+	return db.GetUser(username)
+}
+
+// hasPermission checks the user's role and, for API token requests, that the
+// token was also granted a scope covering p
+func hasPermission(r *http.Request, p Permission) bool {
+	user, err := currentUserRecord(r)
+	if err != nil {
+		return false
+	}
+	if token := apiTokenFromContext(r); token != nil && !token.Allows(p) {
+		return false
+	}
+	return user.Role.Can(p)
+}
+
+// canEditContact allows editors to change any card and everybody holding
+// PermEditOwnContact to change the card linked to their own login
+func canEditContact(r *http.Request, contactID string) bool {
+	user, err := currentUserRecord(r)
+	if err != nil {
+		return false
+	}
+	if hasPermission(r, PermEditContacts) {
+		return true
+	}
+	return hasPermission(r, PermEditOwnContact) && user.ContactID != nil && *user.ContactID == contactID
+}
+
+func forbidden(w http.ResponseWriter, r *http.Request) {
+	if username, err := getCurrentUser(r); err == nil {
+		fmt.Printf("Access denied for %s: %s %s\n", username, r.Method, r.URL.Path)
+	}
+	http.Error(w, "Forbidden - You do not have permission to perform this action", http.StatusForbidden)
+}
+
+// allow wraps a handler so it only runs for users whose role grants p
+func allow(p Permission, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hasPermission(r, p) {
+			forbidden(w, r)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// allowContactEdit guards routes carrying a contact {id}
+func allowContactEdit(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !canEditContact(r, mux.Vars(r)["id"]) {
+			forbidden(w, r)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// USER ADMIN HANDLERS
+var usersPageHTML = `
+<!doctype html>
+<html lang="en">
+    <head>
+        <meta charset="UTF-8" />
+        <meta name="viewport" content="width=device-width, initial-scale=1.0" />
+        <title>Users - AFCB</title>
+        <link rel="icon" type="image/x-icon" href="/static/favicon/favicon.ico" />
+        <script src="https://cdn.tailwindcss.com"></script>
+        <script src="https://unpkg.com/htmx.org@1.9.10"></script>
+        <script src="/static/csrf.js"></script>
+    </head>
+    <body class="bg-gray-100" hx-headers='{"X-CSRF-Token": "{{.CSRFToken}}"}'>
+        <nav class="bg-white shadow-md">
+            <div class="container mx-auto px-4">
+                <div class="flex justify-between items-center py-4">
+                    <a href="/" class="text-2xl font-bold text-blue-600">AFcb</a>
+                    <div class="flex items-center space-x-4">
+                        <a href="/" class="text-gray-600 hover:text-blue-600">Contacts</a>
+                        <a href="/companies-page" class="text-gray-600 hover:text-blue-600">Companies</a>
+                        <a href="/admin/users" class="text-blue-600 font-semibold">Users</a>
+                        <a href="/admin/fields" class="text-gray-600 hover:text-blue-600">Fields</a>
+                        <a href="/admin/tags" class="text-gray-600 hover:text-blue-600">Tags</a>
+                        <a href="/admin/contact-types" class="text-gray-600 hover:text-blue-600">Types</a>
+                        <a href="/admin/duplicates" class="text-gray-600 hover:text-blue-600">Duplicates</a>
+                        <a href="/admin/audit" class="text-gray-600 hover:text-blue-600">Audit Log</a>
+                        <a href="/admin/license" class="text-gray-600 hover:text-blue-600">Admin</a>
+                        <a href="/logout" class="px-4 py-2 bg-red-600 text-white rounded-md hover:bg-red-700">Logout</a>
+                    </div>
+                </div>
+            </div>
+        </nav>
+        <main class="container mx-auto px-4 py-8">
+            <h1 class="text-3xl font-bold text-gray-800 mb-6">Users</h1>
+            <div class="bg-white rounded-lg shadow-md overflow-hidden">
+                <table class="min-w-full divide-y divide-gray-200">
+                    <thead class="bg-gray-50">
+                        <tr>
+                            <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Username</th>
+                            <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Contact</th>
+                            <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Role</th>
+                            <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Sessions</th>
+                            <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Login</th>
+                            <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Invitation</th>
+                        </tr>
+                    </thead>
+                    <tbody class="bg-white divide-y divide-gray-200">
+                        {{range .Users}}
+                        <tr>
+                            <td class="px-6 py-4 whitespace-nowrap text-sm font-medium text-gray-900">{{.Username}}</td>
+                            <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-500">{{if .ContactID}}{{.ContactID}}{{else}}-{{end}}</td>
+                            <td class="px-6 py-4 whitespace-nowrap text-sm">
+                                <select name="role" class="border rounded py-1 px-2 text-gray-700"
+                                        hx-put="/admin/users/{{.Username}}/role"
+                                        hx-trigger="change"
+                                        hx-target="next .role-result"
+                                        hx-swap="innerHTML">
+                                    {{$current := .Role}}
+                                    {{range $.Roles}}
+                                    <option value="{{.Role}}" title="{{.Description}}" {{if eq .Role $current}}selected{{end}}>{{.Label}}</option>
+                                    {{end}}
+                                </select>
+                                <span class="role-result ml-2"></span>
+                            </td>
+                            <td class="px-6 py-4 whitespace-nowrap text-sm">
+                                <button class="text-red-600 hover:text-red-900"
+                                        hx-delete="/admin/users/{{.Username}}/sessions"
+                                        hx-target="next span"
+                                        hx-swap="innerHTML"
+                                        hx-confirm="Sign {{.Username}} out everywhere?">Revoke all</button>
+                                <span class="ml-2"></span>
+                            </td>
+                            <td class="px-6 py-4 whitespace-nowrap text-sm">
+                                {{$username := .Username}}
+                                {{with index $.Locked .Username}}
+                                <span class="text-red-600">Locked until {{.}}</span>
+                                <button class="ml-2 text-blue-600 hover:text-blue-900"
+                                        hx-post="/admin/users/{{$username}}/unlock"
+                                        hx-target="closest td"
+                                        hx-swap="innerHTML">Unlock</button>
+                                {{else}}
+                                <span class="text-gray-500">Active</span>
+                                {{end}}
+                            </td>
+                            <td class="px-6 py-4 whitespace-nowrap text-sm">
+                                {{with index $.Invited .Username}}
+                                <span class="text-gray-700">Pending until {{.}}</span>
+                                <button class="ml-2 text-blue-600 hover:text-blue-900"
+                                        hx-post="/admin/users/{{$username}}/invite"
+                                        hx-target="closest td"
+                                        hx-swap="innerHTML"
+                                        hx-confirm="Send {{$username}} a new invitation? The current link stops working.">Resend</button>
+                                <button class="ml-2 text-red-600 hover:text-red-900"
+                                        hx-delete="/admin/users/{{$username}}/invite"
+                                        hx-target="closest td"
+                                        hx-swap="innerHTML"
+                                        hx-confirm="Revoke the invitation of {{$username}}?">Revoke</button>
+                                {{else}}
+                                {{if .ContactID}}
+                                <button class="text-blue-600 hover:text-blue-900"
+                                        hx-post="/admin/users/{{$username}}/invite"
+                                        hx-target="closest td"
+                                        hx-swap="innerHTML">Send invitation</button>
+                                {{else}}
+                                <span class="text-gray-500">-</span>
+                                {{end}}
+                                {{end}}
+                            </td>
+                        </tr>
+                        {{end}}
+                    </tbody>
+                </table>
+            </div>
+        </main>
+    </body>
+</html>
+`
+
+var usersPage = template.Must(template.New("users").Parse(usersPageHTML))
+
+func usersPageHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := db.GetAllUsers()
+	if err != nil {
+		http.Error(w, "Failed to fetch users: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now().UTC()
+	locks, err := db.LockedAccounts(now)
+	if err != nil {
+		fmt.Printf("Warning: Failed to load locked accounts: %v\n", err)
+	}
+	locked := map[string]string{}
+	for _, u := range users {
+		if until, ok := locks[strings.ToLower(u.Username)]; ok {
+			locked[u.Username] = until.Local().Format("15:04")
+		}
+	}
+
+	pending, err := db.PendingInvites(now)
+	if err != nil {
+		fmt.Printf("Warning: Failed to load pending invitations: %v\n", err)
+	}
+	invited := map[string]string{}
+	for username, expires := range pending {
+		invited[username] = expires.Local().Format("Jan 2 15:04")
+	}
+
+	data := struct {
+		Users     []User
+		Roles     []roleInfo
+		Locked    map[string]string
+		Invited   map[string]string
+		CSRFToken string
+	}{
+		Users:     users,
+		Roles:     roles,
+		Locked:    locked,
+		Invited:   invited,
+		CSRFToken: csrfToken(r),
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := usersPage.Execute(w, data); err != nil {
+		fmt.Printf("Error rendering users page: %v\n", err)
+	}
+}
+
+func updateUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	username := mux.Vars(r)["username"]
+	role := Role(r.FormValue("role"))
+	w.Header().Set("Content-Type", "text/html")
+
+	if !role.Valid() {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `<span class="text-red-500 text-xs">Unknown role</span>`)
+		return
+	}
+
+	user, err := db.GetUser(username)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	// Never leave the system without an administrator
+	if user.Role == RoleAdmin && role != RoleAdmin {
+		admins, err := db.CountUsersWithRole(RoleAdmin)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if admins <= 1 {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprintf(w, `<span class="text-red-500 text-xs">Cannot remove the last admin</span>`)
+			return
+		}
+	}
+
+	if err := db.UpdateUserRole(username, role); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	updated := *user
+	updated.Role = role
+	recordAudit(r, AuditUpdate, "user", username, user, &updated)
+
+	fmt.Printf("Role of %s changed from %s to %s\n", username, user.Role, role)
+	fmt.Fprintf(w, `<span class="text-green-600 text-xs">Saved</span>`)
+}
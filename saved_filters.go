@@ -0,0 +1,213 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Saved filters are named contact searches, e.g. "type:work company:bank
+// tag:vip", kept per user. Pinned ones are listed in the sidebar of the
+// contact page next to the tags.
+
+type SavedFilter struct {
+	ID     int64
+	Name   string
+	Query  string
+	Pinned bool
+}
+
+func (db *DB) ListSavedFilters(username string) ([]SavedFilter, error) {
+	rows, err := db.Query(`SELECT id, name, query, pinned FROM saved_filters
+		WHERE username = ? ORDER BY name COLLATE NOCASE`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var filters []SavedFilter
+	for rows.Next() {
+		var f SavedFilter
+		if err := rows.Scan(&f.ID, &f.Name, &f.Query, &f.Pinned); err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	return filters, rows.Err()
+}
+
+// SaveFilter stores a filter under name, replacing the query of an existing
+// filter with the same name. New filters start pinned.
+func (db *DB) SaveFilter(username, name, query string) error {
+	_, err := db.Exec(`INSERT INTO saved_filters (username, name, query, pinned, created_at) VALUES (?, ?, ?, 1, ?)
+		ON CONFLICT(username, name) DO UPDATE SET query = excluded.query`,
+		username, name, query, time.Now().UTC())
+	return err
+}
+
+func (db *DB) SetFilterPinned(username string, id int64, pinned bool) error {
+	result, err := db.Exec("UPDATE saved_filters SET pinned = ? WHERE id = ? AND username = ?", pinned, id, username)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (db *DB) DeleteSavedFilter(username string, id int64) error {
+	result, err := db.Exec("DELETE FROM saved_filters WHERE id = ? AND username = ?", id, username)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// tagQuery returns the search query for contacts tagged name
+func tagQuery(name string) string {
+	if strings.ContainsAny(name, " \t") {
+		return `tag:"` + name + `"`
+	}
+	return "tag:" + name
+}
+
+var sidebarTemplate = template.Must(template.New("sidebar").Funcs(template.FuncMap{
+	"tagQuery": tagQuery,
+}).Parse(`
+{{if .Error}}<div class="bg-red-50 border border-red-200 text-red-700 rounded p-2 mb-4 text-sm">{{.Error}}</div>{{end}}
+<div class="bg-white rounded-lg shadow-md p-4 mb-4">
+    <h3 class="text-sm font-bold text-gray-500 uppercase mb-2">Saved filters</h3>
+    {{range .Filters}}{{if .Pinned}}
+    <button type="button" class="block w-full text-left text-sm text-gray-700 hover:text-blue-600 py-1"
+            data-query="{{.Query}}" onclick="applySearch(this.dataset.query)" title="{{.Query}}">{{.Name}}</button>
+    {{end}}{{end}}
+    {{if not .HasPinned}}<p class="text-xs text-gray-400">Pin a saved filter to keep it here.</p>{{end}}
+    <details class="mt-3">
+        <summary class="text-xs text-blue-600 cursor-pointer">Manage</summary>
+        <form class="mt-2" hx-post="/filters" hx-target="#sidebar" hx-swap="innerHTML" hx-include="#search-input">
+            <input type="text" name="name" required maxlength="60" placeholder="Name for current search"
+                   class="w-full border rounded py-1 px-2 text-sm">
+            <button type="submit" class="mt-1 w-full px-2 py-1 bg-blue-600 text-white text-sm rounded hover:bg-blue-700">Save search</button>
+        </form>
+        <ul class="mt-3 space-y-1">
+            {{range .Filters}}
+            <li class="flex items-center justify-between text-sm">
+                <span class="truncate" title="{{.Query}}">{{.Name}}</span>
+                <span class="flex-shrink-0 space-x-1">
+                    <button class="text-xs text-gray-500 hover:text-blue-600"
+                            hx-post="/filters/{{.ID}}/pin"
+                            hx-vals='{"pinned": "{{if .Pinned}}0{{else}}1{{end}}"}'
+                            hx-target="#sidebar"
+                            hx-swap="innerHTML">{{if .Pinned}}Unpin{{else}}Pin{{end}}</button>
+                    <button class="text-xs text-red-600 hover:text-red-900"
+                            hx-delete="/filters/{{.ID}}"
+                            hx-target="#sidebar"
+                            hx-swap="innerHTML"
+                            hx-confirm="Delete the saved filter {{.Name}}?">Delete</button>
+                </span>
+            </li>
+            {{end}}
+        </ul>
+    </details>
+</div>
+{{if .Tags}}
+<div class="bg-white rounded-lg shadow-md p-4">
+    <h3 class="text-sm font-bold text-gray-500 uppercase mb-2">Tags</h3>
+    <div class="flex flex-wrap gap-1">
+        {{range .Tags}}
+        <button type="button" class="px-2 py-0.5 rounded-full text-xs font-medium {{.Classes}}"
+                data-query="{{tagQuery .Name}}" onclick="applySearch(this.dataset.query)">{{.Name}}</button>
+        {{end}}
+    </div>
+</div>
+{{end}}
+`))
+
+func renderSidebar(w http.ResponseWriter, r *http.Request, errMessage string) {
+	username, _ := getCurrentUser(r)
+	filters, err := db.ListSavedFilters(username)
+	if err != nil {
+		http.Error(w, "Failed to load saved filters: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Filters   []SavedFilter
+		HasPinned bool
+		Tags      []Tag
+		Error     string
+	}{Filters: filters, Tags: loadTags(), Error: errMessage}
+	for _, f := range filters {
+		data.HasPinned = data.HasPinned || f.Pinned
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := sidebarTemplate.Execute(w, data); err != nil {
+		fmt.Printf("Error rendering sidebar: %v\n", err)
+	}
+}
+
+func sidebarHandler(w http.ResponseWriter, r *http.Request) {
+	renderSidebar(w, r, "")
+}
+
+func saveFilterHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	query := strings.TrimSpace(r.FormValue("q"))
+	switch {
+	case name == "":
+		renderSidebar(w, r, "Give the filter a name")
+		return
+	case query == "":
+		renderSidebar(w, r, "Search for something first, then save it")
+		return
+	}
+
+	username, _ := getCurrentUser(r)
+	if err := db.SaveFilter(username, name, query); err != nil {
+		http.Error(w, "Failed to save filter: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("Saved filter %q for %s: %s\n", name, username, query)
+	renderSidebar(w, r, "")
+}
+
+func pinFilterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err == nil {
+		username, _ := getCurrentUser(r)
+		err = db.SetFilterPinned(username, id, r.FormValue("pinned") == "1")
+	}
+	if err != nil {
+		http.Error(w, "Filter not found", http.StatusNotFound)
+		return
+	}
+	renderSidebar(w, r, "")
+}
+
+func deleteFilterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err == nil {
+		username, _ := getCurrentUser(r)
+		err = db.DeleteSavedFilter(username, id)
+	}
+	if err != nil {
+		http.Error(w, "Filter not found", http.StatusNotFound)
+		return
+	}
+	renderSidebar(w, r, "")
+}
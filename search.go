@@ -0,0 +1,540 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+)
+
+// Full-text search over contacts and companies.
+//
+// The index lives in two FTS5 tables kept in sync by triggers. FTS5 is not
+// compiled into go-sqlite3 by default, so release builds need
+//
+//	go build -tags sqlite_fts5
+//
+// which the Makefile passes. Without it the index is skipped, a warning is
+// printed at startup and searches fall back to LIKE matching with the same
+// query syntax, just unranked.
+//
+// Query syntax: whitespace separated terms that must all match, each as a
+// prefix ("jo" finds "john"); "quoted phrases"; and field qualifiers such as
+// company:acme or type:work. Unknown qualifiers are searched as plain text.
+// email: and phone: also match a contact's extra emails and phones, and
+// custom: matches the values of custom fields. tag:vip only keeps contacts
+// tagged exactly vip.
+
+type searchField struct {
+	FTS  string // FTS5 column filter
+	Like string // columns for the LIKE fallback
+}
+
+var (
+	contactEmailsColumn    = fmt.Sprintf(contactEmailsText, "c.id")
+	contactPhonesColumn    = fmt.Sprintf(contactPhonesText, "c.id")
+	contactAddressesColumn = fmt.Sprintf(contactAddressesText, "c.id")
+	contactCustomColumn    = fmt.Sprintf(customValuesText, "contact", "c.id")
+	companyCustomColumn    = fmt.Sprintf(customValuesText, "company", "c.id")
+)
+
+var contactSearchFields = map[string]searchField{
+	"name":    {"{first_name last_name}", "c.first_name,c.last_name"},
+	"first":   {"first_name", "c.first_name"},
+	"last":    {"last_name", "c.last_name"},
+	"email":   {"{email emails}", "c.email," + contactEmailsColumn},
+	"phone":   {"{phone phones}", "c.phone," + contactPhonesColumn},
+	"address": {"addresses", contactAddressesColumn},
+	"type":    {"contact_type", "c.contact_type"},
+	"company": {"company", "comp.name"},
+	"custom":  {"custom", contactCustomColumn},
+	"tag":     {}, // handled by contactFilter
+}
+
+var companySearchFields = map[string]searchField{
+	"name":         {"name", "c.name"},
+	"bank":         {"bank_name", "c.bank_name"},
+	"account":      {"account_number", "c.account_number"},
+	"reg":          {"registration_number", "c.registration_number"},
+	"registration": {"registration_number", "c.registration_number"},
+	"custom":       {"custom", companyCustomColumn},
+}
+
+type searchTerm struct {
+	Field  string // empty for all fields
+	Text   string
+	Phrase bool // quoted, so no prefix matching
+}
+
+// parseSearchQuery splits a query into terms, honouring double quotes and
+// field qualifiers known to fields
+func parseSearchQuery(q string, fields map[string]searchField) []searchTerm {
+	var terms []searchTerm
+	runes := []rune(q)
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		term := searchTerm{}
+
+		// field qualifier
+		j := i
+		for j < len(runes) && (unicode.IsLetter(runes[j])) {
+			j++
+		}
+		if j < len(runes) && runes[j] == ':' && j > i {
+			if _, ok := fields[strings.ToLower(string(runes[i:j]))]; ok {
+				term.Field = strings.ToLower(string(runes[i:j]))
+				i = j + 1
+			}
+		}
+
+		if i < len(runes) && runes[i] == '"' {
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			term.Text = string(runes[i+1 : end])
+			term.Phrase = true
+			i = end + 1
+		} else {
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) {
+				end++
+			}
+			term.Text = string(runes[i:end])
+			i = end
+		}
+
+		term.Text = strings.TrimSpace(term.Text)
+		if term.Text != "" && hasSearchableRune(term.Text) {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+func hasSearchableRune(s string) bool {
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// ftsMatchExpression builds an FTS5 MATCH expression in which every term is
+// a quoted string, so user input can never produce a syntax error
+func ftsMatchExpression(terms []searchTerm, fields map[string]searchField) string {
+	parts := make([]string, 0, len(terms))
+	for _, t := range terms {
+		expr := `"` + strings.ReplaceAll(t.Text, `"`, `""`) + `"`
+		if !t.Phrase {
+			expr += "*"
+		}
+		if t.Field != "" {
+			expr = fields[t.Field].FTS + " : " + expr
+		}
+		parts = append(parts, expr)
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// likeEscaper makes % and _ in a search term match themselves
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// likeConditions builds the WHERE clause for the fallback: every term must
+// match at least one of its columns
+func likeConditions(terms []searchTerm, fields map[string]searchField, allColumns string) (string, []interface{}) {
+	var clauses []string
+	var args []interface{}
+	for _, t := range terms {
+		columns := allColumns
+		if t.Field != "" {
+			columns = fields[t.Field].Like
+		}
+		var ors []string
+		for _, col := range strings.Split(columns, ",") {
+			ors = append(ors, col+` LIKE ? ESCAPE '\'`)
+			args = append(args, "%"+likeEscaper.Replace(t.Text)+"%")
+		}
+		clauses = append(clauses, "("+strings.Join(ors, " OR ")+")")
+	}
+	return strings.Join(clauses, " AND "), args
+}
+
+// contactDetailsFTS returns the values of the emails, phones, addresses and
+// custom columns of contacts_fts for the contact whose id is id
+func contactDetailsFTS(id string) string {
+	return fmt.Sprintf("COALESCE(%s, ''), COALESCE(%s, ''), COALESCE(%s, ''), COALESCE(%s, '')",
+		fmt.Sprintf(contactEmailsText, id), fmt.Sprintf(contactPhonesText, id), fmt.Sprintf(contactAddressesText, id),
+		fmt.Sprintf(customValuesText, "contact", id))
+}
+
+// companyCustomFTS returns the value of the custom column of companies_fts
+func companyCustomFTS(id string) string {
+	return fmt.Sprintf("COALESCE(%s, '')", fmt.Sprintf(customValuesText, "company", id))
+}
+
+// customValueTriggers keep the custom columns of both indexes in sync with
+// custom_field_values
+func customValueTriggers() []string {
+	var triggers []string
+	for _, event := range []struct{ name, row string }{{"insert", "new"}, {"delete", "old"}} {
+		id := event.row + ".entity_id"
+		triggers = append(triggers, fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS custom_field_values_fts_%s AFTER %s ON custom_field_values BEGIN
+		UPDATE contacts_fts SET custom = COALESCE(%s, '') WHERE %s.entity_type = 'contact' AND contact_id = %s;
+		UPDATE companies_fts SET custom = COALESCE(%s, '') WHERE %s.entity_type = 'company' AND company_id = %s;
+	END`, event.name, strings.ToUpper(event.name),
+			fmt.Sprintf(customValuesText, "contact", id), event.row, id,
+			fmt.Sprintf(customValuesText, "company", id), event.row, id))
+	}
+	return triggers
+}
+
+// contactDetailTriggers keep one detail column of contacts_fts in sync with
+// its table
+func contactDetailTriggers(table, column, text string) []string {
+	var triggers []string
+	for _, event := range []struct{ name, row string }{{"insert", "new"}, {"delete", "old"}} {
+		id := event.row + ".contact_id"
+		triggers = append(triggers, fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS %s_fts_%s AFTER %s ON %s BEGIN
+		UPDATE contacts_fts SET %s = COALESCE(%s, '') WHERE contact_id = %s;
+	END`, table, event.name, strings.ToUpper(event.name), table, column, fmt.Sprintf(text, id), id))
+	}
+	return triggers
+}
+
+var searchIndexStatements = append([]string{
+	`CREATE VIRTUAL TABLE IF NOT EXISTS contacts_fts USING fts5(
+		contact_id UNINDEXED, first_name, last_name, email, phone, contact_type, company,
+		emails, phones, addresses, custom,
+		tokenize = 'unicode61 remove_diacritics 2', prefix = '2 3'
+	)`,
+	`CREATE VIRTUAL TABLE IF NOT EXISTS companies_fts USING fts5(
+		company_id UNINDEXED, name, bank_name, account_number, registration_number, custom,
+		tokenize = 'unicode61 remove_diacritics 2', prefix = '2 3'
+	)`,
+
+	`CREATE TRIGGER IF NOT EXISTS contacts_fts_insert AFTER INSERT ON contacts BEGIN
+		INSERT INTO contacts_fts (contact_id, first_name, last_name, email, phone, contact_type, company, emails, phones, addresses, custom)
+		VALUES (new.id, new.first_name, new.last_name, new.email, new.phone, new.contact_type,
+			COALESCE((SELECT name FROM companies WHERE id = new.company_id AND deleted_at IS NULL), ''), ` + contactDetailsFTS("new.id") + `);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS contacts_fts_update AFTER UPDATE ON contacts BEGIN
+		DELETE FROM contacts_fts WHERE contact_id = old.id;
+		INSERT INTO contacts_fts (contact_id, first_name, last_name, email, phone, contact_type, company, emails, phones, addresses, custom)
+		VALUES (new.id, new.first_name, new.last_name, new.email, new.phone, new.contact_type,
+			COALESCE((SELECT name FROM companies WHERE id = new.company_id AND deleted_at IS NULL), ''), ` + contactDetailsFTS("new.id") + `);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS contacts_fts_delete AFTER DELETE ON contacts BEGIN
+		DELETE FROM contacts_fts WHERE contact_id = old.id;
+	END`,
+
+	`CREATE TRIGGER IF NOT EXISTS companies_fts_insert AFTER INSERT ON companies BEGIN
+		INSERT INTO companies_fts (company_id, name, bank_name, account_number, registration_number, custom)
+		VALUES (new.id, new.name, new.bank_name, new.account_number, new.registration_number, ` + companyCustomFTS("new.id") + `);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS companies_fts_update AFTER UPDATE ON companies BEGIN
+		DELETE FROM companies_fts WHERE company_id = old.id;
+		INSERT INTO companies_fts (company_id, name, bank_name, account_number, registration_number, custom)
+		VALUES (new.id, new.name, new.bank_name, new.account_number, new.registration_number, ` + companyCustomFTS("new.id") + `);
+		UPDATE contacts_fts SET company = CASE WHEN new.deleted_at IS NULL THEN new.name ELSE '' END
+		WHERE contact_id IN (SELECT id FROM contacts WHERE company_id = new.id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS companies_fts_delete AFTER DELETE ON companies BEGIN
+		DELETE FROM companies_fts WHERE company_id = old.id;
+		UPDATE contacts_fts SET company = ''
+		WHERE contact_id IN (SELECT id FROM contacts WHERE company_id = old.id);
+	END`,
+}, append(append(append(
+	contactDetailTriggers("contact_emails", "emails", contactEmailsText),
+	contactDetailTriggers("contact_phones", "phones", contactPhonesText)...),
+	contactDetailTriggers("contact_addresses", "addresses", contactAddressesText)...),
+	customValueTriggers()...)...)
+
+var searchIndexTriggers = []string{
+	"contacts_fts_insert", "contacts_fts_update", "contacts_fts_delete",
+	"companies_fts_insert", "companies_fts_update", "companies_fts_delete",
+	"contact_emails_fts_insert", "contact_emails_fts_delete",
+	"contact_phones_fts_insert", "contact_phones_fts_delete",
+	"contact_addresses_fts_insert", "contact_addresses_fts_delete",
+	"custom_field_values_fts_insert", "custom_field_values_fts_delete",
+}
+
+// fts5Available reports whether the SQLite library was built with FTS5
+func fts5Available(conn *sql.DB) bool {
+	var enabled bool
+	err := conn.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled)
+	return err == nil && enabled
+}
+
+// initSearchIndex creates the FTS tables and triggers if needed and fills
+// the tables from scratch whenever the triggers were missing. The index is derived
+// data, which is why it is managed here and not by a numbered migration: a
+// binary built without FTS5 simply leaves it out.
+func initSearchIndex(conn *sql.DB) (bool, error) {
+	if !fts5Available(conn) {
+		// the triggers of an index created by an FTS5 build would make every
+		// write fail here, so drop them; the next FTS5 build rebuilds the index
+		for _, trigger := range searchIndexTriggers {
+			if _, err := conn.Exec("DROP TRIGGER IF EXISTS " + trigger); err != nil {
+				return false, err
+			}
+		}
+		fmt.Println("Warning: SQLite was built without FTS5, so search is unranked and slow on large tables; build with `make` or -tags sqlite_fts5")
+		return false, nil
+	}
+
+	tx, err := conn.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// an index from before contact details and custom fields were searchable
+	// lacks their columns and has to be recreated
+	var outdated int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name IN ('contacts_fts', 'companies_fts')
+		AND sql NOT LIKE '%custom%'`).Scan(&outdated); err != nil {
+		return false, err
+	}
+	// triggers from before companies in the recycle bin were left out of
+	// contact search are replaced, which rebuilds the index below
+	var staleTriggers int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = 'companies_fts_update'
+		AND sql NOT LIKE '%deleted_at%'`).Scan(&staleTriggers); err != nil {
+		return false, err
+	}
+	if outdated > 0 || staleTriggers > 0 {
+		var drops []string
+		if outdated > 0 {
+			drops = append(drops, "DROP TABLE IF EXISTS contacts_fts", "DROP TABLE IF EXISTS companies_fts")
+		}
+		for _, trigger := range searchIndexTriggers {
+			drops = append(drops, "DROP TRIGGER IF EXISTS "+trigger)
+		}
+		if err := execAll(drops...)(tx); err != nil {
+			return false, err
+		}
+	}
+
+	var existing int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = 'contacts_fts_insert'`).Scan(&existing); err != nil {
+		return false, err
+	}
+
+	if err := execAll(searchIndexStatements...)(tx); err != nil {
+		return false, fmt.Errorf("failed to create search index: %v", err)
+	}
+	if existing == 0 {
+		if err := rebuildSearchIndex(tx); err != nil {
+			return false, err
+		}
+		log.Printf("Built full-text search index")
+	}
+	return true, tx.Commit()
+}
+
+func rebuildSearchIndex(tx *sql.Tx) error {
+	return execAll(
+		`DELETE FROM contacts_fts`,
+		`INSERT INTO contacts_fts (contact_id, first_name, last_name, email, phone, contact_type, company, emails, phones, addresses, custom)
+			SELECT c.id, c.first_name, c.last_name, c.email, c.phone, c.contact_type, COALESCE(comp.name, ''), `+contactDetailsFTS("c.id")+`
+			FROM contacts c LEFT JOIN companies comp ON c.company_id = comp.id AND comp.deleted_at IS NULL`,
+		`DELETE FROM companies_fts`,
+		`INSERT INTO companies_fts (company_id, name, bank_name, account_number, registration_number, custom)
+			SELECT id, name, COALESCE(bank_name, ''), COALESCE(account_number, ''), COALESCE(registration_number, ''), `+companyCustomFTS("companies.id")+`
+			FROM companies`,
+	)(tx)
+}
+
+func scanContacts(rows *sql.Rows) ([]Contact, error) {
+	defer rows.Close()
+
+	var contacts []Contact
+	for rows.Next() {
+		var contact Contact
+		var companyID sql.NullString
+		err := rows.Scan(&contact.ID, &contact.ContactType, &contact.FirstName, &contact.LastName, &contact.Email, &contact.Phone, &contact.PhoneE164, &companyID)
+		if err != nil {
+			return nil, err
+		}
+		if companyID.Valid {
+			contact.CompanyID = &companyID.String
+		}
+		contacts = append(contacts, contact)
+	}
+	return contacts, rows.Err()
+}
+
+func scanCompanies(rows *sql.Rows) ([]Company, error) {
+	defer rows.Close()
+
+	var companies []Company
+	for rows.Next() {
+		var company Company
+		var createdBy sql.NullString
+		err := rows.Scan(&company.ID, &company.Name, &company.BankName, &company.AccountNumber,
+			&company.AccountDocumentPath, &company.RegistrationNumber,
+			&company.RegistrationDocumentPath, &company.CreatedAt, &createdBy)
+		if err != nil {
+			return nil, err
+		}
+		if createdBy.Valid {
+			company.CreatedBy = &createdBy.String
+		}
+		companies = append(companies, company)
+	}
+	return companies, rows.Err()
+}
+
+const (
+	contactColumns = "c.id, c.contact_type, c.first_name, c.last_name, c.email, c.phone, COALESCE(c.phone_e164, ''), c.company_id"
+	companyColumns = `c.id, c.name, c.bank_name, c.account_number, c.account_document_path,
+		c.registration_number, c.registration_document_path, c.created_at, c.created_by`
+)
+
+// contactFilter returns the FROM/WHERE part selecting the contacts matching
+// keyword and the ORDER BY term ranking them, if any. ok is false when the
+// keyword has nothing searchable in it. tag: terms are exact filters on the
+// contact's tags rather than text searches.
+func (db *DB) contactFilter(keyword string) (from string, args []interface{}, rank string, ok bool) {
+	from = `FROM contacts c
+		LEFT JOIN companies comp ON c.company_id = comp.id AND comp.deleted_at IS NULL
+		WHERE c.deleted_at IS NULL`
+	if keyword == "" {
+		return from, nil, "", true
+	}
+
+	var terms []searchTerm
+	var tagFilter string
+	var tagArgs []interface{}
+	for _, t := range parseSearchQuery(keyword, contactSearchFields) {
+		if t.Field == "tag" {
+			tagFilter += " AND c.id IN (SELECT ct.contact_id FROM contact_tags ct JOIN tags t ON t.id = ct.tag_id WHERE t.name = ?)"
+			tagArgs = append(tagArgs, t.Text)
+		} else {
+			terms = append(terms, t)
+		}
+	}
+	if len(terms) == 0 {
+		if tagFilter == "" {
+			return "", nil, "", false
+		}
+		return from + tagFilter, tagArgs, "", true
+	}
+
+	if db.searchIndex {
+		// bm25 weights follow the column order of contacts_fts; names count most
+		return `FROM contacts_fts f
+			JOIN contacts c ON c.id = f.contact_id
+			LEFT JOIN companies comp ON c.company_id = comp.id AND comp.deleted_at IS NULL
+			WHERE contacts_fts MATCH ? AND c.deleted_at IS NULL` + tagFilter,
+			append([]interface{}{ftsMatchExpression(terms, contactSearchFields)}, tagArgs...),
+			"bm25(contacts_fts, 0, 10.0, 10.0, 5.0, 3.0, 1.0, 4.0, 4.0, 2.0, 1.0, 1.0)", true
+	}
+
+	where, args := likeConditions(terms, contactSearchFields, "c.first_name,c.last_name,c.email,c.phone,c.contact_type,comp.name,"+
+		contactEmailsColumn+","+contactPhonesColumn+","+contactAddressesColumn+","+contactCustomColumn)
+	return from + " AND " + where + tagFilter, append(args, tagArgs...), "", true
+}
+
+func (db *DB) companyFilter(keyword string) (from string, args []interface{}, rank string, ok bool) {
+	from = "FROM companies c WHERE c.deleted_at IS NULL"
+	if keyword == "" {
+		return from, nil, "", true
+	}
+
+	terms := parseSearchQuery(keyword, companySearchFields)
+	if len(terms) == 0 {
+		return "", nil, "", false
+	}
+
+	if db.searchIndex {
+		return `FROM companies_fts f
+			JOIN companies c ON c.id = f.company_id
+			WHERE companies_fts MATCH ? AND c.deleted_at IS NULL`,
+			[]interface{}{ftsMatchExpression(terms, companySearchFields)},
+			"bm25(companies_fts, 0, 10.0, 2.0, 3.0, 3.0, 1.0)", true
+	}
+
+	where, args := likeConditions(terms, companySearchFields, "c.name,c.bank_name,c.account_number,c.registration_number,"+companyCustomColumn)
+	return from + " AND " + where, args, "", true
+}
+
+// ListContacts returns one page of the contacts matching keyword (all
+// contacts when empty) together with the total number of matches
+func (db *DB) ListContacts(keyword string, opts ListOptions) ([]Contact, int, error) {
+	from, args, rank, ok := db.contactFilter(keyword)
+	if !ok {
+		return nil, 0, nil
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) "+from, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query("SELECT "+contactColumns+" "+from+
+		" ORDER BY "+opts.orderBy(contactSorts, rank)+opts.limitClause(), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	contacts, err := scanContacts(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := db.attachContactDetails(contacts); err != nil {
+		return nil, 0, err
+	}
+	if err := db.attachContactTags(contacts); err != nil {
+		return nil, 0, err
+	}
+	return contacts, total, db.attachContactCustomValues(contacts)
+}
+
+// ListCompanies is the company counterpart of ListContacts
+func (db *DB) ListCompanies(keyword string, opts ListOptions) ([]Company, int, error) {
+	from, args, rank, ok := db.companyFilter(keyword)
+	if !ok {
+		return nil, 0, nil
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) "+from, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query("SELECT "+companyColumns+" "+from+
+		" ORDER BY "+opts.orderBy(companySorts, rank)+opts.limitClause(), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	companies, err := scanCompanies(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return companies, total, db.attachCompanyCustomValues(companies)
+}
+
+// SearchContacts returns every contact matching keyword, best matches first
+func (db *DB) SearchContacts(keyword string) ([]Contact, error) {
+	if keyword == "" {
+		return nil, nil
+	}
+	contacts, _, err := db.ListContacts(keyword, ListOptions{})
+	return contacts, err
+}
+
+// SearchCompanies returns every company matching keyword, best matches first
+func (db *DB) SearchCompanies(keyword string) ([]Company, error) {
+	if keyword == "" {
+		return nil, nil
+	}
+	companies, _, err := db.ListCompanies(keyword, ListOptions{})
+	return companies, err
+}
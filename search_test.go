@@ -0,0 +1,153 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	got := parseSearchQuery(`john company:acme "van der" type:"work" foo:bar -- `, contactSearchFields)
+	want := []searchTerm{
+		{Text: "john"},
+		{Field: "company", Text: "acme"},
+		{Text: "van der", Phrase: true},
+		{Field: "type", Text: "work", Phrase: true},
+		{Text: "foo:bar"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseSearchQuery = %+v, want %+v", got, want)
+	}
+
+	expr := ftsMatchExpression(got[:3], contactSearchFields)
+	if want := `"john"* AND company : "acme"* AND "van der"`; expr != want {
+		t.Errorf("ftsMatchExpression = %s, want %s", expr, want)
+	}
+
+	// wildcards in a term are matched literally by the LIKE fallback
+	_, args := likeConditions(parseSearchQuery(`email:50%_off\`, contactSearchFields), contactSearchFields, "c.email")
+	if want := `%50\%\_off\\%`; len(args) == 0 || args[0] != want {
+		t.Errorf("likeConditions args = %v, want %s first", args, want)
+	}
+}
+
+// TestSearchContacts runs against the FTS5 index when the test binary was
+// built with -tags sqlite_fts5 and against the LIKE fallback otherwise
+func TestSearchContacts(t *testing.T) {
+	conn := openTestDB(t)
+	if _, err := migrateUp(conn, 0); err != nil {
+		t.Fatalf("migrateUp failed: %v", err)
+	}
+	searchIndex, err := initSearchIndex(conn)
+	if err != nil {
+		t.Fatalf("initSearchIndex failed: %v", err)
+	}
+	testDB := &DB{DB: conn, searchIndex: searchIndex}
+
+	acme, globex := "acme", "globex"
+	for _, c := range []Company{{ID: acme, Name: "Acme Corp"}, {ID: globex, Name: "Globex"}} {
+		if err := testDB.CreateCompany(&c); err != nil {
+			t.Fatalf("CreateCompany failed: %v", err)
+		}
+	}
+	for _, c := range []Contact{
+		{ID: "c1", ContactType: "Work", FirstName: "John", LastName: "Smith", Email: "john@acme.test", Phone: "111", CompanyID: &acme},
+		{ID: "c2", ContactType: "Personal", FirstName: "Johanna", LastName: "Doe", Email: "jo@globex.test", Phone: "222", CompanyID: &globex},
+		{ID: "c3", ContactType: "Work", FirstName: "Mary", LastName: "Johnson", Email: "mary@acme.test", Phone: "333", CompanyID: &acme},
+	} {
+		if err := insertContact(testDB, &c); err != nil {
+			t.Fatalf("insertContact failed: %v", err)
+		}
+	}
+
+	ids := func(q string) []string {
+		t.Helper()
+		contacts, err := testDB.SearchContacts(q)
+		if err != nil {
+			t.Fatalf("SearchContacts(%q) failed: %v", q, err)
+		}
+		var ids []string
+		for _, c := range contacts {
+			ids = append(ids, c.ID)
+		}
+		return ids
+	}
+
+	// prefix matching also finds Johnson, but the exact first name ranks first
+	if got := ids("john acme"); !reflect.DeepEqual(got, []string{"c1", "c3"}) {
+		t.Errorf("john acme = %v, want [c1 c3]", got)
+	}
+	if got := ids("company:acme type:work"); len(got) != 2 {
+		t.Errorf("company:acme type:work = %v, want 2 results", got)
+	}
+	if got := ids("company:globex"); !reflect.DeepEqual(got, []string{"c2"}) {
+		t.Errorf("company:globex = %v, want [c2]", got)
+	}
+
+	// renaming a company must be picked up by contact search
+	if _, err := conn.Exec("UPDATE companies SET name = 'Initech' WHERE id = ?", globex); err != nil {
+		t.Fatalf("Failed to rename company: %v", err)
+	}
+	if got := ids("company:initech"); !reflect.DeepEqual(got, []string{"c2"}) {
+		t.Errorf("company:initech = %v, want [c2]", got)
+	}
+
+	// a company in the recycle bin no longer finds its contacts
+	if err := testDB.DeleteCompany(globex); err != nil {
+		t.Fatalf("DeleteCompany failed: %v", err)
+	}
+	if got := ids("initech"); len(got) != 0 {
+		t.Errorf("initech with the company in the recycle bin = %v, want none", got)
+	}
+	if restored, err := testDB.RestoreCompany(globex); err != nil || !restored {
+		t.Fatalf("RestoreCompany = %t, %v", restored, err)
+	}
+	if got := ids("company:initech"); !reflect.DeepEqual(got, []string{"c2"}) {
+		t.Errorf("company:initech after restoring = %v, want [c2]", got)
+	}
+
+	// paging keeps the sort order across pages and reports the full total
+	var paged []string
+	for page := 1; page <= 2; page++ {
+		contacts, total, err := testDB.ListContacts("", ListOptions{Sort: "name", Desc: true, Page: page, PerPage: 2})
+		if err != nil {
+			t.Fatalf("ListContacts page %d failed: %v", page, err)
+		}
+		if total != 3 {
+			t.Errorf("page %d total = %d, want 3", page, total)
+		}
+		for _, c := range contacts {
+			paged = append(paged, c.ID)
+		}
+	}
+	if want := []string{"c3", "c1", "c2"}; !reflect.DeepEqual(paged, want) {
+		t.Errorf("paged by name desc = %v, want %v", paged, want)
+	}
+
+	// extra details are searchable and follow edits
+	johanna, err := testDB.GetContact("c2")
+	if err != nil {
+		t.Fatalf("GetContact failed: %v", err)
+	}
+	johanna.Emails = []ContactEmail{{Label: "home", Email: "johanna@home.test"}}
+	johanna.Phones = []ContactPhone{{Label: "mobile", Phone: "5550199"}}
+	johanna.Addresses = []ContactAddress{{Label: "home", Street: "742 Evergreen Terrace", City: "Springfield"}}
+	if err := testDB.UpdateContact(johanna); err != nil {
+		t.Fatalf("UpdateContact failed: %v", err)
+	}
+	for _, q := range []string{"email:johanna@home", "phone:5550199", "address:springfield", "evergreen"} {
+		if got := ids(q); !reflect.DeepEqual(got, []string{"c2"}) {
+			t.Errorf("%s = %v, want [c2]", q, got)
+		}
+	}
+	johanna.Addresses = nil
+	if err := testDB.UpdateContact(johanna); err != nil {
+		t.Fatalf("UpdateContact failed: %v", err)
+	}
+	if got := ids("springfield"); len(got) != 0 {
+		t.Errorf("springfield after removing the address = %v, want none", got)
+	}
+	reloaded, _ := testDB.GetContact("c2")
+	if len(reloaded.Emails) != 1 || len(reloaded.Phones) != 1 || len(reloaded.Addresses) != 0 {
+		t.Errorf("reloaded details = %+v", reloaded)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Server lifecycle. On SIGINT or SIGTERM the server stops accepting
+// connections, lets in-flight requests finish and then closes the database.
+// /healthz and /readyz report on the server for container orchestrators.
+
+// draining is set once shutdown begins, so /readyz takes the instance out
+// of rotation while requests finish
+var draining atomic.Bool
+
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       config.Timeouts.Read,
+		WriteTimeout:      config.Timeouts.Write,
+		IdleTimeout:       config.Timeouts.Idle,
+	}
+}
+
+// serve runs the servers until one fails or a signal asks to stop, then
+// shuts them all down gracefully. listen starts a server and blocks.
+func serve(servers []*http.Server, listen func(*http.Server) error) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	failed := make(chan error, len(servers))
+	for _, s := range servers {
+		go func() {
+			if err := listen(s); !errors.Is(err, http.ErrServerClosed) {
+				failed <- fmt.Errorf("%s: %v", s.Addr, err)
+			}
+		}()
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		fmt.Println("Shutting down, waiting for requests to finish")
+	case err = <-failed:
+	}
+	draining.Store(true)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Timeouts.Shutdown)
+	defer cancel()
+	for _, s := range servers {
+		if serr := s.Shutdown(shutdownCtx); serr != nil {
+			fmt.Printf("Warning: Server %s did not shut down cleanly: %v\n", s.Addr, serr)
+		}
+	}
+	return err
+}
+
+// HEALTH CHECKS
+
+type healthReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// runHealthChecks runs the named checks, all of which have to pass
+func runHealthChecks(w http.ResponseWriter, r *http.Request, checks map[string]func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	report := healthReport{Status: "ok", Checks: map[string]string{}}
+	status := http.StatusOK
+	for name, check := range checks {
+		if err := check(ctx); err != nil {
+			report.Checks[name] = err.Error()
+			report.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		} else {
+			report.Checks[name] = "ok"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+func checkDatabase(ctx context.Context) error {
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("database unreachable: %v", err)
+	}
+	var one int
+	if err := db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return fmt.Errorf("database query failed: %v", err)
+	}
+	return nil
+}
+
+func checkUploadDir(ctx context.Context) error {
+	f, err := os.CreateTemp(config.Uploads.Dir, ".healthz-*")
+	if err != nil {
+		return fmt.Errorf("upload directory not writable: %v", err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return nil
+}
+
+func checkNotDraining(ctx context.Context) error {
+	if draining.Load() {
+		return errors.New("shutting down")
+	}
+	return nil
+}
+
+// healthzHandler reports whether the server is alive
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	runHealthChecks(w, r, map[string]func(context.Context) error{
+		"database": checkDatabase,
+	})
+}
+
+// readyzHandler reports whether the server can take requests
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	runHealthChecks(w, r, map[string]func(context.Context) error{
+		"database": checkDatabase,
+		"uploads":  checkUploadDir,
+		"server":   checkNotDraining,
+	})
+}
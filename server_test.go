@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	testDB := useTestDB(t)
+	savedDir := config.Uploads.Dir
+	config.Uploads.Dir = t.TempDir()
+	t.Cleanup(func() {
+		config.Uploads.Dir = savedDir
+		draining.Store(false)
+	})
+
+	check := func(handler http.HandlerFunc, wantCode int, wantFailed string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", "/", nil))
+		var report healthReport
+		if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+			t.Fatalf("invalid report: %v", err)
+		}
+		if w.Code != wantCode {
+			t.Errorf("status = %d, want %d; report %+v", w.Code, wantCode, report)
+		}
+		if wantFailed != "" && report.Checks[wantFailed] == "ok" {
+			t.Errorf("check %s passed, want it to fail: %+v", wantFailed, report)
+		}
+	}
+
+	check(healthzHandler, http.StatusOK, "")
+	check(readyzHandler, http.StatusOK, "")
+
+	config.Uploads.Dir = filepath.Join(t.TempDir(), "missing")
+	check(healthzHandler, http.StatusOK, "")
+	check(readyzHandler, http.StatusServiceUnavailable, "uploads")
+	config.Uploads.Dir = t.TempDir()
+
+	draining.Store(true)
+	check(readyzHandler, http.StatusServiceUnavailable, "server")
+	draining.Store(false)
+
+	testDB.Close()
+	check(healthzHandler, http.StatusServiceUnavailable, "database")
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	sessionCookieName = "session"
+
+	// last_seen_at is only rewritten when it is older than this, so that
+	// every HTMX request doesn't turn into a write
+	sessionTouchInterval = time.Minute
+)
+
+type Session struct {
+	ID         string
+	Username   string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	IPAddress  string
+	UserAgent  string
+	// CSRFToken is echoed by pages on every change, see csrf.go
+	CSRFToken string
+}
+
+type contextKey string
+
+const sessionContextKey contextKey = "session"
+
+// newSessionToken returns a random opaque token for the session cookie. Only
+// its SHA-256 hash is stored, so a leaked database cannot be replayed.
+func newSessionToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate session token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// SESSION HANDLERS
+func (db *DB) CreateSession(username string, r *http.Request) (string, *Session, error) {
+	token, err := newSessionToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	csrfToken, err := newSessionToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now().UTC()
+	session := &Session{
+		ID:         hashSessionToken(token),
+		Username:   username,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(config.Session.Lifetime),
+		IPAddress:  clientIP(r),
+		UserAgent:  r.UserAgent(),
+		CSRFToken:  csrfToken,
+	}
+
+	_, err = db.Exec(`INSERT INTO sessions (id, username, created_at, last_seen_at, expires_at, ip_address, user_agent, csrf_token)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		session.ID, session.Username, session.CreatedAt, session.LastSeenAt, session.ExpiresAt, session.IPAddress, session.UserAgent,
+		session.CSRFToken)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, session, nil
+}
+
+func (db *DB) GetSession(id string) (*Session, error) {
+	var session Session
+	err := db.QueryRow(`SELECT id, username, created_at, last_seen_at, expires_at, ip_address, user_agent, csrf_token
+		FROM sessions WHERE id = ?`, id).Scan(
+		&session.ID, &session.Username, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt,
+		&session.IPAddress, &session.UserAgent, &session.CSRFToken)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("session not found")
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (db *DB) TouchSession(id string, seenAt time.Time) error {
+	_, err := db.Exec("UPDATE sessions SET last_seen_at = ? WHERE id = ?", seenAt, id)
+	return err
+}
+
+func (db *DB) GetUserSessions(username string) ([]Session, error) {
+	rows, err := db.Query(`SELECT id, username, created_at, last_seen_at, expires_at, ip_address, user_agent
+		FROM sessions WHERE username = ? ORDER BY last_seen_at DESC`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(&session.ID, &session.Username, &session.CreatedAt, &session.LastSeenAt,
+			&session.ExpiresAt, &session.IPAddress, &session.UserAgent)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+func (db *DB) DeleteSession(id string) error {
+	_, err := db.Exec("DELETE FROM sessions WHERE id = ?", id)
+	return err
+}
+
+// DeleteUserSessions revokes every session of a user except keepID, which may
+// be empty to revoke them all.
+func (db *DB) DeleteUserSessions(username, keepID string) (int64, error) {
+	result, err := db.Exec("DELETE FROM sessions WHERE username = ? AND id != ?", username, keepID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *DB) DeleteExpiredSessions() (int64, error) {
+	now := time.Now().UTC()
+	result, err := db.Exec("DELETE FROM sessions WHERE expires_at < ? OR last_seen_at < ?",
+		now, now.Add(-config.Session.IdleTimeout))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *Session) expired(now time.Time) bool {
+	return now.After(s.ExpiresAt) || now.Sub(s.LastSeenAt) > config.Session.IdleTimeout
+}
+
+// sessionFromRequest resolves the session cookie against the store. It
+// returns the session cached on the request context when authMiddleware has
+// already done the lookup.
+func sessionFromRequest(r *http.Request) (*Session, error) {
+	if session, ok := r.Context().Value(sessionContextKey).(*Session); ok {
+		return session, nil
+	}
+
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, fmt.Errorf("not authenticated")
+	}
+
+	session, err := db.GetSession(hashSessionToken(cookie.Value))
+	if err != nil {
+		return nil, fmt.Errorf("not authenticated")
+	}
+
+	now := time.Now().UTC()
+	if session.expired(now) {
+		db.DeleteSession(session.ID)
+		return nil, fmt.Errorf("session expired")
+	}
+
+	if now.Sub(session.LastSeenAt) > sessionTouchInterval {
+		if err := db.TouchSession(session.ID, now); err != nil {
+			fmt.Printf("Warning: Failed to update session activity: %v\n", err)
+		}
+		session.LastSeenAt = now
+	}
+	return session, nil
+}
+
+func withSession(r *http.Request, session *Session) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), sessionContextKey, session))
+}
+
+func setSessionCookie(w http.ResponseWriter, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   config.TLSEnabled(),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   config.TLSEnabled(),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// startSessionCleanup periodically purges expired and idle sessions
+func startSessionCleanup(interval time.Duration) {
+	purge := func() {
+		n, err := db.DeleteExpiredSessions()
+		if err != nil {
+			fmt.Printf("Warning: Failed to purge expired sessions: %v\n", err)
+			return
+		}
+		if n > 0 {
+			fmt.Printf("Purged %d expired sessions\n", n)
+		}
+		if _, err := db.DeleteStaleLoginFailures(time.Now().UTC()); err != nil {
+			fmt.Printf("Warning: Failed to purge old failed logins: %v\n", err)
+		}
+		if _, err := db.DeleteExpiredInvites(time.Now().UTC()); err != nil {
+			fmt.Printf("Warning: Failed to purge expired invitations: %v\n", err)
+		}
+	}
+
+	purge()
+	go func() {
+		for range time.Tick(interval) {
+			purge()
+		}
+	}()
+}
+
+var sessionsPageHTML = `
+<!doctype html>
+<html lang="en">
+    <head>
+        <meta charset="UTF-8" />
+        <meta name="viewport" content="width=device-width, initial-scale=1.0" />
+        <title>Active Sessions - AFCB</title>
+        <link rel="icon" type="image/x-icon" href="/static/favicon/favicon.ico" />
+        <script src="https://cdn.tailwindcss.com"></script>
+        <script src="https://unpkg.com/htmx.org@1.9.10"></script>
+        <script src="/static/csrf.js"></script>
+    </head>
+    <body class="bg-gray-100" hx-headers='{"X-CSRF-Token": "{{.CSRFToken}}"}'>
+        <nav class="bg-white shadow-md">
+            <div class="container mx-auto px-4">
+                <div class="flex justify-between items-center py-4">
+                    <a href="/" class="text-2xl font-bold text-blue-600">AFcb</a>
+                    <div class="flex items-center space-x-4">
+                        <a href="/" class="text-gray-600 hover:text-blue-600">Contacts</a>
+                        <a href="/companies-page" class="text-gray-600 hover:text-blue-600">Companies</a>
+                        <a href="/account/sessions" class="text-blue-600 font-semibold">Sessions</a>
+                        <a href="/account/tokens" class="text-gray-600 hover:text-blue-600">API Tokens</a>
+                        <a href="/logout" class="px-4 py-2 bg-red-600 text-white rounded-md hover:bg-red-700">Logout</a>
+                    </div>
+                </div>
+            </div>
+        </nav>
+        <main class="container mx-auto px-4 py-8">
+            <div class="flex justify-between items-center mb-6">
+                <h1 class="text-3xl font-bold text-gray-800">Active Sessions</h1>
+                <button class="bg-red-600 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-red-700 transition-colors duration-300"
+                        hx-post="/account/sessions/revoke-others"
+                        hx-target="#sessions-table-body"
+                        hx-swap="innerHTML"
+                        hx-confirm="Sign out of all other sessions?">
+                    Sign Out Other Sessions
+                </button>
+            </div>
+            <div class="bg-white rounded-lg shadow-md overflow-hidden">
+                <table class="min-w-full divide-y divide-gray-200">
+                    <thead class="bg-gray-50">
+                        <tr>
+                            <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Device</th>
+                            <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">IP Address</th>
+                            <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Signed In</th>
+                            <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Last Active</th>
+                            <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Actions</th>
+                        </tr>
+                    </thead>
+                    <tbody id="sessions-table-body" class="bg-white divide-y divide-gray-200">
+                        {{template "session-rows" .}}
+                    </tbody>
+                </table>
+            </div>
+        </main>
+    </body>
+</html>
+`
+
+var sessionRowsHTML = `
+{{define "session-rows"}}
+{{range .Sessions}}
+<tr id="session-row-{{.ID}}">
+    <td class="px-6 py-4 text-sm text-gray-900 max-w-md truncate" title="{{.UserAgent}}">{{if .UserAgent}}{{.UserAgent}}{{else}}Unknown{{end}}</td>
+    <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-500">{{.IPAddress}}</td>
+    <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-500">{{.CreatedAt.Local.Format "2006-01-02 15:04"}}</td>
+    <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-500">{{.LastSeenAt.Local.Format "2006-01-02 15:04"}}</td>
+    <td class="px-6 py-4 whitespace-nowrap text-sm font-medium">
+        {{if eq .ID $.CurrentID}}
+        <span class="px-3 py-1 rounded-full text-sm font-medium bg-green-100 text-green-800">This session</span>
+        {{else}}
+        <button class="text-red-600 hover:text-red-900"
+                hx-delete="/account/sessions/{{.ID}}"
+                hx-target="#session-row-{{.ID}}"
+                hx-swap="outerHTML"
+                hx-confirm="Revoke this session?">Revoke</button>
+        {{end}}
+    </td>
+</tr>
+{{end}}
+{{end}}
+`
+
+var sessionsPage = template.Must(template.Must(template.New("sessions").Parse(sessionRowsHTML)).Parse(sessionsPageHTML))
+
+func renderSessions(w http.ResponseWriter, r *http.Request, name string) {
+	session, err := sessionFromRequest(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	sessions, err := db.GetUserSessions(session.Username)
+	if err != nil {
+		http.Error(w, "Failed to fetch sessions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Sessions  []Session
+		CurrentID string
+		CSRFToken string
+	}{
+		Sessions:  sessions,
+		CurrentID: session.ID,
+		CSRFToken: session.CSRFToken,
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := sessionsPage.ExecuteTemplate(w, name, data); err != nil {
+		fmt.Printf("Error rendering sessions: %v\n", err)
+	}
+}
+
+func sessionsPageHandler(w http.ResponseWriter, r *http.Request) {
+	renderSessions(w, r, "sessions")
+}
+
+func revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	current, err := sessionFromRequest(r)
+	if err != nil {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	target, err := db.GetSession(id)
+	if err != nil || target.Username != current.Username {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	if err := db.DeleteSession(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, AuditDelete, "session", current.Username, map[string]interface{}{"ip": target.IPAddress, "user_agent": target.UserAgent}, nil)
+
+	// Return empty content - HTMX will remove element
+	w.WriteHeader(http.StatusOK)
+}
+
+func revokeOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	current, err := sessionFromRequest(r)
+	if err != nil {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	n, err := db.DeleteUserSessions(current.Username, current.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("Revoked %d other sessions for user: %s\n", n, current.Username)
+	recordAudit(r, AuditDelete, "session", current.Username, map[string]interface{}{"sessions": n}, nil)
+
+	renderSessions(w, r, "session-rows")
+}
+
+// revokeUserSessionsHandler lets an admin sign a user out everywhere
+func revokeUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+	n, err := db.DeleteUserSessions(username, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("Admin revoked %d sessions for user: %s\n", n, username)
+	recordAudit(r, AuditDelete, "session", username, map[string]interface{}{"sessions": n}, nil)
+
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprintf(w, `<span class="text-green-600 text-sm">Revoked %d sessions</span>`, n)
+}
@@ -0,0 +1,9 @@
+// The server answers a change without a valid CSRF token with 403 and a
+// notice to show; HTMX does not swap error responses unless told to.
+document.addEventListener("htmx:beforeSwap", function (evt) {
+    var xhr = evt.detail.xhr;
+    if (xhr.status === 403 && xhr.getResponseHeader("X-CSRF-Error")) {
+        evt.detail.shouldSwap = true;
+        evt.detail.isError = false;
+    }
+});
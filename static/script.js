@@ -40,3 +40,38 @@ function downloadContactPDF(contactId) {
     document.body.removeChild(iframe);
   }, 2000);
 }
+
+// Download the contacts matching the current search
+function exportContacts(select) {
+  const format = select.value;
+  if (!format) return;
+  select.value = "";
+
+  const search = document.getElementById("search-input");
+  const params = new URLSearchParams({ format: format });
+  if (search && search.value) {
+    params.set("q", search.value);
+  }
+  document.querySelectorAll("#contact-sort select").forEach((sort) => {
+    if (sort.value) {
+      params.set(sort.name, sort.value);
+    }
+  });
+  window.location = `/contacts/export?${params}`;
+}
+
+// Append a blank email, phone or address row to a contact form
+function addDetailRow(templateId, containerId) {
+  const template = document.getElementById(templateId);
+  const container = document.getElementById(containerId);
+  if (!template || !container) return;
+  container.appendChild(template.content.cloneNode(true));
+}
+
+// Run a search from the sidebar as if it had been typed
+function applySearch(query) {
+  const search = document.getElementById("search-input");
+  if (!search) return;
+  search.value = query;
+  htmx.trigger(search, "search");
+}
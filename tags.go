@@ -0,0 +1,550 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Tags. Admins manage the tag list and colors on /admin/tags; contacts carry
+// any number of them by name in Contact.Tags. Searching for tag:name keeps
+// only the contacts with that tag.
+
+type Tag struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+// badgeColors are the colors offered for tags and contact types, mapped to
+// the classes of their badges
+var badgeColors = []string{"gray", "red", "orange", "yellow", "green", "teal", "blue", "indigo", "purple", "pink"}
+
+var badgeColorClasses = map[string]string{
+	"gray":   "bg-gray-100 text-gray-800",
+	"red":    "bg-red-100 text-red-800",
+	"orange": "bg-orange-100 text-orange-800",
+	"yellow": "bg-yellow-100 text-yellow-800",
+	"green":  "bg-green-100 text-green-800",
+	"teal":   "bg-teal-100 text-teal-800",
+	"blue":   "bg-blue-100 text-blue-800",
+	"indigo": "bg-indigo-100 text-indigo-800",
+	"purple": "bg-purple-100 text-purple-800",
+	"pink":   "bg-pink-100 text-pink-800",
+}
+
+func badgeClasses(color string) string {
+	if classes, ok := badgeColorClasses[color]; ok {
+		return classes
+	}
+	return badgeColorClasses["gray"]
+}
+
+// Classes returns the Tailwind classes of the tag's chip
+func (t Tag) Classes() string {
+	return badgeClasses(t.Color)
+}
+
+// validateTag checks a tag definition from the admin page
+func validateTag(t *Tag) error {
+	t.Name = strings.Join(strings.Fields(t.Name), " ")
+	if t.Name == "" {
+		return &ValidationError{Field: "name", Message: "Tag name is required"}
+	}
+	if len(t.Name) > 40 {
+		return &ValidationError{Field: "name", Message: "Tag names are at most 40 characters"}
+	}
+	if !hasSearchableRune(t.Name) {
+		return &ValidationError{Field: "name", Message: "Tag name needs at least one letter or digit"}
+	}
+	if _, ok := badgeColorClasses[t.Color]; !ok {
+		return &ValidationError{Field: "color", Message: "Unknown tag color"}
+	}
+	return nil
+}
+
+// validateContactTags checks that every tag of c exists, replacing the
+// names by their stored spelling and dropping duplicates
+func validateContactTags(c *Contact) error {
+	if len(c.Tags) == 0 {
+		return nil
+	}
+	tags, err := db.ListTags()
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]string, len(tags))
+	for _, t := range tags {
+		byName[strings.ToLower(t.Name)] = t.Name
+	}
+
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range c.Tags {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		stored, ok := byName[strings.ToLower(name)]
+		if !ok {
+			return &ValidationError{Field: "tags", Message: fmt.Sprintf("Unknown tag %q", name)}
+		}
+		if !seen[stored] {
+			seen[stored] = true
+			names = append(names, stored)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return strings.ToLower(names[i]) < strings.ToLower(names[j]) })
+	c.Tags = names
+	return nil
+}
+
+// contactTagsFromForm reads the tag checkboxes. The forms always post an
+// empty Tags value, so a form without it leaves the tags alone.
+func contactTagsFromForm(r *http.Request, c *Contact) {
+	posted, ok := r.Form["Tags"]
+	if !ok {
+		return
+	}
+	c.Tags = nil
+	for _, name := range posted {
+		if name != "" {
+			c.Tags = append(c.Tags, name)
+		}
+	}
+}
+
+// DATABASE
+
+func (db *DB) ListTags() ([]Tag, error) {
+	rows, err := db.Query("SELECT id, name, color FROM tags ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []Tag
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.ID, &t.Name, &t.Color); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+func (db *DB) GetTag(id int64) (*Tag, error) {
+	var t Tag
+	err := db.QueryRow("SELECT id, name, color FROM tags WHERE id = ?", id).Scan(&t.ID, &t.Name, &t.Color)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (db *DB) CreateTag(t *Tag) error {
+	result, err := db.Exec("INSERT INTO tags (name, color, created_at) VALUES (?, ?, ?)", t.Name, t.Color, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	t.ID, err = result.LastInsertId()
+	return err
+}
+
+func (db *DB) UpdateTagColor(id int64, color string) error {
+	_, err := db.Exec("UPDATE tags SET color = ? WHERE id = ?", color, id)
+	return err
+}
+
+// DeleteTag removes a tag from every contact and then the tag itself
+func (db *DB) DeleteTag(id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM contact_tags WHERE tag_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM tags WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertContactTags(ex execer, c *Contact) error {
+	for _, name := range c.Tags {
+		if _, err := ex.Exec(`INSERT OR IGNORE INTO contact_tags (contact_id, tag_id)
+			SELECT ?, id FROM tags WHERE name = ?`, c.ID, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteContactTags(ex execer, contactID string) error {
+	_, err := ex.Exec("DELETE FROM contact_tags WHERE contact_id = ?", contactID)
+	return err
+}
+
+// attachContactTags loads the tag names of contacts
+func (db *DB) attachContactTags(contacts []Contact) error {
+	byID := map[string]*Contact{}
+	ids := make([]interface{}, 0, len(contacts))
+	for i := range contacts {
+		contacts[i].Tags = nil
+		byID[contacts[i].ID] = &contacts[i]
+		ids = append(ids, contacts[i].ID)
+	}
+
+	// stay well below SQLite's limit on bound parameters
+	const chunkSize = 500
+	for start := 0; start < len(ids); start += chunkSize {
+		chunk := ids[start:min(start+chunkSize, len(ids))]
+		rows, err := db.Query(`SELECT ct.contact_id, t.name FROM contact_tags ct
+			JOIN tags t ON t.id = ct.tag_id
+			WHERE ct.contact_id IN (?`+strings.Repeat(", ?", len(chunk)-1)+`)
+			ORDER BY t.name`, chunk...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id, name string
+			if err := rows.Scan(&id, &name); err != nil {
+				rows.Close()
+				return err
+			}
+			byID[id].Tags = append(byID[id].Tags, name)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DISPLAY
+
+// loadTags is ListTags for rendering, where a failure only hides the tags
+func loadTags() []Tag {
+	tags, err := db.ListTags()
+	if err != nil {
+		fmt.Printf("Warning: Failed to load tags: %v\n", err)
+	}
+	return tags
+}
+
+// contactTagList returns the tags named in names, with their colors
+func contactTagList(tags []Tag, names []string) []Tag {
+	var list []Tag
+	for _, name := range names {
+		for _, t := range tags {
+			if t.Name == name {
+				list = append(list, t)
+				break
+			}
+		}
+	}
+	return list
+}
+
+// tagOption is a tag in the contact forms
+type tagOption struct {
+	Tag
+	Checked bool
+}
+
+func tagOptions(tags []Tag, names []string) []tagOption {
+	options := make([]tagOption, len(tags))
+	for i, t := range tags {
+		options[i].Tag = t
+		for _, name := range names {
+			options[i].Checked = options[i].Checked || name == t.Name
+		}
+	}
+	return options
+}
+
+// tagsHTML renders the tag chips of a card and the tag checkboxes of the
+// contact modals
+var tagsHTML = `
+{{define "tag-chips"}}
+{{range .}}<span class="inline-block mt-2 mr-1 px-2 py-0.5 rounded-full text-xs font-medium {{.Classes}}">{{.Name}}</span>{{end}}
+{{end}}
+
+{{define "contact-tags"}}
+{{if .}}
+<div class="mb-4">
+    <span class="block text-gray-700 text-sm font-bold mb-2">Tags</span>
+    <input type="hidden" name="Tags" value="">
+    <div class="flex flex-wrap gap-2">
+        {{range .}}
+        <label class="inline-flex items-center px-2 py-1 rounded-full text-xs font-medium cursor-pointer {{.Classes}}">
+            <input type="checkbox" name="Tags" value="{{.Name}}" class="mr-1" {{if .Checked}}checked{{end}}>{{.Name}}
+        </label>
+        {{end}}
+    </div>
+</div>
+{{end}}
+{{end}}
+`
+
+var tagTemplates = template.Must(template.New("tags").Parse(tagsHTML))
+
+// renderTagChips renders the chips of the given tags for the contact card
+func renderTagChips(tags []Tag) template.HTML {
+	var b strings.Builder
+	if err := tagTemplates.ExecuteTemplate(&b, "tag-chips", tags); err != nil {
+		fmt.Printf("Error rendering tags: %v\n", err)
+	}
+	return template.HTML(b.String())
+}
+
+// ADMIN PAGE
+
+var tagsPage = template.Must(template.New("tags-page").Funcs(template.FuncMap{
+	"colors": func() []string { return badgeColors },
+}).Parse(`
+{{define "tags-page"}}
+<!doctype html>
+<html lang="en">
+    <head>
+        <meta charset="UTF-8" />
+        <meta name="viewport" content="width=device-width, initial-scale=1.0" />
+        <title>Tags - AFCB</title>
+        <link rel="icon" type="image/x-icon" href="/static/favicon/favicon.ico" />
+        <script src="https://cdn.tailwindcss.com"></script>
+        <script src="https://unpkg.com/htmx.org@1.9.10"></script>
+        <script src="/static/csrf.js"></script>
+    </head>
+    <body class="bg-gray-100" hx-headers='{"X-CSRF-Token": "{{.CSRFToken}}"}'>
+        <nav class="bg-white shadow-md">
+            <div class="container mx-auto px-4">
+                <div class="flex justify-between items-center py-4">
+                    <a href="/" class="text-2xl font-bold text-blue-600">AFcb</a>
+                    <div class="flex items-center space-x-4">
+                        <a href="/" class="text-gray-600 hover:text-blue-600">Contacts</a>
+                        <a href="/companies-page" class="text-gray-600 hover:text-blue-600">Companies</a>
+                        <a href="/admin/users" class="text-gray-600 hover:text-blue-600">Users</a>
+                        <a href="/admin/fields" class="text-gray-600 hover:text-blue-600">Fields</a>
+                        <a href="/admin/tags" class="text-blue-600 font-semibold">Tags</a>
+                        <a href="/admin/contact-types" class="text-gray-600 hover:text-blue-600">Types</a>
+                        <a href="/admin/duplicates" class="text-gray-600 hover:text-blue-600">Duplicates</a>
+                        <a href="/admin/audit" class="text-gray-600 hover:text-blue-600">Audit Log</a>
+                        <a href="/admin/license" class="text-gray-600 hover:text-blue-600">Admin</a>
+                        <a href="/logout" class="px-4 py-2 bg-red-600 text-white rounded-md hover:bg-red-700">Logout</a>
+                    </div>
+                </div>
+            </div>
+        </nav>
+        <main class="container mx-auto px-4 py-8">
+            <h1 class="text-3xl font-bold text-gray-800 mb-6">Tags</h1>
+            <form class="bg-white rounded-lg shadow-md p-4 mb-6 flex flex-wrap items-end gap-4"
+                  hx-post="/admin/tags"
+                  hx-target="#tags"
+                  hx-swap="innerHTML"
+                  hx-on::after-request="if(event.detail.successful) this.reset()">
+                <label class="text-sm text-gray-700">Name
+                    <input type="text" name="name" required maxlength="40" placeholder="VIP" class="block border rounded py-1 px-2">
+                </label>
+                <label class="text-sm text-gray-700">Color
+                    <select name="color" class="block border rounded py-1 px-2">
+                        {{range colors}}<option value="{{.}}">{{.}}</option>{{end}}
+                    </select>
+                </label>
+                <button type="submit" class="px-4 py-2 bg-blue-600 text-white rounded-md hover:bg-blue-700">Add Tag</button>
+            </form>
+            <div id="tags">
+                {{template "tags-list" .}}
+            </div>
+        </main>
+    </body>
+</html>
+{{end}}
+
+{{define "tags-list"}}
+{{if .Error}}<div class="bg-red-50 border border-red-200 text-red-700 rounded p-3 mb-4">{{.Error}}</div>{{end}}
+<div class="bg-white rounded-lg shadow-md overflow-hidden">
+    <table class="min-w-full divide-y divide-gray-200">
+        <thead class="bg-gray-50">
+            <tr>
+                <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Tag</th>
+                <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Color</th>
+                <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Contacts</th>
+                <th class="px-6 py-3"></th>
+            </tr>
+        </thead>
+        <tbody class="bg-white divide-y divide-gray-200">
+            {{range .Tags}}
+            <tr>
+                <td class="px-6 py-3"><span class="px-2 py-0.5 rounded-full text-xs font-medium {{.Classes}}">{{.Name}}</span></td>
+                <td class="px-6 py-3 text-sm">
+                    {{$color := .Color}}
+                    <select name="color" class="border rounded py-1 px-2"
+                            hx-put="/admin/tags/{{.ID}}"
+                            hx-target="#tags"
+                            hx-swap="innerHTML">
+                        {{range colors}}<option value="{{.}}" {{if eq . $color}}selected{{end}}>{{.}}</option>{{end}}
+                    </select>
+                </td>
+                <td class="px-6 py-3 text-sm text-gray-500">{{index $.Counts .ID}}</td>
+                <td class="px-6 py-3 text-sm text-right">
+                    <button class="text-red-600 hover:text-red-900"
+                            hx-delete="/admin/tags/{{.ID}}"
+                            hx-target="#tags"
+                            hx-swap="innerHTML"
+                            hx-confirm="Delete the tag {{.Name}} and remove it from every contact?">Delete</button>
+                </td>
+            </tr>
+            {{else}}
+            <tr><td colspan="4" class="px-6 py-4 text-center text-gray-500">No tags yet</td></tr>
+            {{end}}
+        </tbody>
+    </table>
+</div>
+{{end}}
+`))
+
+// tagCounts returns the number of contacts carrying each tag by tag id,
+// leaving out the recycle bin
+func (db *DB) tagCounts() (map[int64]int, error) {
+	rows, err := db.Query(`SELECT ct.tag_id, COUNT(*) FROM contact_tags ct
+		JOIN contacts c ON c.id = ct.contact_id AND c.deleted_at IS NULL
+		GROUP BY ct.tag_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[int64]int{}
+	for rows.Next() {
+		var id int64
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		counts[id] = n
+	}
+	return counts, rows.Err()
+}
+
+func renderTags(w http.ResponseWriter, r *http.Request, name, errMessage string) {
+	tags, err := db.ListTags()
+	var counts map[int64]int
+	if err == nil {
+		counts, err = db.tagCounts()
+	}
+	if err != nil {
+		http.Error(w, "Failed to load tags: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Tags      []Tag
+		Counts    map[int64]int
+		Error     string
+		CSRFToken string
+	}{tags, counts, errMessage, csrfToken(r)}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := tagsPage.ExecuteTemplate(w, name, data); err != nil {
+		fmt.Printf("Error rendering tags: %v\n", err)
+	}
+}
+
+func tagsPageHandler(w http.ResponseWriter, r *http.Request) {
+	renderTags(w, r, "tags-page", "")
+}
+
+func createTagHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	tag := &Tag{Name: r.FormValue("name"), Color: r.FormValue("color")}
+	err := validateTag(tag)
+	if err == nil {
+		err = db.CreateTag(tag)
+		if isUniqueViolation(err) {
+			err = fmt.Errorf("a tag named %s already exists", tag.Name)
+		}
+	}
+	if err != nil {
+		renderTags(w, r, "tags-list", err.Error())
+		return
+	}
+
+	recordAudit(r, AuditCreate, "tag", strconv.FormatInt(tag.ID, 10), nil, tag)
+	fmt.Printf("Tag %s created\n", tag.Name)
+	renderTags(w, r, "tags-list", "")
+}
+
+// tagFromRequest loads the tag named by the {id} route variable
+func tagFromRequest(w http.ResponseWriter, r *http.Request) (*Tag, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Tag not found", http.StatusNotFound)
+		return nil, false
+	}
+	tag, err := db.GetTag(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Tag not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return tag, true
+}
+
+func updateTagHandler(w http.ResponseWriter, r *http.Request) {
+	tag, ok := tagFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	before := *tag
+	tag.Color = r.FormValue("color")
+	if err := validateTag(tag); err != nil {
+		renderTags(w, r, "tags-list", err.Error())
+		return
+	}
+	if err := db.UpdateTagColor(tag.ID, tag.Color); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, AuditUpdate, "tag", strconv.FormatInt(tag.ID, 10), &before, tag)
+	renderTags(w, r, "tags-list", "")
+}
+
+func deleteTagHandler(w http.ResponseWriter, r *http.Request) {
+	tag, ok := tagFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := db.DeleteTag(tag.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, AuditDelete, "tag", strconv.FormatInt(tag.ID, 10), tag, nil)
+	fmt.Printf("Tag %s deleted\n", tag.Name)
+	renderTags(w, r, "tags-list", "")
+}
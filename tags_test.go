@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestContactTags(t *testing.T) {
+	testDB := useTestDB(t)
+
+	for _, tag := range []Tag{{Name: "VIP", Color: "yellow"}, {Name: "Key  account", Color: "blue"}} {
+		if err := validateTag(&tag); err != nil {
+			t.Fatalf("validateTag(%s) failed: %v", tag.Name, err)
+		}
+		if err := testDB.CreateTag(&tag); err != nil {
+			t.Fatalf("CreateTag(%s) failed: %v", tag.Name, err)
+		}
+	}
+	if err := testDB.CreateTag(&Tag{Name: "vip", Color: "red"}); !isUniqueViolation(err) {
+		t.Errorf("CreateTag with a name differing in case = %v, want a unique violation", err)
+	}
+
+	// tags are matched case-insensitively and stored with their own spelling
+	john := Contact{ID: "c1", ContactType: "Work", FirstName: "John", LastName: "Smith", Email: "john@acme.test", Phone: "111",
+		Tags: []string{"vip", "key account", "VIP"}}
+	if err := validateContactTags(&john); err != nil {
+		t.Fatalf("validateContactTags failed: %v", err)
+	}
+	if want := []string{"Key account", "VIP"}; !reflect.DeepEqual(john.Tags, want) {
+		t.Errorf("validated tags = %v, want %v", john.Tags, want)
+	}
+	if err := validateContactTags(&Contact{Tags: []string{"unknown"}}); err == nil {
+		t.Errorf("an unknown tag was accepted")
+	}
+
+	mary := Contact{ID: "c2", ContactType: "Work", FirstName: "Mary", LastName: "Johnson", Email: "mary@acme.test", Phone: "222",
+		Tags: []string{"VIP"}}
+	for _, c := range []*Contact{&john, &mary} {
+		if err := insertContact(testDB, c); err != nil {
+			t.Fatalf("insertContact failed: %v", err)
+		}
+	}
+
+	ids := func(q string) []string {
+		t.Helper()
+		contacts, err := testDB.SearchContacts(q)
+		if err != nil {
+			t.Fatalf("SearchContacts(%q) failed: %v", q, err)
+		}
+		var ids []string
+		for _, c := range contacts {
+			ids = append(ids, c.ID)
+		}
+		return ids
+	}
+	if got := ids("tag:vip"); len(got) != 2 {
+		t.Errorf("tag:vip = %v, want both contacts", got)
+	}
+	if got := ids(`tag:"key account" tag:vip`); !reflect.DeepEqual(got, []string{"c1"}) {
+		t.Errorf("both tags = %v, want [c1]", got)
+	}
+	if got := ids("smith tag:vip"); !reflect.DeepEqual(got, []string{"c1"}) {
+		t.Errorf("smith tag:vip = %v, want [c1]", got)
+	}
+	if got := ids("tag:vi"); len(got) != 0 {
+		t.Errorf("tag:vi = %v, want no prefix matches", got)
+	}
+
+	// deleting a tag removes it from its contacts
+	tags, err := testDB.ListTags()
+	if err != nil || len(tags) != 2 || tags[1].Name != "VIP" {
+		t.Fatalf("ListTags = %v, %v", tags, err)
+	}
+	if err := testDB.DeleteTag(tags[1].ID); err != nil {
+		t.Fatalf("DeleteTag failed: %v", err)
+	}
+	reloaded, err := testDB.GetContact("c1")
+	if err != nil {
+		t.Fatalf("GetContact failed: %v", err)
+	}
+	if want := []string{"Key account"}; !reflect.DeepEqual(reloaded.Tags, want) {
+		t.Errorf("tags after deleting VIP = %v, want %v", reloaded.Tags, want)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnsureSelfSignedCert(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls", "cert.pem"), filepath.Join(dir, "tls", "key.pem")
+	hosts := []string{"localhost", "127.0.0.1", "crm.office.test"}
+
+	if err := ensureSelfSignedCert(certFile, keyFile, hosts); err != nil {
+		t.Fatalf("ensureSelfSignedCert failed: %v", err)
+	}
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("generated files do not load: %v", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range hosts {
+		if err := cert.VerifyHostname(h); err != nil {
+			t.Errorf("certificate not valid for %s: %v", h, err)
+		}
+	}
+	if info, _ := os.Stat(keyFile); info.Mode().Perm() != 0600 {
+		t.Errorf("key file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// a valid certificate is kept
+	before, _ := os.ReadFile(certFile)
+	if err := ensureSelfSignedCert(certFile, keyFile, hosts); err != nil {
+		t.Fatalf("second ensureSelfSignedCert failed: %v", err)
+	}
+	if after, _ := os.ReadFile(certFile); string(after) != string(before) {
+		t.Errorf("existing certificate was replaced")
+	}
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		listen, host, url, want string
+	}{
+		{":1330", "crm.office.test:8080", "/contacts?q=a", "https://crm.office.test:1330/contacts?q=a"},
+		{":443", "crm.office.test", "/", "https://crm.office.test/"},
+		{"0.0.0.0:443", "[::1]:80", "/login", "https://[::1]/login"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.url, nil)
+		r.Host = tt.host
+		w := httptest.NewRecorder()
+		httpsRedirectHandler(tt.listen).ServeHTTP(w, r)
+		if got := w.Header().Get("Location"); w.Code != http.StatusMovedPermanently || got != tt.want {
+			t.Errorf("redirect of %s%s = %d %q, want %q", tt.host, tt.url, w.Code, got, tt.want)
+		}
+	}
+}
+
+func TestHSTSMiddleware(t *testing.T) {
+	h := hstsMiddleware(config.TLS.HSTSMaxAge)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://crm.office.test/", nil))
+	if got := w.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("HSTS over plain HTTP = %q, want none", got)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "https://crm.office.test/", nil))
+	if got := w.Header().Get("Strict-Transport-Security"); got != "max-age=31536000" {
+		t.Errorf("HSTS over HTTPS = %q", got)
+	}
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Personal access tokens let scripts call the app with an
+// "Authorization: Bearer" header instead of the session cookie. A token never
+// grants more than its owner's role; its scopes can only narrow that down.
+
+const (
+	apiTokenPrefix = "afcb_"
+
+	// last_used_at is throttled the same way as session activity
+	apiTokenTouchInterval = time.Minute
+)
+
+type TokenScope string
+
+const (
+	ScopeContactsRead   TokenScope = "contacts:read"
+	ScopeContactsWrite  TokenScope = "contacts:write"
+	ScopeCompaniesRead  TokenScope = "companies:read"
+	ScopeCompaniesWrite TokenScope = "companies:write"
+)
+
+type scopeInfo struct {
+	Scope       TokenScope
+	Label       string
+	Description string
+}
+
+// tokenScopes lists the scopes in the order they are offered in the UI
+var tokenScopes = []scopeInfo{
+	{ScopeContactsRead, "Contacts: read", "List, search and export contacts"},
+	{ScopeContactsWrite, "Contacts: read & write", "Create, edit and delete contacts"},
+	{ScopeCompaniesRead, "Companies: read", "List and search companies"},
+	{ScopeCompaniesWrite, "Companies: read & write", "Create, edit and delete companies"},
+}
+
+// scopePermissions maps each scope to the permissions it unlocks. User and
+// license administration are deliberately not reachable with a token.
+var scopePermissions = map[TokenScope][]Permission{
+	ScopeContactsRead:   {PermViewContacts},
+	ScopeContactsWrite:  {PermViewContacts, PermEditContacts, PermEditOwnContact},
+	ScopeCompaniesRead:  {PermViewCompanies},
+	ScopeCompaniesWrite: {PermViewCompanies, PermManageCompanies},
+}
+
+func (s TokenScope) Valid() bool {
+	_, ok := scopePermissions[s]
+	return ok
+}
+
+type APIToken struct {
+	ID         int64        `json:"id"`
+	Username   string       `json:"username"`
+	Name       string       `json:"name"`
+	Hash       string       `json:"-"`
+	Prefix     string       `json:"prefix"`
+	Scopes     []TokenScope `json:"scopes"`
+	CreatedAt  time.Time    `json:"created_at"`
+	LastUsedAt *time.Time   `json:"last_used_at"`
+	ExpiresAt  *time.Time   `json:"expires_at"`
+}
+
+func (t *APIToken) Allows(p Permission) bool {
+	for _, scope := range t.Scopes {
+		for _, granted := range scopePermissions[scope] {
+			if granted == p {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (t *APIToken) HasScope(s TokenScope) bool {
+	for _, scope := range t.Scopes {
+		if scope == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *APIToken) expired(now time.Time) bool {
+	return t.ExpiresAt != nil && now.After(*t.ExpiresAt)
+}
+
+const apiTokenContextKey contextKey = "api-token"
+
+func withAPIToken(r *http.Request, token *APIToken) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), apiTokenContextKey, token))
+}
+
+// apiTokenFromContext returns the token a request was authenticated with, or
+// nil for cookie sessions
+func apiTokenFromContext(r *http.Request) *APIToken {
+	token, _ := r.Context().Value(apiTokenContextKey).(*APIToken)
+	return token
+}
+
+// newAPIToken returns a fresh secret. The prefix makes leaked tokens easy to
+// recognise in logs and secret scanners.
+func newAPIToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate API token: %w", err)
+	}
+	return apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func encodeScopes(scopes []TokenScope) string {
+	parts := make([]string, len(scopes))
+	for i, s := range scopes {
+		parts[i] = string(s)
+	}
+	return strings.Join(parts, " ")
+}
+
+func decodeScopes(s string) []TokenScope {
+	var scopes []TokenScope
+	for _, part := range strings.Fields(s) {
+		scopes = append(scopes, TokenScope(part))
+	}
+	return scopes
+}
+
+// API TOKEN HANDLERS
+func (db *DB) CreateAPIToken(username, name string, scopes []TokenScope, expiresAt *time.Time) (string, *APIToken, error) {
+	secret, err := newAPIToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	token := &APIToken{
+		Username:  username,
+		Name:      name,
+		Hash:      hashSessionToken(secret),
+		Prefix:    secret[:len(apiTokenPrefix)+6],
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}
+
+	result, err := db.Exec(`INSERT INTO api_tokens (username, name, token_hash, prefix, scopes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		token.Username, token.Name, token.Hash, token.Prefix, encodeScopes(token.Scopes), token.CreatedAt, token.ExpiresAt)
+	if err != nil {
+		return "", nil, err
+	}
+	token.ID, _ = result.LastInsertId()
+	return secret, token, nil
+}
+
+const apiTokenColumns = "id, username, name, token_hash, prefix, scopes, created_at, last_used_at, expires_at"
+
+func scanAPIToken(scan func(dest ...interface{}) error) (*APIToken, error) {
+	var token APIToken
+	var scopes string
+	var lastUsedAt, expiresAt sql.NullTime
+	if err := scan(&token.ID, &token.Username, &token.Name, &token.Hash, &token.Prefix, &scopes,
+		&token.CreatedAt, &lastUsedAt, &expiresAt); err != nil {
+		return nil, err
+	}
+	token.Scopes = decodeScopes(scopes)
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	if expiresAt.Valid {
+		token.ExpiresAt = &expiresAt.Time
+	}
+	return &token, nil
+}
+
+func (db *DB) GetAPITokenByHash(hash string) (*APIToken, error) {
+	token, err := scanAPIToken(db.QueryRow("SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = ?", hash).Scan)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("token not found")
+	}
+	return token, err
+}
+
+func (db *DB) GetUserAPITokens(username string) ([]APIToken, error) {
+	rows, err := db.Query("SELECT "+apiTokenColumns+" FROM api_tokens WHERE username = ? ORDER BY created_at DESC", username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		token, err := scanAPIToken(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, nil
+}
+
+func (db *DB) TouchAPIToken(id int64, usedAt time.Time) error {
+	_, err := db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", usedAt, id)
+	return err
+}
+
+// DeleteAPIToken revokes a token, but only if it belongs to username
+func (db *DB) DeleteAPIToken(id int64, username string) (bool, error) {
+	result, err := db.Exec("DELETE FROM api_tokens WHERE id = ? AND username = ?", id, username)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// bearerToken extracts the secret from an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(auth[7:]), true
+}
+
+// authenticateBearer resolves the bearer token of r to its token record and
+// owner. The caller decides how to report failures.
+func authenticateBearer(r *http.Request) (*APIToken, *User, error) {
+	secret, ok := bearerToken(r)
+	if !ok || !strings.HasPrefix(secret, apiTokenPrefix) {
+		return nil, nil, fmt.Errorf("invalid token")
+	}
+
+	token, err := db.GetAPITokenByHash(hashSessionToken(secret))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid token")
+	}
+
+	now := time.Now().UTC()
+	if token.expired(now) {
+		return nil, nil, fmt.Errorf("token expired")
+	}
+
+	user, err := db.GetUser(token.Username)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid token")
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > apiTokenTouchInterval {
+		if err := db.TouchAPIToken(token.ID, now); err != nil {
+			fmt.Printf("Warning: Failed to update token usage: %v\n", err)
+		}
+		token.LastUsedAt = &now
+	}
+	return token, user, nil
+}
+
+var apiTokensPageHTML = `
+<!doctype html>
+<html lang="en">
+    <head>
+        <meta charset="UTF-8" />
+        <meta name="viewport" content="width=device-width, initial-scale=1.0" />
+        <title>API Tokens - AFCB</title>
+        <link rel="icon" type="image/x-icon" href="/static/favicon/favicon.ico" />
+        <script src="https://cdn.tailwindcss.com"></script>
+        <script src="https://unpkg.com/htmx.org@1.9.10"></script>
+        <script src="/static/csrf.js"></script>
+    </head>
+    <body class="bg-gray-100" hx-headers='{"X-CSRF-Token": "{{.CSRFToken}}"}'>
+        <nav class="bg-white shadow-md">
+            <div class="container mx-auto px-4">
+                <div class="flex justify-between items-center py-4">
+                    <a href="/" class="text-2xl font-bold text-blue-600">AFcb</a>
+                    <div class="flex items-center space-x-4">
+                        <a href="/" class="text-gray-600 hover:text-blue-600">Contacts</a>
+                        <a href="/companies-page" class="text-gray-600 hover:text-blue-600">Companies</a>
+                        <a href="/account/sessions" class="text-gray-600 hover:text-blue-600">Sessions</a>
+                        <a href="/account/tokens" class="text-blue-600 font-semibold">API Tokens</a>
+                        <a href="/logout" class="px-4 py-2 bg-red-600 text-white rounded-md hover:bg-red-700">Logout</a>
+                    </div>
+                </div>
+            </div>
+        </nav>
+        <main class="container mx-auto px-4 py-8">
+            <h1 class="text-3xl font-bold text-gray-800 mb-6">API Tokens</h1>
+            <div class="bg-white rounded-lg shadow-md p-6 mb-8">
+                <h2 class="text-xl font-semibold text-gray-800 mb-4">New Token</h2>
+                <form hx-post="/account/tokens" hx-target="#new-token" hx-swap="innerHTML"
+                      hx-on::after-request="if(event.detail.successful) this.reset()">
+                    <div class="mb-4">
+                        <label for="name" class="block text-gray-700 font-bold mb-2">Name</label>
+                        <input type="text" id="name" name="name" required maxlength="100" placeholder="e.g. nightly sync script"
+                               class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
+                    </div>
+                    <div class="mb-4">
+                        <span class="block text-gray-700 font-bold mb-2">Scopes</span>
+                        {{range .Scopes}}
+                        <label class="flex items-center space-x-2 mb-1">
+                            <input type="checkbox" name="scopes" value="{{.Scope}}">
+                            <span class="text-gray-800">{{.Label}}</span>
+                            <span class="text-gray-500 text-sm">- {{.Description}}</span>
+                        </label>
+                        {{end}}
+                    </div>
+                    <div class="mb-4">
+                        <label for="expires_in" class="block text-gray-700 font-bold mb-2">Expires</label>
+                        <select id="expires_in" name="expires_in" class="border rounded py-2 px-3 text-gray-700">
+                            <option value="30">In 30 days</option>
+                            <option value="90">In 90 days</option>
+                            <option value="365">In a year</option>
+                            <option value="0">Never</option>
+                        </select>
+                    </div>
+                    <button type="submit" class="bg-blue-600 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-blue-700 transition-colors duration-300">
+                        Create Token
+                    </button>
+                </form>
+                <div id="new-token" class="mt-4"></div>
+            </div>
+            <div class="bg-white rounded-lg shadow-md overflow-hidden">
+                <table class="min-w-full divide-y divide-gray-200">
+                    <thead class="bg-gray-50">
+                        <tr>
+                            <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Name</th>
+                            <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Token</th>
+                            <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Scopes</th>
+                            <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Created</th>
+                            <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Last Used</th>
+                            <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Expires</th>
+                            <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Actions</th>
+                        </tr>
+                    </thead>
+                    <tbody id="tokens-table-body" class="bg-white divide-y divide-gray-200">
+                        {{template "token-rows" .}}
+                    </tbody>
+                </table>
+            </div>
+        </main>
+    </body>
+</html>
+`
+
+var apiTokenRowsHTML = `
+{{define "token-rows"}}
+{{range .Tokens}}
+<tr id="token-row-{{.ID}}">
+    <td class="px-6 py-4 whitespace-nowrap text-sm font-medium text-gray-900">{{.Name}}</td>
+    <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-500"><code>{{.Prefix}}…</code></td>
+    <td class="px-6 py-4 text-sm text-gray-500">{{range .Scopes}}<span class="inline-block px-2 py-0.5 mr-1 mb-1 rounded bg-gray-100 text-gray-700">{{.}}</span>{{end}}</td>
+    <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-500">{{.CreatedAt.Local.Format "2006-01-02 15:04"}}</td>
+    <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-500">{{if .LastUsedAt}}{{.LastUsedAt.Local.Format "2006-01-02 15:04"}}{{else}}Never{{end}}</td>
+    <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-500">{{if .ExpiresAt}}{{.ExpiresAt.Local.Format "2006-01-02"}}{{else}}Never{{end}}</td>
+    <td class="px-6 py-4 whitespace-nowrap text-sm font-medium">
+        <button class="text-red-600 hover:text-red-900"
+                hx-delete="/account/tokens/{{.ID}}"
+                hx-target="#token-row-{{.ID}}"
+                hx-swap="outerHTML"
+                hx-confirm="Revoke this token? Scripts using it will stop working.">Revoke</button>
+    </td>
+</tr>
+{{end}}
+{{end}}
+`
+
+var apiTokenCreatedHTML = `
+<div class="bg-green-50 border border-green-200 rounded-lg p-4">
+    <p class="text-green-800 font-semibold mb-2">Token "{{.Token.Name}}" created. Copy it now, it will not be shown again.</p>
+    <code class="block bg-white border rounded p-2 text-sm break-all select-all">{{.Secret}}</code>
+</div>
+<table class="hidden">
+    <tbody hx-swap-oob="afterbegin:#tokens-table-body">
+        {{template "token-rows" .}}
+    </tbody>
+</table>
+`
+
+var apiTokensPage = template.Must(template.Must(template.Must(template.New("tokens").
+	Parse(apiTokenRowsHTML)).
+	New("token-created").Parse(apiTokenCreatedHTML)).
+	New("tokens-page").Parse(apiTokensPageHTML))
+
+// tokens are managed from an interactive session only, so a leaked token
+// cannot be used to mint further tokens
+func apiTokensPageHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionFromRequest(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	tokens, err := db.GetUserAPITokens(session.Username)
+	if err != nil {
+		http.Error(w, "Failed to fetch tokens: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Tokens    []APIToken
+		Scopes    []scopeInfo
+		CSRFToken string
+	}{
+		Tokens:    tokens,
+		Scopes:    tokenScopes,
+		CSRFToken: session.CSRFToken,
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := apiTokensPage.ExecuteTemplate(w, "tokens-page", data); err != nil {
+		fmt.Printf("Error rendering tokens page: %v\n", err)
+	}
+}
+
+func createAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionFromRequest(r)
+	if err != nil {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	fail := func(message string) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `<span class="text-red-500 text-sm">%s</span>`, template.HTMLEscapeString(message))
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" || len(name) > 100 {
+		fail("Name is required (max 100 characters)")
+		return
+	}
+
+	var scopes []TokenScope
+	for _, value := range r.Form["scopes"] {
+		scope := TokenScope(value)
+		if !scope.Valid() {
+			fail("Unknown scope: " + value)
+			return
+		}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		fail("Select at least one scope")
+		return
+	}
+
+	var expiresAt *time.Time
+	if days, err := strconv.Atoi(r.FormValue("expires_in")); err == nil && days > 0 {
+		t := time.Now().UTC().AddDate(0, 0, days)
+		expiresAt = &t
+	}
+
+	secret, token, err := db.CreateAPIToken(session.Username, name, scopes, expiresAt)
+	if err != nil {
+		http.Error(w, "Failed to create token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("API token %q (%s) created for user: %s\n", token.Name, token.Prefix, token.Username)
+	recordAudit(r, AuditCreate, "api_token", strconv.FormatInt(token.ID, 10), nil, token)
+
+	data := struct {
+		Token  *APIToken
+		Secret string
+		Tokens []APIToken
+	}{
+		Token:  token,
+		Secret: secret,
+		Tokens: []APIToken{*token},
+	}
+	if err := apiTokensPage.ExecuteTemplate(w, "token-created", data); err != nil {
+		fmt.Printf("Error rendering token: %v\n", err)
+	}
+}
+
+func revokeAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionFromRequest(r)
+	if err != nil {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+
+	deleted, err := db.DeleteAPIToken(id, session.Username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+	fmt.Printf("API token %d revoked by user: %s\n", id, session.Username)
+	recordAudit(r, AuditDelete, "api_token", strconv.FormatInt(id, 10), nil, nil)
+
+	// Return empty content - HTMX will remove element
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestAPITokenScopes(t *testing.T) {
+	token := &APIToken{Scopes: decodeScopes(encodeScopes([]TokenScope{ScopeContactsRead, ScopeCompaniesWrite}))}
+
+	allowed := map[Permission]bool{
+		PermViewContacts:    true,
+		PermEditContacts:    false,
+		PermEditOwnContact:  false,
+		PermViewCompanies:   true,
+		PermManageCompanies: true,
+		PermManageUsers:     false,
+		PermManageLicense:   false,
+	}
+	for p, want := range allowed {
+		if got := token.Allows(p); got != want {
+			t.Errorf("Allows(%s) = %t, want %t", p, got, want)
+		}
+	}
+}
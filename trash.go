@@ -0,0 +1,471 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Recycle bin. Deleting a contact or company only sets deleted_at, which
+// hides the row everywhere else. From /trash it can be restored or purged;
+// the retention job purges whatever has been in the bin for longer than
+// trash.retention_days.
+
+// TrashItem is one row of the recycle bin listing
+type TrashItem struct {
+	ID        string
+	Name      string
+	Detail    string
+	DeletedAt time.Time
+}
+
+func scanTrashItems(rows *sql.Rows) ([]TrashItem, error) {
+	defer rows.Close()
+	var items []TrashItem
+	for rows.Next() {
+		var item TrashItem
+		var detail sql.NullString
+		if err := rows.Scan(&item.ID, &item.Name, &detail, &item.DeletedAt); err != nil {
+			return nil, err
+		}
+		item.Detail = detail.String
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func (db *DB) GetDeletedContacts() ([]TrashItem, error) {
+	rows, err := db.Query(`SELECT id, first_name || ' ' || last_name, email, deleted_at
+		FROM contacts WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	return scanTrashItems(rows)
+}
+
+func (db *DB) GetDeletedCompanies() ([]TrashItem, error) {
+	rows, err := db.Query(`SELECT id, name, registration_number, deleted_at
+		FROM companies WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	return scanTrashItems(rows)
+}
+
+// GetDeletedContact loads a contact from the recycle bin
+func (db *DB) GetDeletedContact(id string) (*Contact, error) {
+	var contact Contact
+	var companyID sql.NullString
+	err := db.QueryRow(`SELECT id, contact_type, first_name, last_name, email, phone, COALESCE(phone_e164, ''), company_id
+		FROM contacts WHERE id = ? AND deleted_at IS NOT NULL`, id).Scan(
+		&contact.ID, &contact.ContactType, &contact.FirstName, &contact.LastName, &contact.Email, &contact.Phone, &contact.PhoneE164, &companyID)
+	if err != nil {
+		return nil, err
+	}
+	if companyID.Valid {
+		contact.CompanyID = &companyID.String
+	}
+	return &contact, nil
+}
+
+// GetDeletedCompany loads a company from the recycle bin
+func (db *DB) GetDeletedCompany(id string) (*Company, error) {
+	var company Company
+	var createdBy sql.NullString
+	err := db.QueryRow(`SELECT id, name, bank_name, account_number, account_document_path,
+		registration_number, registration_document_path, created_at, created_by
+		FROM companies WHERE id = ? AND deleted_at IS NOT NULL`, id).Scan(
+		&company.ID, &company.Name, &company.BankName, &company.AccountNumber,
+		&company.AccountDocumentPath, &company.RegistrationNumber,
+		&company.RegistrationDocumentPath, &company.CreatedAt, &createdBy)
+	if err != nil {
+		return nil, err
+	}
+	if createdBy.Valid {
+		company.CreatedBy = &createdBy.String
+	}
+	return &company, nil
+}
+
+// EmailInTrash reports whether a deleted contact still holds email
+func (db *DB) EmailInTrash(email string) bool {
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM contacts WHERE email = ? AND deleted_at IS NOT NULL", email).Scan(&n)
+	return n > 0
+}
+
+// ContactDeleted reports whether the contact is in the recycle bin. Logins
+// linked to such a contact are refused until it is restored.
+func (db *DB) ContactDeleted(id string) bool {
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM contacts WHERE id = ? AND deleted_at IS NOT NULL", id).Scan(&n)
+	return n > 0
+}
+
+func (db *DB) RestoreContact(id string) (bool, error) {
+	result, err := db.Exec("UPDATE contacts SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (db *DB) RestoreCompany(id string) (bool, error) {
+	result, err := db.Exec("UPDATE companies SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// expiredTrash lists the ids in table deleted before cutoff
+func (db *DB) expiredTrash(table string, cutoff time.Time) ([]string, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT id FROM %s WHERE deleted_at IS NOT NULL AND deleted_at < ?", table), cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// purgeContact permanently deletes a contact in the recycle bin, its history
+// and the login linked to it, all or nothing
+func purgeContact(id string) (*Contact, error) {
+	contact, err := db.GetDeletedContact(id)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM contacts WHERE id = ? AND deleted_at IS NOT NULL", id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM contact_versions WHERE contact_id = ?", id); err != nil {
+		return nil, err
+	}
+	if err := deleteContactDetails(tx, id); err != nil {
+		return nil, err
+	}
+	if err := deleteCustomValues(tx, "contact", id); err != nil {
+		return nil, err
+	}
+	if err := deleteContactTags(tx, id); err != nil {
+		return nil, err
+	}
+	// the login goes by its link, not by the card's email, which may name
+	// somebody else's account
+	if err := revokeContactCredentials(tx, id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM users WHERE contact_id = ?", id); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return contact, nil
+}
+
+// purgeCompany permanently deletes a company in the recycle bin, unlinks its
+// contacts and removes its uploaded documents
+func purgeCompany(id string) (*Company, error) {
+	company, err := db.GetDeletedCompany(id)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM companies WHERE id = ? AND deleted_at IS NOT NULL", id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("UPDATE contacts SET company_id = NULL WHERE company_id = ?", id); err != nil {
+		return nil, err
+	}
+	if err := deleteCustomValues(tx, "company", id); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if company.AccountDocumentPath != "" {
+		deleteUploadedFile(company.AccountDocumentPath)
+	}
+	if company.RegistrationDocumentPath != "" {
+		deleteUploadedFile(company.RegistrationDocumentPath)
+	}
+	return company, nil
+}
+
+// purgeExpiredTrash purges everything deleted more than days ago
+func purgeExpiredTrash(days int) {
+	cutoff := time.Now().UTC().AddDate(0, 0, -days)
+
+	contactIDs, err := db.expiredTrash("contacts", cutoff)
+	if err != nil {
+		fmt.Printf("Warning: Failed to list expired contacts: %v\n", err)
+	}
+	for _, id := range contactIDs {
+		contact, err := purgeContact(id)
+		if err != nil {
+			fmt.Printf("Warning: Failed to purge contact %s: %v\n", id, err)
+			continue
+		}
+		recordSystemAudit("retention", AuditPurge, "contact", id, contact, nil)
+	}
+
+	companyIDs, err := db.expiredTrash("companies", cutoff)
+	if err != nil {
+		fmt.Printf("Warning: Failed to list expired companies: %v\n", err)
+	}
+	for _, id := range companyIDs {
+		company, err := purgeCompany(id)
+		if err != nil {
+			fmt.Printf("Warning: Failed to purge company %s: %v\n", id, err)
+			continue
+		}
+		recordSystemAudit("retention", AuditPurge, "company", id, company, nil)
+	}
+
+	if n := len(contactIDs) + len(companyIDs); n > 0 {
+		fmt.Printf("Purged %d contacts and %d companies deleted more than %d days ago\n", len(contactIDs), len(companyIDs), days)
+	}
+}
+
+// startTrashPurge periodically empties the recycle bin of expired rows
+func startTrashPurge(interval time.Duration) {
+	days := config.Trash.RetentionDays
+	if days == 0 {
+		fmt.Println("Recycle bin retention disabled, deleted rows are kept until purged")
+		return
+	}
+
+	purgeExpiredTrash(days)
+	go func() {
+		for range time.Tick(interval) {
+			purgeExpiredTrash(days)
+		}
+	}()
+}
+
+// TRASH HANDLERS
+var trashPageHTML = `
+<!doctype html>
+<html lang="en">
+    <head>
+        <meta charset="UTF-8" />
+        <meta name="viewport" content="width=device-width, initial-scale=1.0" />
+        <title>Recycle Bin - AFCB</title>
+        <link rel="icon" type="image/x-icon" href="/static/favicon/favicon.ico" />
+        <script src="https://cdn.tailwindcss.com"></script>
+        <script src="https://unpkg.com/htmx.org@1.9.10"></script>
+        <script src="/static/csrf.js"></script>
+    </head>
+    <body class="bg-gray-100" hx-headers='{"X-CSRF-Token": "{{.CSRFToken}}"}'>
+        <nav class="bg-white shadow-md">
+            <div class="container mx-auto px-4">
+                <div class="flex justify-between items-center py-4">
+                    <a href="/" class="text-2xl font-bold text-blue-600">AFcb</a>
+                    <div class="flex items-center space-x-4">
+                        <a href="/" class="text-gray-600 hover:text-blue-600">Contacts</a>
+                        <a href="/companies-page" class="text-gray-600 hover:text-blue-600">Companies</a>
+                        <a href="/trash" class="text-blue-600 font-semibold">Recycle Bin</a>
+                        <a href="/logout" class="px-4 py-2 bg-red-600 text-white rounded-md hover:bg-red-700">Logout</a>
+                    </div>
+                </div>
+            </div>
+        </nav>
+        <main class="container mx-auto px-4 py-8">
+            <h1 class="text-3xl font-bold text-gray-800 mb-2">Recycle Bin</h1>
+            <p class="text-gray-600 mb-6">
+                {{if .RetentionDays}}Items are permanently deleted {{.RetentionDays}} days after they were moved here.
+                {{else}}Items stay here until they are deleted permanently.{{end}}
+            </p>
+            {{template "trash-section" .Contacts}}
+            {{if .CanManageCompanies}}{{template "trash-section" .Companies}}{{end}}
+        </main>
+    </body>
+</html>
+
+{{define "trash-section"}}
+<h2 class="text-xl font-semibold text-gray-800 mb-3">{{.Title}}</h2>
+<div class="bg-white rounded-lg shadow-md overflow-hidden mb-8">
+    <table class="min-w-full divide-y divide-gray-200">
+        <thead class="bg-gray-50">
+            <tr>
+                <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Name</th>
+                <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Deleted</th>
+                <th class="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Actions</th>
+            </tr>
+        </thead>
+        <tbody class="bg-white divide-y divide-gray-200">
+            {{$kind := .Kind}}
+            {{range .Items}}
+            <tr>
+                <td class="px-6 py-4 whitespace-nowrap">
+                    <div class="text-sm font-medium text-gray-900">{{.Name}}</div>
+                    <div class="text-sm text-gray-500">{{.Detail}}</div>
+                </td>
+                <td class="px-6 py-4 whitespace-nowrap text-sm text-gray-500">{{.DeletedAt.Format "2006-01-02 15:04"}}</td>
+                <td class="px-6 py-4 whitespace-nowrap text-sm font-medium">
+                    <button class="text-blue-600 hover:text-blue-900 mr-3"
+                            hx-post="/trash/{{$kind}}/{{.ID}}/restore"
+                            hx-target="closest tr"
+                            hx-swap="outerHTML">Restore</button>
+                    <button class="text-red-600 hover:text-red-900"
+                            hx-delete="/trash/{{$kind}}/{{.ID}}"
+                            hx-target="closest tr"
+                            hx-swap="outerHTML"
+                            hx-confirm="Permanently delete {{.Name}}? This cannot be undone.">Delete permanently</button>
+                </td>
+            </tr>
+            {{else}}
+            <tr><td colspan="3" class="px-6 py-4 text-center text-gray-500">Nothing here</td></tr>
+            {{end}}
+        </tbody>
+    </table>
+</div>
+{{end}}
+`
+
+var trashPage = template.Must(template.New("trash").Parse(trashPageHTML))
+
+type trashSection struct {
+	Title string
+	Kind  string
+	Items []TrashItem
+}
+
+func trashPageHandler(w http.ResponseWriter, r *http.Request) {
+	contacts, err := db.GetDeletedContacts()
+	if err != nil {
+		http.Error(w, "Failed to load recycle bin: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Contacts           trashSection
+		Companies          trashSection
+		CanManageCompanies bool
+		RetentionDays      int
+		CSRFToken          string
+	}{
+		Contacts:           trashSection{"Contacts", "contacts", contacts},
+		CanManageCompanies: hasPermission(r, PermManageCompanies),
+		RetentionDays:      config.Trash.RetentionDays,
+		CSRFToken:          csrfToken(r),
+	}
+	if data.CanManageCompanies {
+		companies, err := db.GetDeletedCompanies()
+		if err != nil {
+			http.Error(w, "Failed to load recycle bin: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data.Companies = trashSection{"Companies", "companies", companies}
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := trashPage.Execute(w, data); err != nil {
+		fmt.Printf("Error rendering recycle bin: %v\n", err)
+	}
+}
+
+func restoreContactHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	restored, err := db.RestoreContact(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !restored {
+		http.Error(w, "Contact not found in recycle bin", http.StatusNotFound)
+		return
+	}
+
+	contact, err := db.GetContact(id)
+	if err == nil {
+		recordAudit(r, AuditRestore, "contact", id, nil, contact)
+	}
+	fmt.Printf("Contact %s restored from recycle bin\n", id)
+
+	// Return empty content - HTMX will remove the row
+	w.WriteHeader(http.StatusOK)
+}
+
+func purgeContactHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	contact, err := purgeContact(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Contact not found in recycle bin", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, AuditPurge, "contact", id, contact, nil)
+	fmt.Printf("Contact %s purged from recycle bin\n", id)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func restoreCompanyHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	restored, err := db.RestoreCompany(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !restored {
+		http.Error(w, "Company not found in recycle bin", http.StatusNotFound)
+		return
+	}
+
+	company, err := db.GetCompany(id)
+	if err == nil {
+		recordAudit(r, AuditRestore, "company", id, nil, company)
+	}
+	fmt.Printf("Company %s restored from recycle bin\n", id)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func purgeCompanyHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	company, err := purgeCompany(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Company not found in recycle bin", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, AuditPurge, "company", id, company, nil)
+	fmt.Printf("Company %s purged from recycle bin\n", id)
+
+	w.WriteHeader(http.StatusOK)
+}
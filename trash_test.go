@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSoftDelete(t *testing.T) {
+	testDB := useTestDB(t)
+
+	acme := "acme"
+	if err := testDB.CreateCompany(&Company{ID: acme, Name: "Acme"}); err != nil {
+		t.Fatalf("CreateCompany failed: %v", err)
+	}
+	for _, c := range []Contact{
+		{ID: "c1", ContactType: "Work", FirstName: "John", LastName: "Smith", Email: "john@acme.test", Phone: "111", CompanyID: &acme},
+		{ID: "c2", ContactType: "Work", FirstName: "Mary", LastName: "Jones", Email: "mary@acme.test", Phone: "222", CompanyID: &acme},
+	} {
+		if err := insertContact(testDB, &c); err != nil {
+			t.Fatalf("insertContact failed: %v", err)
+		}
+	}
+
+	count := func() int {
+		t.Helper()
+		_, total, err := testDB.ListContacts("", ListOptions{})
+		if err != nil {
+			t.Fatalf("ListContacts failed: %v", err)
+		}
+		return total
+	}
+
+	if err := testDB.DeleteContact("c1"); err != nil {
+		t.Fatalf("DeleteContact failed: %v", err)
+	}
+	if got := count(); got != 1 {
+		t.Errorf("contacts after delete = %d, want 1", got)
+	}
+	if _, err := testDB.GetContact("c1"); err == nil {
+		t.Error("GetContact returned a deleted contact")
+	}
+	if err := checkEmailAvailable("john@acme.test", ""); err != errEmailInTrash {
+		t.Errorf("checkEmailAvailable = %v, want errEmailInTrash", err)
+	}
+
+	if ok, err := testDB.RestoreContact("c1"); err != nil || !ok {
+		t.Fatalf("RestoreContact = %v, %v", ok, err)
+	}
+	if got := count(); got != 2 {
+		t.Errorf("contacts after restore = %d, want 2", got)
+	}
+
+	// only rows deleted before the cutoff expire
+	testDB.DeleteContact("c2")
+	if ids, _ := testDB.expiredTrash("contacts", time.Now().UTC().Add(-time.Hour)); len(ids) != 0 {
+		t.Errorf("expired before cutoff = %v, want none", ids)
+	}
+	if ids, _ := testDB.expiredTrash("contacts", time.Now().UTC().Add(time.Hour)); len(ids) != 1 || ids[0] != "c2" {
+		t.Errorf("expired after cutoff = %v, want [c2]", ids)
+	}
+
+	// a company can only be purged from the bin, and its contacts are unlinked
+	if _, err := purgeCompany(acme); err == nil {
+		t.Error("purgeCompany succeeded for a live company")
+	}
+	testDB.DeleteCompany(acme)
+	if _, err := purgeCompany(acme); err != nil {
+		t.Fatalf("purgeCompany failed: %v", err)
+	}
+	contact, err := testDB.GetContact("c1")
+	if err != nil {
+		t.Fatalf("GetContact failed: %v", err)
+	}
+	if contact.CompanyID != nil {
+		t.Errorf("company_id after purge = %v, want nil", *contact.CompanyID)
+	}
+}
+
+func TestPurgeContactDeletesLinkedLogin(t *testing.T) {
+	testDB := useTestDB(t)
+
+	// the card's email names the admin, but its login is mary@old.test
+	contact := Contact{ID: "c1", ContactType: "Work", FirstName: "Mary", LastName: "Jones", Email: "boss@acme.test", Phone: "111"}
+	if err := insertContact(testDB, &contact); err != nil {
+		t.Fatalf("insertContact failed: %v", err)
+	}
+	testDB.CreateUser(&User{Username: "boss@acme.test", Password: "secret", Role: RoleAdmin})
+	testDB.CreateUser(&User{Username: "mary@old.test", Password: "secret", ContactID: &contact.ID})
+	testDB.Exec("INSERT INTO contact_tags (contact_id, tag_id) VALUES ('c1', 1)")
+
+	testDB.DeleteContact("c1")
+	if _, err := purgeContact("c1"); err != nil {
+		t.Fatalf("purgeContact failed: %v", err)
+	}
+	if _, err := testDB.GetUser("boss@acme.test"); err != nil {
+		t.Errorf("purging the card deleted the login its email names: %v", err)
+	}
+	if _, err := testDB.GetUser("mary@old.test"); err == nil {
+		t.Errorf("the login linked to the card survived the purge")
+	}
+	var tags int
+	testDB.QueryRow("SELECT COUNT(*) FROM contact_tags WHERE contact_id = 'c1'").Scan(&tags)
+	if tags != 0 {
+		t.Errorf("%d tags left after the purge", tags)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,12 +11,19 @@ import (
 	gonanoid "github.com/matoous/go-nanoid"
 )
 
-const uploadDir = "./uploads"
-
-func init() {
-	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		fmt.Printf("Warning: Could not create uploads directory: %v\n", err)
+// parseUploadForm parses a multipart form of at most the configured upload
+// size, answering the request itself when that fails
+func parseUploadForm(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, config.MaxUploadBytes())
+	err := r.ParseMultipartForm(config.MaxUploadBytes())
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		http.Error(w, fmt.Sprintf("Upload is larger than %d MB", config.Uploads.MaxSizeMB), http.StatusRequestEntityTooLarge)
+	case err != nil:
+		http.Error(w, "Invalid upload form", http.StatusBadRequest)
 	}
+	return err
 }
 
 func handleFileUpload(r *http.Request, formFieldName string) (string, error) {
@@ -37,7 +45,7 @@ func handleFileUpload(r *http.Request, formFieldName string) (string, error) {
 	//get file ext
 	ext := filepath.Ext(header.Filename)
 	filename := id + ext
-	filepath := filepath.Join(uploadDir, filename)
+	filepath := filepath.Join(config.Uploads.Dir, filename)
 
 	//create file
 	dst, err := os.Create(filepath)
@@ -56,6 +64,6 @@ func deleteUploadedFile(filename string) error {
 	if filename == "" {
 		return nil
 	}
-	filepath := filepath.Join(uploadDir, filename)
+	filepath := filepath.Join(config.Uploads.Dir, filename)
 	return os.Remove(filepath)
 }
@@ -1,8 +1,9 @@
 package main
 
 type User struct {
-	Username           string
-	Password           string
-	ContactID          *string
-	NeedPasswordChange bool
+	Username           string  `json:"username"`
+	Password           string  `json:"-"`
+	ContactID          *string `json:"contact_id"`
+	NeedPasswordChange bool    `json:"needs_password_change"`
+	Role               Role    `json:"role"`
 }
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// vCardEntry holds the properties of one vCard that the directory cares
+// about. Only the first EMAIL and TEL are kept.
+type vCardEntry struct {
+	FirstName  string
+	LastName   string
+	FullName   string
+	Email      string
+	Phone      string
+	Org        string
+	Categories []string
+}
+
+// parseVCards reads every BEGIN:VCARD ... END:VCARD block from r. It
+// understands versions 2.1, 3.0 and 4.0 well enough for address book
+// exports: folded lines, property groups ("item1.EMAIL") and parameters
+// ("TEL;TYPE=cell") are handled, anything unknown is ignored.
+func parseVCards(r io.Reader) ([]vCardEntry, error) {
+	lines, err := unfoldVCardLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []vCardEntry
+	var current *vCardEntry
+	for _, line := range lines {
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			continue
+		}
+		name, value := line[:colon], line[colon+1:]
+
+		// strip parameters and group prefix
+		if semi := strings.IndexByte(name, ';'); semi >= 0 {
+			name = name[:semi]
+		}
+		if dot := strings.LastIndexByte(name, '.'); dot >= 0 {
+			name = name[dot+1:]
+		}
+		name = strings.ToUpper(name)
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VCARD"):
+			current = &vCardEntry{}
+		case name == "END" && strings.EqualFold(value, "VCARD"):
+			if current != nil {
+				entries = append(entries, *current)
+			}
+			current = nil
+		case current == nil:
+			continue
+		case name == "N":
+			parts := splitVCardValue(value, ';')
+			if len(parts) > 0 {
+				current.LastName = parts[0]
+			}
+			if len(parts) > 1 {
+				current.FirstName = parts[1]
+			}
+		case name == "FN":
+			current.FullName = unescapeVCardValue(value)
+		case name == "EMAIL":
+			if current.Email == "" {
+				current.Email = strings.TrimPrefix(unescapeVCardValue(value), "mailto:")
+			}
+		case name == "TEL":
+			if current.Phone == "" {
+				current.Phone = strings.TrimPrefix(unescapeVCardValue(value), "tel:")
+			}
+		case name == "ORG":
+			if parts := splitVCardValue(value, ';'); len(parts) > 0 {
+				current.Org = parts[0]
+			}
+		case name == "CATEGORIES":
+			current.Categories = append(current.Categories, splitVCardValue(value, ',')...)
+		}
+	}
+	return entries, nil
+}
+
+// unfoldVCardLines joins continuation lines, which start with a space or tab
+func unfoldVCardLines(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// splitVCardValue splits a structured value on sep, honouring backslash escapes
+func splitVCardValue(value string, sep byte) []string {
+	var parts []string
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && i+1 < len(value):
+			b.WriteByte('\\')
+			b.WriteByte(value[i+1])
+			i++
+		case value[i] == sep:
+			parts = append(parts, strings.TrimSpace(unescapeVCardValue(b.String())))
+			b.Reset()
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+	return append(parts, strings.TrimSpace(unescapeVCardValue(b.String())))
+}
+
+var vCardUnescaper = strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+
+func unescapeVCardValue(value string) string {
+	return strings.TrimSpace(vCardUnescaper.Replace(value))
+}
+
+var vCardEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, ",", `\,`, ";", `\;`)
+
+func escapeVCardValue(value string) string {
+	return vCardEscaper.Replace(value)
+}
+
+// vCardLabelTypes maps detail labels to vCard TYPE parameters; "other" has none
+var vCardLabelTypes = map[string]string{
+	"work":   "WORK",
+	"home":   "HOME",
+	"mobile": "CELL",
+	"fax":    "FAX",
+}
+
+// vCardTypeParam returns the TYPE parameter combining base with the type for
+// label, e.g. ";TYPE=INTERNET,WORK", or nothing when neither applies
+func vCardTypeParam(base, label string) string {
+	var types []string
+	if base != "" {
+		types = append(types, base)
+	}
+	if t, ok := vCardLabelTypes[label]; ok {
+		types = append(types, t)
+	}
+	if len(types) == 0 {
+		return ""
+	}
+	return ";TYPE=" + strings.Join(types, ",")
+}
+
+// vCardPhone returns the E.164 form of a phone, or for numbers saved before
+// they were normalized, its digits and a leading plus
+func vCardPhone(phone, e164 string) string {
+	if e164 != "" {
+		return e164
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' || r == '+' {
+			return r
+		}
+		return -1
+	}, phone)
+}
+
+// formatVCard renders a single vCard 3.0 entry. It is used for the QR code on
+// the PDF card and, concatenated, for .vcf exports.
+func formatVCard(contact *Contact, companyName string, rev time.Time) string {
+	var vcard strings.Builder
+
+	vcard.WriteString("BEGIN:VCARD\n")
+	vcard.WriteString("VERSION:3.0\n")
+	vcard.WriteString(fmt.Sprintf("FN:%s %s\n", escapeVCardValue(contact.FirstName), escapeVCardValue(contact.LastName)))
+	vcard.WriteString(fmt.Sprintf("N:%s;%s;;;\n", escapeVCardValue(contact.LastName), escapeVCardValue(contact.FirstName)))
+
+	if contact.Email != "" {
+		vcard.WriteString(fmt.Sprintf("EMAIL;TYPE=INTERNET,PREF:%s\n", escapeVCardValue(contact.Email)))
+	}
+	for _, e := range contact.Emails {
+		vcard.WriteString(fmt.Sprintf("EMAIL%s:%s\n", vCardTypeParam("INTERNET", e.Label), escapeVCardValue(e.Email)))
+	}
+
+	if contact.Phone != "" {
+		vcard.WriteString(fmt.Sprintf("TEL;TYPE=VOICE,PREF:%s\n", vCardPhone(contact.Phone, contact.PhoneE164)))
+	}
+	for _, p := range contact.Phones {
+		base := "VOICE"
+		if p.Label == "mobile" || p.Label == "fax" {
+			base = ""
+		}
+		vcard.WriteString(fmt.Sprintf("TEL%s:%s\n", vCardTypeParam(base, p.Label), vCardPhone(p.Phone, p.E164)))
+	}
+
+	for _, a := range contact.Addresses {
+		vcard.WriteString(fmt.Sprintf("ADR%s:;;%s;%s;%s;%s;%s\n", vCardTypeParam("", a.Label),
+			escapeVCardValue(a.Street), escapeVCardValue(a.City), escapeVCardValue(a.Region),
+			escapeVCardValue(a.PostalCode), escapeVCardValue(a.Country)))
+	}
+
+	if companyName != "" {
+		vcard.WriteString(fmt.Sprintf("ORG:%s\n", escapeVCardValue(companyName)))
+	}
+
+	// the contact type and tags are all categories
+	var categories []string
+	if contact.ContactType != "" {
+		categories = append(categories, escapeVCardValue(contact.ContactType))
+	}
+	for _, tag := range contact.Tags {
+		categories = append(categories, escapeVCardValue(tag))
+	}
+	if len(categories) > 0 {
+		vcard.WriteString(fmt.Sprintf("CATEGORIES:%s\n", strings.Join(categories, ",")))
+	}
+
+	// Timestamp
+	vcard.WriteString(fmt.Sprintf("REV:%s\n", rev.UTC().Format("20060102T150405Z")))
+
+	vcard.WriteString("END:VCARD")
+
+	return vcard.String()
+}
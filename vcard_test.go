@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseVCards(t *testing.T) {
+	input := "BEGIN:VCARD\r\nVERSION:3.0\r\nN:Smith;John;;;\r\nFN:John Smith\r\n" +
+		"item1.EMAIL;TYPE=INTERNET:john@\r\n example.com\r\nEMAIL:other@example.com\r\n" +
+		"TEL;TYPE=CELL:+1 555 1234\r\nORG:Acme\\, Inc.;Sales\r\nCATEGORIES:Work,VIP\r\nEND:VCARD\r\n" +
+		"BEGIN:VCARD\nVERSION:4.0\nFN:Mary Lo\nEND:VCARD\n"
+
+	entries, err := parseVCards(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseVCards failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Got %d entries, want 2", len(entries))
+	}
+
+	john := entries[0]
+	if john.FirstName != "John" || john.LastName != "Smith" {
+		t.Errorf("Name = %q %q", john.FirstName, john.LastName)
+	}
+	if john.Email != "john@example.com" {
+		t.Errorf("Email = %q, want the first, unfolded address", john.Email)
+	}
+	if john.Phone != "+1 555 1234" {
+		t.Errorf("Phone = %q", john.Phone)
+	}
+	if john.Org != "Acme, Inc." {
+		t.Errorf("Org = %q", john.Org)
+	}
+	if len(john.Categories) != 2 || john.Categories[0] != "Work" {
+		t.Errorf("Categories = %v", john.Categories)
+	}
+
+	if entries[1].FullName != "Mary Lo" {
+		t.Errorf("FullName = %q", entries[1].FullName)
+	}
+}
+
+func TestFormatVCardDetails(t *testing.T) {
+	contact := &Contact{
+		FirstName: "John", LastName: "Smith", Email: "john@acme.test", Phone: "+1 (555) 0100",
+		Emails:    []ContactEmail{{Label: "home", Email: "john@home.test"}},
+		Phones:    []ContactPhone{{Label: "mobile", Phone: "555-0101"}, {Label: "work", Phone: "5550102"}, {Label: "other", Phone: "5550103"}},
+		Addresses: []ContactAddress{{Label: "work", Street: "1 Main St", City: "Springfield", Country: "US"}},
+	}
+	vcard := formatVCard(contact, "", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	for _, want := range []string{
+		"EMAIL;TYPE=INTERNET,PREF:john@acme.test\n",
+		"EMAIL;TYPE=INTERNET,HOME:john@home.test\n",
+		"TEL;TYPE=VOICE,PREF:+15550100\n",
+		"TEL;TYPE=CELL:5550101\n",
+		"TEL;TYPE=VOICE,WORK:5550102\n",
+		"TEL;TYPE=VOICE:5550103\n",
+		"ADR;TYPE=WORK:;;1 Main St;Springfield;;;US\n",
+	} {
+		if !strings.Contains(vcard, want) {
+			t.Errorf("vCard is missing %q:\n%s", want, vcard)
+		}
+	}
+}